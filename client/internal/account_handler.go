@@ -0,0 +1,46 @@
+package internal
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ogzhanolguncu/go-chat/protocol"
+)
+
+func accountMessageHandler(parts []string, c *Client) (string, error) {
+	switch parts[0] {
+	case "/passwd":
+		if len(parts) != 3 {
+			return fmt.Sprintf("[%s] [%s](fg:red)", time.Now().Format("01-02 15:04"), "Usage: /passwd <current_password> <new_password>"), nil
+		}
+		if _, err := c.conn.Write([]byte(c.preparePasswordChangePayload(parts[1], parts[2]))); err != nil {
+			return "", fmt.Errorf("error sending password change: %v", err)
+		}
+		return fmt.Sprintf("[%s] [Changing password...](fg:magenta)", time.Now().Format("01-02 15:04")), nil
+	case "/deleteaccount":
+		if len(parts) != 2 {
+			return fmt.Sprintf("[%s] [%s](fg:red)", time.Now().Format("01-02 15:04"), "Usage: /deleteaccount <password>"), nil
+		}
+		if _, err := c.conn.Write([]byte(c.prepareDeleteAccountPayload(parts[1]))); err != nil {
+			return "", fmt.Errorf("error sending account deletion: %v", err)
+		}
+		return fmt.Sprintf("[%s] [Deleting account...](fg:magenta)", time.Now().Format("01-02 15:04")), nil
+	default:
+		return fmt.Sprintf("[%s] [Unknown '%s' command](fg:red)", time.Now().Format("01-02 15:04"), parts[0]), nil
+	}
+}
+
+func (c *Client) preparePasswordChangePayload(currentPassword, newPassword string) string {
+	return c.encodeFn(protocol.Payload{
+		MessageType: protocol.MessageTypePASSWD,
+		Password:    currentPassword,
+		NewPassword: newPassword,
+	})
+}
+
+func (c *Client) prepareDeleteAccountPayload(password string) string {
+	return c.encodeFn(protocol.Payload{
+		MessageType: protocol.MessageTypeDEL_ACCT,
+		Password:    password,
+	})
+}
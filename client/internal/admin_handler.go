@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ogzhanolguncu/go-chat/protocol"
+)
+
+const adminUsage = "Usage: /admin <kick|mute|unmute|ban|banip|unban|role> <username> [duration|role] [reason] or /admin bans"
+
+func adminMessageHandler(parts []string, c *Client) (string, error) {
+	// Listing bans is the only action without a target
+	if len(parts) == 2 && parts[1] == protocol.ModActionBans {
+		if _, err := c.conn.Write([]byte(c.prepareModerationPayload(protocol.ModActionBans, "", ""))); err != nil {
+			return "", fmt.Errorf("error sending moderation request: %v", err)
+		}
+		return fmt.Sprintf("[%s] [Fetching bans...](fg:magenta)", time.Now().Format("01-02 15:04")), nil
+	}
+	if len(parts) < 3 {
+		return fmt.Sprintf("[%s] [%s](fg:red)", time.Now().Format("01-02 15:04"), adminUsage), nil
+	}
+
+	action, target := parts[1], parts[2]
+	// Everything after the target is the argument, so ban reasons can have spaces
+	argument := strings.Join(parts[3:], " ")
+
+	switch action {
+	case protocol.ModActionKick, protocol.ModActionUnmute, protocol.ModActionUnban, protocol.ModActionMute:
+	case protocol.ModActionBan, protocol.ModActionBanIP:
+		if argument == "" {
+			return fmt.Sprintf("[%s] [%s](fg:red)", time.Now().Format("01-02 15:04"), fmt.Sprintf("Usage: /admin %s <username> <duration|perm> [reason]", action)), nil
+		}
+	case protocol.ModActionRole:
+		if argument == "" {
+			return fmt.Sprintf("[%s] [%s](fg:red)", time.Now().Format("01-02 15:04"), "Usage: /admin role <username> <admin|moderator|user>"), nil
+		}
+	default:
+		return fmt.Sprintf("[%s] [%s](fg:red)", time.Now().Format("01-02 15:04"), adminUsage), nil
+	}
+
+	if _, err := c.conn.Write([]byte(c.prepareModerationPayload(action, target, argument))); err != nil {
+		return "", fmt.Errorf("error sending moderation request: %v", err)
+	}
+	// Outcome arrives as a system message
+	return fmt.Sprintf("[%s] [Sending %s request for %s...](fg:magenta)", time.Now().Format("01-02 15:04"), action, target), nil
+}
+
+func (c *Client) prepareModerationPayload(action, target, argument string) string {
+	return c.encodeFn(protocol.Payload{
+		MessageType: protocol.MessageTypeMOD,
+		Recipient:   target,
+		Content:     argument,
+		Status:      action,
+	})
+}
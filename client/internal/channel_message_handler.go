@@ -10,15 +10,29 @@ import (
 )
 
 const (
-	cmdCreate  = "create"
-	cmdJoin    = "join"
-	cmdMessage = "message"
-	cmdLeave   = "leave"
-	cmdUsers   = "users"
-	cmdKick    = "kick"
-	cmdBan     = "ban"
-	cmdList    = "list" // Useful for getting channel list on group chat
-	cmdTyping  = "typing"
+	cmdCreate      = "create"
+	cmdJoin        = "join"
+	cmdMessage     = "message"
+	cmdLeave       = "leave"
+	cmdUsers       = "users"
+	cmdKick        = "kick"
+	cmdBan         = "ban"
+	cmdList        = "list" // Useful for getting channel list on group chat
+	cmdTyping      = "typing"
+	cmdHistory     = "history"
+	cmdOp          = "op"
+	cmdDeop        = "deop"
+	cmdTransfer    = "transfer"
+	cmdUnban       = "unban"
+	cmdBans        = "bans"
+	cmdInvite      = "invite"
+	cmdAccept      = "accept"
+	cmdTopic       = "topic"
+	cmdDescription = "description"
+	cmdMute        = "mute"
+	cmdUnmute      = "unmute"
+	cmdSlowMode    = "slowmode"
+	cmdResize      = "resize"
 )
 
 func chMessageHandler(parts []string, c *Client) (string, error) {
@@ -40,31 +54,54 @@ func chMessageHandler(parts []string, c *Client) (string, error) {
 	case cmdLeave:
 		return handleLeaveChannel(c, channelName)
 	case cmdUsers:
-		return handleGetUsersOfChannel(c, channelName, args)
+		return handleGetUsersOfChannel(c, channelName)
 	case cmdList:
-		return handleGetChannelList(c)
+		return handleGetChannelList(c, channelName, args)
 	case cmdKick:
 		return handleKickUser(c, channelName, args)
 	case cmdBan:
 		return handleBanUser(c, channelName, args)
 	case cmdTyping:
-		return handleTypingIndicator(c, channelName, args)
+		return handleTypingIndicator(c, channelName)
+	case cmdHistory:
+		return handleChannelHistory(c, channelName)
+	case cmdOp:
+		return handleTargetUserAction(c, channelName, args, protocol.OpUser, "make '%s' a moderator")
+	case cmdDeop:
+		return handleTargetUserAction(c, channelName, args, protocol.DeopUser, "remove '%s' from moderators")
+	case cmdTransfer:
+		return handleTargetUserAction(c, channelName, args, protocol.TransferChannel, "transfer the channel to '%s'")
+	case cmdUnban:
+		return handleTargetUserAction(c, channelName, args, protocol.UnbanUser, "unban '%s'")
+	case cmdBans:
+		return handleGetChannelBans(c, channelName)
+	case cmdInvite:
+		return handleInviteUser(c, channelName, args)
+	case cmdAccept:
+		return handleAcceptInvite(c, channelName, args)
+	case cmdTopic:
+		return handleSetMetadata(c, channelName, protocol.SetTopic, "topic", args)
+	case cmdDescription:
+		return handleSetMetadata(c, channelName, protocol.SetDescription, "description", args)
+	case cmdMute:
+		return handleMuteUser(c, channelName, args)
+	case cmdUnmute:
+		return handleTargetUserAction(c, channelName, args, protocol.UnmuteUser, "unmute '%s'")
+	case cmdSlowMode:
+		return handleSlowMode(c, channelName, args)
+	case cmdResize:
+		return handleResizeChannel(c, channelName, args)
 	default:
 		return fmt.Sprintf("[%s] [Unknown action: %s](fg:red)", time.Now().Format("01-02 15:04"), action), nil
 	}
 }
 
-func handleTypingIndicator(c *Client, channelName string, args []string) (string, error) {
-	var password string
-	if len(args) > 1 {
-		password = args[0]
-	}
-
+// Password is only sent to join, after that the server knows us as a member
+func handleTypingIndicator(c *Client, channelName string) (string, error) {
 	payload, err := protocol.NewChannelPayloadBuilder().
 		SetRequester(c.name).
 		SetChannelAction(protocol.TypingChannel).
 		SetChannelName(channelName).
-		SetChannelPassword(password).
 		Build()
 	if err != nil {
 		return "", err
@@ -103,6 +140,26 @@ func handleCreateChannel(c *Client, channelName string, args []string) (string,
 	if err != nil {
 		return "", err
 	}
+	// Options after the visibility can come in any order, a duration is the idle timeout
+	if len(args) > 3 {
+		for _, option := range args[3:] {
+			switch option {
+			case "permanent":
+				// Server only accepts this from admins
+				payload.ChannelPayload.OptionalChannelArgs.Permanent = true
+			case "archive":
+				payload.ChannelPayload.OptionalChannelArgs.Archive = true
+			case "waitlist":
+				payload.ChannelPayload.OptionalChannelArgs.Waitlist = true
+			default:
+				idleTimeout, err := time.ParseDuration(option)
+				if err != nil || idleTimeout <= 0 {
+					return fmt.Sprintf("[%s] [Unknown channel option: %s, use permanent, archive, waitlist or an idle timeout like 30m](fg:red)", time.Now().Format("01-02 15:04"), option), nil
+				}
+				payload.ChannelPayload.OptionalChannelArgs.IdleTimeout = idleTimeout
+			}
+		}
+	}
 
 	if err := sendPayload(c, payload); err != nil {
 		return "", err
@@ -134,20 +191,12 @@ func handleMessageChannel(c *Client, channelName string, args []string) (string,
 		return fmt.Sprintf("[%s] [Message content is required](fg:red)", time.Now().Format("01-02 15:04")), nil
 	}
 
-	var password, message string
-	if len(args) > 1 {
-		password = args[0]
-		message = strings.Join(args[1:], " ")
-	}
-	if len(args) == 1 {
-		message = strings.Join(args, " ")
-	}
+	message := strings.Join(args, " ")
 
 	payload, err := protocol.NewChannelPayloadBuilder().
 		SetRequester(c.name).
 		SetChannelAction(protocol.MessageChannel).
 		SetChannelName(channelName).
-		SetChannelPassword(password).
 		AddOptionalArg("message", message).
 		Build()
 
@@ -162,17 +211,11 @@ func handleMessageChannel(c *Client, channelName string, args []string) (string,
 	return fmt.Sprintf("[%s] [You: %s](fg:cyan)", time.Now().Format("01-02 15:04"), message), nil
 }
 
-func handleGetUsersOfChannel(c *Client, channelName string, args []string) (string, error) {
-	var password string
-	if len(args) > 1 {
-		password = args[0]
-	}
-
+func handleGetUsersOfChannel(c *Client, channelName string) (string, error) {
 	payload, err := protocol.NewChannelPayloadBuilder().
 		SetRequester(c.name).
 		SetChannelAction(protocol.GetUsers).
 		SetChannelName(channelName).
-		SetChannelPassword(password).
 		Build()
 	if err != nil {
 		return "", err
@@ -185,10 +228,39 @@ func handleGetUsersOfChannel(c *Client, channelName string, args []string) (stri
 	return fmt.Sprintf("[%s] [Requested channel '%s' users](fg:magenta)", time.Now().Format("01-02 15:04"), channelName), nil
 }
 
-func handleGetChannelList(c *Client) (string, error) {
+func handleChannelHistory(c *Client, channelName string) (string, error) {
+	payload, err := protocol.NewChannelPayloadBuilder().
+		SetRequester(c.name).
+		SetChannelAction(protocol.HistoryChannel).
+		SetChannelName(channelName).
+		Build()
+	if err != nil {
+		return "", err
+	}
+
+	if err := sendPayload(c, payload); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("[%s] [Requested channel '%s' history](fg:magenta)", time.Now().Format("01-02 15:04"), channelName), nil
+}
+
+// handleGetChannelList takes a name filter in place of the channel name, "-" lists every channel.
+// The order can follow as name, members, activity or created.
+func handleGetChannelList(c *Client, filter string, args []string) (string, error) {
+	if filter == protocol.EmptyChannelField {
+		filter = ""
+	}
+	var order protocol.ChannelSort
+	if len(args) > 0 {
+		order = protocol.ChannelSort(args[0])
+	}
+
 	payload, err := protocol.NewChannelPayloadBuilder().
 		SetRequester(c.name).
 		SetChannelAction(protocol.GetChannels).
+		AddOptionalArg("filter", filter).
+		AddOptionalArg("sort", order).
 		Build()
 	if err != nil {
 		return "", err
@@ -244,12 +316,8 @@ func sendPayload(c *Client, payload *protocol.Payload) error {
 }
 
 func handleKickUser(c *Client, channelName string, args []string) (string, error) {
-	var password, target_user string
-	if len(args) > 1 {
-		password = args[0]
-		target_user = args[1]
-	}
-	if len(args) == 1 {
+	var target_user string
+	if len(args) > 0 {
 		target_user = args[0]
 	}
 
@@ -257,7 +325,6 @@ func handleKickUser(c *Client, channelName string, args []string) (string, error
 		SetRequester(c.name).
 		SetChannelAction(protocol.KickUser).
 		SetChannelName(channelName).
-		SetChannelPassword(password).
 		AddOptionalArg("target_user", target_user).
 		Build()
 	if err != nil {
@@ -272,22 +339,23 @@ func handleKickUser(c *Client, channelName string, args []string) (string, error
 		time.Now().Format("01-02 15:04"), c.name, target_user), nil
 }
 
+// handleBanUser bans until unbanned, unless a duration like 10m or 2h follows the username
 func handleBanUser(c *Client, channelName string, args []string) (string, error) {
-	var password, target_user string
-	if len(args) > 1 {
-		password = args[0]
-		target_user = args[1]
-	}
-	if len(args) == 1 {
+	var target_user string
+	if len(args) > 0 {
 		target_user = args[0]
 	}
+	duration, ok := parseRestrictionDuration(args)
+	if !ok {
+		return fmt.Sprintf("[%s] [Ban duration must look like 10m, 2h or perm](fg:red)", time.Now().Format("01-02 15:04")), nil
+	}
 
 	payload, err := protocol.NewChannelPayloadBuilder().
 		SetRequester(c.name).
 		SetChannelAction(protocol.BanUser).
 		SetChannelName(channelName).
-		SetChannelPassword(password).
 		AddOptionalArg("target_user", target_user).
+		AddOptionalArg("duration", duration).
 		Build()
 	if err != nil {
 		return "", err
@@ -301,7 +369,220 @@ func handleBanUser(c *Client, channelName string, args []string) (string, error)
 		time.Now().Format("01-02 15:04"), c.name, target_user), nil
 }
 
-func (c *Client) HandleChReceive(payload protocol.Payload) (msg string, shouldExit bool) {
+// handleMuteUser keeps a member from posting until unmuted, unless a duration like 10m or 2h follows the username
+func handleMuteUser(c *Client, channelName string, args []string) (string, error) {
+	var target_user string
+	if len(args) > 0 {
+		target_user = args[0]
+	}
+	duration, ok := parseRestrictionDuration(args)
+	if !ok {
+		return fmt.Sprintf("[%s] [Mute duration must look like 10m, 2h or perm](fg:red)", time.Now().Format("01-02 15:04")), nil
+	}
+
+	payload, err := protocol.NewChannelPayloadBuilder().
+		SetRequester(c.name).
+		SetChannelAction(protocol.MuteUser).
+		SetChannelName(channelName).
+		AddOptionalArg("target_user", target_user).
+		AddOptionalArg("duration", duration).
+		Build()
+	if err != nil {
+		return "", err
+	}
+
+	if err := sendPayload(c, payload); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("[%s] [User '%s' has requested to mute '%s' in the channel](fg:magenta)",
+		time.Now().Format("01-02 15:04"), c.name, target_user), nil
+}
+
+// parseRestrictionDuration reads the duration after the username of ban and mute, zero when it's missing or perm
+func parseRestrictionDuration(args []string) (time.Duration, bool) {
+	if len(args) < 2 || args[1] == "perm" || args[1] == "permanent" {
+		return 0, true
+	}
+	duration, err := time.ParseDuration(args[1])
+	if err != nil || duration <= 0 {
+		return 0, false
+	}
+	return duration, true
+}
+
+func handleResizeChannel(c *Client, channelName string, args []string) (string, error) {
+	if len(args) == 0 {
+		return fmt.Sprintf("[%s] [Usage: /ch resize <channelName> <max_users>](fg:red)", time.Now().Format("01-02 15:04")), nil
+	}
+	size, err := strconv.Atoi(args[0])
+	if err != nil || size <= 0 {
+		return fmt.Sprintf("[%s] [Invalid channel size: %s](fg:red)", time.Now().Format("01-02 15:04"), args[0]), nil
+	}
+
+	payload, err := buildChannelPayload(c, protocol.ResizeChannel, channelName, "", size, "")
+	if err != nil {
+		return "", err
+	}
+
+	if err := sendPayload(c, payload); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+// handleSlowMode takes seconds or a duration like 30s or 2m, 0 or off turns slow mode off
+func handleSlowMode(c *Client, channelName string, args []string) (string, error) {
+	if len(args) == 0 {
+		return fmt.Sprintf("[%s] [Usage: /ch slowmode <channelName> <seconds|off>](fg:red)", time.Now().Format("01-02 15:04")), nil
+	}
+	var slowMode time.Duration
+	if args[0] != "off" {
+		if seconds, err := strconv.Atoi(args[0]); err == nil {
+			slowMode = time.Duration(seconds) * time.Second
+		} else if parsed, err := time.ParseDuration(args[0]); err == nil {
+			slowMode = parsed
+		} else {
+			return fmt.Sprintf("[%s] [Slow mode must be a number of seconds, a duration like 2m or off](fg:red)", time.Now().Format("01-02 15:04")), nil
+		}
+	}
+
+	payload, err := protocol.NewChannelPayloadBuilder().
+		SetRequester(c.name).
+		SetChannelAction(protocol.SetSlowMode).
+		SetChannelName(channelName).
+		AddOptionalArg("duration", slowMode).
+		Build()
+	if err != nil {
+		return "", err
+	}
+
+	if err := sendPayload(c, payload); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+func handleGetChannelBans(c *Client, channelName string) (string, error) {
+	payload, err := protocol.NewChannelPayloadBuilder().
+		SetRequester(c.name).
+		SetChannelAction(protocol.GetBans).
+		SetChannelName(channelName).
+		Build()
+	if err != nil {
+		return "", err
+	}
+
+	if err := sendPayload(c, payload); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+// handleInviteUser asks for a single-use invite code. With a username the code only works for them
+// and they're sent it, with "-" or nothing anyone holding the code can use it.
+func handleInviteUser(c *Client, channelName string, args []string) (string, error) {
+	target_user := protocol.EmptyChannelField
+	if len(args) > 0 {
+		target_user = args[0]
+	}
+	var expiry time.Duration
+	if len(args) > 1 {
+		parsed, err := time.ParseDuration(args[1])
+		if err != nil || parsed <= 0 {
+			return fmt.Sprintf("[%s] [Invite expiry must look like 30m or 24h](fg:red)", time.Now().Format("01-02 15:04")), nil
+		}
+		expiry = parsed
+	}
+
+	payload, err := protocol.NewChannelPayloadBuilder().
+		SetRequester(c.name).
+		SetChannelAction(protocol.InviteUser).
+		SetChannelName(channelName).
+		AddOptionalArg("target_user", target_user).
+		AddOptionalArg("duration", expiry).
+		Build()
+	if err != nil {
+		return "", err
+	}
+
+	if err := sendPayload(c, payload); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+func handleAcceptInvite(c *Client, channelName string, args []string) (string, error) {
+	if len(args) == 0 {
+		return fmt.Sprintf("[%s] [Usage: /ch accept <channelName> <code>](fg:red)", time.Now().Format("01-02 15:04")), nil
+	}
+
+	payload, err := protocol.NewChannelPayloadBuilder().
+		SetRequester(c.name).
+		SetChannelAction(protocol.AcceptInvite).
+		SetChannelName(channelName).
+		AddOptionalArg("invite_code", args[0]).
+		Build()
+	if err != nil {
+		return "", err
+	}
+
+	if err := sendPayload(c, payload); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+// handleSetMetadata sends the rest of the command as the new topic or description
+func handleSetMetadata(c *Client, channelName string, action protocol.ChannelActionType, key string, args []string) (string, error) {
+	text := strings.TrimSpace(strings.Join(args, " "))
+	if text == "" {
+		return fmt.Sprintf("[%s] [Usage: /ch %s <channelName> <text>](fg:red)", time.Now().Format("01-02 15:04"), key), nil
+	}
+
+	payload, err := protocol.NewChannelPayloadBuilder().
+		SetRequester(c.name).
+		SetChannelAction(action).
+		SetChannelName(channelName).
+		AddOptionalArg(key, text).
+		Build()
+	if err != nil {
+		return "", err
+	}
+
+	if err := sendPayload(c, payload); err != nil {
+		return "", err
+	}
+	return "", nil
+}
+
+// handleTargetUserAction sends the actions that only need a target user, like op, deop, transfer and unban
+func handleTargetUserAction(c *Client, channelName string, args []string, action protocol.ChannelActionType, request string) (string, error) {
+	var target_user string
+	if len(args) > 0 {
+		target_user = args[0]
+	}
+
+	payload, err := protocol.NewChannelPayloadBuilder().
+		SetRequester(c.name).
+		SetChannelAction(action).
+		SetChannelName(channelName).
+		AddOptionalArg("target_user", target_user).
+		Build()
+	if err != nil {
+		return "", err
+	}
+
+	if err := sendPayload(c, payload); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("[%s] [User '%s' has requested to %s](fg:magenta)",
+		time.Now().Format("01-02 15:04"), c.name, fmt.Sprintf(request, target_user)), nil
+}
+
+// HandleChReceive formats a channel payload, leftChannel is set when we are no longer in its channel
+func (c *Client) HandleChReceive(payload protocol.Payload) (msg string, leftChannel bool) {
 	//If received message is not a channel payload skip the rest
 	if payload.ChannelPayload == nil {
 		return "", false
@@ -319,7 +600,9 @@ func (c *Client) HandleChReceive(payload protocol.Payload) (msg string, shouldEx
 
 		case payload.ChannelPayload.ChannelAction == protocol.LeaveChannel &&
 			payload.ChannelPayload.OptionalChannelArgs.Status == protocol.StatusSuccess:
-			return "", true
+			return fmt.Sprintf("[%s] [You have left '%s'](fg:magenta)",
+				unixTimeUTC.Format("01-02 15:04"),
+				payload.ChannelPayload.ChannelName), true
 
 		case payload.ChannelPayload.ChannelAction == protocol.CloseChannel &&
 			payload.ChannelPayload.OptionalChannelArgs.Status == protocol.StatusSuccess:
@@ -329,16 +612,97 @@ func (c *Client) HandleChReceive(payload protocol.Payload) (msg string, shouldEx
 
 		case payload.ChannelPayload.ChannelAction == protocol.KickUser &&
 			payload.ChannelPayload.OptionalChannelArgs.Status == protocol.StatusSuccess:
-			return fmt.Sprintf("[%s] [You have been kicked by '%s'](fg:magenta)",
+			return fmt.Sprintf("[%s] [You have been kicked from '%s' by '%s'](fg:magenta)",
 				unixTimeUTC.Format("01-02 15:04"),
+				payload.ChannelPayload.ChannelName,
 				payload.ChannelPayload.Requester), true
 
 		case payload.ChannelPayload.ChannelAction == protocol.BanUser &&
 			payload.ChannelPayload.OptionalChannelArgs.Status == protocol.StatusSuccess:
-			return fmt.Sprintf("[%s] [You have been banned by '%s'](fg:magenta)",
+			if duration := payload.ChannelPayload.OptionalChannelArgs.Duration; duration > 0 {
+				return fmt.Sprintf("[%s] [You have been banned from '%s' by '%s' for %s](fg:magenta)",
+					unixTimeUTC.Format("01-02 15:04"),
+					payload.ChannelPayload.ChannelName,
+					payload.ChannelPayload.Requester, duration), true
+			}
+			return fmt.Sprintf("[%s] [You have been banned from '%s' by '%s'](fg:magenta)",
 				unixTimeUTC.Format("01-02 15:04"),
+				payload.ChannelPayload.ChannelName,
 				payload.ChannelPayload.Requester), true
 
+		case payload.ChannelPayload.ChannelAction == protocol.OpUser:
+			message = fmt.Sprintf("[%s] ['%s' is now a moderator](fg:magenta)",
+				unixTimeUTC.Format("01-02 15:04"),
+				payload.ChannelPayload.OptionalChannelArgs.TargetUser)
+
+		case payload.ChannelPayload.ChannelAction == protocol.DeopUser:
+			message = fmt.Sprintf("[%s] ['%s' is no longer a moderator](fg:magenta)",
+				unixTimeUTC.Format("01-02 15:04"),
+				payload.ChannelPayload.OptionalChannelArgs.TargetUser)
+
+		case payload.ChannelPayload.ChannelAction == protocol.TransferChannel:
+			message = fmt.Sprintf("[%s] ['%s' is now the owner](fg:magenta)",
+				unixTimeUTC.Format("01-02 15:04"),
+				payload.ChannelPayload.OptionalChannelArgs.TargetUser)
+
+		case payload.ChannelPayload.ChannelAction == protocol.MuteUser:
+			if duration := payload.ChannelPayload.OptionalChannelArgs.Duration; duration > 0 {
+				message = fmt.Sprintf("[%s] ['%s' has been muted for %s](fg:magenta)",
+					unixTimeUTC.Format("01-02 15:04"),
+					payload.ChannelPayload.OptionalChannelArgs.TargetUser, duration)
+			} else {
+				message = fmt.Sprintf("[%s] ['%s' has been muted](fg:magenta)",
+					unixTimeUTC.Format("01-02 15:04"),
+					payload.ChannelPayload.OptionalChannelArgs.TargetUser)
+			}
+
+		case payload.ChannelPayload.ChannelAction == protocol.UnmuteUser:
+			message = fmt.Sprintf("[%s] ['%s' has been unmuted](fg:magenta)",
+				unixTimeUTC.Format("01-02 15:04"),
+				payload.ChannelPayload.OptionalChannelArgs.TargetUser)
+
+		case payload.ChannelPayload.ChannelAction == protocol.SetSlowMode:
+			if slowMode := payload.ChannelPayload.OptionalChannelArgs.Duration; slowMode > 0 {
+				message = fmt.Sprintf("[%s] [Slow mode is on, members can send one message every %s](fg:magenta)",
+					unixTimeUTC.Format("01-02 15:04"), slowMode)
+			} else {
+				message = fmt.Sprintf("[%s] [Slow mode has been turned off](fg:magenta)",
+					unixTimeUTC.Format("01-02 15:04"))
+			}
+
+		case payload.ChannelPayload.ChannelAction == protocol.ResizeChannel:
+			message = fmt.Sprintf("[%s] [Channel size changed to %d](fg:magenta)",
+				unixTimeUTC.Format("01-02 15:04"),
+				payload.ChannelPayload.ChannelSize)
+
+		case payload.ChannelPayload.ChannelAction == protocol.WaitlistChannel:
+			message = fmt.Sprintf("[%s] [%s](fg:magenta)",
+				unixTimeUTC.Format("01-02 15:04"),
+				payload.ChannelPayload.OptionalChannelArgs.Notice)
+
+		case payload.ChannelPayload.ChannelAction == protocol.UnbanUser:
+			message = fmt.Sprintf("[%s] ['%s' has been unbanned](fg:magenta)",
+				unixTimeUTC.Format("01-02 15:04"),
+				payload.ChannelPayload.OptionalChannelArgs.TargetUser)
+
+		case payload.ChannelPayload.ChannelAction == protocol.GetBans:
+			message = fmt.Sprintf("[%s] [%s](fg:magenta)",
+				unixTimeUTC.Format("01-02 15:04"),
+				formatChannelBans(payload.ChannelPayload.OptionalChannelArgs.Bans))
+
+		case payload.ChannelPayload.ChannelAction == protocol.InviteUser:
+			message = c.formatChannelInvite(payload)
+
+		case payload.ChannelPayload.ChannelAction == protocol.SetTopic:
+			message = fmt.Sprintf("[%s] [Topic changed to '%s'](fg:magenta)",
+				unixTimeUTC.Format("01-02 15:04"),
+				payload.ChannelPayload.OptionalChannelArgs.Topic)
+
+		case payload.ChannelPayload.ChannelAction == protocol.SetDescription:
+			message = fmt.Sprintf("[%s] [Description changed to '%s'](fg:magenta)",
+				unixTimeUTC.Format("01-02 15:04"),
+				payload.ChannelPayload.OptionalChannelArgs.Description)
+
 		case payload.ChannelPayload.ChannelAction == protocol.MessageChannel:
 			//Message Channel
 			message = fmt.Sprintf("[%s] [%s: %s](fg:green)",
@@ -373,3 +737,103 @@ func (c *Client) HandleChReceive(payload protocol.Payload) (msg string, shouldEx
 	}
 	return message, false
 }
+
+func formatChannelBans(bans []protocol.ChannelBan) string {
+	if len(bans) == 0 {
+		return "No one is banned from this channel"
+	}
+	entries := make([]string, 0, len(bans))
+	for _, ban := range bans {
+		until := "permanently"
+		if ban.ExpiresAt != 0 {
+			until = "until " + time.Unix(ban.ExpiresAt, 0).Format("01-02 15:04")
+		}
+		entries = append(entries, fmt.Sprintf("'%s' banned by '%s' on %s %s",
+			ban.Username, ban.BannedBy, time.Unix(ban.BannedAt, 0).Format("01-02 15:04"), until))
+	}
+	return "Bans: " + strings.Join(entries, fmt.Sprintf("%s ", protocol.OptionalUserAndChannelsSeparator))
+}
+
+// formatChannelInvite tells the invited user how to accept, and the inviter which code was created
+func (c *Client) formatChannelInvite(payload protocol.Payload) string {
+	args := payload.ChannelPayload.OptionalChannelArgs
+	chName := payload.ChannelPayload.ChannelName
+	unixTimeUTC := time.Unix(payload.Timestamp, 0)
+	switch args.TargetUser {
+	case c.name:
+		return fmt.Sprintf("[%s] ['%s' invited you to '%s', join with /ch accept %s %s](fg:cyan)",
+			unixTimeUTC.Format("01-02 15:04"), payload.ChannelPayload.Requester, chName, chName, args.InviteCode)
+	case "":
+		return fmt.Sprintf("[%s] [Invite code for '%s' is %s, it can be used once within %s](fg:magenta)",
+			unixTimeUTC.Format("01-02 15:04"), chName, args.InviteCode, args.Duration)
+	default:
+		return fmt.Sprintf("[%s] [Invited '%s' to '%s' with code %s, valid for %s](fg:magenta)",
+			unixTimeUTC.Format("01-02 15:04"), args.TargetUser, chName, args.InviteCode, args.Duration)
+	}
+}
+
+// TopicChange reports the new topic when the payload is a topic change, by us or someone else
+func (c *Client) TopicChange(payload protocol.Payload) (string, bool) {
+	if payload.MessageType != protocol.MessageTypeCH ||
+		payload.ChannelPayload == nil ||
+		payload.ChannelPayload.OptionalChannelArgs == nil ||
+		payload.ChannelPayload.OptionalChannelArgs.Status != protocol.StatusSuccess ||
+		payload.ChannelPayload.OptionalChannelArgs.Topic == "" {
+		return "", false
+	}
+	action := payload.ChannelPayload.ChannelAction
+	if action != protocol.SetTopic && action != protocol.NoticeChannel {
+		return "", false
+	}
+	if ch, exists := c.channels[payload.ChannelPayload.ChannelName]; exists {
+		ch.Topic = payload.ChannelPayload.OptionalChannelArgs.Topic
+	}
+	return payload.ChannelPayload.OptionalChannelArgs.Topic, true
+}
+
+// ChannelDirectory renders a successful channel listing as table rows, one chat line each
+func (c *Client) ChannelDirectory(payload protocol.Payload) ([]string, bool) {
+	if payload.MessageType != protocol.MessageTypeCH ||
+		payload.ChannelPayload == nil ||
+		payload.ChannelPayload.ChannelAction != protocol.GetChannels ||
+		payload.ChannelPayload.OptionalChannelArgs == nil ||
+		payload.ChannelPayload.OptionalChannelArgs.Status != protocol.StatusSuccess {
+		return nil, false
+	}
+	args := payload.ChannelPayload.OptionalChannelArgs
+	unixTimeUTC := time.Unix(payload.Timestamp, 0)
+	if len(args.Channels) == 0 {
+		return []string{fmt.Sprintf("[%s] [No channels found](fg:magenta)", unixTimeUTC.Format("01-02 15:04"))}, true
+	}
+	// Servers that don't send summaries only list the names
+	if len(args.Summaries) == 0 {
+		return []string{fmt.Sprintf("[%s] [%s](fg:magenta)",
+			unixTimeUTC.Format("01-02 15:04"),
+			strings.Join(args.Channels, fmt.Sprintf("%s ", protocol.OptionalUserAndChannelsSeparator)))}, true
+	}
+
+	const row = "%-16s %-7s %-12s %-4s %-11s %s"
+	rows := []string{fmt.Sprintf("[%s](fg:cyan,mod:bold)", fmt.Sprintf(row, "NAME", "USERS", "OWNER", "PASS", "ACTIVE", "TOPIC"))}
+	for _, summary := range args.Summaries {
+		protected := "no"
+		if summary.Protected {
+			protected = "yes"
+		}
+		rows = append(rows, fmt.Sprintf("[%s](fg:magenta)", fmt.Sprintf(row,
+			truncate(summary.Name, 16),
+			fmt.Sprintf("%d/%d", summary.Members, summary.Capacity),
+			truncate(summary.Owner, 12),
+			protected,
+			time.Unix(summary.LastActivity, 0).Format("01-02 15:04"),
+			truncate(summary.Topic, 40))))
+	}
+	return rows, true
+}
+
+func truncate(text string, max int) string {
+	runes := []rune(text)
+	if len(runes) <= max {
+		return text
+	}
+	return string(runes[:max-1]) + "…"
+}
@@ -1,21 +1,28 @@
 package internal
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
 	"log"
 	"net"
 	"slices"
+	"sort"
+	"sync"
 
 	"github.com/ogzhanolguncu/go-chat/protocol"
 )
 
 type ChannelInfo struct {
-	ChName     string
-	ChPassword string
+	ChName      string
+	Topic       string
+	Description string
 }
 type Client struct {
 	conn                       net.Conn
+	reader                     *bufio.Reader      // Shared by all readers, so nothing buffered is lost when switching UIs
+	pending                    []protocol.Payload // Read by a reader that got canceled, handed to the next one
+	pendingLock                sync.Mutex
 	config                     Config
 	name                       string
 	lastWhispererFromGroupChat string
@@ -25,7 +32,10 @@ type Client struct {
 
 	mutedUsers []string
 
-	chInfo *ChannelInfo
+	channels map[string]*ChannelInfo // Every channel we are in, each one gets its own tab
+
+	sessionToken string
+	resumed      bool // Set when the last connection was authenticated by resuming a session
 }
 
 func NewClient(config Config) (*Client, error) {
@@ -44,6 +54,7 @@ func NewClient(config Config) (*Client, error) {
 		config:   config,
 		decodeFn: protocol.InitDecodeProtocol(*encoding),
 		encodeFn: protocol.InitEncodeProtocol(*encoding),
+		channels: make(map[string]*ChannelInfo),
 	}, nil
 }
 
@@ -53,6 +64,7 @@ func (c *Client) Connect() error {
 		return err
 	}
 	c.conn = conn
+	c.reader = bufio.NewReader(conn)
 	return nil
 }
 
@@ -108,14 +120,37 @@ func (c *Client) CheckIfSuccessfulChannel(payload protocol.Payload) bool {
 	return false
 }
 
-func (c *Client) SetChannelInfo(ch ChannelInfo) {
-	// If room is passwordless we use placeholder instead to simplify request payload parsing
-	if ch.ChPassword == "" {
-		ch.ChPassword = protocol.EmptyChannelField
+func (c *Client) AddChannel(ch ChannelInfo) {
+	c.channels[ch.ChName] = &ch
+}
+
+func (c *Client) RemoveChannel(chName string) {
+	delete(c.channels, chName)
+}
+
+func (c *Client) GetChannelInfo(chName string) (ChannelInfo, bool) {
+	ch, exists := c.channels[chName]
+	if !exists {
+		return ChannelInfo{}, false
 	}
-	c.chInfo = &ch
+	return *ch, true
+}
+
+// JoinedChannels returns the names of the channels we are in, sorted
+func (c *Client) JoinedChannels() []string {
+	names := make([]string, 0, len(c.channels))
+	for name := range c.channels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ResetChannels forgets every channel, a resumed session is told again which ones it is still in
+func (c *Client) ResetChannels() {
+	c.channels = make(map[string]*ChannelInfo)
 }
 
-func (c *Client) GetChannelInfo() ChannelInfo {
-	return *c.chInfo
+func (c *Client) GetLastWhisperer() string {
+	return c.lastWhispererFromGroupChat
 }
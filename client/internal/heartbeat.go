@@ -0,0 +1,19 @@
+package internal
+
+import (
+	"time"
+
+	"github.com/ogzhanolguncu/go-chat/protocol"
+)
+
+const (
+	// pingInterval keeps the connection well within the server's heartbeat timeout
+	pingInterval = 30 * time.Second
+	// serverTimeout is how long the client waits for any frame before it considers the server gone
+	serverTimeout = 75 * time.Second
+)
+
+func (c *Client) sendPing() error {
+	_, err := c.conn.Write([]byte(c.encodeFn(protocol.Payload{MessageType: protocol.MessageTypePING})))
+	return err
+}
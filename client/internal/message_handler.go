@@ -1,7 +1,6 @@
 package internal
 
 import (
-	"bufio"
 	"context"
 	"fmt"
 	"io"
@@ -47,7 +46,16 @@ func (c *Client) preparePublicMessagePayload(message, sender string) string {
 //RECEIVER
 
 func (c *Client) ReadMessages(ctx context.Context, incomingChan chan<- protocol.Payload, errorChan chan error) {
-	reader := bufio.NewReader(c.conn)
+	reader := c.reader
+	// Frames the previous UI read but never got to handle, e.g. channel history right after joining
+	for _, payload := range c.takePending() {
+		select {
+		case incomingChan <- payload:
+		case <-ctx.Done():
+		}
+	}
+	// Pings keep the server from dropping us, and its answers prove a silent connection is still alive
+	lastPing, lastReceived := time.Now(), time.Now()
 	for {
 		select {
 		case <-ctx.Done():
@@ -56,6 +64,19 @@ func (c *Client) ReadMessages(ctx context.Context, incomingChan chan<- protocol.
 			close(errorChan)    // Close channel to signal the end of error reporting
 			return
 		default:
+			if time.Since(lastPing) >= pingInterval {
+				if err := c.sendPing(); err != nil {
+					errorChan <- io.EOF
+					return
+				}
+				lastPing = time.Now()
+			}
+			if time.Since(lastReceived) >= serverTimeout {
+				// Server hasn't answered our pings, connection is half-open
+				errorChan <- io.EOF
+				return
+			}
+
 			// Set a deadline for the read operation
 			err := c.conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
 			if err != nil {
@@ -78,17 +99,39 @@ func (c *Client) ReadMessages(ctx context.Context, incomingChan chan<- protocol.
 				continue
 			}
 
+			lastReceived = time.Now()
+
 			payload, err := c.decodeFn(message)
 			if err != nil {
 				// Client keep reading it payload is broken, its safe
 				continue
 			}
+			if payload.MessageType == protocol.MessageTypePONG {
+				continue
+			}
 
-			incomingChan <- payload
+			select {
+			case incomingChan <- payload:
+			case <-ctx.Done():
+				// UI switched while this was read, the next reader gets it
+				c.pendingLock.Lock()
+				c.pending = append(c.pending, payload)
+				c.pendingLock.Unlock()
+			}
 		}
 	}
 }
 
+// takePending returns and clears the frames left over by the previous reader
+func (c *Client) takePending() []protocol.Payload {
+	c.pendingLock.Lock()
+	defer c.pendingLock.Unlock()
+
+	pending := c.pending
+	c.pending = nil
+	return pending
+}
+
 func (c *Client) HandleSend(userInput string) (string, error) {
 	if !strings.HasPrefix(userInput, "/") {
 		if _, err := c.conn.Write([]byte(c.preparePublicMessagePayload(userInput, c.name))); err != nil {
@@ -101,6 +144,10 @@ func (c *Client) HandleSend(userInput string) (string, error) {
 	switch parts[0] {
 	case "/ch":
 		return chMessageHandler(parts, c)
+	case "/passwd", "/deleteaccount":
+		return accountMessageHandler(parts, c)
+	case "/admin":
+		return adminMessageHandler(parts, c)
 	case "/whisper":
 		if len(parts) < 3 {
 			return fmt.Sprintf("[%s] [%s](fg:red)", time.Now().Format("01-02 15:04"), "Usage: /whisper <recipient> <message>"), nil
@@ -171,19 +218,12 @@ func (c *Client) HandleReceive(payload protocol.Payload) string {
 	unixTimeUTC := time.Unix(payload.Timestamp, 0)
 	switch payload.MessageType {
 	case protocol.MessageTypeCH:
-		// This is the only ch receive part we need here. Because before joining a channel we have to make sure our join or create request is successful.
-		//Get Users
-		if payload.ChannelPayload.ChannelAction == protocol.GetChannels &&
-			payload.ChannelPayload.OptionalChannelArgs != nil &&
-			payload.ChannelPayload.OptionalChannelArgs.Channels != nil &&
-			payload.ChannelPayload.OptionalChannelArgs.Status == protocol.StatusSuccess {
-			return fmt.Sprintf("[%s] [%s](fg:magenta)",
-				unixTimeUTC.Format("01-02 15:04"),
-				strings.Join(payload.ChannelPayload.OptionalChannelArgs.Channels, fmt.Sprintf("%s ", protocol.OptionalUserAndChannelsSeparator)))
-		}
-
+		// Only channel payloads that don't belong to a channel tab end up here, e.g. a failed join or an invite to a channel we're not in.
+		// Channel listings are rendered by ChannelDirectory as they take several lines
 		if payload.ChannelPayload.OptionalChannelArgs.Status == protocol.StatusFail {
 			message = fmt.Sprintf("[%s] [%s](fg:red)", unixTimeUTC.Format("01-02 15:04"), payload.ChannelPayload.OptionalChannelArgs.Reason)
+		} else if payload.ChannelPayload.ChannelAction == protocol.InviteUser {
+			message = c.formatChannelInvite(payload)
 		}
 	case protocol.MessageTypeMSG:
 		// If the sender is the current user, display "You" instead of the username
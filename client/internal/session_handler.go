@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ogzhanolguncu/go-chat/protocol"
+)
+
+const resumeTimeout = 5 * time.Second
+
+func (c *Client) SetSessionToken(token string) {
+	c.sessionToken = token
+}
+
+// ConsumeResumed reports whether the current connection was resumed from an earlier session, only once
+func (c *Client) ConsumeResumed() bool {
+	resumed := c.resumed
+	c.resumed = false
+	return resumed
+}
+
+// ResumeSession tries to continue the previous session after a reconnect.
+// Returns false when there is no session to resume or the server rejected it, so the user has to log in again.
+func (c *Client) ResumeSession() (bool, error) {
+	if c.sessionToken == "" {
+		return false, nil
+	}
+
+	if _, err := c.conn.Write([]byte(c.encodeFn(protocol.Payload{
+		MessageType:  protocol.MessageTypeSESS,
+		Username:     c.name,
+		SessionToken: c.sessionToken,
+		Status:       protocol.SessionStatusResume,
+	}))); err != nil {
+		return false, fmt.Errorf("error sending session resume: %w", err)
+	}
+
+	if err := c.conn.SetReadDeadline(time.Now().Add(resumeTimeout)); err != nil {
+		return false, fmt.Errorf("failed to set read deadline: %w", err)
+	}
+	defer c.conn.SetReadDeadline(time.Time{})
+
+	message, err := c.reader.ReadString('\n')
+	if err != nil {
+		return false, err
+	}
+
+	payload, err := c.decodeFn(message)
+	if err != nil || payload.MessageType != protocol.MessageTypeSESS || payload.Status != protocol.SessionStatusIssued {
+		c.sessionToken = ""
+		return false, nil
+	}
+
+	c.sessionToken = payload.SessionToken
+	c.name = payload.Username
+	c.resumed = true
+	return true, nil
+}
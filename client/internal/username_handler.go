@@ -6,8 +6,9 @@ import (
 	"github.com/ogzhanolguncu/go-chat/protocol"
 )
 
-func (c *Client) SendUsernameReq(username, password string) error {
-	if _, err := c.conn.Write([]byte(c.encodeFn(protocol.Payload{MessageType: protocol.MessageTypeUSR, Password: password, Username: username}))); err != nil {
+// SendUsernameReq sends credentials to server, action is either protocol.AuthActionLogin or protocol.AuthActionRegister
+func (c *Client) SendUsernameReq(username, password, action string) error {
+	if _, err := c.conn.Write([]byte(c.encodeFn(protocol.Payload{MessageType: protocol.MessageTypeUSR, Password: password, Username: username, Status: action}))); err != nil {
 		return fmt.Errorf("error sending username to server: %w", err)
 	}
 	return nil
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -12,15 +13,21 @@ import (
 )
 
 func main() {
+	// Client outlives reconnects so the session can be resumed without going through login again
+	client, err := internal.NewClient(internal.NewConfig())
+	if err != nil {
+		log.Fatalf("Failed to create client: %v", err)
+	}
+
 	retry.Do(
 		func() error {
-			return runClient()
+			return runClient(client)
 		},
 		retry.Attempts(5),
 		retry.Delay(time.Second),
 		retry.DelayType(retry.BackOffDelay),
 		retry.OnRetry(func(n uint, err error) {
-			if err.Error() == io.EOF.Error() {
+			if errors.Is(err, io.EOF) {
 				err = fmt.Errorf("server is not responding")
 			}
 			fmt.Printf("Trying to reconnect, but %v\n", err)
@@ -28,44 +35,34 @@ func main() {
 	)
 }
 
-func runClient() error {
-	client, err := internal.NewClient(internal.NewConfig())
-	if err != nil {
-		return fmt.Errorf("failed to create client: %v", err)
-	}
+func runClient(client *internal.Client) error {
 	defer client.Close()
 
 	if err := client.Connect(); err != nil {
-		return fmt.Errorf("failed to connect server: %v", err)
+		return fmt.Errorf("failed to connect server: %w", err)
 	}
 
-	if err := manageUIs(client); err != nil {
-		log.Fatalf("Error managing UIs: %v", err)
-	}
-	return nil
+	// Errors bubble up to retry, so a dropped connection reconnects and resumes the session
+	return manageUIs(client)
 }
 
 func manageUIs(client *internal.Client) error {
-	terminate, err := ui_manager.HandleLoginUI(client)
-	if terminate {
-		return nil
-	}
+	resumed, err := client.ResumeSession()
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to resume session: %w", err)
 	}
-	for {
-		switchToChannel, err := ui_manager.HandleChatUI(client)
-		if err != nil {
-			return fmt.Errorf("error in chat UI: %v", err)
-		}
-
-		if switchToChannel {
-			if err := ui_manager.HandleChannelUI(client); err != nil {
-				return fmt.Errorf("error in channel UI: %v", err)
-			}
-		} else {
-			// User wants to quit the application
+	if !resumed {
+		terminate, err := ui_manager.HandleLoginUI(client)
+		if terminate {
 			return nil
 		}
+		if err != nil {
+			return err
+		}
 	}
+	if err := ui_manager.HandleChatUI(client); err != nil {
+		return fmt.Errorf("error in chat UI: %w", err)
+	}
+	// User wants to quit the application
+	return nil
 }
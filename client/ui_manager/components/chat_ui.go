@@ -2,8 +2,8 @@ package ui_manager
 
 import (
 	"fmt"
-
 	"strings"
+	"time"
 
 	ui "github.com/gizak/termui/v3"
 	"github.com/gizak/termui/v3/widgets"
@@ -12,8 +12,8 @@ import (
 type ChatUI struct {
 	inputMode            bool
 	userListScrollOffset int
-	chatScrollOffset     int
-	chatMessages         []string
+	tabs                 []*Tab // The main chat is always the first one
+	activeTab            int
 	rawChatMessages      []string // Required for input history
 	currentUserName      string
 	cursorVisible        bool
@@ -23,9 +23,9 @@ type ChatUI struct {
 func NewChatUI(username string) *ChatUI {
 	return &ChatUI{
 		userListScrollOffset: 0,
-		chatScrollOffset:     0,
 		inputMode:            true,
-		chatMessages:         []string{},
+		tabs:                 []*Tab{newTab(MainTab, "")},
+		activeTab:            0,
 		rawChatMessages:      []string{},
 		currentUserName:      username,
 		cursorVisible:        true,
@@ -33,27 +33,35 @@ func NewChatUI(username string) *ChatUI {
 	}
 }
 
-func (cu *ChatUI) InitUI() (header *widgets.Paragraph, commandBox *widgets.Paragraph, chatBox *widgets.Paragraph, inputBox *widgets.Paragraph, userList *widgets.List, err error) {
+func (cu *ChatUI) InitUI() (tabBar *widgets.TabPane, header *widgets.Paragraph, commandBox *widgets.Paragraph, chatBox *widgets.Paragraph, inputBox *widgets.Paragraph, userList *widgets.List, err error) {
 	if err := ui.Init(); err != nil {
-		return nil, nil, nil, nil, nil, fmt.Errorf("failed to initialize termui: %v", err)
+		return nil, nil, nil, nil, nil, nil, fmt.Errorf("failed to initialize termui: %v", err)
 	}
-	p1, p2, l1, p3, l2 := cu.prepareUIItems()
-	return p1, p2, l1, p3, l2, nil
+	t1, p1, p2, p3, p4, l1 := cu.prepareUIItems()
+	return t1, p1, p2, p3, p4, l1, nil
 }
 
-func (cu *ChatUI) Draw(header *widgets.Paragraph, commandBox *widgets.Paragraph, chatBox *widgets.Paragraph, inputBox *widgets.Paragraph, userList *widgets.List) func() {
+func (cu *ChatUI) Draw(tabBar *widgets.TabPane, header *widgets.Paragraph, commandBox *widgets.Paragraph, chatBox *widgets.Paragraph, inputBox *widgets.Paragraph, userList *widgets.List) func() {
 	return func() {
-		ui.Render(header, commandBox, chatBox, inputBox, userList)
+		// Commands are only listed on the main chat, other tabs use the space for messages
+		if cu.ActiveTab().Kind == MainTab {
+			ui.Render(tabBar, header, commandBox, chatBox, inputBox, userList)
+			return
+		}
+		ui.Render(tabBar, header, chatBox, inputBox, userList)
 	}
 }
 
-func (cu *ChatUI) prepareUIItems() (header *widgets.Paragraph, commandBox *widgets.Paragraph, chatBox *widgets.Paragraph, inputBox *widgets.Paragraph, userList *widgets.List) {
-	termWidth, termHeight := ui.TerminalDimensions()
+func (cu *ChatUI) prepareUIItems() (tabBar *widgets.TabPane, header *widgets.Paragraph, commandBox *widgets.Paragraph, chatBox *widgets.Paragraph, inputBox *widgets.Paragraph, userList *widgets.List) {
+	// Tab Bar
+	tabBar = widgets.NewTabPane()
+	tabBar.Border = true
+	tabBar.BorderStyle.Fg = ui.ColorCyan
+	tabBar.ActiveTabStyle = ui.NewStyle(ui.ColorBlack, ui.ColorYellow)
+	tabBar.InactiveTabStyle = ui.NewStyle(ui.ColorWhite)
 
 	// Header
 	header = widgets.NewParagraph()
-	header.Text = fmt.Sprintf("Welcome to chatroom, %s", cu.currentUserName)
-	header.SetRect(0, 0, termWidth, 3)
 	header.Border = true
 	header.TextStyle.Fg = ui.ColorYellow
 	header.BorderStyle.Fg = ui.ColorCyan
@@ -62,18 +70,19 @@ func (cu *ChatUI) prepareUIItems() (header *widgets.Paragraph, commandBox *widge
 	commandBox = widgets.NewParagraph()
 	commandBox.Title = "Commands"
 	commandBox.Text = "General:\n" +
-		"  /clear - Clear chat                                  |  /quit - Exit app\n\n" +
+		"  /clear - Clear chat                                  |  /quit - Exit app\n" +
+		"  <Tab> or <C-n> - Next tab                            |  <C-p> - Previous tab\n\n" +
 		"User Interactions:\n" +
-		"  /whisper <username> <message> - Send PM              |  /reply <message> - Reply to last PM\n" +
+		"  /whisper <username> <message> - Send PM in a new tab |  /reply <message> - Reply to last PM\n" +
 		"  /mute <username> - Hide messages                     |  /unmute <username> - Show messages\n" +
 		"  /block <username> - Block user                       |  /unblock <username> - Unblock user\n\n" +
 		"Channel Commands:\n" +
-		"  /ch create <name> <password> <max_users> <public|private> - Create channel\n" +
-		"  /ch join <name> <password> - Join channel            |  /ch leave - Leave current channel\n" +
-		"  /ch users - List users in current channel            |  /ch list - Show active channels\n" +
+		"  /ch create <name> <password> <max_users> <public|private> [archive] [waitlist] [idle timeout] - Create channel\n" +
+		"  /ch join <name> <password> - Join channel            |  /ch leave <name> - Leave channel\n" +
+		"  /ch users <name> - List users in channel             |  /ch list - Show active channels\n" +
+		"  In a channel tab messages go to the channel and /quit leaves it, /close closes a whisper tab\n" +
 		"Channel Owner Commands:\n" +
-		"  /ch kick <username> - Kick user from channel         |  /ch ban <username> - Ban user from channel"
-	commandBox.SetRect(0, 3, termWidth*3/4, 19)
+		"  /ch kick <name> <username> - Kick user from channel  |  /ch ban <name> <username> - Ban user from channel"
 	commandBox.Border = true
 	commandBox.TitleStyle.Fg = ui.ColorYellow
 	commandBox.BorderStyle.Fg = ui.ColorCyan
@@ -83,7 +92,6 @@ func (cu *ChatUI) prepareUIItems() (header *widgets.Paragraph, commandBox *widge
 	// Chat Box
 	chatBox = widgets.NewParagraph()
 	chatBox.Title = "Chat Messages"
-	chatBox.SetRect(0, 19, termWidth*3/4, termHeight-3)
 	chatBox.BorderStyle.Fg = ui.ColorCyan
 	chatBox.TitleStyle.Fg = ui.ColorYellow
 	chatBox.WrapText = true
@@ -91,7 +99,6 @@ func (cu *ChatUI) prepareUIItems() (header *widgets.Paragraph, commandBox *widge
 	// Input Box
 	inputBox = widgets.NewParagraph()
 	inputBox.Title = "Type your message"
-	inputBox.SetRect(0, termHeight-3, termWidth, termHeight)
 	inputBox.TextStyle.Fg = ui.ColorWhite
 	inputBox.BorderStyle.Fg = ui.ColorCyan
 	inputBox.TitleStyle.Fg = ui.ColorYellow
@@ -102,18 +109,153 @@ func (cu *ChatUI) prepareUIItems() (header *widgets.Paragraph, commandBox *widge
 	userList.Rows = nil
 	userList.TextStyle = ui.NewStyle(ui.ColorWhite)
 	userList.WrapText = false
-	userList.SetRect(termWidth*3/4, 3, termWidth, termHeight-3)
 	userList.BorderStyle.Fg = ui.ColorCyan
 	userList.TitleStyle.Fg = ui.ColorYellow
 	userList.SelectedRowStyle = ui.NewStyle(ui.ColorBlack, ui.ColorYellow)
 
-	return header, commandBox, chatBox, inputBox, userList
+	cu.layout(tabBar, header, commandBox, chatBox, inputBox, userList)
+	cu.RenderTabs(tabBar)
+	cu.UpdateHeader(header)
+	return tabBar, header, commandBox, chatBox, inputBox, userList
+}
+
+// layout sizes the widgets for the active tab, the chat box takes the command box's place outside the main chat
+func (cu *ChatUI) layout(tabBar *widgets.TabPane, header *widgets.Paragraph, commandBox *widgets.Paragraph, chatBox *widgets.Paragraph, inputBox *widgets.Paragraph, userList *widgets.List) {
+	termWidth, termHeight := ui.TerminalDimensions()
+
+	tabBar.SetRect(0, 0, termWidth, 3)
+	header.SetRect(0, 3, termWidth, 6)
+	commandBox.SetRect(0, 6, termWidth*3/4, 23)
+	if cu.ActiveTab().Kind == MainTab {
+		chatBox.SetRect(0, 23, termWidth*3/4, termHeight-3)
+	} else {
+		chatBox.SetRect(0, 6, termWidth*3/4, termHeight-3)
+	}
+	inputBox.SetRect(0, termHeight-3, termWidth, termHeight)
+	userList.SetRect(termWidth*3/4, 6, termWidth, termHeight-3)
+}
+
+func (cu *ChatUI) ActiveTab() *Tab {
+	return cu.tabs[cu.activeTab]
+}
+
+func (cu *ChatUI) FindTab(kind TabKind, name string) *Tab {
+	for _, tab := range cu.tabs {
+		if tab.Kind == kind && tab.Name == name {
+			return tab
+		}
+	}
+	return nil
+}
+
+// OpenTab returns the tab of the conversation, adding it to the end of the tab bar if it's not there yet
+func (cu *ChatUI) OpenTab(kind TabKind, name string) *Tab {
+	if tab := cu.FindTab(kind, name); tab != nil {
+		return tab
+	}
+	tab := newTab(kind, name)
+	cu.tabs = append(cu.tabs, tab)
+	return tab
+}
+
+// CloseTab removes the tab, the main chat is shown if it was the active one. The main chat can't be closed.
+func (cu *ChatUI) CloseTab(tab *Tab) {
+	for i, t := range cu.tabs {
+		if t != tab || t.Kind == MainTab {
+			continue
+		}
+		cu.tabs = append(cu.tabs[:i], cu.tabs[i+1:]...)
+		if cu.activeTab == i {
+			cu.activeTab = 0
+		} else if cu.activeTab > i {
+			cu.activeTab--
+		}
+		return
+	}
+}
+
+func (cu *ChatUI) SelectTab(tab *Tab) {
+	for i, t := range cu.tabs {
+		if t == tab {
+			cu.activeTab = i
+			t.unread = 0
+			return
+		}
+	}
 }
+
+// SwitchTab moves to the next tab for a positive direction and the previous one otherwise, wrapping around
+func (cu *ChatUI) SwitchTab(direction int) {
+	next := (cu.activeTab + direction + len(cu.tabs)) % len(cu.tabs)
+	cu.SelectTab(cu.tabs[next])
+}
+
+// ShowActiveTab redraws everything that depends on the active tab, call it after switching, opening or closing tabs
+func (cu *ChatUI) ShowActiveTab(tabBar *widgets.TabPane, header *widgets.Paragraph, commandBox *widgets.Paragraph, chatBox *widgets.Paragraph, inputBox *widgets.Paragraph, userList *widgets.List) {
+	cu.layout(tabBar, header, commandBox, chatBox, inputBox, userList)
+	cu.ScrollChatBox(chatBox, len(cu.ActiveTab().messages))
+	cu.RenderTabs(tabBar)
+	cu.UpdateHeader(header)
+	ui.Clear()
+}
+
+func (cu *ChatUI) RenderTabs(tabBar *widgets.TabPane) {
+	titles := make([]string, 0, len(cu.tabs))
+	for _, tab := range cu.tabs {
+		titles = append(titles, tab.title())
+	}
+	tabBar.TabNames = titles
+	tabBar.ActiveTabIndex = cu.activeTab
+}
+
+func (cu *ChatUI) UpdateHeader(header *widgets.Paragraph) {
+	tab := cu.ActiveTab()
+	switch tab.Kind {
+	case MainTab:
+		header.Text = fmt.Sprintf("Welcome to chatroom, %s", cu.currentUserName)
+		return
+	case WhisperTab:
+		header.Text = fmt.Sprintf("Whispering with: %s | User: %s", tab.Name, cu.currentUserName)
+		return
+	}
+
+	headerText := fmt.Sprintf("Channel: %s | User: %s", tab.Name, cu.currentUserName)
+	if tab.topic != "" {
+		headerText = fmt.Sprintf("Channel: %s | Topic: %s | User: %s", tab.Name, tab.topic, cu.currentUserName)
+	}
+	if typingText := tab.typingText(cu.currentUserName); typingText != "" {
+		header.Text = fmt.Sprintf("%s | [%s](fg:yellow,mod:bold)", headerText, typingText)
+	} else {
+		header.Text = headerText
+	}
+}
+
+// ExpireTypingUsers drops stale typing indicators of every tab, reports whether the active tab's header changed
+func (cu *ChatUI) ExpireTypingUsers() bool {
+	now := time.Now()
+	changed := false
+	for i, tab := range cu.tabs {
+		if tab.expireTypingUsers(now) && i == cu.activeTab {
+			changed = true
+		}
+	}
+	return changed
+}
+
 func (cu *ChatUI) UpdateChatBox(input string, chatBox *widgets.Paragraph) {
-	cu.chatMessages = append(cu.chatMessages, input)
-	cu.chatScrollOffset = len(cu.chatMessages) - (chatBox.Inner.Dy() - 1)
-	if cu.chatScrollOffset < 0 {
-		cu.chatScrollOffset = 0
+	cu.AppendToTab(cu.ActiveTab(), input, chatBox)
+}
+
+// AppendToTab adds a message to the tab, messages of inactive tabs are counted as unread until it's selected
+func (cu *ChatUI) AppendToTab(tab *Tab, input string, chatBox *widgets.Paragraph) {
+	tab.messages = append(tab.messages, input)
+	if tab != cu.ActiveTab() {
+		tab.unread++
+		return
+	}
+	tab.scrollOffset = len(tab.messages) - (chatBox.Inner.Dy() - 1)
+	if tab.scrollOffset < 0 {
+		tab.scrollOffset = 0
 	}
 	cu.refreshChatBox(chatBox)
 }
@@ -126,39 +268,35 @@ func (cu *ChatUI) UpdateRawChatBox(input string) {
 }
 
 func (cu *ChatUI) refreshChatBox(chatBox *widgets.Paragraph) {
+	tab := cu.ActiveTab()
 	visibleLines := chatBox.Inner.Dy() - 1
-	if cu.chatScrollOffset+visibleLines > len(cu.chatMessages) {
-		visibleLines = len(cu.chatMessages) - cu.chatScrollOffset
+	if tab.scrollOffset+visibleLines > len(tab.messages) {
+		visibleLines = len(tab.messages) - tab.scrollOffset
 	}
-	chatBox.Text = strings.Join(cu.chatMessages[cu.chatScrollOffset:cu.chatScrollOffset+visibleLines], "\n")
+	chatBox.Text = strings.Join(tab.messages[tab.scrollOffset:tab.scrollOffset+visibleLines], "\n")
 }
 
-func (cu *ChatUI) ResizeUI(header *widgets.Paragraph, commandBox *widgets.Paragraph, chatBox *widgets.Paragraph, inputBox *widgets.Paragraph, userList *widgets.List) {
-	termWidth, termHeight := ui.TerminalDimensions()
-
-	header.SetRect(0, 0, termWidth, 3)
-	commandBox.SetRect(0, 3, termWidth*3/4, 14)
-	chatBox.SetRect(0, 14, termWidth*3/4, termHeight-3)
-	inputBox.SetRect(0, termHeight-3, termWidth, termHeight)
-	userList.SetRect(termWidth*3/4, 3, termWidth, termHeight-3)
+func (cu *ChatUI) ResizeUI(tabBar *widgets.TabPane, header *widgets.Paragraph, commandBox *widgets.Paragraph, chatBox *widgets.Paragraph, inputBox *widgets.Paragraph, userList *widgets.List) {
+	cu.layout(tabBar, header, commandBox, chatBox, inputBox, userList)
 
 	ui.Clear()
-	cu.Draw(header, commandBox, chatBox, inputBox, userList)
+	cu.Draw(tabBar, header, commandBox, chatBox, inputBox, userList)()
 }
 
 func (cu *ChatUI) ScrollChatBox(chatBox *widgets.Paragraph, direction int) {
-	cu.chatScrollOffset += direction
-	if cu.chatScrollOffset < 0 {
-		cu.chatScrollOffset = 0
+	tab := cu.ActiveTab()
+	tab.scrollOffset += direction
+	if tab.scrollOffset < 0 {
+		tab.scrollOffset = 0
 	}
 
 	visibleLines := chatBox.Inner.Dy() - 1
-	totalMessages := len(cu.chatMessages)
+	totalMessages := len(tab.messages)
 
 	if totalMessages <= visibleLines {
-		cu.chatScrollOffset = 0
-	} else if cu.chatScrollOffset > totalMessages-visibleLines {
-		cu.chatScrollOffset = totalMessages - visibleLines
+		tab.scrollOffset = 0
+	} else if tab.scrollOffset > totalMessages-visibleLines {
+		tab.scrollOffset = totalMessages - visibleLines
 	}
 
 	cu.refreshChatBox(chatBox)
@@ -169,8 +307,9 @@ func (cu *ChatUI) UpdateUserList(userList *widgets.List, users []string) {
 }
 
 func (cu *ChatUI) ClearChatBox(chatBox *widgets.Paragraph) {
-	cu.chatMessages = []string{}
-	cu.chatScrollOffset = 0
+	tab := cu.ActiveTab()
+	tab.messages = []string{}
+	tab.scrollOffset = 0
 	cu.refreshChatBox(chatBox)
 }
 
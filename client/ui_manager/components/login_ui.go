@@ -13,6 +13,7 @@ type LoginUI struct {
 	password      string
 	currentField  int // 0 for username, 1 for password
 	cursorVisible bool
+	registerMode  bool
 }
 
 func NewLoginUI() *LoginUI {
@@ -61,7 +62,7 @@ func (lu *LoginUI) prepareUIItems() (container *widgets.Paragraph, description *
 	description.Rows = []string{
 		"• Enter your username and password",
 		"• Use Tab to switch between fields",
-		"• Press Enter to submit",
+		"• Press Enter to submit, Ctrl+R to switch between login and register",
 	}
 	description.SetRect(containerStartX+1, containerStartY+1, containerStartX+containerWidth-1, containerStartY+6)
 	description.BorderStyle.Fg = ui.ColorCyan
@@ -153,8 +154,29 @@ func (lu *LoginUI) Close() {
 	ui.Close()
 }
 
+// ToggleMode switches between login and register mode
+func (lu *LoginUI) ToggleMode() {
+	lu.registerMode = !lu.registerMode
+}
+
+func (lu *LoginUI) IsRegisterMode() bool {
+	return lu.registerMode
+}
+
+func (lu *LoginUI) UpdateContainer(container *widgets.Paragraph) {
+	if lu.registerMode {
+		container.Title = "📝 Register"
+	} else {
+		container.Title = "🔐 Secure Login"
+	}
+}
+
 func (lu *LoginUI) ResetErrorBox(errorBox *widgets.Paragraph) {
-	errorBox.Text = "Enter credentials (new users will be registered)"
+	if lu.registerMode {
+		errorBox.Text = "Choose a username and password to create an account"
+	} else {
+		errorBox.Text = "Enter credentials to log in"
+	}
 	errorBox.TextStyle.Fg = ui.ColorYellow
 }
 
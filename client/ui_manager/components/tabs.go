@@ -0,0 +1,92 @@
+package ui_manager
+
+import (
+	"fmt"
+	"time"
+)
+
+type TabKind int
+
+const (
+	MainTab TabKind = iota
+	ChannelTab
+	WhisperTab
+)
+
+const typingTimeout = 2 * time.Second
+
+// Tab is a conversation in the tab bar, it keeps its own messages while another one is shown
+type Tab struct {
+	Kind         TabKind
+	Name         string // Channel name or whisper peer, empty for the main chat
+	topic        string
+	messages     []string
+	scrollOffset int
+	unread       int
+	typingUsers  map[string]time.Time
+}
+
+func newTab(kind TabKind, name string) *Tab {
+	return &Tab{
+		Kind:        kind,
+		Name:        name,
+		messages:    []string{},
+		typingUsers: make(map[string]time.Time),
+	}
+}
+
+func (t *Tab) title() string {
+	var title string
+	switch t.Kind {
+	case MainTab:
+		title = "main"
+	case ChannelTab:
+		title = "#" + t.Name
+	case WhisperTab:
+		title = "@" + t.Name
+	}
+	if t.unread > 0 {
+		title = fmt.Sprintf("%s (%d)", title, t.unread)
+	}
+	return title
+}
+
+func (t *Tab) SetTopic(topic string) {
+	t.topic = topic
+}
+
+func (t *Tab) SetUserTyping(username string) {
+	t.typingUsers[username] = time.Now()
+}
+
+// expireTypingUsers forgets users who stopped typing, reports whether anyone was dropped
+func (t *Tab) expireTypingUsers(now time.Time) bool {
+	expired := false
+	for username, lastTyped := range t.typingUsers {
+		if now.Sub(lastTyped) > typingTimeout {
+			delete(t.typingUsers, username)
+			expired = true
+		}
+	}
+	return expired
+}
+
+func (t *Tab) typingText(currentUserName string) string {
+	var typingUsers []string
+	for username := range t.typingUsers {
+		if username != currentUserName {
+			typingUsers = append(typingUsers, username)
+		}
+	}
+
+	switch len(typingUsers) {
+	case 0:
+		return ""
+	case 1:
+		return fmt.Sprintf("%s is typing...", typingUsers[0])
+	case 2:
+		return fmt.Sprintf("%s and %s are typing...", typingUsers[0], typingUsers[1])
+	default:
+		return "Several people are typing..."
+	}
+}
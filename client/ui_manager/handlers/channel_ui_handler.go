@@ -1,135 +1,148 @@
 package ui_manager
 
 import (
-	"context"
 	"fmt"
 	"strings"
 	"time"
 
-	ui "github.com/gizak/termui/v3"
 	"github.com/gizak/termui/v3/widgets"
 	"github.com/ogzhanolguncu/go-chat/client/internal"
 	ui_manager "github.com/ogzhanolguncu/go-chat/client/ui_manager/components"
 	"github.com/ogzhanolguncu/go-chat/protocol"
 )
 
-func HandleChannelUI(client *internal.Client) error {
-	channelUi := ui_manager.NewChannelUI(client.GetUsername(), client.GetChannelInfo().ChName)
-	defer channelUi.Close()
-
-	header, chatBox, inputBox, err := channelUi.InitUI()
-	if err != nil {
-		return fmt.Errorf("failed to initialize termui: %v", err)
+// openChannelTab opens and shows the tab of a channel we just joined or created
+func openChannelTab(client *internal.Client, chatUI *ui_manager.ChatUI, payload protocol.Payload, chatBox *widgets.Paragraph) {
+	chInfo := internal.ChannelInfo{
+		ChName:      payload.ChannelPayload.ChannelName,
+		Topic:       payload.ChannelPayload.OptionalChannelArgs.Topic,
+		Description: payload.ChannelPayload.OptionalChannelArgs.Description,
 	}
-	channelUi.UpdateChatBox(fmt.Sprintf("[%s] [Welcome to the %s!](fg:cyan)", time.Now().Format("01-02 15:04"), client.GetChannelInfo().ChName), chatBox)
-	channelUi.UpdateHeader(header)
-	draw := channelUi.Draw(header, chatBox, inputBox)
-	draw()
-
-	uiEvents := ui.PollEvents()
-
-	incomingChan := make(chan protocol.Payload)
-	errorChan := make(chan error, 1)
-	exitChan := make(chan struct{})
+	client.AddChannel(chInfo)
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	go client.ReadMessages(ctx, incomingChan, errorChan)
-	cursorTicker := time.NewTicker(500 * time.Millisecond)
-	defer cursorTicker.Stop()
-
-	for {
-		select {
-		case <-cursorTicker.C:
-			channelUi.ToggleCursor()
-			channelUi.RenderInput(inputBox)
-			draw()
-		case e := <-uiEvents:
-			switch e.ID {
-			case "<MouseWheelUp>":
-				channelUi.ScrollChatBox(chatBox, -1)
-			case "<MouseWheelDown>":
-				channelUi.ScrollChatBox(chatBox, 1)
-			case "<C-c>":
-				handleExit(client, channelUi, chatBox, exitChan)
-			case "<Enter>":
-				if len(channelUi.GetInputText()) > 0 {
-					handleEnterKey(client, channelUi, chatBox, exitChan)
-				}
-			case "<Space>":
-				channelUi.HandleKeyPress("<Space>")
-			default:
-				go client.HandleSend(fmt.Sprintf("/ch typing %s %s", client.GetChannelInfo().ChName, client.GetChannelInfo().ChPassword))
-				channelUi.HandleKeyPress(e.ID)
-			}
-			channelUi.RenderInput(inputBox)
-			draw()
-		case payload := <-incomingChan:
-			msg, shouldExit := client.HandleChReceive(payload)
-			if strings.HasPrefix(msg, "T-") {
-				username := strings.TrimPrefix(msg, "T-")
-				channelUi.SetUserTyping(username)
-				channelUi.UpdateHeader(header)
-				draw()
-				continue
-			}
-			if msg != "" {
-				channelUi.UpdateChatBox(msg, chatBox)
-				draw()
-			}
-			//Required for displaying message first otherwise function just quits
-			if shouldExit {
-				go func() {
-					time.Sleep(1 * time.Second)
-					exitChan <- struct{}{}
-				}()
+	tab := chatUI.FindTab(ui_manager.ChannelTab, chInfo.ChName)
+	if tab == nil {
+		tab = chatUI.OpenTab(ui_manager.ChannelTab, chInfo.ChName)
+		chatUI.AppendToTab(tab, fmt.Sprintf("[%s] [Welcome to the %s!](fg:cyan)", time.Now().Format("01-02 15:04"), chInfo.ChName), chatBox)
+		if chInfo.Description != "" {
+			chatUI.AppendToTab(tab, fmt.Sprintf("[%s] [%s](fg:cyan)", time.Now().Format("01-02 15:04"), chInfo.Description), chatBox)
+		}
+		if idleTimeout := payload.ChannelPayload.OptionalChannelArgs.IdleTimeout; idleTimeout > 0 && !payload.ChannelPayload.OptionalChannelArgs.Permanent {
+			outcome := "closed"
+			if payload.ChannelPayload.OptionalChannelArgs.Archive {
+				outcome = "archived"
 			}
-		case err := <-errorChan:
-			return err
-		case <-exitChan:
-			return nil
+			chatUI.AppendToTab(tab, fmt.Sprintf("[%s] [The channel is %s after %s without activity](fg:cyan)", time.Now().Format("01-02 15:04"), outcome, idleTimeout), chatBox)
 		}
 	}
+	tab.SetTopic(chInfo.Topic)
+	chatUI.SelectTab(tab)
 }
 
-func handleExit(client *internal.Client, channelUi *ui_manager.ChannelUI, chatBox *widgets.Paragraph, exitChan chan struct{}) {
-	chMsgPayload := fmt.Sprintf("/ch leave %s", client.GetChannelInfo().ChName)
-	message, err := client.HandleSend(chMsgPayload)
-	if err != nil {
-		message = err.Error()
+// routeChannelPayload puts a channel payload into its channel's tab, and closes the tab once we are out of the channel
+func routeChannelPayload(client *internal.Client, chatUI *ui_manager.ChatUI, payload protocol.Payload, chatBox *widgets.Paragraph) {
+	if rows, ok := client.ChannelDirectory(payload); ok {
+		for _, row := range rows {
+			chatUI.UpdateChatBox(row, chatBox)
+		}
+		return
+	}
+
+	mainTab := chatUI.FindTab(ui_manager.MainTab, "")
+	tab := chatUI.FindTab(ui_manager.ChannelTab, payload.ChannelPayload.ChannelName)
+	failed := payload.ChannelPayload.OptionalChannelArgs != nil && payload.ChannelPayload.OptionalChannelArgs.Status == protocol.StatusFail
+	if tab == nil || failed {
+		// Failures answer what was just typed, so they show up where it was typed
+		target := mainTab
+		if failed {
+			target = chatUI.ActiveTab()
+		}
+		if message := client.HandleReceive(payload); message != "" {
+			chatUI.AppendToTab(target, message, chatBox)
+		}
+		return
+	}
+
+	if topic, changed := client.TopicChange(payload); changed {
+		tab.SetTopic(topic)
+	}
+	msg, leftChannel := client.HandleChReceive(payload)
+	if strings.HasPrefix(msg, "T-") {
+		tab.SetUserTyping(strings.TrimPrefix(msg, "T-"))
+		return
+	}
+	if leftChannel {
+		client.RemoveChannel(tab.Name)
+		chatUI.CloseTab(tab)
+		chatUI.AppendToTab(mainTab, msg, chatBox)
+		return
+	}
+	if msg != "" {
+		chatUI.AppendToTab(tab, msg, chatBox)
 	}
-	channelUi.UpdateChatBox(message, chatBox)
-	channelUi.UpdateInputText("")
-	go func() {
-		time.Sleep(1 * time.Second)
-		exitChan <- struct{}{}
-	}()
 }
 
-func handleEnterKey(client *internal.Client, channelUi *ui_manager.ChannelUI, chatBox *widgets.Paragraph, exitChan chan struct{}) {
-	inputText := channelUi.GetInputText()
+// handleChannelInput sends the input of a channel tab, plain text is a message to the channel
+func handleChannelInput(client *internal.Client, chatUI *ui_manager.ChatUI, tab *ui_manager.Tab, inputText string, chatBox *widgets.Paragraph) {
 	var message string
 	var err error
 
 	switch {
-	case inputText == "/clear":
-		channelUi.ClearChatBox(chatBox)
 	case inputText == "/quit":
-		handleExit(client, channelUi, chatBox, exitChan)
+		// The tab closes once the server confirms we left
+		message, err = client.HandleSend(fmt.Sprintf("/ch leave %s", tab.Name))
+	case strings.HasPrefix(inputText, "/ch "):
+		message, err = client.HandleSend(inputText)
 	case strings.HasPrefix(inputText, "/kick "):
 		parts := strings.Fields(inputText)
-		chMsgPayload := fmt.Sprintf("/ch kick %s %s %s", client.GetChannelInfo().ChName, client.GetChannelInfo().ChPassword, strings.TrimSpace(parts[1]))
+		chMsgPayload := fmt.Sprintf("/ch kick %s %s", tab.Name, strings.TrimSpace(parts[1]))
 		message, err = client.HandleSend(chMsgPayload)
+	case strings.HasPrefix(inputText, "/mute "):
+		parts := strings.Fields(inputText)
+		message, err = client.HandleSend(fmt.Sprintf("/ch mute %s %s", tab.Name, strings.Join(parts[1:], " ")))
+	case strings.HasPrefix(inputText, "/slowmode "):
+		message, err = client.HandleSend(fmt.Sprintf("/ch slowmode %s %s", tab.Name, strings.TrimPrefix(inputText, "/slowmode ")))
+	case strings.HasPrefix(inputText, "/resize "):
+		message, err = client.HandleSend(fmt.Sprintf("/ch resize %s %s", tab.Name, strings.TrimPrefix(inputText, "/resize ")))
 	case strings.HasPrefix(inputText, "/ban "):
 		parts := strings.Fields(inputText)
-		chMsgPayload := fmt.Sprintf("/ch ban %s %s %s", client.GetChannelInfo().ChName, client.GetChannelInfo().ChPassword, strings.TrimSpace(parts[1]))
+		chMsgPayload := fmt.Sprintf("/ch ban %s %s", tab.Name, strings.Join(parts[1:], " "))
 		message, err = client.HandleSend(chMsgPayload)
+	case inputText == "/invite" || strings.HasPrefix(inputText, "/invite "):
+		parts := strings.Fields(inputText)
+		chMsgPayload := strings.TrimSpace(fmt.Sprintf("/ch invite %s %s", tab.Name, strings.Join(parts[1:], " ")))
+		message, err = client.HandleSend(chMsgPayload)
+	case strings.HasPrefix(inputText, "/topic "):
+		message, err = client.HandleSend(fmt.Sprintf("/ch topic %s %s", tab.Name, strings.TrimPrefix(inputText, "/topic ")))
+	case strings.HasPrefix(inputText, "/description "):
+		message, err = client.HandleSend(fmt.Sprintf("/ch description %s %s", tab.Name, strings.TrimPrefix(inputText, "/description ")))
+	case inputText == "/bans":
+		message, err = client.HandleSend(fmt.Sprintf("/ch bans %s", tab.Name))
+	case strings.HasPrefix(inputText, "/op "),
+		strings.HasPrefix(inputText, "/deop "),
+		strings.HasPrefix(inputText, "/transfer "),
+		strings.HasPrefix(inputText, "/unban "),
+		strings.HasPrefix(inputText, "/unmute "):
+		parts := strings.Fields(inputText)
+		if len(parts) < 2 {
+			break
+		}
+		chMsgPayload := fmt.Sprintf("/ch %s %s %s", strings.TrimPrefix(parts[0], "/"), tab.Name, parts[1])
+		message, err = client.HandleSend(chMsgPayload)
+	case inputText == "/list" || strings.HasPrefix(inputText, "/list "):
+		parts := strings.Fields(inputText)
+		filter := protocol.EmptyChannelField
+		if len(parts) > 1 {
+			filter = parts[1]
+		}
+		message, err = client.HandleSend(strings.TrimSpace(fmt.Sprintf("/ch list %s %s", filter, strings.Join(parts[min(len(parts), 2):], " "))))
+	case inputText == "/history":
+		message, err = client.HandleSend(fmt.Sprintf("/ch history %s", tab.Name))
 	case inputText == "/users":
-		chMsgPayload := fmt.Sprintf("/ch users %s %s", client.GetChannelInfo().ChName, client.GetChannelInfo().ChPassword)
+		chMsgPayload := fmt.Sprintf("/ch users %s", tab.Name)
 		message, err = client.HandleSend(chMsgPayload)
 	default:
-		chMsgPayload := fmt.Sprintf("/ch message %s %s %s", client.GetChannelInfo().ChName, client.GetChannelInfo().ChPassword, inputText)
+		chMsgPayload := fmt.Sprintf("/ch message %s %s", tab.Name, inputText)
 		message, err = client.HandleSend(chMsgPayload)
 	}
 
@@ -137,7 +150,6 @@ func handleEnterKey(client *internal.Client, channelUi *ui_manager.ChannelUI, ch
 		message = err.Error()
 	}
 	if message != "" {
-		channelUi.UpdateChatBox(message, chatBox)
+		chatUI.UpdateChatBox(message, chatBox)
 	}
-	channelUi.UpdateInputText("")
 }
@@ -3,9 +3,11 @@ package ui_manager
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	ui "github.com/gizak/termui/v3"
+	"github.com/gizak/termui/v3/widgets"
 	"github.com/ogzhanolguncu/go-chat/client/internal"
 	ui_manager "github.com/ogzhanolguncu/go-chat/client/ui_manager/components"
 	"github.com/ogzhanolguncu/go-chat/client/utils"
@@ -22,27 +24,44 @@ var fakeNames = []string{
 	"Ursula", "Victor", "Wendy", "Xander", "Yara",
 }
 
-func HandleChatUI(client *internal.Client) (bool, error) {
+func HandleChatUI(client *internal.Client) error {
 	chatUI := ui_manager.NewChatUI(client.GetUsername())
 	defer chatUI.Close()
 
-	header, commandBox, chatBox, inputBox, userList, err := chatUI.InitUI()
+	tabBar, header, commandBox, chatBox, inputBox, userList, err := chatUI.InitUI()
 	if err != nil {
-		return false, fmt.Errorf("failed to initialize termui: %v", err)
+		return fmt.Errorf("failed to initialize termui: %v", err)
 	}
 	chatUI.UpdateChatBox(fmt.Sprintf("[%s] [Welcome to the chat!](fg:cyan)", time.Now().Format("01-02 15:04")), chatBox)
-	draw := chatUI.Draw(header, commandBox, chatBox, inputBox, userList)
+	draw := chatUI.Draw(tabBar, header, commandBox, chatBox, inputBox, userList)
 	draw()
 
+	// redraw brings the tab bar and header up to date, and lays out the screen again if another tab got active
+	redraw := func(previous *ui_manager.Tab) {
+		if chatUI.ActiveTab() != previous {
+			chatUI.ShowActiveTab(tabBar, header, commandBox, chatBox, inputBox, userList)
+		} else {
+			chatUI.RenderTabs(tabBar)
+			chatUI.UpdateHeader(header)
+		}
+		chatUI.RenderInput(inputBox)
+		draw()
+	}
+
 	uiEvents := ui.PollEvents()
 	incomingChan := make(chan protocol.Payload)
 	errorChan := make(chan error, 1)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
-	//TODO: still can't fetch both of them when kicked or banned or leave the room
+	// After a resumed session the server replays only the missed messages, so full history is skipped
+	resumed := client.ConsumeResumed()
+	// Channel tabs start over, a resumed session gets a join for each channel it is still in
+	client.ResetChannels()
 	go func() {
-		client.FetchChatHistory()
+		if !resumed {
+			client.FetchChatHistory()
+		}
 		client.FetchActiveUserList()
 	}()
 	go client.ReadMessages(ctx, incomingChan, errorChan)
@@ -55,9 +74,13 @@ func HandleChatUI(client *internal.Client) (bool, error) {
 		select {
 		case <-cursorTicker.C:
 			chatUI.ToggleCursor()
+			if chatUI.ExpireTypingUsers() {
+				chatUI.UpdateHeader(header)
+			}
 			chatUI.RenderInput(inputBox)
 			draw()
 		case e := <-uiEvents:
+			previous := chatUI.ActiveTab()
 			switch e.ID {
 			case "<Up>":
 				chatHistory(false)
@@ -69,87 +92,184 @@ func HandleChatUI(client *internal.Client) (bool, error) {
 				chatUI.ScrollChatBox(chatBox, -1)
 			case "<MouseWheelDown>":
 				chatUI.ScrollChatBox(chatBox, 1)
+			case "<Tab>", "<C-n>":
+				chatUI.SwitchTab(1)
+			case "<C-p>":
+				chatUI.SwitchTab(-1)
 			case "<C-c>":
-				return false, nil
+				leaveChannels(client)
+				return nil
 			case "<Enter>":
-				if chatUI.IsInputMode() && len(inputBox.Text) > 0 {
+				if chatUI.IsInputMode() && len(chatUI.GetInputText()) > 0 {
 					inputText := chatUI.GetInputText()
-					if inputText == "/quit" {
-						return false, nil
-					}
-					if inputText == "/clear" {
-						chatUI.ClearChatBox(chatBox)
-						chatUI.UpdateInputText("")
-						draw()
-						continue
+					quit, err := handleEnterKey(client, chatUI, chatBox)
+					if err != nil {
+						return err
 					}
-					if inputText == "/ch list" {
-						message, err := client.HandleSend("/ch list -")
-						if err != nil {
-							message = err.Error()
-						}
-						chatUI.UpdateChatBox(message, chatBox)
-						chatUI.UpdateRawChatBox("/ch list -")
-						chatHistory(true)
-						chatUI.UpdateInputText("")
-					} else {
-						message, err := client.HandleSend(inputText)
-						if err != nil {
-							return false, err
-						}
-						chatUI.UpdateChatBox(message, chatBox)
-						chatUI.UpdateRawChatBox(inputText)
-						chatHistory(true)
-						chatUI.UpdateInputText("")
+					if quit {
+						leaveChannels(client)
+						return nil
 					}
+					chatUI.UpdateRawChatBox(inputText)
+					chatHistory(true)
+					chatUI.UpdateInputText("")
 				}
 			case "<Resize>":
-				chatUI.ResizeUI(header, commandBox, chatBox, inputBox, userList)
+				chatUI.ResizeUI(tabBar, header, commandBox, chatBox, inputBox, userList)
 			default:
+				if tab := chatUI.ActiveTab(); tab.Kind == ui_manager.ChannelTab && len(e.ID) == 1 {
+					go client.HandleSend(fmt.Sprintf("/ch typing %s", tab.Name))
+				}
 				chatUI.HandleKeyPress(e.ID)
 			}
-			chatUI.RenderInput(inputBox)
-			draw()
+			redraw(previous)
 		case payload := <-incomingChan:
-			// If its a channel message action and success status return true to switch to Channel UI
-			if client.CheckIfSuccessfulChannel(payload) {
-				client.SetChannelInfo(internal.ChannelInfo{ChName: payload.ChannelPayload.ChannelName, ChPassword: payload.ChannelPayload.ChannelPassword})
-				return true, nil
+			previous := chatUI.ActiveTab()
+			routePayload(client, chatUI, payload, chatBox, userList)
+			redraw(previous)
+		case err := <-errorChan:
+			return err
+		}
+	}
+}
+
+// handleEnterKey sends the input as the active tab means it, quit is set when the user wants to exit the app
+func handleEnterKey(client *internal.Client, chatUI *ui_manager.ChatUI, chatBox *widgets.Paragraph) (quit bool, err error) {
+	inputText := chatUI.GetInputText()
+	if inputText == "/ch list" {
+		inputText = "/ch list -"
+	}
+	if inputText == "/clear" {
+		chatUI.ClearChatBox(chatBox)
+		return false, nil
+	}
+
+	switch tab := chatUI.ActiveTab(); tab.Kind {
+	case ui_manager.ChannelTab:
+		handleChannelInput(client, chatUI, tab, inputText, chatBox)
+		return false, nil
+	case ui_manager.WhisperTab:
+		if inputText == "/close" {
+			chatUI.CloseTab(tab)
+			return false, nil
+		}
+		if !strings.HasPrefix(inputText, "/") {
+			inputText = fmt.Sprintf("/whisper %s %s", tab.Name, inputText)
+		}
+	}
+
+	if inputText == "/quit" {
+		return true, nil
+	}
+	message, err := client.HandleSend(inputText)
+	if err != nil {
+		return false, err
+	}
+	// Sent whispers go to the conversation's tab, which is opened if needed
+	if peer := whisperPeer(client, inputText); peer != "" {
+		tab := chatUI.OpenTab(ui_manager.WhisperTab, peer)
+		chatUI.SelectTab(tab)
+		chatUI.AppendToTab(tab, message, chatBox)
+		return false, nil
+	}
+	chatUI.UpdateChatBox(message, chatBox)
+	return false, nil
+}
+
+// whisperPeer returns who the input whispered to, empty if it isn't a whisper that got sent
+func whisperPeer(client *internal.Client, inputText string) string {
+	parts := strings.Fields(inputText)
+	switch {
+	case len(parts) >= 3 && parts[0] == "/whisper":
+		return parts[1]
+	case len(parts) >= 2 && parts[0] == "/reply":
+		return client.GetLastWhisperer()
+	}
+	return ""
+}
+
+// leaveChannels leaves every channel before quitting, a dropped connection keeps them for the resumed session
+func leaveChannels(client *internal.Client) {
+	for _, chName := range client.JoinedChannels() {
+		client.HandleSend(fmt.Sprintf("/ch leave %s", chName))
+	}
+}
+
+// routePayload puts the payload into the tab it belongs to, inactive tabs count it as unread
+func routePayload(client *internal.Client, chatUI *ui_manager.ChatUI, payload protocol.Payload, chatBox *widgets.Paragraph, userList *widgets.List) {
+	mainTab := chatUI.FindTab(ui_manager.MainTab, "")
+
+	// A successful join or create opens the channel's tab
+	if client.CheckIfSuccessfulChannel(payload) {
+		openChannelTab(client, chatUI, payload, chatBox)
+		return
+	}
+	if payload.MessageType == protocol.MessageTypeWSP {
+		notificationMsg := payload.Content
+		// Make notification shorter to fit it into small notification window
+		if len(payload.Content) >= 10 {
+			notificationMsg = payload.Content[0:10] + "..."
+		}
+		go utils.NotifyUser(fmt.Sprintf("Whisper from %s", payload.Sender), notificationMsg, "/System/Library/Sounds/Purr.aiff")
+	}
+	if client.CheckIfUserMuted(payload.Sender) {
+		return
+	}
+
+	switch payload.MessageType {
+	case protocol.MessageTypeHSTRY:
+		switch payload.Status {
+		case "missed":
+			if len(payload.DecodedChatHistory) == 0 {
+				chatUI.AppendToTab(mainTab, fmt.Sprintf("[%s] [Reconnected, you didn't miss anything](fg:cyan)", time.Now().Format("01-02 15:04")), chatBox)
+				return
 			}
-			if payload.MessageType == protocol.MessageTypeWSP {
-				notificationMsg := payload.Content
-				// Make notification shorter to fit it into small notification window
-				if len(payload.Content) >= 10 {
-					notificationMsg = payload.Content[0:10] + "..."
-				}
-				go utils.NotifyUser(fmt.Sprintf("Whisper from %s", payload.Sender), notificationMsg, "/System/Library/Sounds/Purr.aiff")
+			chatUI.AppendToTab(mainTab, "---- MISSED WHILE DISCONNECTED ----", chatBox)
+			for _, v := range payload.DecodedChatHistory {
+				chatUI.AppendToTab(mainTab, client.HandleReceive(v), chatBox)
 			}
-			if client.CheckIfUserMuted(payload.Sender) {
-				continue
+			chatUI.AppendToTab(mainTab, "---- MISSED WHILE DISCONNECTED ----", chatBox)
+		case "channel":
+			// Server sends the latest channel messages after joining and on /history
+			if len(payload.DecodedChatHistory) == 0 || payload.DecodedChatHistory[0].ChannelPayload == nil {
+				return
 			}
-			if payload.MessageType == protocol.MessageTypeHSTRY {
-				if len(payload.DecodedChatHistory) != 0 {
-					chatUI.UpdateChatBox("---- CHAT HISTORY ----", chatBox)
-				}
-				for _, v := range payload.DecodedChatHistory {
-					chatUI.UpdateChatBox(client.HandleReceive(v), chatBox)
-				}
-				if len(payload.DecodedChatHistory) != 0 {
-					chatUI.UpdateChatBox("---- CHAT HISTORY ----", chatBox)
+			tab := chatUI.FindTab(ui_manager.ChannelTab, payload.DecodedChatHistory[0].ChannelPayload.ChannelName)
+			if tab == nil {
+				return
+			}
+			chatUI.AppendToTab(tab, "---- CHANNEL HISTORY ----", chatBox)
+			for _, v := range payload.DecodedChatHistory {
+				if msg, _ := client.HandleChReceive(v); msg != "" {
+					chatUI.AppendToTab(tab, msg, chatBox)
 				}
-				draw()
-				continue
 			}
-			if payload.MessageType == protocol.MessageTypeACT_USRS {
-
-				payload.ActiveUsers = append(payload.ActiveUsers, fakeNames...)
-				chatUI.UpdateUserList(userList, payload.ActiveUsers)
-				draw()
-				continue
+			chatUI.AppendToTab(tab, "---- CHANNEL HISTORY ----", chatBox)
+		default:
+			if len(payload.DecodedChatHistory) == 0 {
+				return
+			}
+			chatUI.AppendToTab(mainTab, "---- CHAT HISTORY ----", chatBox)
+			for _, v := range payload.DecodedChatHistory {
+				chatUI.AppendToTab(mainTab, client.HandleReceive(v), chatBox)
 			}
+			chatUI.AppendToTab(mainTab, "---- CHAT HISTORY ----", chatBox)
+		}
+	case protocol.MessageTypeACT_USRS:
+		payload.ActiveUsers = append(payload.ActiveUsers, fakeNames...)
+		chatUI.UpdateUserList(userList, payload.ActiveUsers)
+	case protocol.MessageTypeWSP:
+		chatUI.AppendToTab(chatUI.OpenTab(ui_manager.WhisperTab, payload.Sender), client.HandleReceive(payload), chatBox)
+	case protocol.MessageTypeCH:
+		routeChannelPayload(client, chatUI, payload, chatBox)
+	case protocol.MessageTypeSYS:
+		// Failures answer what was just typed, so they show up where it was typed
+		if payload.Status == "fail" {
 			chatUI.UpdateChatBox(client.HandleReceive(payload), chatBox)
-		case err := <-errorChan:
-			return false, err
+			return
 		}
+		chatUI.AppendToTab(mainTab, client.HandleReceive(payload), chatBox)
+	default:
+		chatUI.AppendToTab(mainTab, client.HandleReceive(payload), chatBox)
 	}
 }
@@ -60,12 +60,21 @@ func HandleLoginUI(client *internal.Client) (bool, error) {
 				lu.SwitchField()
 				lu.ResetErrorBox(errorBox)
 
+			case "<C-r>":
+				lu.ToggleMode()
+				lu.UpdateContainer(container)
+				lu.ResetErrorBox(errorBox)
+
 			case "<Enter>":
 				if !loginAttemptInProgress {
 					username, password := lu.GetCredentials()
 					loginAttemptInProgress = true
 					showLoader = true
-					client.SendUsernameReq(username, password)
+					action := protocol.AuthActionLogin
+					if lu.IsRegisterMode() {
+						action = protocol.AuthActionRegister
+					}
+					client.SendUsernameReq(username, password, action)
 				}
 			case "<Backspace>":
 				lu.DeleteLastChar()
@@ -82,6 +91,17 @@ func HandleLoginUI(client *internal.Client) (bool, error) {
 				}
 			}
 		case payload := <-responseChan:
+			// Session token arrives right before the auth response
+			if payload.MessageType == protocol.MessageTypeSESS {
+				client.SetSessionToken(payload.SessionToken)
+				continue
+			}
+			// Notices like ban reasons also arrive right before the auth response
+			if payload.MessageType == protocol.MessageTypeSYS {
+				errorBox.Text = payload.Content
+				errorBox.TextStyle.Fg = ui.ColorRed
+				continue
+			}
 			loginAttemptInProgress = false
 			showLoader = false
 
@@ -1,6 +1,9 @@
 package protocol
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // Chat Channel(CH): CH|timestamp|ch_action|requester|chName|chPassword|chSize|optional_args
 type ChannelActionType int
@@ -17,6 +20,21 @@ const (
 	NoticeChannel
 	CloseChannel
 	TypingChannel
+	HistoryChannel
+	OpUser
+	DeopUser
+	TransferChannel
+	UnbanUser
+	GetBans
+	InviteUser
+	AcceptInvite
+	SetTopic
+	SetDescription
+	MuteUser
+	UnmuteUser
+	SetSlowMode
+	ResizeChannel
+	WaitlistChannel // Answer to a join that got queued because the channel is full
 )
 
 // Status represents the result of an action
@@ -35,16 +53,60 @@ const (
 	VisibilityPrivate Visibility = "private"
 )
 
+// ChannelSort orders the channel directory
+type ChannelSort string
+
+const (
+	SortByName     ChannelSort = "name"     // Alphabetical, the default
+	SortByMembers  ChannelSort = "members"  // Most members first
+	SortByActivity ChannelSort = "activity" // Most recently active first
+	SortByCreated  ChannelSort = "created"  // Newest first
+)
+
 // OptionalChannelArgs contains optional arguments for room operations
 type OptionalChannelArgs struct {
-	Status     Status
-	Visibility Visibility
-	Message    string
-	Reason     string
-	Channels   []string // For GetRooms
-	Users      []string // For GetUsers
-	TargetUser string   // For KICK and BAN actions
-	Notice     string
+	Status      Status
+	Visibility  Visibility
+	Message     string
+	Reason      string
+	Channels    []string // For GetRooms, kept next to ChannelSummaries for older clients
+	Users       []string // For GetUsers
+	TargetUser  string   // For KICK, BAN, MUTE, OP, DEOP, TRANSFER and INVITE actions
+	Notice      string
+	Permanent   bool             // For CreateChannel, only admins can create permanent channels
+	Duration    time.Duration    // For BanUser and MuteUser, zero lasts until lifted. For InviteUser, how long the code is valid. For SetSlowMode, time between messages, zero turns it off
+	Bans        []ChannelBan     // For GetBans
+	InviteCode  string           // For InviteUser and AcceptInvite
+	Topic       string           // For SetTopic, the join response and topic change notices
+	Description string           // For SetDescription and the join response
+	CreatedAt   int64            // For the join response
+	Summaries   []ChannelSummary // For GetChannels
+	Filter      string           // For GetChannels, only names containing it are listed
+	Sort        ChannelSort      // For GetChannels
+	IdleTimeout time.Duration    // For CreateChannel, how long the channel may be idle before it's closed, zero uses the server default
+	Archive     bool             // For CreateChannel, an idle or empty channel is archived with its history instead of closed
+	Waitlist    bool             // For CreateChannel, users joining a full channel are queued and let in when a seat frees up
+}
+
+// ChannelSummary describes a listed channel
+type ChannelSummary struct {
+	Name         string
+	Topic        string
+	Description  string
+	Members      int
+	Capacity     int
+	CreatedAt    int64
+	Owner        string
+	Protected    bool // Joining needs a password
+	LastActivity int64
+}
+
+// ChannelBan describes a user banned from a channel, ExpiresAt is 0 for bans that last until lifted
+type ChannelBan struct {
+	Username  string
+	BannedBy  string
+	BannedAt  int64
+	ExpiresAt int64
 }
 
 // ChannelPayload represents the payload for room-related operations
@@ -81,35 +143,94 @@ func (rat ChannelActionType) String() string {
 		return "CloseChannel"
 	case TypingChannel:
 		return "TypingChannel"
+	case HistoryChannel:
+		return "HistoryChannel"
+	case OpUser:
+		return "OpUser"
+	case DeopUser:
+		return "DeopUser"
+	case TransferChannel:
+		return "TransferChannel"
+	case UnbanUser:
+		return "UnbanUser"
+	case GetBans:
+		return "GetBans"
+	case InviteUser:
+		return "InviteUser"
+	case AcceptInvite:
+		return "AcceptInvite"
+	case SetTopic:
+		return "SetTopic"
+	case SetDescription:
+		return "SetDescription"
+	case MuteUser:
+		return "MuteUser"
+	case UnmuteUser:
+		return "UnmuteUser"
+	case SetSlowMode:
+		return "SetSlowMode"
+	case ResizeChannel:
+		return "ResizeChannel"
+	case WaitlistChannel:
+		return "WaitlistChannel"
 	default:
 		return "Unknown"
 	}
 }
 
 var ChannelActionMap = map[string]ChannelActionType{
-	"CreateChannel":  CreateChannel,
-	"JoinChannel":    JoinChannel,
-	"LeaveChannel":   LeaveChannel,
-	"KickUser":       KickUser,
-	"BanUser":        BanUser,
-	"GetUsers":       GetUsers,
-	"GetChannels":    GetChannels,
-	"MessageChannel": MessageChannel,
-	"NoticeChannel":  NoticeChannel,
-	"CloseChannel":   CloseChannel,
-	"TypingChannel":  TypingChannel,
+	"CreateChannel":   CreateChannel,
+	"JoinChannel":     JoinChannel,
+	"LeaveChannel":    LeaveChannel,
+	"KickUser":        KickUser,
+	"BanUser":         BanUser,
+	"GetUsers":        GetUsers,
+	"GetChannels":     GetChannels,
+	"MessageChannel":  MessageChannel,
+	"NoticeChannel":   NoticeChannel,
+	"CloseChannel":    CloseChannel,
+	"TypingChannel":   TypingChannel,
+	"HistoryChannel":  HistoryChannel,
+	"OpUser":          OpUser,
+	"DeopUser":        DeopUser,
+	"TransferChannel": TransferChannel,
+	"UnbanUser":       UnbanUser,
+	"GetBans":         GetBans,
+	"InviteUser":      InviteUser,
+	"AcceptInvite":    AcceptInvite,
+	"SetTopic":        SetTopic,
+	"SetDescription":  SetDescription,
+	"MuteUser":        MuteUser,
+	"UnmuteUser":      UnmuteUser,
+	"SetSlowMode":     SetSlowMode,
+	"ResizeChannel":   ResizeChannel,
+	"WaitlistChannel": WaitlistChannel,
 }
 
 var ClientChannelActionMap = map[string]ChannelActionType{
-	"create":  CreateChannel,
-	"join":    JoinChannel,
-	"leave":   LeaveChannel,
-	"kick":    KickUser,
-	"ban":     BanUser,
-	"users":   GetUsers,
-	"list":    GetChannels,
-	"message": MessageChannel,
-	"typing":  TypingChannel,
+	"create":      CreateChannel,
+	"join":        JoinChannel,
+	"leave":       LeaveChannel,
+	"kick":        KickUser,
+	"ban":         BanUser,
+	"users":       GetUsers,
+	"list":        GetChannels,
+	"message":     MessageChannel,
+	"typing":      TypingChannel,
+	"history":     HistoryChannel,
+	"op":          OpUser,
+	"deop":        DeopUser,
+	"transfer":    TransferChannel,
+	"unban":       UnbanUser,
+	"bans":        GetBans,
+	"invite":      InviteUser,
+	"accept":      AcceptInvite,
+	"topic":       SetTopic,
+	"description": SetDescription,
+	"mute":        MuteUser,
+	"unmute":      UnmuteUser,
+	"slowmode":    SetSlowMode,
+	"resize":      ResizeChannel,
 }
 
 // ParseChannelAction converts a string to ChannelActionType
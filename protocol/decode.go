@@ -2,9 +2,12 @@ package protocol
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -17,6 +20,10 @@ const (
 	errMissingRequester   = "missing requester separator"
 	errMissingActiveUsers = "missing rawActiveUsers separator"
 	errMissingContent     = "missing content separator"
+	errMissingToken       = "missing token separator"
+	errMissingPassword    = "missing password separator"
+	errMissingTarget      = "missing target separator"
+	errMissingArgument    = "missing argument separator"
 	errInvalidTimestamp   = "invalid timestamp format: %v"
 	errUnsupportedMsgType = "unsupported message type %s"
 )
@@ -74,6 +81,37 @@ func decodeProtocol(encoding bool, message string) (Payload, error) {
 		}
 
 		return Payload{MessageType: MessageTypeUSR, Timestamp: timestamp, Username: name, Password: password, Status: status}, nil
+	case MessageTypeSESS:
+		timestamp, name, token, status, err := parseSESS(parts)
+		if err != nil {
+			return Payload{}, err
+		}
+
+		return Payload{MessageType: MessageTypeSESS, Timestamp: timestamp, Username: name, SessionToken: token, Status: status}, nil
+	case MessageTypePASSWD:
+		timestamp, password, newPassword, err := parsePASSWD(parts)
+		if err != nil {
+			return Payload{}, err
+		}
+		return Payload{MessageType: MessageTypePASSWD, Timestamp: timestamp, Password: password, NewPassword: newPassword}, nil
+	case MessageTypeDEL_ACCT:
+		timestamp, password, err := parseDEL_ACCT(parts)
+		if err != nil {
+			return Payload{}, err
+		}
+		return Payload{MessageType: MessageTypeDEL_ACCT, Timestamp: timestamp, Password: password}, nil
+	case MessageTypeMOD:
+		timestamp, target, argument, action, err := parseMOD(parts)
+		if err != nil {
+			return Payload{}, err
+		}
+		return Payload{MessageType: MessageTypeMOD, Timestamp: timestamp, Recipient: target, Content: argument, Status: action}, nil
+	case MessageTypePING, MessageTypePONG:
+		timestamp, err := parseHeartbeat(MessageType(messageType), parts)
+		if err != nil {
+			return Payload{}, err
+		}
+		return Payload{MessageType: MessageType(messageType), Timestamp: timestamp}, nil
 	case MessageTypeACT_USRS:
 		timestamp, activeUsers, status, err := parseACT_USRS(parts)
 		if err != nil {
@@ -198,6 +236,89 @@ func parseUSR(msg string) (timestamp int64, name, password, status string, err e
 	return timestamp, name, password, status, nil
 }
 
+func parseSESS(msg string) (timestamp int64, name, token, status string, err error) {
+	timestampStr, rest, found := strings.Cut(msg, "|")
+	if !found {
+		return 0, "", "", "", fmt.Errorf(errInvalidFormat, "SESS", errMissingTimestamp)
+	}
+	timestamp, err = strconv.ParseInt(timestampStr, 10, 64)
+	if err != nil {
+		return 0, "", "", "", fmt.Errorf(errInvalidTimestamp, err)
+	}
+	name, rest, found = strings.Cut(rest, "|")
+	if !found {
+		return 0, "", "", "", fmt.Errorf(errInvalidFormat, "SESS", errMissingName)
+	}
+	token, status, found = strings.Cut(rest, "|")
+	if !found {
+		return 0, "", "", "", fmt.Errorf(errInvalidFormat, "SESS", errMissingToken)
+	}
+
+	return timestamp, name, token, status, nil
+}
+
+func parsePASSWD(msg string) (timestamp int64, password, newPassword string, err error) {
+	timestampStr, rest, found := strings.Cut(msg, "|")
+	if !found {
+		return 0, "", "", fmt.Errorf(errInvalidFormat, "PASSWD", errMissingTimestamp)
+	}
+	timestamp, err = strconv.ParseInt(timestampStr, 10, 64)
+	if err != nil {
+		return 0, "", "", fmt.Errorf(errInvalidTimestamp, err)
+	}
+	password, newPassword, found = strings.Cut(rest, "|")
+	if !found {
+		return 0, "", "", fmt.Errorf(errInvalidFormat, "PASSWD", errMissingPassword)
+	}
+
+	return timestamp, password, newPassword, nil
+}
+
+func parseDEL_ACCT(msg string) (timestamp int64, password string, err error) {
+	timestampStr, password, found := strings.Cut(msg, "|")
+	if !found {
+		return 0, "", fmt.Errorf(errInvalidFormat, "DEL_ACCT", errMissingTimestamp)
+	}
+	timestamp, err = strconv.ParseInt(timestampStr, 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf(errInvalidTimestamp, err)
+	}
+
+	return timestamp, password, nil
+}
+
+func parseMOD(msg string) (timestamp int64, target, argument, action string, err error) {
+	timestampStr, rest, found := strings.Cut(msg, "|")
+	if !found {
+		return 0, "", "", "", fmt.Errorf(errInvalidFormat, "MOD", errMissingTimestamp)
+	}
+	timestamp, err = strconv.ParseInt(timestampStr, 10, 64)
+	if err != nil {
+		return 0, "", "", "", fmt.Errorf(errInvalidTimestamp, err)
+	}
+	target, rest, found = strings.Cut(rest, "|")
+	if !found {
+		return 0, "", "", "", fmt.Errorf(errInvalidFormat, "MOD", errMissingTarget)
+	}
+	// Action is always last, so a ban reason in the argument may contain separators
+	separatorIndex := strings.LastIndex(rest, "|")
+	if separatorIndex == -1 {
+		return 0, "", "", "", fmt.Errorf(errInvalidFormat, "MOD", errMissingArgument)
+	}
+	argument, action = rest[:separatorIndex], rest[separatorIndex+1:]
+
+	return timestamp, target, argument, action, nil
+}
+
+func parseHeartbeat(messageType MessageType, msg string) (timestamp int64, err error) {
+	timestamp, err = strconv.ParseInt(msg, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf(errInvalidFormat, messageType, fmt.Sprintf(errInvalidTimestamp, err))
+	}
+
+	return timestamp, nil
+}
+
 func parseACT_USRS(msg string) (timestamp int64, activeUsers []string, status string, err error) {
 	timestampStr, rest, found := strings.Cut(msg, "|")
 	if !found {
@@ -331,14 +452,27 @@ func parseCH(msg string) (timestamp int64, room_action, requester, roomName, roo
 }
 
 const (
-	optArgStatus     = "status="
-	optArgVisibility = "visibility="
-	optArgMessage    = "message="
-	optArgReason     = "reason="
-	optArgRooms      = "channels="
-	optArgUsers      = "users="
-	optArgTargetUser = "target_user="
-	optArgNotice     = "notice="
+	optArgStatus      = "status="
+	optArgVisibility  = "visibility="
+	optArgMessage     = "message="
+	optArgReason      = "reason="
+	optArgRooms       = "channels="
+	optArgUsers       = "users="
+	optArgTargetUser  = "target_user="
+	optArgNotice      = "notice="
+	optArgPermanent   = "permanent="
+	optArgDuration    = "duration="
+	optArgBans        = "bans="
+	optArgInviteCode  = "invite_code="
+	optArgTopic       = "topic="
+	optArgDescription = "description="
+	optArgCreatedAt   = "created_at="
+	optArgSummaries   = "summaries="
+	optArgFilter      = "filter="
+	optArgSort        = "sort="
+	optArgIdleTimeout = "idle_timeout="
+	optArgArchive     = "archive="
+	optArgWaitlist    = "waitlist="
 )
 
 func parseRoomOptionalArgs(optionalArgs string) *OptionalChannelArgs {
@@ -377,7 +511,126 @@ func parseRoomOptionalArgs(optionalArgs string) *OptionalChannelArgs {
 		case strings.HasPrefix(optionalArg, optArgNotice):
 			notice, _ := strings.CutPrefix(optionalArg, optArgNotice)
 			finalOptionalArg.Notice = notice
+
+		case strings.HasPrefix(optionalArg, optArgPermanent):
+			permanent, _ := strings.CutPrefix(optionalArg, optArgPermanent)
+			finalOptionalArg.Permanent = permanent == "true"
+
+		case strings.HasPrefix(optionalArg, optArgDuration):
+			duration, _ := strings.CutPrefix(optionalArg, optArgDuration)
+			finalOptionalArg.Duration, _ = time.ParseDuration(duration)
+
+		case strings.HasPrefix(optionalArg, optArgBans):
+			bans, _ := strings.CutPrefix(optionalArg, optArgBans)
+			finalOptionalArg.Bans = parseChannelBans(bans)
+
+		case strings.HasPrefix(optionalArg, optArgInviteCode):
+			inviteCode, _ := strings.CutPrefix(optionalArg, optArgInviteCode)
+			finalOptionalArg.InviteCode = inviteCode
+
+		case strings.HasPrefix(optionalArg, optArgTopic):
+			topic, _ := strings.CutPrefix(optionalArg, optArgTopic)
+			finalOptionalArg.Topic = unescapeFreeText(topic)
+
+		case strings.HasPrefix(optionalArg, optArgDescription):
+			description, _ := strings.CutPrefix(optionalArg, optArgDescription)
+			finalOptionalArg.Description = unescapeFreeText(description)
+
+		case strings.HasPrefix(optionalArg, optArgCreatedAt):
+			createdAt, _ := strings.CutPrefix(optionalArg, optArgCreatedAt)
+			finalOptionalArg.CreatedAt, _ = strconv.ParseInt(createdAt, 10, 64)
+
+		case strings.HasPrefix(optionalArg, optArgSummaries):
+			summaries, _ := strings.CutPrefix(optionalArg, optArgSummaries)
+			finalOptionalArg.Summaries = parseChannelSummaries(summaries)
+
+		case strings.HasPrefix(optionalArg, optArgFilter):
+			filter, _ := strings.CutPrefix(optionalArg, optArgFilter)
+			finalOptionalArg.Filter = filter
+
+		case strings.HasPrefix(optionalArg, optArgSort):
+			sort, _ := strings.CutPrefix(optionalArg, optArgSort)
+			finalOptionalArg.Sort = ChannelSort(sort)
+
+		case strings.HasPrefix(optionalArg, optArgIdleTimeout):
+			idleTimeout, _ := strings.CutPrefix(optionalArg, optArgIdleTimeout)
+			finalOptionalArg.IdleTimeout, _ = time.ParseDuration(idleTimeout)
+
+		case strings.HasPrefix(optionalArg, optArgArchive):
+			archive, _ := strings.CutPrefix(optionalArg, optArgArchive)
+			finalOptionalArg.Archive = archive == "true"
+
+		case strings.HasPrefix(optionalArg, optArgWaitlist):
+			waitlist, _ := strings.CutPrefix(optionalArg, optArgWaitlist)
+			finalOptionalArg.Waitlist = waitlist == "true"
 		}
 	}
 	return finalOptionalArg
 }
+
+// unescapeFreeText reverses the escaping of topics and descriptions, text that isn't escaped is kept as is
+func unescapeFreeText(text string) string {
+	unescaped, err := url.QueryUnescape(text)
+	if err != nil {
+		return text
+	}
+	return unescaped
+}
+
+// parseChannelSummaries reads name:topic:description:members:capacity:created_at:owner:protected:last_activity
+// entries, malformed ones are skipped
+func parseChannelSummaries(summaries string) []ChannelSummary {
+	parsed := []ChannelSummary{}
+	for _, entry := range strings.Split(summaries, OptionalUserAndChannelsSeparator) {
+		fields := strings.Split(entry, channelSummaryFieldSeparator)
+		if len(fields) != 9 {
+			continue
+		}
+		name, nameErr := url.QueryUnescape(fields[0])
+		topic, topicErr := url.QueryUnescape(fields[1])
+		description, descriptionErr := url.QueryUnescape(fields[2])
+		members, membersErr := strconv.Atoi(fields[3])
+		capacity, capacityErr := strconv.Atoi(fields[4])
+		createdAt, createdAtErr := strconv.ParseInt(fields[5], 10, 64)
+		owner, ownerErr := url.QueryUnescape(fields[6])
+		protected, protectedErr := strconv.ParseBool(fields[7])
+		lastActivity, lastActivityErr := strconv.ParseInt(fields[8], 10, 64)
+		err := errors.Join(nameErr, topicErr, descriptionErr, membersErr, capacityErr, createdAtErr, ownerErr, protectedErr, lastActivityErr)
+		if err != nil {
+			continue
+		}
+		parsed = append(parsed, ChannelSummary{
+			Name:         name,
+			Topic:        topic,
+			Description:  description,
+			Members:      members,
+			Capacity:     capacity,
+			CreatedAt:    createdAt,
+			Owner:        owner,
+			Protected:    protected,
+			LastActivity: lastActivity,
+		})
+	}
+	return parsed
+}
+
+// parseChannelBans reads username:banned_by:banned_at:expires_at entries, malformed ones are skipped
+func parseChannelBans(bans string) []ChannelBan {
+	parsed := []ChannelBan{}
+	for _, entry := range strings.Split(bans, OptionalUserAndChannelsSeparator) {
+		fields := strings.Split(entry, channelBanFieldSeparator)
+		if len(fields) != 4 {
+			continue
+		}
+		bannedAt, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		expiresAt, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil {
+			continue
+		}
+		parsed = append(parsed, ChannelBan{Username: fields[0], BannedBy: fields[1], BannedAt: bannedAt, ExpiresAt: expiresAt})
+	}
+	return parsed
+}
@@ -72,6 +72,216 @@ func TestDecodeUsernameMessage(t *testing.T) {
 	})
 }
 
+func TestDecodeSessionMessage(t *testing.T) {
+	timestamp := time.Now().Unix()
+	t.Run("should decode session message into payload successfully", func(t *testing.T) {
+		encodedString := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("SESS|%d|Oz|abc.def|resume\r\n", timestamp)))
+
+		payload, _ := decodeProtocol(true, encodedString)
+		assert.Equal(t, Payload{MessageType: MessageTypeSESS, Timestamp: timestamp, Username: "Oz", SessionToken: "abc.def", Status: "resume"}, payload)
+	})
+
+	t.Run("should check for at least 4 parts of message SESS", func(t *testing.T) {
+		encodedString := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("SESS|%d|Oz|resume\r\n", timestamp)))
+		_, err := decodeProtocol(true, encodedString)
+		assert.EqualError(t, err, "invalid SESS format: missing token separator")
+	})
+}
+
+func TestDecodeAccountMessages(t *testing.T) {
+	timestamp := time.Now().Unix()
+	t.Run("should decode password change message into payload successfully", func(t *testing.T) {
+		encodedString := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("PASSWD|%d|Old1234.|New1234.\r\n", timestamp)))
+
+		payload, err := decodeProtocol(true, encodedString)
+		assert.NoError(t, err)
+		assert.Equal(t, Payload{MessageType: MessageTypePASSWD, Timestamp: timestamp, Password: "Old1234.", NewPassword: "New1234."}, payload)
+	})
+
+	t.Run("should check for new password in PASSWD", func(t *testing.T) {
+		encodedString := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("PASSWD|%d|Old1234.\r\n", timestamp)))
+		_, err := decodeProtocol(true, encodedString)
+		assert.EqualError(t, err, "invalid PASSWD format: missing password separator")
+	})
+
+	t.Run("should decode account deletion message into payload successfully", func(t *testing.T) {
+		encodedString := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("DEL_ACCT|%d|Old1234.\r\n", timestamp)))
+
+		payload, err := decodeProtocol(true, encodedString)
+		assert.NoError(t, err)
+		assert.Equal(t, Payload{MessageType: MessageTypeDEL_ACCT, Timestamp: timestamp, Password: "Old1234."}, payload)
+	})
+}
+
+func TestDecodeModerationMessage(t *testing.T) {
+	timestamp := time.Now().Unix()
+	t.Run("should decode moderation message into payload successfully", func(t *testing.T) {
+		encodedString := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("MOD|%d|Oz|moderator|role\r\n", timestamp)))
+
+		payload, err := decodeProtocol(true, encodedString)
+		assert.NoError(t, err)
+		assert.Equal(t, Payload{MessageType: MessageTypeMOD, Timestamp: timestamp, Recipient: "Oz", Content: "moderator", Status: "role"}, payload)
+	})
+
+	t.Run("should keep separators inside ban reason", func(t *testing.T) {
+		encodedString := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("MOD|%d|Oz|1h spam | flood|ban\r\n", timestamp)))
+
+		payload, err := decodeProtocol(true, encodedString)
+		assert.NoError(t, err)
+		assert.Equal(t, "1h spam | flood", payload.Content)
+		assert.Equal(t, "ban", payload.Status)
+	})
+
+	t.Run("should check for argument in MOD", func(t *testing.T) {
+		encodedString := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("MOD|%d|Oz\r\n", timestamp)))
+		_, err := decodeProtocol(true, encodedString)
+		assert.EqualError(t, err, "invalid MOD format: missing target separator")
+	})
+}
+
+func TestDecodePermanentChannel(t *testing.T) {
+	t.Run("should round trip permanent flag of channel", func(t *testing.T) {
+		payload, err := NewChannelPayloadBuilder().
+			SetRequester("Oz").
+			SetChannelAction(CreateChannel).
+			SetChannelName("lobby").
+			SetChannelSize(10).
+			AddOptionalArg("visibility", VisibilityPublic).
+			AddOptionalArg("permanent", true).
+			Build()
+		assert.NoError(t, err)
+
+		encoded := encodeProtocol(false, *payload)
+		assert.Contains(t, encoded, "visibility=public;permanent=true")
+
+		decoded, err := decodeProtocol(false, encoded)
+		assert.NoError(t, err)
+		assert.True(t, decoded.ChannelPayload.OptionalChannelArgs.Permanent)
+	})
+
+	t.Run("should round trip idle timeout, archive and waitlist flags of channel", func(t *testing.T) {
+		payload, err := NewChannelPayloadBuilder().
+			SetRequester("Oz").
+			SetChannelAction(CreateChannel).
+			SetChannelName("golang").
+			SetChannelSize(10).
+			AddOptionalArg("visibility", VisibilityPublic).
+			AddOptionalArg("idle_timeout", 2*time.Hour).
+			AddOptionalArg("archive", true).
+			AddOptionalArg("waitlist", true).
+			Build()
+		assert.NoError(t, err)
+
+		encoded := encodeProtocol(false, *payload)
+		assert.Contains(t, encoded, "idle_timeout=2h0m0s;archive=true;waitlist=true")
+
+		decoded, err := decodeProtocol(false, encoded)
+		assert.NoError(t, err)
+		assert.Equal(t, 2*time.Hour, decoded.ChannelPayload.OptionalChannelArgs.IdleTimeout)
+		assert.True(t, decoded.ChannelPayload.OptionalChannelArgs.Archive)
+		assert.True(t, decoded.ChannelPayload.OptionalChannelArgs.Waitlist)
+	})
+}
+
+func TestDecodeChannelBans(t *testing.T) {
+	t.Run("should round trip ban duration and ban list", func(t *testing.T) {
+		payload, err := NewChannelPayloadBuilder().
+			SetRequester("Oz").
+			SetChannelAction(BanUser).
+			SetChannelName("golang").
+			AddOptionalArg("target_user", "john").
+			AddOptionalArg("duration", 10*time.Minute).
+			Build()
+		assert.NoError(t, err)
+
+		decoded, err := decodeProtocol(false, encodeProtocol(false, *payload))
+		assert.NoError(t, err)
+		assert.Equal(t, 10*time.Minute, decoded.ChannelPayload.OptionalChannelArgs.Duration)
+
+		bans := []ChannelBan{
+			{Username: "john", BannedBy: "Oz", BannedAt: 1700000000, ExpiresAt: 1700000600},
+			{Username: "jane", BannedBy: "Oz", BannedAt: 1700000000},
+		}
+		payload, err = NewChannelPayloadBuilder().
+			SetRequester("Oz").
+			SetChannelAction(GetBans).
+			SetChannelName("golang").
+			AddOptionalArg("status", StatusSuccess).
+			AddOptionalArg("bans", bans).
+			Build()
+		assert.NoError(t, err)
+
+		encoded := encodeProtocol(false, *payload)
+		assert.Contains(t, encoded, "bans=john:Oz:1700000000:1700000600,jane:Oz:1700000000:0")
+		decoded, err = decodeProtocol(false, encoded)
+		assert.NoError(t, err)
+		assert.Equal(t, bans, decoded.ChannelPayload.OptionalChannelArgs.Bans)
+	})
+}
+
+func TestDecodeChannelSummaries(t *testing.T) {
+	t.Run("should round trip channel summaries with free text topics", func(t *testing.T) {
+		summaries := []ChannelSummary{
+			{Name: "golang", Topic: "Generics: yes, finally; really", Description: "All about Go|gophers", Members: 3, Capacity: 10, CreatedAt: 1700000000, Owner: "Oz", Protected: true, LastActivity: 1700000100},
+			{Name: "lobby", Members: 0, Capacity: 50, CreatedAt: 1600000000, Owner: "admin", LastActivity: 1600000000},
+		}
+		payload, err := NewChannelPayloadBuilder().
+			SetRequester("Oz").
+			SetChannelAction(GetChannels).
+			AddOptionalArg("status", StatusSuccess).
+			AddOptionalArg("summaries", summaries).
+			AddOptionalArg("filter", "go").
+			AddOptionalArg("sort", SortByMembers).
+			Build()
+		assert.NoError(t, err)
+
+		decoded, err := decodeProtocol(false, encodeProtocol(false, *payload))
+		assert.NoError(t, err)
+		assert.Equal(t, summaries, decoded.ChannelPayload.OptionalChannelArgs.Summaries)
+		assert.Equal(t, "go", decoded.ChannelPayload.OptionalChannelArgs.Filter)
+		assert.Equal(t, SortByMembers, decoded.ChannelPayload.OptionalChannelArgs.Sort)
+	})
+}
+
+func TestDecodeChannelMetadata(t *testing.T) {
+	t.Run("should round trip topics and descriptions containing separators", func(t *testing.T) {
+		payload, err := NewChannelPayloadBuilder().
+			SetRequester("Oz").
+			SetChannelAction(SetTopic).
+			SetChannelName("golang").
+			AddOptionalArg("status", StatusSuccess).
+			AddOptionalArg("topic", "a; b;status=fail").
+			AddOptionalArg("description", "Go|gophers, 100% of them").
+			Build()
+		assert.NoError(t, err)
+
+		decoded, err := decodeProtocol(false, encodeProtocol(false, *payload))
+		assert.NoError(t, err)
+		assert.Equal(t, "a; b;status=fail", decoded.ChannelPayload.OptionalChannelArgs.Topic)
+		assert.Equal(t, "Go|gophers, 100% of them", decoded.ChannelPayload.OptionalChannelArgs.Description)
+		assert.Equal(t, StatusSuccess, decoded.ChannelPayload.OptionalChannelArgs.Status)
+	})
+}
+
+func TestDecodeHeartbeatMessage(t *testing.T) {
+	timestamp := time.Now().Unix()
+	t.Run("should decode ping and pong messages into payload successfully", func(t *testing.T) {
+		for _, messageType := range []MessageType{MessageTypePING, MessageTypePONG} {
+			encodedString := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s|%d\r\n", messageType, timestamp)))
+
+			payload, err := decodeProtocol(true, encodedString)
+			assert.NoError(t, err)
+			assert.Equal(t, Payload{MessageType: messageType, Timestamp: timestamp}, payload)
+		}
+	})
+
+	t.Run("should reject heartbeat without valid timestamp", func(t *testing.T) {
+		encodedString := base64.StdEncoding.EncodeToString([]byte("PING|abc\r\n"))
+		_, err := decodeProtocol(true, encodedString)
+		assert.Error(t, err)
+	})
+}
+
 func TestDecodeActiveUsrMessage(t *testing.T) {
 	timestamp := time.Now().Unix()
 	encodedString := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("ACT_USRS|%d|hey,there|res\r\n", timestamp)))
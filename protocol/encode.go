@@ -24,6 +24,15 @@ func encodeProtocol(isBase64 bool, payload Payload) string {
 		sb.WriteString(fmt.Sprintf("%s|%d|", messageType, timestamp))
 	}
 
+	// Heartbeats carry nothing but the timestamp, so there is no trailing separator
+	writeHeartbeat := func(messageType MessageType) {
+		timestamp := payload.Timestamp
+		if timestamp == 0 {
+			timestamp = time.Now().Unix()
+		}
+		sb.WriteString(fmt.Sprintf("%s|%d", messageType, timestamp))
+	}
+
 	messageFormatters := map[MessageType]func(){
 		MessageTypeMSG: func() {
 			writeCommonPrefix(payload.MessageType)
@@ -56,6 +65,28 @@ func encodeProtocol(isBase64 bool, payload Payload) string {
 			writeCommonPrefix(payload.MessageType)
 			sb.WriteString(fmt.Sprintf("%s|%s|%s", payload.Sender, strings.Join(payload.EncodedChatHistory, ","), payload.Status))
 		},
+		MessageTypeSESS: func() {
+			writeCommonPrefix(payload.MessageType)
+			sb.WriteString(fmt.Sprintf("%s|%s|%s", payload.Username, payload.SessionToken, payload.Status))
+		},
+		MessageTypePASSWD: func() {
+			writeCommonPrefix(payload.MessageType)
+			sb.WriteString(fmt.Sprintf("%s|%s", payload.Password, payload.NewPassword))
+		},
+		MessageTypeDEL_ACCT: func() {
+			writeCommonPrefix(payload.MessageType)
+			sb.WriteString(payload.Password)
+		},
+		MessageTypeMOD: func() {
+			writeCommonPrefix(payload.MessageType)
+			sb.WriteString(fmt.Sprintf("%s|%s|%s", payload.Recipient, payload.Content, payload.Status))
+		},
+		MessageTypePING: func() {
+			writeHeartbeat(payload.MessageType)
+		},
+		MessageTypePONG: func() {
+			writeHeartbeat(payload.MessageType)
+		},
 		MessageTypeCH: func() {
 			sb.WriteString(encodeCH(&payload))
 		},
@@ -2,6 +2,8 @@ package protocol
 
 import (
 	"fmt"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -10,6 +12,8 @@ const (
 	// If args are empty it will return empty string
 	optionalArgsSeparator            = ";"
 	OptionalUserAndChannelsSeparator = ","
+	channelBanFieldSeparator         = ":"
+	channelSummaryFieldSeparator     = ":"
 	EmptyChannelField                = "-"
 )
 
@@ -74,6 +78,32 @@ func (b *ChannelPayloadBuilder) AddOptionalArg(key string, value interface{}) *C
 		b.payload.ChannelPayload.OptionalChannelArgs.TargetUser = value.(string)
 	case "notice":
 		b.payload.ChannelPayload.OptionalChannelArgs.Notice = value.(string)
+	case "permanent":
+		b.payload.ChannelPayload.OptionalChannelArgs.Permanent = value.(bool)
+	case "duration":
+		b.payload.ChannelPayload.OptionalChannelArgs.Duration = value.(time.Duration)
+	case "bans":
+		b.payload.ChannelPayload.OptionalChannelArgs.Bans = value.([]ChannelBan)
+	case "invite_code":
+		b.payload.ChannelPayload.OptionalChannelArgs.InviteCode = value.(string)
+	case "topic":
+		b.payload.ChannelPayload.OptionalChannelArgs.Topic = value.(string)
+	case "description":
+		b.payload.ChannelPayload.OptionalChannelArgs.Description = value.(string)
+	case "created_at":
+		b.payload.ChannelPayload.OptionalChannelArgs.CreatedAt = value.(int64)
+	case "summaries":
+		b.payload.ChannelPayload.OptionalChannelArgs.Summaries = value.([]ChannelSummary)
+	case "filter":
+		b.payload.ChannelPayload.OptionalChannelArgs.Filter = value.(string)
+	case "sort":
+		b.payload.ChannelPayload.OptionalChannelArgs.Sort = value.(ChannelSort)
+	case "idle_timeout":
+		b.payload.ChannelPayload.OptionalChannelArgs.IdleTimeout = value.(time.Duration)
+	case "archive":
+		b.payload.ChannelPayload.OptionalChannelArgs.Archive = value.(bool)
+	case "waitlist":
+		b.payload.ChannelPayload.OptionalChannelArgs.Waitlist = value.(bool)
 	}
 	return b
 }
@@ -165,5 +195,81 @@ func serializeChannelOptionalArgs(args *OptionalChannelArgs) string {
 		optsParts = append(optsParts, "target_user="+args.TargetUser)
 	}
 
+	if args.Permanent {
+		optsParts = append(optsParts, "permanent=true")
+	}
+
+	if args.Duration != 0 {
+		optsParts = append(optsParts, "duration="+args.Duration.String())
+	}
+
+	if args.Bans != nil {
+		bans := make([]string, 0, len(args.Bans))
+		for _, ban := range args.Bans {
+			bans = append(bans, strings.Join([]string{
+				ban.Username,
+				ban.BannedBy,
+				strconv.FormatInt(ban.BannedAt, 10),
+				strconv.FormatInt(ban.ExpiresAt, 10),
+			}, channelBanFieldSeparator))
+		}
+		optsParts = append(optsParts, "bans="+strings.Join(bans, OptionalUserAndChannelsSeparator))
+	}
+
+	if args.InviteCode != "" {
+		optsParts = append(optsParts, "invite_code="+args.InviteCode)
+	}
+
+	// Topics and descriptions are free text, so they're escaped to not clash with the separators
+	if args.Topic != "" {
+		optsParts = append(optsParts, "topic="+url.QueryEscape(args.Topic))
+	}
+
+	if args.Description != "" {
+		optsParts = append(optsParts, "description="+url.QueryEscape(args.Description))
+	}
+
+	if args.CreatedAt != 0 {
+		optsParts = append(optsParts, "created_at="+strconv.FormatInt(args.CreatedAt, 10))
+	}
+
+	if args.Summaries != nil {
+		summaries := make([]string, 0, len(args.Summaries))
+		for _, summary := range args.Summaries {
+			summaries = append(summaries, strings.Join([]string{
+				url.QueryEscape(summary.Name),
+				url.QueryEscape(summary.Topic),
+				url.QueryEscape(summary.Description),
+				strconv.Itoa(summary.Members),
+				strconv.Itoa(summary.Capacity),
+				strconv.FormatInt(summary.CreatedAt, 10),
+				url.QueryEscape(summary.Owner),
+				strconv.FormatBool(summary.Protected),
+				strconv.FormatInt(summary.LastActivity, 10),
+			}, channelSummaryFieldSeparator))
+		}
+		optsParts = append(optsParts, "summaries="+strings.Join(summaries, OptionalUserAndChannelsSeparator))
+	}
+
+	if args.Filter != "" {
+		optsParts = append(optsParts, "filter="+args.Filter)
+	}
+
+	if args.Sort != "" {
+		optsParts = append(optsParts, "sort="+string(args.Sort))
+	}
+
+	if args.IdleTimeout != 0 {
+		optsParts = append(optsParts, "idle_timeout="+args.IdleTimeout.String())
+	}
+
+	if args.Archive {
+		optsParts = append(optsParts, "archive=true")
+	}
+
+	if args.Waitlist {
+		optsParts = append(optsParts, "waitlist=true")
+	}
+
 	return strings.Join(optsParts, optionalArgsSeparator)
 }
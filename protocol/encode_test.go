@@ -117,6 +117,76 @@ func TestEncodeUsrMessage(t *testing.T) {
 	})
 }
 
+func TestEncodeSessionMessage(t *testing.T) {
+	t.Run("should encode session message successfully", func(t *testing.T) {
+		tests := []struct {
+			username string
+			token    string
+			status   string
+			expected string
+		}{
+			{"Oz", "abc.def", "res", fmt.Sprintf("SESS|%d|Oz|abc.def|res\r\n", time.Now().Unix())},
+			{"Oz", "abc.def", "resume", fmt.Sprintf("SESS|%d|Oz|abc.def|resume\r\n", time.Now().Unix())},
+			{"", "", "fail", fmt.Sprintf("SESS|%d|||fail\r\n", time.Now().Unix())},
+		}
+		for _, test := range tests {
+			result := encodeProtocol(true, Payload{MessageType: MessageTypeSESS, Username: test.username, SessionToken: test.token, Status: test.status})
+			decoded, _ := base64.StdEncoding.DecodeString(result)
+			assert.Equal(t, test.expected, string(decoded))
+		}
+	})
+}
+
+func TestEncodeAccountMessages(t *testing.T) {
+	t.Run("should encode password change message successfully", func(t *testing.T) {
+		result := encodeProtocol(true, Payload{MessageType: MessageTypePASSWD, Password: "Old1234.", NewPassword: "New1234."})
+		decoded, _ := base64.StdEncoding.DecodeString(result)
+		assert.Equal(t, fmt.Sprintf("PASSWD|%d|Old1234.|New1234.\r\n", time.Now().Unix()), string(decoded))
+	})
+
+	t.Run("should encode account deletion message successfully", func(t *testing.T) {
+		result := encodeProtocol(true, Payload{MessageType: MessageTypeDEL_ACCT, Password: "Old1234."})
+		decoded, _ := base64.StdEncoding.DecodeString(result)
+		assert.Equal(t, fmt.Sprintf("DEL_ACCT|%d|Old1234.\r\n", time.Now().Unix()), string(decoded))
+	})
+}
+
+func TestEncodeModerationMessage(t *testing.T) {
+	t.Run("should encode moderation message successfully", func(t *testing.T) {
+		tests := []struct {
+			target   string
+			argument string
+			action   string
+			expected string
+		}{
+			{"Oz", "10m", ModActionBan, fmt.Sprintf("MOD|%d|Oz|10m|ban\r\n", time.Now().Unix())},
+			{"Oz", "", ModActionKick, fmt.Sprintf("MOD|%d|Oz||kick\r\n", time.Now().Unix())},
+		}
+		for _, test := range tests {
+			result := encodeProtocol(true, Payload{MessageType: MessageTypeMOD, Recipient: test.target, Content: test.argument, Status: test.action})
+			decoded, _ := base64.StdEncoding.DecodeString(result)
+			assert.Equal(t, test.expected, string(decoded))
+		}
+	})
+}
+
+func TestEncodeHeartbeatMessage(t *testing.T) {
+	t.Run("should encode ping and pong messages successfully", func(t *testing.T) {
+		tests := []struct {
+			messageType MessageType
+			expected    string
+		}{
+			{MessageTypePING, "PING|1234567890\r\n"},
+			{MessageTypePONG, "PONG|1234567890\r\n"},
+		}
+		for _, test := range tests {
+			result := encodeProtocol(true, Payload{MessageType: test.messageType, Timestamp: 1234567890})
+			decoded, _ := base64.StdEncoding.DecodeString(result)
+			assert.Equal(t, test.expected, string(decoded))
+		}
+	})
+}
+
 func TestEncodeActiveUsrMessage(t *testing.T) {
 	t.Run("should encode active users message successfully", func(t *testing.T) {
 		tests := []struct {
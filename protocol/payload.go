@@ -4,8 +4,13 @@ package protocol
 // Whisper/DM Message (WSP): 		WSP|timestamp|sender|recipient|message_content\r\n
 // System Notice (SYS): 			SYS|timestamp|message_content|status \r\n status = "fail" | "success"
 // Active Users(ACT_USRS):			ACT_USRS|timestampactive_user_array|status\r\n status = "res" | "req"
-// Username Message(USR): 			USR|timestamp|username|password|status\r\n status = "fail | "success"
-// Chat History(HSTRY): 			HSTRY|timestamp|requester|messages_array|status\r\n status = "res" | "req"
+// Username Message(USR): 			USR|timestamp|username|password|status\r\n status = "login" | "register" when requesting, "fail | "success" when responding
+// Chat History(HSTRY): 			HSTRY|timestamp|requester|messages_array|status\r\n status = "res" | "req" | "missed" when replaying messages after a resumed session | "channel" when sending channel messages, which are CH frames
+// Session(SESS): 				SESS|timestamp|username|token|status\r\n status = "resume" when requesting, "res" when issued, "fail" when token is rejected
+// Password Change(PASSWD): 		PASSWD|timestamp|current_password|new_password\r\n answered with SYS
+// Account Deletion(DEL_ACCT): 		DEL_ACCT|timestamp|password\r\n answered with SYS, connection is closed on success
+// Moderation(MOD): 				MOD|timestamp|target|argument|action\r\n action = "kick" | "mute" | "unmute" | "ban" | "banip" | "unban" | "bans" | "role", argument is a duration, "duration reason" for bans, or a role. Answered with SYS
+// Heartbeat(PING/PONG): 			PING|timestamp\r\n answered with PONG|timestamp\r\n, keeps idle connections alive
 // Chat Channel(CH): 				CH|timestamp|room_action|requester|roomName|roomPassword|roomSize|optional_args
 
 const Separator = "|"
@@ -21,6 +26,37 @@ const (
 	MessageTypeACT_USRS MessageType = "ACT_USRS" //Active users
 	MessageTypeHSTRY    MessageType = "HSTRY"    //Chat history
 	MessageTypeCH       MessageType = "CH"
+	MessageTypeSESS     MessageType = "SESS"     //Session token
+	MessageTypePING     MessageType = "PING"     //Heartbeat request
+	MessageTypePONG     MessageType = "PONG"     //Heartbeat response
+	MessageTypePASSWD   MessageType = "PASSWD"   //Password change
+	MessageTypeDEL_ACCT MessageType = "DEL_ACCT" //Account deletion
+	MessageTypeMOD      MessageType = "MOD"      //Server-wide moderation
+)
+
+// Auth actions, sent in status field of USR requests. Empty status means login.
+const (
+	AuthActionLogin    = "login"
+	AuthActionRegister = "register"
+)
+
+// Moderation actions, sent in status field of MOD messages.
+const (
+	ModActionKick   = "kick"
+	ModActionMute   = "mute"
+	ModActionUnmute = "unmute"
+	ModActionBan    = "ban"
+	ModActionBanIP  = "banip" // Bans the username along with the IPs it's connected from
+	ModActionUnban  = "unban"
+	ModActionBans   = "bans" // Lists active bans, target is empty
+	ModActionRole   = "role"
+)
+
+// Session statuses, sent in status field of SESS messages.
+const (
+	SessionStatusResume = "resume"
+	SessionStatusIssued = "res"
+	SessionStatusFail   = "fail"
 )
 
 type Payload struct {
@@ -31,8 +67,11 @@ type Payload struct {
 	Recipient   string
 	Status      string
 
-	Username string
-	Password string
+	Username    string
+	Password    string
+	NewPassword string
+
+	SessionToken string
 
 	ActiveUsers []string
 
@@ -0,0 +1,43 @@
+package chat_ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock abstracts time so limiters can be tested deterministically
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// RealClock is the wall clock used outside of tests
+var RealClock Clock = realClock{}
+
+// FakeClock is a manually advanced clock for tests
+type FakeClock struct {
+	now time.Time
+	mu  sync.Mutex
+}
+
+// NewFakeClock returns a FakeClock frozen at the given time
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+// Now returns the current fake time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the fake time forward by the given duration
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
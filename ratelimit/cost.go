@@ -0,0 +1,93 @@
+package chat_ratelimit
+
+import "github.com/ogzhanolguncu/go-chat/protocol"
+
+// MessageCost represents the number of tokens a single message consumes
+type MessageCost uint8
+
+// CostTable assigns token costs to incoming messages.
+// Channel frames are priced by their ChannelActionType, everything else by MessageType.
+// Anything missing from the tables is charged the Default cost.
+// Frames clients send on their own are marked as background, they're charged to a separate budget.
+type CostTable struct {
+	Default           MessageCost
+	MessageTypes      map[protocol.MessageType]MessageCost
+	ChannelActions    map[protocol.ChannelActionType]MessageCost
+	BackgroundTypes   map[protocol.MessageType]bool
+	BackgroundActions map[protocol.ChannelActionType]bool
+}
+
+// DefaultCostTable returns the cost table used by the server.
+// Typing indicators, active user polls and heartbeats are sent automatically, so they're background frames.
+// They can't eat into the budget of what users type, but can't be flooded either.
+// Chat history and account changes are more expensive because they hit the database.
+func DefaultCostTable() CostTable {
+	return CostTable{
+		Default: 1,
+		MessageTypes: map[protocol.MessageType]MessageCost{
+			protocol.MessageTypeMSG:      1,
+			protocol.MessageTypeWSP:      1,
+			protocol.MessageTypeBLCK_USR: 1,
+			protocol.MessageTypeACT_USRS: 1,
+			protocol.MessageTypePING:     1,
+			protocol.MessageTypeHSTRY:    2,
+			protocol.MessageTypePASSWD:   2,
+			protocol.MessageTypeDEL_ACCT: 2,
+		},
+		ChannelActions: map[protocol.ChannelActionType]MessageCost{
+			protocol.CreateChannel:   2,
+			protocol.JoinChannel:     1,
+			protocol.LeaveChannel:    1,
+			protocol.KickUser:        1,
+			protocol.BanUser:         1,
+			protocol.GetUsers:        1,
+			protocol.GetChannels:     1,
+			protocol.MessageChannel:  1,
+			protocol.TypingChannel:   1,
+			protocol.HistoryChannel:  2,
+			protocol.OpUser:          1,
+			protocol.DeopUser:        1,
+			protocol.TransferChannel: 1,
+			protocol.UnbanUser:       1,
+			protocol.GetBans:         1,
+			protocol.InviteUser:      2,
+			protocol.AcceptInvite:    1,
+			protocol.SetTopic:        1,
+			protocol.SetDescription:  1,
+			protocol.MuteUser:        1,
+			protocol.UnmuteUser:      1,
+			protocol.SetSlowMode:     1,
+			protocol.ResizeChannel:   1,
+		},
+		BackgroundTypes: map[protocol.MessageType]bool{
+			protocol.MessageTypeACT_USRS: true,
+			protocol.MessageTypePING:     true,
+		},
+		BackgroundActions: map[protocol.ChannelActionType]bool{
+			protocol.TypingChannel: true,
+		},
+	}
+}
+
+// IsBackground reports whether the payload is charged to the background budget
+func (ct CostTable) IsBackground(payload protocol.Payload) bool {
+	if payload.MessageType == protocol.MessageTypeCH && payload.ChannelPayload != nil {
+		return ct.BackgroundActions[payload.ChannelPayload.ChannelAction]
+	}
+	return ct.BackgroundTypes[payload.MessageType]
+}
+
+// Cost returns how many tokens the given payload consumes
+func (ct CostTable) Cost(payload protocol.Payload) MessageCost {
+	if payload.MessageType == protocol.MessageTypeCH && payload.ChannelPayload != nil {
+		if cost, ok := ct.ChannelActions[payload.ChannelPayload.ChannelAction]; ok {
+			return cost
+		}
+		return ct.Default
+	}
+
+	if cost, ok := ct.MessageTypes[payload.MessageType]; ok {
+		return cost
+	}
+	return ct.Default
+}
@@ -0,0 +1,85 @@
+package chat_ratelimit
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ogzhanolguncu/go-chat/protocol"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCostTable(t *testing.T) {
+	costTable := DefaultCostTable()
+
+	chPayload := func(action protocol.ChannelActionType) protocol.Payload {
+		return protocol.Payload{
+			MessageType:    protocol.MessageTypeCH,
+			ChannelPayload: &protocol.ChannelPayload{ChannelAction: action},
+		}
+	}
+
+	tests := []struct {
+		testName string
+		payload  protocol.Payload
+		expected MessageCost
+	}{
+		{"group message costs one token", protocol.Payload{MessageType: protocol.MessageTypeMSG}, 1},
+		{"whisper costs one token", protocol.Payload{MessageType: protocol.MessageTypeWSP}, 1},
+		{"block user costs one token", protocol.Payload{MessageType: protocol.MessageTypeBLCK_USR}, 1},
+		{"active users poll costs one token", protocol.Payload{MessageType: protocol.MessageTypeACT_USRS}, 1},
+		{"heartbeat costs one token", protocol.Payload{MessageType: protocol.MessageTypePING}, 1},
+		{"chat history costs two tokens", protocol.Payload{MessageType: protocol.MessageTypeHSTRY}, 2},
+		{"unknown message type falls back to default", protocol.Payload{MessageType: "UNKNOWN"}, costTable.Default},
+		{"channel typing costs one token", chPayload(protocol.TypingChannel), 1},
+		{"channel message costs one token", chPayload(protocol.MessageChannel), 1},
+		{"channel create costs two tokens", chPayload(protocol.CreateChannel), 2},
+		{"channel join costs one token", chPayload(protocol.JoinChannel), 1},
+		{"unknown channel action falls back to default", chPayload(protocol.ChannelActionType(999)), costTable.Default},
+		{"channel frame without channel payload falls back to default", protocol.Payload{MessageType: protocol.MessageTypeCH}, costTable.Default},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.testName, func(t *testing.T) {
+			require.Equal(t, tt.expected, costTable.Cost(tt.payload))
+		})
+	}
+
+	t.Run("automatic frames are charged to the background budget", func(t *testing.T) {
+		require.True(t, costTable.IsBackground(protocol.Payload{MessageType: protocol.MessageTypePING}))
+		require.True(t, costTable.IsBackground(protocol.Payload{MessageType: protocol.MessageTypeACT_USRS}))
+		require.True(t, costTable.IsBackground(chPayload(protocol.TypingChannel)))
+		require.False(t, costTable.IsBackground(protocol.Payload{MessageType: protocol.MessageTypeMSG}))
+		require.False(t, costTable.IsBackground(chPayload(protocol.MessageChannel)))
+	})
+}
+
+func TestCheckCost(t *testing.T) {
+	t.Run("should consume tokens according to cost", func(t *testing.T) {
+		ratelimit := NewRatelimit(TokenBucket{RefillInterval: time.Hour, RefillRate: 1, BucketLimit: 5})
+		conn := &net.TCPConn{}
+		ratelimit.Add(conn)
+
+		require.True(t, ratelimit.CheckCost(conn, 2))
+		require.True(t, ratelimit.CheckCost(conn, 2))
+		require.Equal(t, AvailableToken(1), ratelimit.userRatelimitMap[conn])
+
+		require.False(t, ratelimit.CheckCost(conn, 2))
+		require.Equal(t, AvailableToken(1), ratelimit.userRatelimitMap[conn])
+	})
+
+	t.Run("should always allow free messages even when bucket is empty", func(t *testing.T) {
+		ratelimit := NewRatelimit(TokenBucket{RefillInterval: time.Hour, RefillRate: 1, BucketLimit: 1})
+		conn := &net.TCPConn{}
+		ratelimit.Add(conn)
+
+		require.True(t, ratelimit.Check(conn))
+		require.False(t, ratelimit.Check(conn))
+		require.True(t, ratelimit.CheckCost(conn, 0))
+	})
+
+	t.Run("should reject free messages from unknown connections", func(t *testing.T) {
+		ratelimit := NewRatelimit(TokenBucket{RefillInterval: time.Hour, RefillRate: 1, BucketLimit: 1})
+		require.False(t, ratelimit.CheckCost(&net.TCPConn{}, 0))
+	})
+}
@@ -0,0 +1,182 @@
+package chat_ratelimit
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Limiter decides whether a single subject (e.g. a connection) may spend n units right now.
+// Implementations compute their state lazily from timestamps, so they need no background goroutine.
+type Limiter interface {
+	// AllowN reports whether n units can be spent now and spends them if so
+	AllowN(n int) bool
+}
+
+// TokenBucketLimiter is a token bucket that refills based on elapsed time instead of a ticker.
+// It starts full and allows bursts up to its limit.
+type TokenBucketLimiter struct {
+	limit      float64
+	refillRate float64 // Tokens per second
+	tokens     float64
+	lastRefill time.Time
+	clock      Clock
+	mu         sync.Mutex
+}
+
+// NewTokenBucketLimiter creates a bucket holding up to limit tokens, adding refillAmount tokens every refillInterval
+func NewTokenBucketLimiter(limit, refillAmount int, refillInterval time.Duration, clock Clock) *TokenBucketLimiter {
+	return &TokenBucketLimiter{
+		limit:      float64(limit),
+		refillRate: float64(refillAmount) / refillInterval.Seconds(),
+		tokens:     float64(limit),
+		lastRefill: clock.Now(),
+		clock:      clock,
+	}
+}
+
+func (tb *TokenBucketLimiter) AllowN(n int) bool {
+	tb.mu.Lock()
+	defer tb.mu.Unlock()
+
+	now := tb.clock.Now()
+	elapsed := now.Sub(tb.lastRefill).Seconds()
+	if elapsed > 0 {
+		tb.tokens = min(tb.limit, tb.tokens+elapsed*tb.refillRate)
+		tb.lastRefill = now
+	}
+
+	if tb.tokens < float64(n) {
+		return false
+	}
+	tb.tokens -= float64(n)
+	return true
+}
+
+// SlidingWindowLog keeps a timestamp for every spent unit and allows at most limit units in any window.
+// It is exact but memory grows with the limit, so it suits small limits.
+type SlidingWindowLog struct {
+	limit  int
+	window time.Duration
+	log    []time.Time
+	clock  Clock
+	mu     sync.Mutex
+}
+
+// NewSlidingWindowLog creates a limiter allowing limit units per window
+func NewSlidingWindowLog(limit int, window time.Duration, clock Clock) *SlidingWindowLog {
+	return &SlidingWindowLog{
+		limit:  limit,
+		window: window,
+		log:    make([]time.Time, 0, limit),
+		clock:  clock,
+	}
+}
+
+func (sw *SlidingWindowLog) AllowN(n int) bool {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+
+	now := sw.clock.Now()
+	windowStart := now.Add(-sw.window)
+
+	// Drop entries that fell out of the window, log is always sorted
+	expired := 0
+	for expired < len(sw.log) && !sw.log[expired].After(windowStart) {
+		expired++
+	}
+	sw.log = sw.log[expired:]
+
+	if len(sw.log)+n > sw.limit {
+		return false
+	}
+	for i := 0; i < n; i++ {
+		sw.log = append(sw.log, now)
+	}
+	return true
+}
+
+// GCRA implements the generic cell rate algorithm.
+// It only stores the theoretical arrival time of the next unit, making it the cheapest of the three.
+type GCRA struct {
+	emissionInterval time.Duration // Time it takes to earn a single unit
+	burstTolerance   time.Duration // How far ahead of schedule a caller may be
+	tat              time.Time     // Theoretical arrival time
+	clock            Clock
+	mu               sync.Mutex
+}
+
+// NewGCRA creates a limiter allowing rate units per period with bursts up to burst units.
+// A rate below 1 is treated as 1.
+func NewGCRA(rate int, period time.Duration, burst int, clock Clock) *GCRA {
+	emissionInterval := period / time.Duration(max(rate, 1))
+	return &GCRA{
+		emissionInterval: emissionInterval,
+		burstTolerance:   emissionInterval * time.Duration(burst),
+		tat:              clock.Now(),
+		clock:            clock,
+	}
+}
+
+func (g *GCRA) AllowN(n int) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	now := g.clock.Now()
+	tat := g.tat
+	if now.After(tat) {
+		tat = now
+	}
+
+	newTat := tat.Add(g.emissionInterval * time.Duration(n))
+	allowAt := newTat.Add(-g.burstTolerance)
+	if now.Before(allowAt) {
+		return false
+	}
+	g.tat = newTat
+	return true
+}
+
+// ConnLimiter rate limits multiple connections, giving each its own Limiter.
+// Unlike Ratelimit it has no refill goroutine, limiters catch up whenever they are checked.
+type ConnLimiter struct {
+	limiters   map[net.Conn]Limiter
+	newLimiter func() Limiter
+	mu         sync.Mutex
+}
+
+// NewConnLimiter creates a ConnLimiter that builds a fresh Limiter for every added connection
+func NewConnLimiter(newLimiter func() Limiter) *ConnLimiter {
+	return &ConnLimiter{
+		limiters:   make(map[net.Conn]Limiter),
+		newLimiter: newLimiter,
+	}
+}
+
+// Add adds a new connection to the rate limiter
+func (cl *ConnLimiter) Add(conn net.Conn) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	if _, exists := cl.limiters[conn]; !exists {
+		cl.limiters[conn] = cl.newLimiter()
+	}
+}
+
+// Remove removes a connection from the rate limiter
+func (cl *ConnLimiter) Remove(conn net.Conn) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	delete(cl.limiters, conn)
+}
+
+// CheckCost reports whether the connection may spend the given cost and spends it if so.
+// Returns false for unknown connections.
+func (cl *ConnLimiter) CheckCost(conn net.Conn, cost MessageCost) bool {
+	cl.mu.Lock()
+	limiter, exists := cl.limiters[conn]
+	cl.mu.Unlock()
+	if !exists {
+		return false
+	}
+	return limiter.AllowN(int(cost))
+}
@@ -0,0 +1,148 @@
+package chat_ratelimit
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenBucketLimiter(t *testing.T) {
+	t.Run("should allow bursts up to the limit", func(t *testing.T) {
+		clock := NewFakeClock(time.Unix(0, 0))
+		limiter := NewTokenBucketLimiter(3, 1, time.Second, clock)
+
+		require.True(t, limiter.AllowN(1))
+		require.True(t, limiter.AllowN(2))
+		require.False(t, limiter.AllowN(1))
+	})
+
+	t.Run("should refill lazily based on elapsed time", func(t *testing.T) {
+		clock := NewFakeClock(time.Unix(0, 0))
+		limiter := NewTokenBucketLimiter(2, 1, 3*time.Second, clock)
+
+		require.True(t, limiter.AllowN(2))
+		require.False(t, limiter.AllowN(1))
+
+		clock.Advance(2 * time.Second)
+		require.False(t, limiter.AllowN(1))
+
+		clock.Advance(time.Second)
+		require.True(t, limiter.AllowN(1))
+		require.False(t, limiter.AllowN(1))
+	})
+
+	t.Run("should never refill above the limit", func(t *testing.T) {
+		clock := NewFakeClock(time.Unix(0, 0))
+		limiter := NewTokenBucketLimiter(2, 1, time.Second, clock)
+
+		clock.Advance(time.Hour)
+		require.True(t, limiter.AllowN(2))
+		require.False(t, limiter.AllowN(1))
+	})
+}
+
+func TestSlidingWindowLog(t *testing.T) {
+	t.Run("should allow at most limit units per window", func(t *testing.T) {
+		clock := NewFakeClock(time.Unix(0, 0))
+		limiter := NewSlidingWindowLog(3, 10*time.Second, clock)
+
+		require.True(t, limiter.AllowN(1))
+		clock.Advance(4 * time.Second)
+		require.True(t, limiter.AllowN(2))
+		require.False(t, limiter.AllowN(1))
+
+		// First entry leaves the window
+		clock.Advance(6 * time.Second)
+		require.True(t, limiter.AllowN(1))
+		require.False(t, limiter.AllowN(1))
+
+		// Remaining entries leave the window
+		clock.Advance(4 * time.Second)
+		require.True(t, limiter.AllowN(2))
+	})
+
+	t.Run("should reject requests larger than the limit", func(t *testing.T) {
+		clock := NewFakeClock(time.Unix(0, 0))
+		limiter := NewSlidingWindowLog(2, time.Second, clock)
+
+		require.False(t, limiter.AllowN(3))
+		require.True(t, limiter.AllowN(2))
+	})
+}
+
+func TestGCRA(t *testing.T) {
+	t.Run("should allow bursts up to burst size", func(t *testing.T) {
+		clock := NewFakeClock(time.Unix(0, 0))
+		limiter := NewGCRA(1, time.Second, 3, clock)
+
+		require.True(t, limiter.AllowN(1))
+		require.True(t, limiter.AllowN(1))
+		require.True(t, limiter.AllowN(1))
+		require.False(t, limiter.AllowN(1))
+	})
+
+	t.Run("should earn one unit per emission interval", func(t *testing.T) {
+		clock := NewFakeClock(time.Unix(0, 0))
+		limiter := NewGCRA(2, time.Second, 1, clock)
+
+		require.True(t, limiter.AllowN(1))
+		require.False(t, limiter.AllowN(1))
+
+		clock.Advance(400 * time.Millisecond)
+		require.False(t, limiter.AllowN(1))
+
+		clock.Advance(100 * time.Millisecond)
+		require.True(t, limiter.AllowN(1))
+	})
+
+	t.Run("should not bank unused capacity beyond burst", func(t *testing.T) {
+		clock := NewFakeClock(time.Unix(0, 0))
+		limiter := NewGCRA(1, time.Second, 2, clock)
+
+		clock.Advance(time.Hour)
+		require.True(t, limiter.AllowN(2))
+		require.False(t, limiter.AllowN(1))
+	})
+
+	t.Run("should treat a rate below one as one", func(t *testing.T) {
+		clock := NewFakeClock(time.Unix(0, 0))
+		limiter := NewGCRA(0, time.Second, 1, clock)
+
+		require.True(t, limiter.AllowN(1))
+		require.False(t, limiter.AllowN(1))
+
+		clock.Advance(time.Second)
+		require.True(t, limiter.AllowN(1))
+	})
+}
+
+func TestConnLimiter(t *testing.T) {
+	t.Run("should give every connection its own limiter", func(t *testing.T) {
+		clock := NewFakeClock(time.Unix(0, 0))
+		connLimiter := NewConnLimiter(func() Limiter {
+			return NewTokenBucketLimiter(1, 1, time.Second, clock)
+		})
+		conn1, conn2 := &net.TCPConn{}, &net.TCPConn{}
+		connLimiter.Add(conn1)
+		connLimiter.Add(conn2)
+
+		require.True(t, connLimiter.CheckCost(conn1, 1))
+		require.False(t, connLimiter.CheckCost(conn1, 1))
+		require.True(t, connLimiter.CheckCost(conn2, 1))
+		require.True(t, connLimiter.CheckCost(conn1, 0))
+	})
+
+	t.Run("should reject removed connections", func(t *testing.T) {
+		connLimiter := NewConnLimiter(func() Limiter {
+			return NewGCRA(1, time.Second, 1, RealClock)
+		})
+		conn := &net.TCPConn{}
+
+		connLimiter.Add(conn)
+		connLimiter.Remove(conn)
+
+		require.False(t, connLimiter.CheckCost(conn, 1))
+	})
+}
@@ -8,7 +8,7 @@ import (
 
 // TokenBucket represents the configuration for a token bucket rate limiter.
 // A bucket fills with tokens at a constant rate.
-// Each message consumes one token.
+// Each message consumes tokens according to its cost, see CostTable.
 // If the bucket is empty, messages are rejected.
 // The bucket has a maximum capacity to limit burst size.
 type TokenBucket struct {
@@ -79,12 +79,18 @@ func (r *Ratelimit) Remove(conn net.Conn) {
 // Check checks if the connection is rate limited and consumes a token if available
 // Returns true if a token was consumed, false if rate limited or connection not found
 func (r *Ratelimit) Check(conn net.Conn) bool {
+	return r.CheckCost(conn, 1)
+}
+
+// CheckCost works like Check but consumes the given number of tokens.
+// Zero cost messages are always allowed for known connections.
+func (r *Ratelimit) CheckCost(conn net.Conn, cost MessageCost) bool {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	tokens, exists := r.userRatelimitMap[conn]
-	if !exists || tokens == 0 {
+	if !exists || tokens < AvailableToken(cost) {
 		return false
 	}
-	r.userRatelimitMap[conn] = tokens - 1
+	r.userRatelimitMap[conn] = tokens - AvailableToken(cost)
 	return true
 }
@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+// DeletedUsername replaces the name of deleted accounts in stored history, it can't be registered
+const DeletedUsername = "[deleted]"
+
+var ErrReservedUsername = errors.New("username is reserved")
+
+// ChangePassword replaces the user's password after verifying the current one.
+// The check goes through Login, so a hijacked session can't guess the password faster than a login could.
+func (am *AuthManager) ChangePassword(username, currentPassword, newPassword string) error {
+	if _, err := am.Login(username, currentPassword, ""); err != nil {
+		return err
+	}
+	if err := validatePassword(newPassword); err != nil {
+		return err
+	}
+
+	hashedPass, err := hashPassword(newPassword)
+	if err != nil {
+		return fmt.Errorf("could not hash password: %w", err)
+	}
+	if _, err := am.db.Exec("UPDATE users SET password = ? WHERE username = ?", hashedPass, username); err != nil {
+		return fmt.Errorf("could not update password: %w", err)
+	}
+
+	log.Printf("User '%s' has changed their password", username)
+	return nil
+}
+
+// DeleteUser removes the account after verifying its password, along with its login attempts and invite.
+// Data owned by other managers, like blocks and chat history, has to be cleaned up by the caller.
+func (am *AuthManager) DeleteUser(username, password string) error {
+	if _, err := am.Login(username, password, ""); err != nil {
+		return err
+	}
+
+	tx, err := am.db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	statements := []struct {
+		query string
+		args  []any
+	}{
+		{"DELETE FROM users WHERE username = ?", []any{username}},
+		{"DELETE FROM login_attempts WHERE kind = ? AND subject = ?", []any{attemptByUsername, username}},
+		{"DELETE FROM invited_users WHERE username = ?", []any{username}},
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt.query, stmt.args...); err != nil {
+			return fmt.Errorf("could not delete user: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit user deletion: %w", err)
+	}
+
+	log.Printf("User '%s' has deleted their account", username)
+	return nil
+}
@@ -20,6 +20,8 @@ var (
 	ErrInvalidUsername      = errors.New("username must be at least 2 characters long")
 	ErrWeakPassword         = errors.New("password does not meet strength requirements")
 	ErrAuthenticationFailed = errors.New("invalid username or password")
+	ErrUserExists           = errors.New("username is already taken")
+	ErrRegistrationClosed   = errors.New("registration is invite only")
 )
 
 type AuthManager struct {
@@ -27,6 +29,9 @@ type AuthManager struct {
 	getUserStmt     *sql.Stmt
 	addUserStmt     *sql.Stmt
 	getPasswordStmt *sql.Stmt
+
+	inviteOnly bool
+	attempts   loginAttempts
 }
 
 func NewAuthManager(dbPath string) (*AuthManager, error) {
@@ -40,7 +45,7 @@ func NewAuthManager(dbPath string) (*AuthManager, error) {
 		return nil, err
 	}
 
-	am := &AuthManager{db: db}
+	am := &AuthManager{db: db, attempts: loginAttempts{inFlight: make(map[attemptSubject]int)}}
 	if err := am.prepareStatements(); err != nil {
 		db.Close()
 		return nil, err
@@ -57,11 +62,18 @@ func createSchema(db *sql.DB) error {
 			password TEXT NOT NULL
 		);
 		CREATE INDEX IF NOT EXISTS idx_username ON users(username);
+		CREATE TABLE IF NOT EXISTS invited_users (
+			username TEXT PRIMARY KEY,
+			timestamp DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
 	`)
 	if err != nil {
 		return fmt.Errorf("failed to create schema: %w", err)
 	}
-	return nil
+	if err := migrateRoles(db); err != nil {
+		return err
+	}
+	return createLoginAttemptsSchema(db)
 }
 
 func (am *AuthManager) prepareStatements() error {
@@ -124,6 +136,9 @@ func validateUsername(username string) error {
 	if len(username) < 2 {
 		return ErrInvalidUsername
 	}
+	if username == DeletedUsername {
+		return ErrReservedUsername
+	}
 	return nil
 }
 
@@ -167,13 +182,8 @@ func (am *AuthManager) AuthenticateUser(username, password string) (bool, error)
 	err := am.getPasswordStmt.QueryRow(username).Scan(&storedPassword)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			log.Printf("User does not exist in the database '%s' creating new record", username)
-			err = am.AddUser(username, password)
-			if err != nil {
-				return false, err
-			}
-			return true, nil
-
+			log.Printf("User does not exist in the database '%s'", username)
+			return false, ErrAuthenticationFailed
 		}
 		return false, fmt.Errorf("error querying user: %w", err)
 	}
@@ -188,6 +198,66 @@ func (am *AuthManager) AuthenticateUser(username, password string) (bool, error)
 	return true, nil
 }
 
+// Registration
+// -----------------------------
+
+// SetInviteOnly toggles between open registration and invite only registration
+func (am *AuthManager) SetInviteOnly(inviteOnly bool) {
+	am.inviteOnly = inviteOnly
+}
+
+// InviteUser allows the given username to register while registration is invite only
+func (am *AuthManager) InviteUser(username string) error {
+	if err := validateUsername(username); err != nil {
+		return err
+	}
+	_, err := am.db.Exec("INSERT OR IGNORE INTO invited_users (username) VALUES (?)", username)
+	if err != nil {
+		return fmt.Errorf("could not invite user: %w", err)
+	}
+	return nil
+}
+
+// RegisterUser creates a new account. Unlike AuthenticateUser it fails if the username is taken.
+func (am *AuthManager) RegisterUser(username, password string) error {
+	if err := validateUsername(username); err != nil {
+		return err
+	}
+
+	var existing string
+	err := am.getUserStmt.QueryRow(username).Scan(&existing)
+	if err == nil {
+		return ErrUserExists
+	}
+	if err != sql.ErrNoRows {
+		return fmt.Errorf("error querying user: %w", err)
+	}
+
+	if am.inviteOnly {
+		var invited bool
+		err := am.db.QueryRow("SELECT EXISTS(SELECT 1 FROM invited_users WHERE username = ?)", username).Scan(&invited)
+		if err != nil {
+			return fmt.Errorf("error querying invites: %w", err)
+		}
+		if !invited {
+			return ErrRegistrationClosed
+		}
+	}
+
+	if err := am.AddUser(username, password); err != nil {
+		return err
+	}
+
+	if am.inviteOnly {
+		if _, err := am.db.Exec("DELETE FROM invited_users WHERE username = ?", username); err != nil {
+			log.Printf("Failed to remove used invite of '%s': %v", username, err)
+		}
+	}
+
+	log.Printf("User '%s' has successfully registered", username)
+	return nil
+}
+
 func checkPasswordHash(password, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
@@ -1,8 +1,12 @@
 package auth
 
 import (
+	"errors"
 	"os"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/assert"
@@ -82,6 +86,41 @@ func TestAuthenticateUser(t *testing.T) {
 	}
 }
 
+func TestRegisterUser(t *testing.T) {
+	am := setupTestDB(t)
+	defer cleanupTestDB(t, am)
+
+	t.Run("should register new users when registration is open", func(t *testing.T) {
+		assert.NoError(t, am.RegisterUser("testuser", "P@ssw0rd"))
+
+		ok, err := am.AuthenticateUser("testuser", "P@ssw0rd")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("should not register taken usernames", func(t *testing.T) {
+		assert.ErrorIs(t, am.RegisterUser("testuser", "AnotherP@ss1"), ErrUserExists)
+	})
+
+	t.Run("should not register unknown users on login", func(t *testing.T) {
+		ok, err := am.AuthenticateUser("typouser", "P@ssw0rd")
+		assert.ErrorIs(t, err, ErrAuthenticationFailed)
+		assert.False(t, ok)
+		assert.NoError(t, am.RegisterUser("typouser", "P@ssw0rd"))
+	})
+
+	t.Run("should only register invited users when invite only", func(t *testing.T) {
+		am.SetInviteOnly(true)
+		defer am.SetInviteOnly(false)
+
+		assert.ErrorIs(t, am.RegisterUser("stranger", "P@ssw0rd"), ErrRegistrationClosed)
+
+		assert.NoError(t, am.InviteUser("friend"))
+		assert.NoError(t, am.RegisterUser("friend", "P@ssw0rd"))
+		assert.ErrorIs(t, am.RegisterUser("friend", "P@ssw0rd"), ErrUserExists)
+	})
+}
+
 func TestCheckPasswordStrength(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -104,3 +143,196 @@ func TestCheckPasswordStrength(t *testing.T) {
 		})
 	}
 }
+
+func TestLoginThrottling(t *testing.T) {
+	am := setupTestDB(t)
+	defer cleanupTestDB(t, am)
+
+	current := time.Unix(1_700_000_000, 0)
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+
+	err := am.AddUser("testuser", "P@ssw0rd")
+	assert.NoError(t, err, "Error adding test user")
+
+	t.Run("should back off exponentially after repeated failures", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			_, err := am.Login("testuser", "wrongpassword", "10.0.0.1")
+			assert.ErrorIs(t, err, ErrAuthenticationFailed)
+		}
+
+		_, err := am.Login("testuser", "P@ssw0rd", "10.0.0.1")
+		var throttledErr *LoginThrottledError
+		assert.ErrorAs(t, err, &throttledErr)
+		assert.False(t, throttledErr.Locked)
+		assert.Equal(t, time.Second, throttledErr.RetryAfter())
+
+		current = current.Add(time.Second)
+		_, err = am.Login("testuser", "wrongpassword", "10.0.0.1")
+		assert.ErrorIs(t, err, ErrAuthenticationFailed)
+
+		_, err = am.Login("testuser", "P@ssw0rd", "10.0.0.1")
+		assert.ErrorAs(t, err, &throttledErr)
+		assert.Equal(t, 2*time.Second, throttledErr.RetryAfter())
+	})
+
+	t.Run("should reset failures after successful login", func(t *testing.T) {
+		current = current.Add(time.Minute)
+		ok, err := am.Login("testuser", "P@ssw0rd", "10.0.0.1")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+
+		_, err = am.Login("testuser", "wrongpassword", "10.0.0.1")
+		assert.ErrorIs(t, err, ErrAuthenticationFailed)
+		ok, err = am.Login("testuser", "P@ssw0rd", "10.0.0.1")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("should lock the account and let an admin unlock it", func(t *testing.T) {
+		// Wait out the backoff between attempts so every attempt counts
+		for i := 0; i < 10; i++ {
+			current = current.Add(5 * time.Minute)
+			_, err := am.Login("testuser", "wrongpassword", "")
+			assert.ErrorIs(t, err, ErrAuthenticationFailed)
+		}
+
+		_, err := am.Login("testuser", "P@ssw0rd", "")
+		var throttledErr *LoginThrottledError
+		assert.ErrorAs(t, err, &throttledErr)
+		assert.True(t, throttledErr.Locked)
+
+		assert.NoError(t, am.UnlockUser("testuser"))
+		ok, err := am.Login("testuser", "P@ssw0rd", "")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("should back off an IP trying many usernames", func(t *testing.T) {
+		current = current.Add(2 * failureResetWindow)
+		for i := 0; i < 10; i++ {
+			_, err := am.Login("testuser", "wrongpassword", "10.0.0.2")
+			assert.NoError(t, am.UnlockUser("testuser"))
+			assert.ErrorIs(t, err, ErrAuthenticationFailed)
+		}
+
+		_, err := am.Login("testuser", "P@ssw0rd", "10.0.0.2")
+		var throttledErr *LoginThrottledError
+		assert.ErrorAs(t, err, &throttledErr)
+
+		ok, err := am.Login("testuser", "P@ssw0rd", "10.0.0.3")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("should lock an IP that keeps failing despite the backoff", func(t *testing.T) {
+		current = current.Add(2 * failureResetWindow)
+		for i := 0; i < 50; i++ {
+			current = current.Add(maxLoginBackoff)
+			_, err := am.Login("testuser", "wrongpassword", "10.0.0.4")
+			assert.NoError(t, am.UnlockUser("testuser"))
+			assert.ErrorIs(t, err, ErrAuthenticationFailed)
+		}
+
+		_, err := am.Login("testuser", "P@ssw0rd", "10.0.0.4")
+		var throttledErr *LoginThrottledError
+		assert.ErrorAs(t, err, &throttledErr)
+		assert.True(t, throttledErr.Locked)
+	})
+
+	t.Run("should cap the backoff", func(t *testing.T) {
+		assert.Equal(t, maxLoginBackoff, loginBackoff(100))
+		assert.Equal(t, 4*time.Second, loginBackoff(2))
+	})
+
+	t.Run("should not let parallel attempts slip past the backoff", func(t *testing.T) {
+		current = current.Add(2 * failureResetWindow)
+		assert.NoError(t, am.UnlockUser("testuser"))
+
+		var wg sync.WaitGroup
+		var failed atomic.Int32
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := am.Login("testuser", "wrongpassword", ""); errors.Is(err, ErrAuthenticationFailed) {
+					failed.Add(1)
+				}
+			}()
+		}
+		wg.Wait()
+		assert.LessOrEqual(t, failed.Load(), int32(throttlePolicies[attemptByUsername].backoffAfter))
+	})
+}
+
+func TestAccountManagement(t *testing.T) {
+	am := setupTestDB(t)
+	defer cleanupTestDB(t, am)
+
+	assert.NoError(t, am.AddUser("testuser", "P@ssw0rd"))
+
+	t.Run("should change password only with the current password", func(t *testing.T) {
+		assert.ErrorIs(t, am.ChangePassword("testuser", "wrongpassword", "N3wP@ssword"), ErrAuthenticationFailed)
+		assert.ErrorIs(t, am.ChangePassword("testuser", "P@ssw0rd", "weak"), ErrWeakPassword)
+		assert.NoError(t, am.ChangePassword("testuser", "P@ssw0rd", "N3wP@ssword"))
+
+		_, err := am.AuthenticateUser("testuser", "P@ssw0rd")
+		assert.ErrorIs(t, err, ErrAuthenticationFailed)
+		ok, err := am.AuthenticateUser("testuser", "N3wP@ssword")
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	})
+
+	t.Run("should throttle guessing the current password", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			assert.ErrorIs(t, am.DeleteUser("testuser", "wrongpassword"), ErrAuthenticationFailed)
+		}
+		var throttledErr *LoginThrottledError
+		assert.ErrorAs(t, am.ChangePassword("testuser", "N3wP@ssword", "An0therP@ss"), &throttledErr)
+		assert.NoError(t, am.UnlockUser("testuser"))
+	})
+
+	t.Run("should delete account only with the password", func(t *testing.T) {
+		assert.ErrorIs(t, am.DeleteUser("testuser", "wrongpassword"), ErrAuthenticationFailed)
+		assert.NoError(t, am.DeleteUser("testuser", "N3wP@ssword"))
+
+		_, err := am.AuthenticateUser("testuser", "N3wP@ssword")
+		assert.ErrorIs(t, err, ErrAuthenticationFailed)
+		// Username is free again
+		assert.NoError(t, am.RegisterUser("testuser", "P@ssw0rd"))
+	})
+
+	t.Run("should not register the deleted user placeholder", func(t *testing.T) {
+		assert.ErrorIs(t, am.RegisterUser(DeletedUsername, "P@ssw0rd"), ErrReservedUsername)
+	})
+}
+
+func TestRoles(t *testing.T) {
+	am := setupTestDB(t)
+	defer cleanupTestDB(t, am)
+
+	assert.NoError(t, am.AddUser("testuser", "P@ssw0rd"))
+
+	t.Run("should default to user role", func(t *testing.T) {
+		role, err := am.GetRole("testuser")
+		assert.NoError(t, err)
+		assert.Equal(t, RoleUser, role)
+	})
+
+	t.Run("should set role of existing users only", func(t *testing.T) {
+		assert.NoError(t, am.SetRole("testuser", RoleModerator))
+		role, err := am.GetRole("testuser")
+		assert.NoError(t, err)
+		assert.Equal(t, RoleModerator, role)
+
+		assert.ErrorIs(t, am.SetRole("nobody", RoleAdmin), ErrNoSuchUser)
+		assert.ErrorIs(t, am.SetRole("testuser", Role("owner")), ErrUnknownRole)
+	})
+
+	t.Run("should rank roles", func(t *testing.T) {
+		assert.True(t, RoleAdmin.Outranks(RoleModerator))
+		assert.False(t, RoleModerator.Outranks(RoleModerator))
+		assert.True(t, RoleModerator.AtLeast(RoleModerator))
+		assert.False(t, RoleUser.AtLeast(RoleModerator))
+	})
+}
@@ -0,0 +1,239 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	baseLoginBackoff   = time.Second
+	maxLoginBackoff    = 5 * time.Minute // Well below failureResetWindow so failures keep adding up to a lockout
+	loginLockout       = 15 * time.Minute
+	failureResetWindow = time.Hour // Failures older than this are forgotten
+)
+
+type attemptKind string
+
+const (
+	attemptByUsername attemptKind = "username"
+	attemptByIP       attemptKind = "ip"
+)
+
+// throttlePolicy decides after how many failures backoff and lockout kick in.
+// IPs get more room than usernames since many users can share one address.
+type throttlePolicy struct {
+	backoffAfter int
+	lockoutAfter int
+}
+
+var throttlePolicies = map[attemptKind]throttlePolicy{
+	attemptByUsername: {backoffAfter: 3, lockoutAfter: 10},
+	attemptByIP:       {backoffAfter: 10, lockoutAfter: 50},
+}
+
+type attemptSubject struct {
+	kind    attemptKind
+	subject string
+}
+
+// loginAttempts counts attempts that passed the throttle but haven't failed or succeeded yet.
+// Checking and counting happen under one lock, so parallel attempts can't all slip through the same check.
+type loginAttempts struct {
+	inFlight map[attemptSubject]int
+	mu       sync.Mutex
+}
+
+// now is swapped in tests
+var now = time.Now
+
+// LoginThrottledError is returned when a login is attempted during backoff or lockout
+type LoginThrottledError struct {
+	Until  time.Time
+	Locked bool
+}
+
+func (e *LoginThrottledError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("account locked until %s", e.Until.Format("15:04:05"))
+	}
+	return fmt.Sprintf("too many failed attempts, try again in %s", e.RetryAfter())
+}
+
+// RetryAfter returns how long the caller has to wait, rounded up to seconds
+func (e *LoginThrottledError) RetryAfter() time.Duration {
+	wait := e.Until.Sub(now())
+	if wait < 0 {
+		return 0
+	}
+	if rem := wait % time.Second; rem != 0 {
+		wait += time.Second - rem
+	}
+	return wait
+}
+
+func createLoginAttemptsSchema(db *sql.DB) error {
+	_, err := db.Exec(`
+		CREATE TABLE IF NOT EXISTS login_attempts (
+			kind TEXT NOT NULL,
+			subject TEXT NOT NULL,
+			failures INTEGER NOT NULL DEFAULT 0,
+			last_failure INTEGER NOT NULL DEFAULT 0,
+			locked_until INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (kind, subject)
+		);
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create login attempts schema: %w", err)
+	}
+	return nil
+}
+
+// Login authenticates the user while guarding against brute force attempts.
+// Failed attempts are tracked per username and per IP, after a few failures each new attempt
+// has to wait exponentially longer and eventually the username or IP gets locked out.
+func (am *AuthManager) Login(username, password, ip string) (bool, error) {
+	subjects := []attemptSubject{{attemptByUsername, username}, {attemptByIP, ip}}
+	if err := am.reserveAttempt(subjects); err != nil {
+		return false, err
+	}
+
+	authenticated, err := am.AuthenticateUser(username, password)
+	am.finishAttempt(subjects, errors.Is(err, ErrAuthenticationFailed))
+	if errors.Is(err, ErrAuthenticationFailed) {
+		return false, err
+	}
+
+	if authenticated {
+		// Only the username is cleared, otherwise a single valid account would reset an attacking IP
+		if _, err := am.db.Exec("DELETE FROM login_attempts WHERE kind = ? AND subject = ?", attemptByUsername, username); err != nil {
+			log.Printf("Failed to clear login attempts of '%s': %v", username, err)
+		}
+	}
+	return authenticated, err
+}
+
+// UnlockUser clears failed attempts and lockout of the given username
+func (am *AuthManager) UnlockUser(username string) error {
+	_, err := am.db.Exec("DELETE FROM login_attempts WHERE kind = ? AND subject = ?", attemptByUsername, username)
+	if err != nil {
+		return fmt.Errorf("could not unlock user: %w", err)
+	}
+	return nil
+}
+
+// reserveAttempt checks the throttle of every subject and counts the attempt as in flight for all of them
+func (am *AuthManager) reserveAttempt(subjects []attemptSubject) error {
+	am.attempts.mu.Lock()
+	defer am.attempts.mu.Unlock()
+
+	for _, s := range subjects {
+		if err := am.checkThrottle(s.kind, s.subject, am.attempts.inFlight[s]); err != nil {
+			return err
+		}
+	}
+	for _, s := range subjects {
+		am.attempts.inFlight[s]++
+	}
+	return nil
+}
+
+// finishAttempt records the outcome of a reserved attempt and releases it
+func (am *AuthManager) finishAttempt(subjects []attemptSubject, failed bool) {
+	am.attempts.mu.Lock()
+	defer am.attempts.mu.Unlock()
+
+	for _, s := range subjects {
+		if failed {
+			am.recordFailure(s.kind, s.subject)
+		}
+		if am.attempts.inFlight[s]--; am.attempts.inFlight[s] <= 0 {
+			delete(am.attempts.inFlight, s)
+		}
+	}
+}
+
+// checkThrottle counts attempts still in flight as failures that just happened
+func (am *AuthManager) checkThrottle(kind attemptKind, subject string, inFlight int) error {
+	if subject == "" {
+		return nil
+	}
+
+	var failures int
+	var lastFailure, lockedUntil int64
+	err := am.db.QueryRow("SELECT failures, last_failure, locked_until FROM login_attempts WHERE kind = ? AND subject = ?", kind, subject).
+		Scan(&failures, &lastFailure, &lockedUntil)
+	if err != nil && err != sql.ErrNoRows {
+		// Don't lock everyone out because of a database hiccup
+		log.Printf("Failed to check login attempts of %s '%s': %v", kind, subject, err)
+		return nil
+	}
+
+	current := now()
+	if lockedUntil > current.Unix() {
+		return &LoginThrottledError{Until: time.Unix(lockedUntil, 0), Locked: true}
+	}
+
+	if current.Sub(time.Unix(lastFailure, 0)) > failureResetWindow {
+		failures = 0
+	}
+	if inFlight > 0 {
+		failures += inFlight
+		lastFailure = current.Unix()
+	}
+	policy := throttlePolicies[kind]
+	if failures < policy.backoffAfter {
+		return nil
+	}
+
+	retryAt := time.Unix(lastFailure, 0).Add(loginBackoff(failures - policy.backoffAfter))
+	if current.Before(retryAt) {
+		return &LoginThrottledError{Until: retryAt}
+	}
+	return nil
+}
+
+// loginBackoff doubles with every failure past the backoff threshold, up to maxLoginBackoff
+func loginBackoff(excessFailures int) time.Duration {
+	// Shifting further would overflow, it's far past the cap anyway
+	return min(baseLoginBackoff<<min(excessFailures, 20), maxLoginBackoff)
+}
+
+func (am *AuthManager) recordFailure(kind attemptKind, subject string) {
+	if subject == "" {
+		return
+	}
+
+	var failures int
+	var lastFailure int64
+	err := am.db.QueryRow("SELECT failures, last_failure FROM login_attempts WHERE kind = ? AND subject = ?", kind, subject).
+		Scan(&failures, &lastFailure)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Failed to read login attempts of %s '%s': %v", kind, subject, err)
+		return
+	}
+
+	current := now()
+	if current.Sub(time.Unix(lastFailure, 0)) > failureResetWindow {
+		failures = 0
+	}
+	failures++
+
+	var lockedUntil int64
+	if failures >= throttlePolicies[kind].lockoutAfter {
+		log.Printf("Locking %s '%s' after %d failed login attempts", kind, subject, failures)
+		lockedUntil = current.Add(loginLockout).Unix()
+		failures = 0
+	}
+
+	_, err = am.db.Exec(`
+		INSERT INTO login_attempts (kind, subject, failures, last_failure, locked_until) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(kind, subject) DO UPDATE SET failures = excluded.failures, last_failure = excluded.last_failure, locked_until = excluded.locked_until
+	`, kind, subject, failures, current.Unix(), lockedUntil)
+	if err != nil {
+		log.Printf("Failed to record login failure of %s '%s': %v", kind, subject, err)
+	}
+}
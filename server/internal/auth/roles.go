@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Role is the server-wide authority of a user, independent from channel ownership
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+var (
+	ErrUnknownRole = errors.New("unknown role")
+	ErrNoSuchUser  = errors.New("user does not exist")
+)
+
+var roleRanks = map[Role]int{
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+func ParseRole(role string) (Role, error) {
+	if _, ok := roleRanks[Role(role)]; !ok {
+		return "", fmt.Errorf("%w '%s', expected '%s', '%s' or '%s'", ErrUnknownRole, role, RoleUser, RoleModerator, RoleAdmin)
+	}
+	return Role(role), nil
+}
+
+// AtLeast reports whether the role has the same or more authority than the other one
+func (r Role) AtLeast(other Role) bool {
+	return roleRanks[r] >= roleRanks[other]
+}
+
+// Outranks reports whether the role has strictly more authority, moderators can't act on each other
+func (r Role) Outranks(other Role) bool {
+	return roleRanks[r] > roleRanks[other]
+}
+
+// migrateRoles adds the role column to users tables created before roles existed
+func migrateRoles(db *sql.DB) error {
+	var exists bool
+	err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM pragma_table_info('users') WHERE name = 'role')").Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to inspect users table: %w", err)
+	}
+	if exists {
+		return nil
+	}
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT '%s'", RoleUser)); err != nil {
+		return fmt.Errorf("failed to add role column: %w", err)
+	}
+	return nil
+}
+
+// GetRole returns the role of the user
+func (am *AuthManager) GetRole(username string) (Role, error) {
+	var role string
+	err := am.db.QueryRow("SELECT role FROM users WHERE username = ?", username).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", ErrNoSuchUser
+	}
+	if err != nil {
+		return "", fmt.Errorf("error querying role: %w", err)
+	}
+	return Role(role), nil
+}
+
+// SetRole changes the role of an existing user
+func (am *AuthManager) SetRole(username string, role Role) error {
+	if _, err := ParseRole(string(role)); err != nil {
+		return err
+	}
+	result, err := am.db.Exec("UPDATE users SET role = ? WHERE username = ?", role, username)
+	if err != nil {
+		return fmt.Errorf("could not set role: %w", err)
+	}
+	if affected, err := result.RowsAffected(); err == nil && affected == 0 {
+		return ErrNoSuchUser
+	}
+
+	log.Printf("User '%s' is now '%s'", username, role)
+	return nil
+}
@@ -76,6 +76,12 @@ func (bu *BlockUserManager) GetBlockedUsersWithDetails(blocker string) ([]Blocke
 	return entries, err
 }
 
+// DeleteUser removes every block the user made or received, used when an account is deleted
+func (bu *BlockUserManager) DeleteUser(username string) error {
+	_, err := bu.db.Exec("DELETE FROM blocked_users WHERE blocker = ? OR blocked = ?", username, username)
+	return err
+}
+
 func (bu *BlockUserManager) Close() error {
 	return bu.db.Close()
 }
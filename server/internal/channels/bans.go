@@ -0,0 +1,142 @@
+package channels
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ogzhanolguncu/go-chat/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+// ChannelBan keeps a user out of a channel until it's lifted or expires
+type ChannelBan struct {
+	BannedBy  string
+	BannedAt  time.Time
+	ExpiresAt time.Time // Zero time means the ban never expires
+}
+
+func (b ChannelBan) active(now time.Time) bool {
+	return b.ExpiresAt.IsZero() || now.Before(b.ExpiresAt)
+}
+
+func (ch *ChannelDetails) isBanned(username string) bool {
+	ban, banned := ch.BannedUsers[username]
+	return banned && ban.active(time.Now())
+}
+
+// banReason tells a banned user why they can't get in
+func (ch *ChannelDetails) banReason(username string) (string, bool) {
+	ban, banned := ch.BannedUsers[username]
+	if !banned || !ban.active(time.Now()) {
+		return "", false
+	}
+	if ban.ExpiresAt.IsZero() {
+		return fmt.Sprintf(bannedUserCannotJoin, ch.ChName), true
+	}
+	return fmt.Sprintf(timedBanCannotJoin, ch.ChName, ban.ExpiresAt.Format("01-02 15:04")), true
+}
+
+func (m *Manager) unbanUser(chPayload protocol.ChannelPayload) (protocol.ChannelPayload, protocol.ChannelPayload) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	channel := m.chMap[chPayload.ChannelName]
+	if checkPayload := m.banListChecks(channel, chPayload); checkPayload != nil {
+		return *checkPayload, protocol.ChannelPayload{}
+	}
+	if chPayload.OptionalChannelArgs == nil ||
+		chPayload.OptionalChannelArgs.TargetUser == "" ||
+		chPayload.OptionalChannelArgs.TargetUser == protocol.EmptyChannelField {
+		return failChannelAction(chPayload, emptyTargetUser), protocol.ChannelPayload{}
+	}
+	target := chPayload.OptionalChannelArgs.TargetUser
+	if !channel.isBanned(target) {
+		return failChannelAction(chPayload, userNotBanned), protocol.ChannelPayload{}
+	}
+
+	m.liftBan(channel, target)
+	channel.LastActivity = time.Now().Unix()
+	chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
+		Status:     protocol.StatusSuccess,
+		TargetUser: target,
+	}
+	chNoticePayload := m.prepareNoticePayload(chPayload, channel, fmt.Sprintf("'%s' has been unbanned", target))
+	return chPayload, chNoticePayload
+}
+
+func (m *Manager) getBans(chPayload protocol.ChannelPayload) protocol.ChannelPayload {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	channel := m.chMap[chPayload.ChannelName]
+	if checkPayload := m.banListChecks(channel, chPayload); checkPayload != nil {
+		return *checkPayload
+	}
+
+	now := time.Now()
+	bans := []protocol.ChannelBan{}
+	for username, ban := range channel.BannedUsers {
+		if !ban.active(now) {
+			continue
+		}
+		entry := protocol.ChannelBan{Username: username, BannedBy: ban.BannedBy, BannedAt: ban.BannedAt.Unix()}
+		if !ban.ExpiresAt.IsZero() {
+			entry.ExpiresAt = ban.ExpiresAt.Unix()
+		}
+		bans = append(bans, entry)
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		if bans[i].BannedAt != bans[j].BannedAt {
+			return bans[i].BannedAt < bans[j].BannedAt
+		}
+		return bans[i].Username < bans[j].Username
+	})
+
+	chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
+		Status: protocol.StatusSuccess,
+		Bans:   bans,
+	}
+	return chPayload
+}
+
+// banListChecks validates the actions only the owner and moderators can use, like unban, bans, unmute and slow mode
+func (m *Manager) banListChecks(channel *ChannelDetails, chPayload protocol.ChannelPayload) *protocol.ChannelPayload {
+	if channel == nil {
+		logger.WithField("channel", chPayload.ChannelName).Warn("Channel does not exist")
+		failed := failChannelAction(chPayload, chDoesNotExist)
+		return &failed
+	}
+	if !channel.canModerate(chPayload.Requester) {
+		failed := failChannelAction(chPayload, notChannelModerator)
+		return &failed
+	}
+	return nil
+}
+
+func (m *Manager) liftBan(channel *ChannelDetails, username string) {
+	delete(channel.BannedUsers, username)
+	if err := m.deleteBan(channel.ChName, username); err != nil {
+		logger.WithError(err).WithField("channel", channel.ChName).Error("Failed to delete channel ban")
+	}
+}
+
+// removeExpiredBans drops timed bans that ran out, joining already ignores them
+func (m *Manager) removeExpiredBans() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	for _, channel := range m.chMap {
+		for username, ban := range channel.BannedUsers {
+			if ban.active(now) {
+				continue
+			}
+			logger.WithFields(logrus.Fields{
+				"channel": channel.ChName,
+				"user":    username,
+			}).Info("Channel ban expired")
+			m.liftBan(channel, username)
+		}
+	}
+}
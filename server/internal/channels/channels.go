@@ -2,28 +2,48 @@ package channels
 
 import (
 	"fmt"
-	"net"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/ogzhanolguncu/go-chat/protocol"
 	"github.com/ogzhanolguncu/go-chat/server/internal/connection"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
 )
 
 const (
 	chAlreadyExists         = "Channel name already exists."
+	alreadyInTheCh          = "You are already in the channel."
 	chDoesNotExist          = "Channel does not exist."
 	incorrectChPassword     = "Incorrect channel password."
 	chAtCapacity            = "Channel is full. Try again later."
 	notInTheCh              = "User not in the channel."
 	unknownChAction         = "Unknown channel action."
-	noActiveChannels        = "No active channels."
+	invalidChannelSort      = "Sort must be one of name, members, activity or created."
 	notChannelOwner         = "Not a channel owner."
+	notChannelModerator     = "Not a channel owner or moderator."
+	moderatorCannotBeKicked = "Only the owner can kick, ban or mute moderators."
 	emptyTargetUser         = "Target user cannot be empty."
-	ownerCannotBeKicked     = "Owner cannot be kicked."
+	ownerCannotBeKicked     = "Owner cannot be kicked, banned or muted."
 	bannedUserCannotJoin    = "You have been banned from '%s'."
+	timedBanCannotJoin      = "You have been banned from '%s' until %s."
+	invalidBanDuration      = "Ban duration cannot be negative."
+	userNotBanned           = "User is not banned."
+	invalidMuteDuration     = "Mute duration cannot be negative."
+	userNotMuted            = "User is not muted."
+	mutedInChannel          = "You are muted in this channel."
+	timedMuteInChannel      = "You are muted in this channel until %s."
+	slowModeActive          = "Slow mode is on, one message every %s. Try again in %s."
+	invalidSlowMode         = "Slow mode must be between 1s and 1h, or 0 to turn it off."
 	closeInactiveCh         = "Channel '%s' closed due to inactivity."
+	archiveInactiveCh       = "Channel '%s' archived due to inactivity, joining it again reopens it with its history."
+	invalidIdleTimeout      = "Idle timeout must be between 5m and 168h."
+	invalidChannelSize      = "Channel size must be at least %d, the number of members."
+	chCouldNotBeCreated     = "Channel could not be created."
+	chCouldNotBeUpdated     = "Channel could not be updated."
 	typingIndicatorDebounce = 750 * time.Millisecond
 )
 
@@ -39,37 +59,90 @@ func init() {
 
 type ChannelDetails struct {
 	ChName           string
-	ChPass           string
+	ChPass           string // Bcrypt hash, empty when the channel has no password
 	ChCapacity       int
 	Owner            string
 	Users            map[string]bool
 	LastActivity     int64
-	BannedUsers      map[string]bool
+	BannedUsers      map[string]ChannelBan
+	MutedUsers       map[string]ChannelMute
+	Moderators       map[string]bool // Can kick, ban and mute like the owner, except each other
 	Visibility       string
+	Permanent        bool // Permanent channels are never closed, not even when empty or inactive
+	CreatedAt        int64
+	Topic            string
+	Description      string
+	SlowMode         time.Duration // Minimum time between two messages of a member, zero when off
+	IdleTimeout      time.Duration // How long the channel may be idle before it's closed, zero uses defaultIdleTimeout
+	ArchiveOnIdle    bool          // Idle or empty channels are archived instead of closed
+	Archived         bool          // Archived channels have no members and keep their history until someone joins again
+	Waitlist         bool          // Users joining while the channel is full are queued instead of turned away
+	idleWarnedAt     int64         // LastActivity members were warned about, so they're warned once per idle stretch
 	typingIndicators map[string]time.Time
+	lastMessageAt    map[string]time.Time     // For slow mode, not kept across restarts
+	invites          map[string]channelInvite // Keyed by invite code
+	joinedAt         map[string]time.Time     // Longest present member takes over when the owner leaves
+	waitlist         []string                 // Queued usernames in joining order, not kept across restarts
 }
 
 type Manager struct {
 	chMap    map[string]*ChannelDetails
 	cm       *connection.Manager
 	encodeFn func(payload protocol.Payload) string
+	db       *sqlx.DB
+	done     chan struct{}
 	lock     sync.RWMutex
+
+	seatsFreedFn func(chName string) // Set through OnSeatsFreed, admits waitlisted users the background checker found room for
 }
 
-// Use this connnection manager and encodeFn -ONLY- for close channel message dispatch
-func NewChannelManager(cm *connection.Manager, encodeFn func(payload protocol.Payload) string) *Manager {
+// Use this connnection manager and encodeFn -ONLY- for close channel message dispatch.
+// Channels stored in the database are loaded back, so they survive restarts.
+func NewChannelManager(cm *connection.Manager, encodeFn func(payload protocol.Payload) string, dbPath string) (*Manager, error) {
 	logger.Info("Initializing new ChannelManager")
+	db, err := sqlx.Connect("sqlite3", dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if err := createSchema(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+	chMap, err := loadChannels(db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to load channels: %w", err)
+	}
+	logger.WithField("channelCount", len(chMap)).Info("Channels loaded")
+
 	m := &Manager{
-		chMap:    make(map[string]*ChannelDetails),
+		chMap:    chMap,
 		cm:       cm,
 		encodeFn: encodeFn,
+		db:       db,
+		done:     make(chan struct{}),
 	}
 	go m.startInactiveChannelChecker()
 	go m.cleanUpTypingIndicators()
-	return m
+	return m, nil
 }
 
+// Close stops the background checkers and closes the database
+func (m *Manager) Close() error {
+	close(m.done)
+	return m.db.Close()
+}
+
+// Handle runs the channel action. Passwords are never sent back, neither to the requester nor in notices.
 func (m *Manager) Handle(payload protocol.Payload) (protocol.ChannelPayload, protocol.ChannelPayload) {
+	res, notice := m.dispatch(payload)
+	res.ChannelPassword = ""
+	notice.ChannelPassword = ""
+	return res, notice
+}
+
+func (m *Manager) dispatch(payload protocol.Payload) (protocol.ChannelPayload, protocol.ChannelPayload) {
 	logger.WithFields(logrus.Fields{
 		"action":  payload.ChannelPayload.ChannelAction,
 		"channel": payload.ChannelPayload.ChannelName,
@@ -95,6 +168,32 @@ func (m *Manager) Handle(payload protocol.Payload) (protocol.ChannelPayload, pro
 		return m.banUser(*payload.ChannelPayload)
 	case protocol.TypingChannel:
 		return m.typingIndicator(*payload.ChannelPayload), protocol.ChannelPayload{}
+	case protocol.HistoryChannel:
+		return m.historyChannel(*payload.ChannelPayload), protocol.ChannelPayload{}
+	case protocol.OpUser:
+		return m.opUser(*payload.ChannelPayload)
+	case protocol.DeopUser:
+		return m.deopUser(*payload.ChannelPayload)
+	case protocol.TransferChannel:
+		return m.transferChannel(*payload.ChannelPayload)
+	case protocol.UnbanUser:
+		return m.unbanUser(*payload.ChannelPayload)
+	case protocol.GetBans:
+		return m.getBans(*payload.ChannelPayload), protocol.ChannelPayload{}
+	case protocol.InviteUser:
+		return m.inviteUser(*payload.ChannelPayload), protocol.ChannelPayload{}
+	case protocol.AcceptInvite:
+		return m.acceptInvite(*payload.ChannelPayload)
+	case protocol.SetTopic, protocol.SetDescription:
+		return m.setMetadata(*payload.ChannelPayload)
+	case protocol.MuteUser:
+		return m.muteUser(*payload.ChannelPayload)
+	case protocol.UnmuteUser:
+		return m.unmuteUser(*payload.ChannelPayload)
+	case protocol.SetSlowMode:
+		return m.setSlowMode(*payload.ChannelPayload)
+	case protocol.ResizeChannel:
+		return m.resizeChannel(*payload.ChannelPayload)
 	default:
 		logger.WithField("action", payload.ChannelPayload.ChannelAction).Warn("Unknown channel action")
 		return protocol.ChannelPayload{
@@ -118,6 +217,17 @@ func (m *Manager) typingIndicator(chPayload protocol.ChannelPayload) protocol.Ch
 		return chPayload
 	}
 
+	// Only members can type, otherwise anyone could show up in the header
+	if !channel.Users[chPayload.Requester] {
+		chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
+			Status: protocol.StatusFail,
+		}
+		return chPayload
+	}
+
+	// Someone typing keeps the channel open, even if the indicator itself is debounced
+	channel.LastActivity = time.Now().Unix()
+
 	lastTime, exists := channel.typingIndicators[chPayload.Requester]
 	if !exists {
 		channel.typingIndicators[chPayload.Requester] = time.Now()
@@ -139,6 +249,17 @@ func (m *Manager) typingIndicator(chPayload protocol.ChannelPayload) protocol.Ch
 }
 
 func (m *Manager) createChannel(chPayload protocol.ChannelPayload) protocol.ChannelPayload {
+	// Hashed before locking, bcrypt is slow on purpose
+	passwordHash, err := hashChannelPassword(chPayload.ChannelPassword)
+	if err != nil {
+		logger.WithError(err).WithField("channel", chPayload.ChannelName).Error("Failed to hash channel password")
+		chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
+			Status: protocol.StatusFail,
+			Reason: chCouldNotBeCreated,
+		}
+		return chPayload
+	}
+
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
@@ -156,17 +277,50 @@ func (m *Manager) createChannel(chPayload protocol.ChannelPayload) protocol.Chan
 		return chPayload
 	}
 
-	m.chMap[chPayload.ChannelName] = &ChannelDetails{
+	// Frames without optional args create a public channel with the default idle timeout
+	if chPayload.OptionalChannelArgs == nil {
+		chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic}
+	}
+	idleTimeout := chPayload.OptionalChannelArgs.IdleTimeout
+	if idleTimeout == 0 {
+		idleTimeout = defaultIdleTimeout
+	}
+	if idleTimeout < minIdleTimeout || idleTimeout > maxIdleTimeout {
+		return failChannelAction(chPayload, invalidIdleTimeout)
+	}
+	// Stored in whole seconds
+	idleTimeout = idleTimeout.Truncate(time.Second)
+
+	channel := &ChannelDetails{
 		ChName:           chPayload.ChannelName,
-		ChPass:           chPayload.ChannelPassword,
+		ChPass:           passwordHash,
 		Owner:            chPayload.Requester,
 		ChCapacity:       chPayload.ChannelSize,
 		Users:            map[string]bool{chPayload.Requester: true},
-		BannedUsers:      map[string]bool{},
+		BannedUsers:      map[string]ChannelBan{},
+		MutedUsers:       map[string]ChannelMute{},
+		Moderators:       map[string]bool{},
 		LastActivity:     time.Now().Unix(),
+		CreatedAt:        time.Now().Unix(),
 		Visibility:       string(chPayload.OptionalChannelArgs.Visibility),
+		Permanent:        chPayload.OptionalChannelArgs.Permanent,
+		IdleTimeout:      idleTimeout,
+		ArchiveOnIdle:    chPayload.OptionalChannelArgs.Archive,
+		Waitlist:         chPayload.OptionalChannelArgs.Waitlist,
 		typingIndicators: make(map[string]time.Time),
+		invites:          make(map[string]channelInvite),
+		joinedAt:         map[string]time.Time{chPayload.Requester: time.Now()},
+		lastMessageAt:    make(map[string]time.Time),
+	}
+	if err := m.storeChannel(channel); err != nil {
+		logger.WithError(err).WithField("channel", chPayload.ChannelName).Error("Failed to store channel")
+		chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
+			Status: protocol.StatusFail,
+			Reason: chCouldNotBeCreated,
+		}
+		return chPayload
 	}
+	m.chMap[chPayload.ChannelName] = channel
 
 	logger.WithFields(logrus.Fields{
 		"channel": chPayload.ChannelName,
@@ -174,13 +328,19 @@ func (m *Manager) createChannel(chPayload protocol.ChannelPayload) protocol.Chan
 	}).Info("channel created successfully")
 
 	chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
-		Status:     protocol.StatusSuccess,
-		Visibility: chPayload.OptionalChannelArgs.Visibility,
+		Status:      protocol.StatusSuccess,
+		Visibility:  chPayload.OptionalChannelArgs.Visibility,
+		Permanent:   channel.Permanent,
+		IdleTimeout: channel.IdleTimeout,
+		Archive:     channel.ArchiveOnIdle,
+		Waitlist:    channel.Waitlist,
 	}
 	return chPayload
 }
 
 func (m *Manager) joinChannel(chPayload protocol.ChannelPayload) (protocol.ChannelPayload, protocol.ChannelPayload) {
+	checkedHash, passwordMatches := m.checkChannelPassword(chPayload.ChannelName, chPayload.ChannelPassword)
+
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
@@ -200,18 +360,17 @@ func (m *Manager) joinChannel(chPayload protocol.ChannelPayload) (protocol.Chann
 		return chPayload, protocol.ChannelPayload{}
 	}
 
-	_, bannedUserDetected := channel.BannedUsers[chPayload.Requester]
-	if bannedUserDetected {
+	if reason, banned := channel.banReason(chPayload.Requester); banned {
 		logger.Warn("Banned user is trying to join")
 		chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
 			Status: protocol.StatusFail,
-			Reason: fmt.Sprintf(bannedUserCannotJoin, channel.ChName),
+			Reason: reason,
 		}
 		return chPayload, protocol.ChannelPayload{}
 	}
 
-	// Wrong password
-	if channel.ChPass != chPayload.ChannelPassword {
+	// Wrong password, or the channel got recreated with another one while it was checked
+	if !passwordMatches || channel.ChPass != checkedHash {
 		logger.WithFields(logrus.Fields{
 			"channel": chPayload.ChannelName,
 			"user":    chPayload.Requester,
@@ -223,8 +382,21 @@ func (m *Manager) joinChannel(chPayload protocol.ChannelPayload) (protocol.Chann
 		return chPayload, protocol.ChannelPayload{}
 	}
 
+	return m.admitMember(channel, chPayload)
+}
+
+// admitMember adds the requester to the channel once they're allowed in, by password or invite
+func (m *Manager) admitMember(channel *ChannelDetails, chPayload protocol.ChannelPayload) (protocol.ChannelPayload, protocol.ChannelPayload) {
+	// Members already hold a seat, so they're never queued
+	if channel.Users[chPayload.Requester] {
+		return failChannelAction(chPayload, alreadyInTheCh), protocol.ChannelPayload{}
+	}
+
 	// Channel is full
 	if len(channel.Users) >= channel.ChCapacity {
+		if channel.Waitlist {
+			return m.waitlistMember(channel, chPayload), protocol.ChannelPayload{}
+		}
 		logger.WithFields(logrus.Fields{
 			"channel": chPayload.ChannelName,
 			"user":    chPayload.Requester,
@@ -236,7 +408,12 @@ func (m *Manager) joinChannel(chPayload protocol.ChannelPayload) (protocol.Chann
 		return chPayload, protocol.ChannelPayload{}
 	}
 
+	if channel.Archived {
+		m.reopenChannel(channel)
+	}
+	channel.dequeue(chPayload.Requester)
 	channel.Users[chPayload.Requester] = true
+	channel.joinedAt[chPayload.Requester] = time.Now()
 	channel.LastActivity = time.Now().Unix()
 	m.chMap[chPayload.ChannelName] = channel
 
@@ -245,8 +422,12 @@ func (m *Manager) joinChannel(chPayload protocol.ChannelPayload) (protocol.Chann
 		"user":    chPayload.Requester,
 	}).Info("User joined channel successfully")
 
+	// Newcomers get the topic along with the response, so it's in the header right away
 	chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
-		Status: protocol.StatusSuccess,
+		Status:      protocol.StatusSuccess,
+		Topic:       channel.Topic,
+		Description: channel.Description,
+		CreatedAt:   channel.CreatedAt,
 	}
 
 	chNoticePayload := m.prepareNoticePayload(chPayload, channel, fmt.Sprintf("'%s' has joined the channel", chPayload.Requester))
@@ -273,6 +454,13 @@ func (m *Manager) leaveChannel(chPayload protocol.ChannelPayload) (protocol.Chan
 	}
 
 	if _, found := channel.Users[chPayload.Requester]; !found {
+		// Leaving the waitlist, members don't have to know
+		if channel.dequeue(chPayload.Requester) {
+			chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
+				Status: protocol.StatusSuccess,
+			}
+			return chPayload, protocol.ChannelPayload{}
+		}
 		logger.WithFields(logrus.Fields{
 			"channel": chPayload.ChannelName,
 			"user":    chPayload.Requester,
@@ -284,13 +472,25 @@ func (m *Manager) leaveChannel(chPayload protocol.ChannelPayload) (protocol.Chan
 		return chPayload, protocol.ChannelPayload{}
 	}
 
-	delete(channel.Users, chPayload.Requester)
+	m.removeMember(channel, chPayload.Requester)
 	channel.LastActivity = time.Now().Unix()
 
-	if len(channel.Users) == 0 {
-		logger.WithField("channel", chPayload.ChannelName).Info("Channel deleted as it's empty")
-		m.channelCloseNoticeToGroupChat(channel.ChName)
-		delete(m.chMap, chPayload.ChannelName)
+	// With people waiting the channel stays, they get the seats that just freed up
+	if len(channel.Users) == 0 && len(channel.waitlist) == 0 && !channel.Permanent {
+		if channel.ArchiveOnIdle {
+			logger.WithField("channel", chPayload.ChannelName).Info("Channel archived as it's empty")
+			m.archiveChannel(channel)
+		} else {
+			logger.WithField("channel", chPayload.ChannelName).Info("Channel deleted as it's empty")
+			m.removeChannel(chPayload.ChannelName)
+		}
+	}
+
+	notice := fmt.Sprintf("'%s' has left the channel", chPayload.Requester)
+	if channel.Owner == chPayload.Requester {
+		if newOwner, promoted := m.promoteLongestPresent(channel); promoted {
+			notice = fmt.Sprintf("'%s' has left the channel, '%s' is now the owner", chPayload.Requester, newOwner)
+		}
 	}
 
 	logger.WithFields(logrus.Fields{
@@ -301,37 +501,58 @@ func (m *Manager) leaveChannel(chPayload protocol.ChannelPayload) (protocol.Chan
 	chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
 		Status: protocol.StatusSuccess,
 	}
-	chNoticePayload := m.prepareNoticePayload(chPayload, channel, fmt.Sprintf("'%s' has left the channel", chPayload.Requester))
+	chNoticePayload := m.prepareNoticePayload(chPayload, channel, notice)
 	return chPayload, chNoticePayload
 }
 
+// getChannels lists public channels whose name contains the filter, in the requested order.
+// An empty listing is still a success, the client tells there is nothing to show.
 func (m *Manager) getChannels(chPayload protocol.ChannelPayload) protocol.ChannelPayload {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
 
 	logger.Info("Getting list of channels")
 
-	channels := make([]string, 0, len(m.chMap))
-	for channelName, channelDetails := range m.chMap {
-		if channelDetails.Visibility == string(protocol.VisibilityPublic) {
-			channels = append(channels, channelName)
+	var filter string
+	order := protocol.SortByName
+	if chPayload.OptionalChannelArgs != nil {
+		filter = strings.ToLower(chPayload.OptionalChannelArgs.Filter)
+		if chPayload.OptionalChannelArgs.Sort != "" {
+			order = chPayload.OptionalChannelArgs.Sort
 		}
 	}
+	less, validSort := summarySorters[order]
+	if !validSort {
+		return failChannelAction(chPayload, invalidChannelSort)
+	}
 
-	logger.WithField("channelCount", len(channels)).Info("Channel list retrieved")
-	// There is no public channel
-	if len(channels) == 0 {
-		chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
-			Status: protocol.StatusFail,
-			Reason: noActiveChannels,
+	summaries := make([]protocol.ChannelSummary, 0, len(m.chMap))
+	for channelName, channelDetails := range m.chMap {
+		if channelDetails.Visibility != string(protocol.VisibilityPublic) {
+			continue
 		}
-	} else {
-		chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
-			Status:   protocol.StatusSuccess,
-			Channels: channels,
+		if filter != "" && !strings.Contains(strings.ToLower(channelName), filter) {
+			continue
+		}
+		summaries = append(summaries, channelDetails.summary())
+	}
+	sort.Slice(summaries, func(i, j int) bool {
+		if less(summaries[i], summaries[j]) != less(summaries[j], summaries[i]) {
+			return less(summaries[i], summaries[j])
 		}
+		return summaries[i].Name < summaries[j].Name
+	})
+	channels := make([]string, 0, len(summaries))
+	for _, summary := range summaries {
+		channels = append(channels, summary.Name)
 	}
 
+	logger.WithField("channelCount", len(channels)).Info("Channel list retrieved")
+	chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
+		Status:    protocol.StatusSuccess,
+		Channels:  channels,
+		Summaries: summaries,
+	}
 	return chPayload
 }
 
@@ -350,6 +571,14 @@ func (m *Manager) getUsers(chPayload protocol.ChannelPayload) protocol.ChannelPa
 		}
 		return chPayload
 	}
+	// Members are authorized by being in the channel, the password is only needed to join
+	if !selectedCh.Users[chPayload.Requester] {
+		chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
+			Status: protocol.StatusFail,
+			Reason: notInTheCh,
+		}
+		return chPayload
+	}
 
 	users := make([]string, 0, len(selectedCh.Users))
 	for user := range selectedCh.Users {
@@ -365,8 +594,8 @@ func (m *Manager) getUsers(chPayload protocol.ChannelPayload) protocol.ChannelPa
 }
 
 func (m *Manager) messageChannel(chPayload protocol.ChannelPayload) protocol.ChannelPayload {
-	m.lock.RLock()
-	defer m.lock.RUnlock()
+	m.lock.Lock()
+	defer m.lock.Unlock()
 
 	selectedCh, exists := m.chMap[chPayload.ChannelName]
 	//Missing channel check
@@ -389,6 +618,13 @@ func (m *Manager) messageChannel(chPayload protocol.ChannelPayload) protocol.Cha
 		return chPayload
 	}
 
+	now := time.Now()
+	if reason, restricted := selectedCh.postingRestriction(chPayload.Requester, now); restricted {
+		return failChannelAction(chPayload, reason)
+	}
+	selectedCh.lastMessageAt[chPayload.Requester] = now
+	selectedCh.LastActivity = now.Unix()
+
 	users := make([]string, 0, len(selectedCh.Users))
 	for user := range selectedCh.Users {
 		users = append(users, user)
@@ -402,25 +638,52 @@ func (m *Manager) messageChannel(chPayload protocol.ChannelPayload) protocol.Cha
 	return chPayload
 }
 
+// historyChannel only checks that the requester may read the channel history, the router fetches it
+func (m *Manager) historyChannel(chPayload protocol.ChannelPayload) protocol.ChannelPayload {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	channel, exists := m.chMap[chPayload.ChannelName]
+	if !exists {
+		chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
+			Status: protocol.StatusFail,
+			Reason: chDoesNotExist,
+		}
+		return chPayload
+	}
+	if !channel.Users[chPayload.Requester] {
+		chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
+			Status: protocol.StatusFail,
+			Reason: notInTheCh,
+		}
+		return chPayload
+	}
+
+	chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
+		Status: protocol.StatusSuccess,
+	}
+	return chPayload
+}
+
 func (m *Manager) kickUser(chPayload protocol.ChannelPayload) (protocol.ChannelPayload, protocol.ChannelPayload) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
 	//Channel has to exist
 	channel := m.chMap[chPayload.ChannelName]
-	checkPayload := m.kickAndBanChecks(channel, chPayload)
+	checkPayload := m.moderationChecks(channel, chPayload)
 	if checkPayload != nil {
 		return *checkPayload, protocol.ChannelPayload{}
 	}
 	//Delete user from user list
-	delete(channel.Users, chPayload.OptionalChannelArgs.TargetUser)
+	m.removeMember(channel, chPayload.OptionalChannelArgs.TargetUser)
 	channel.LastActivity = time.Now().Unix()
 	chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
 		Status:     protocol.StatusSuccess,
 		TargetUser: chPayload.OptionalChannelArgs.TargetUser,
 	}
 
-	chNoticePayload := m.prepareNoticePayload(chPayload, channel, fmt.Sprintf("'%s' has been kicked from the channel", chPayload.Requester))
+	chNoticePayload := m.prepareNoticePayload(chPayload, channel, fmt.Sprintf("'%s' has been kicked from the channel", chPayload.OptionalChannelArgs.TargetUser))
 	return chPayload, chNoticePayload
 }
 
@@ -430,27 +693,211 @@ func (m *Manager) banUser(chPayload protocol.ChannelPayload) (protocol.ChannelPa
 
 	//Channel has to exist
 	channel := m.chMap[chPayload.ChannelName]
-	checkPayload := m.kickAndBanChecks(channel, chPayload)
+	checkPayload := m.moderationChecks(channel, chPayload)
 	if checkPayload != nil {
 		return *checkPayload, protocol.ChannelPayload{}
 	}
+	target := chPayload.OptionalChannelArgs.TargetUser
+	duration := chPayload.OptionalChannelArgs.Duration
+	if duration < 0 {
+		return failChannelAction(chPayload, invalidBanDuration), protocol.ChannelPayload{}
+	}
 
 	//Delete user from user list
-	delete(channel.Users, chPayload.OptionalChannelArgs.TargetUser)
-	//Add user to banned list
-	channel.BannedUsers[chPayload.OptionalChannelArgs.TargetUser] = true
+	m.removeMember(channel, target)
+	//Add user to banned list, banned moderators lose their rights
+	ban := ChannelBan{BannedBy: chPayload.Requester, BannedAt: time.Now()}
+	if duration > 0 {
+		ban.ExpiresAt = ban.BannedAt.Add(duration)
+	}
+	channel.BannedUsers[target] = ban
+	if err := m.storeBan(channel.ChName, target, ban); err != nil {
+		logger.WithError(err).WithField("channel", channel.ChName).Error("Failed to store channel ban")
+	}
+	m.setModerator(channel, target, false)
 	channel.LastActivity = time.Now().Unix()
 	chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
 		Status:     protocol.StatusSuccess,
-		TargetUser: chPayload.OptionalChannelArgs.TargetUser,
+		TargetUser: target,
+		Duration:   duration,
 	}
 
-	chNoticePayload := m.prepareNoticePayload(chPayload, channel, fmt.Sprintf("'%s' has been banned from the channel", chPayload.Requester))
+	notice := fmt.Sprintf("'%s' has been banned from the channel", target)
+	if duration > 0 {
+		notice = fmt.Sprintf("'%s' has been banned from the channel for %s", target, duration)
+	}
+	chNoticePayload := m.prepareNoticePayload(chPayload, channel, notice)
 	return chPayload, chNoticePayload
 }
 
+// Session Restore
+// -----------------------------
+
+// UserChannels returns the names of channels the user is currently in
+func (m *Manager) UserChannels(username string) []string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	channels := make([]string, 0)
+	for chName, channel := range m.chMap {
+		if channel.Users[username] {
+			channels = append(channels, chName)
+		}
+	}
+	return channels
+}
+
+// CreatedAt returns when the channel was created, history from an earlier channel with the same name is older than this
+func (m *Manager) CreatedAt(chName string) (int64, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	channel, exists := m.chMap[chName]
+	if !exists {
+		return 0, false
+	}
+	return channel.CreatedAt, true
+}
+
+// RestoreMember puts a user with a resumed session back into a channel they were in before disconnecting.
+// The password check is skipped since the user already held a seat, but bans are respected.
+// A seat given up to the waitlist is only taken back if the channel has room and nobody is waiting.
+// Returns a join payload for the client and false if the channel is gone, full or the user got banned meanwhile.
+func (m *Manager) RestoreMember(chName, username string) (protocol.ChannelPayload, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	channel, exists := m.chMap[chName]
+	if !exists || channel.Archived || channel.isBanned(username) ||
+		(!channel.Users[username] && (len(channel.Users) >= channel.ChCapacity || len(channel.waitlist) > 0)) {
+		logger.WithFields(logrus.Fields{
+			"channel": chName,
+			"user":    username,
+		}).Warn("Could not restore channel membership")
+		return protocol.ChannelPayload{}, false
+	}
+
+	channel.Users[username] = true
+	channel.joinedAt[username] = time.Now()
+	logger.WithFields(logrus.Fields{
+		"channel": chName,
+		"user":    username,
+	}).Info("Channel membership restored")
+
+	return protocol.ChannelPayload{
+		ChannelAction: protocol.JoinChannel,
+		Requester:     username,
+		ChannelName:   channel.ChName,
+		ChannelSize:   channel.ChCapacity,
+		OptionalChannelArgs: &protocol.OptionalChannelArgs{
+			Status:      protocol.StatusSuccess,
+			Topic:       channel.Topic,
+			Description: channel.Description,
+			CreatedAt:   channel.CreatedAt,
+		},
+	}, true
+}
+
+// DeleteUser forgets everything tying a deleted account to channels, so whoever registers the name next starts clean.
+// Channels it still owns go to the longest present member, or to replacement when nobody is left to take them.
+func (m *Manager) DeleteUser(username, replacement string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	newOwners := make(map[string]string)
+	for chName, channel := range m.chMap {
+		if channel.Owner != username {
+			continue
+		}
+		newOwner := m.longestPresent(channel, true)
+		if newOwner == "" {
+			newOwner = m.longestPresent(channel, false)
+		}
+		if newOwner == "" {
+			newOwner = replacement
+		}
+		newOwners[chName] = newOwner
+	}
+	if err := m.deleteUser(username, replacement, newOwners); err != nil {
+		return fmt.Errorf("failed to delete channel data of user: %w", err)
+	}
+
+	for chName, channel := range m.chMap {
+		m.removeMember(channel, username)
+		channel.dequeue(username)
+		delete(channel.lastMessageAt, username)
+		delete(channel.Moderators, username)
+		delete(channel.BannedUsers, username)
+		delete(channel.MutedUsers, username)
+		for target, ban := range channel.BannedUsers {
+			if ban.BannedBy == username {
+				ban.BannedBy = replacement
+				channel.BannedUsers[target] = ban
+			}
+		}
+		for target, mute := range channel.MutedUsers {
+			if mute.MutedBy == username {
+				mute.MutedBy = replacement
+				channel.MutedUsers[target] = mute
+			}
+		}
+		for code, invite := range channel.invites {
+			if invite.Username == username || invite.CreatedBy == username {
+				delete(channel.invites, code)
+			}
+		}
+		if newOwner, found := newOwners[chName]; found {
+			channel.Owner = newOwner
+			delete(channel.Moderators, newOwner)
+		}
+	}
+	logger.WithField("user", username).Info("Channel data of deleted user removed")
+	return nil
+}
+
 // Helper Methods
 // -----------------------------
+
+// hashChannelPassword hashes the channel password, channels without one keep an empty hash
+func hashChannelPassword(password string) (string, error) {
+	if password == "" {
+		return "", nil
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// checkChannelPassword compares the password with the channel's hash without holding the lock during bcrypt.
+// The checked hash is returned, so the caller can make sure it's still the channel's hash once locked.
+func (m *Manager) checkChannelPassword(chName, password string) (string, bool) {
+	m.lock.RLock()
+	channel, exists := m.chMap[chName]
+	var hash string
+	if exists {
+		hash = channel.ChPass
+	}
+	m.lock.RUnlock()
+
+	if !exists {
+		return "", false
+	}
+	if hash == "" {
+		return "", true
+	}
+	return hash, bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// removeChannel deletes the channel from memory and the database
+func (m *Manager) removeChannel(chName string) {
+	delete(m.chMap, chName)
+	if err := m.deleteChannel(chName); err != nil {
+		logger.WithError(err).WithField("channel", chName).Error("Failed to delete stored channel")
+	}
+}
+
 func (*Manager) prepareNoticePayload(chPayload protocol.ChannelPayload, channel *ChannelDetails, message string) protocol.ChannelPayload {
 	chNoticeResp := chPayload
 	chNoticeResp.ChannelAction = protocol.NoticeChannel
@@ -471,7 +918,8 @@ func getUsersInChannnel(channel *ChannelDetails) []string {
 	return users
 }
 
-func (m *Manager) kickAndBanChecks(channel *ChannelDetails, chPayload protocol.ChannelPayload) *protocol.ChannelPayload {
+// moderationChecks validates kick, ban and mute, which act on a target user
+func (m *Manager) moderationChecks(channel *ChannelDetails, chPayload protocol.ChannelPayload) *protocol.ChannelPayload {
 	//Channel has to exist
 	if channel == nil {
 		logger.WithField("channel", chPayload.ChannelName).Warn("Channel does not exist")
@@ -482,17 +930,18 @@ func (m *Manager) kickAndBanChecks(channel *ChannelDetails, chPayload protocol.C
 		return &chPayload
 	}
 
-	//Requester has to be the owner
-	if channel.Owner != chPayload.Requester {
+	//Requester has to be the owner or a moderator
+	if !channel.canModerate(chPayload.Requester) {
 		chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
 			Status: protocol.StatusFail,
-			Reason: notChannelOwner,
+			Reason: notChannelModerator,
 		}
 		return &chPayload
 	}
 
 	//Payload has to have target user
-	if chPayload.OptionalChannelArgs != nil &&
+	if chPayload.OptionalChannelArgs == nil ||
+		chPayload.OptionalChannelArgs.TargetUser == "" ||
 		chPayload.OptionalChannelArgs.TargetUser == protocol.EmptyChannelField {
 		chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
 			Status: protocol.StatusFail,
@@ -509,6 +958,15 @@ func (m *Manager) kickAndBanChecks(channel *ChannelDetails, chPayload protocol.C
 		}
 		return &chPayload
 	}
+
+	//Moderators can't act on each other
+	if channel.Moderators[chPayload.OptionalChannelArgs.TargetUser] && channel.Owner != chPayload.Requester {
+		chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
+			Status: protocol.StatusFail,
+			Reason: moderatorCannotBeKicked,
+		}
+		return &chPayload
+	}
 	return nil
 }
 
@@ -520,67 +978,89 @@ func (m *Manager) startInactiveChannelChecker() {
 	ticker := time.NewTicker(1 * time.Minute)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		m.checkInactiveChannel()
+	for {
+		select {
+		case <-ticker.C:
+			m.checkInactiveChannel()
+			m.removeExpiredBans()
+			m.removeExpiredMutes()
+			m.removeExpiredInvites()
+			m.notifySeatsFreed()
+		case <-m.done:
+			return
+		}
 	}
 }
 
 // This function is an exception among others. Only this function is exposed to the connection manager and encodeFn because in this scenario, the invoker is this function.
+// Members are warned once before their channel is closed or archived, only they are told about it.
 func (m *Manager) checkInactiveChannel() {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
+	now := time.Now()
 	for chName, ch := range m.chMap {
-		if time.Since(time.Unix(ch.LastActivity, 0)) > time.Minute {
+		if ch.Permanent || ch.Archived {
+			continue
+		}
+		idle := now.Sub(time.Unix(ch.LastActivity, 0))
+		timeout := ch.idleTimeout()
+		switch {
+		case idle > timeout && ch.ArchiveOnIdle:
+			logger.WithFields(logrus.Fields{
+				"channel": ch.ChName,
+			}).Info("Channel is inactive archiving it")
+
+			m.sendCloseNoticeToChannelUsers(ch, fmt.Sprintf(archiveInactiveCh, chName))
+			m.archiveChannel(ch)
+		case idle > timeout:
 			logger.WithFields(logrus.Fields{
 				"channel": ch.ChName,
 			}).Info("Channel is inactive removing it")
 
-			m.sendCloseNoticeToChannelUsers(ch)
-			m.channelCloseNoticeToGroupChat(chName)
-			delete(m.chMap, chName)
+			m.sendCloseNoticeToChannelUsers(ch, fmt.Sprintf(closeInactiveCh, chName))
+			m.removeChannel(chName)
+		case idle > timeout-idleWarningLead && ch.idleWarnedAt != ch.LastActivity:
+			m.sendIdleWarningToChannelUsers(ch, timeout-idle)
+			ch.idleWarnedAt = ch.LastActivity
 		}
 	}
 }
 
-func (m *Manager) sendCloseNoticeToChannelUsers(ch *ChannelDetails) {
-	for user := range ch.Users {
-		conn, found := m.cm.FindConnectionByOwnerName(user)
-		if !found {
-			continue
-		}
-		encodedMsg := m.encodeFn(protocol.Payload{
-			MessageType: protocol.MessageTypeCH,
-			ChannelPayload: &protocol.ChannelPayload{
-				ChannelAction: protocol.CloseChannel,
-				OptionalChannelArgs: &protocol.OptionalChannelArgs{
-					Reason: fmt.Sprintf(closeInactiveCh, ch.ChName),
-					Status: protocol.StatusSuccess,
-				},
+func (m *Manager) sendCloseNoticeToChannelUsers(ch *ChannelDetails, reason string) {
+	m.writeToChannelUsers(ch, protocol.Payload{
+		MessageType: protocol.MessageTypeCH,
+		ChannelPayload: &protocol.ChannelPayload{
+			ChannelAction: protocol.CloseChannel,
+			ChannelName:   ch.ChName,
+			OptionalChannelArgs: &protocol.OptionalChannelArgs{
+				Reason: reason,
+				Status: protocol.StatusSuccess,
 			},
-		})
-		conn.Write([]byte(encodedMsg))
-	}
+		},
+	})
 }
 
-func (m *Manager) channelCloseNoticeToGroupChat(chName string) {
-	m.cm.RangeConnections(func(conn net.Conn, info *connection.ConnectionInfo) bool {
-		payload := protocol.Payload{
-			MessageType: protocol.MessageTypeSYS,
-			Content:     fmt.Sprintf("Channel '%s' has been closed due to inactivity", chName),
-			Status:      "success",
+func (m *Manager) writeToChannelUsers(ch *ChannelDetails, payload protocol.Payload) {
+	encodedMsg := m.encodeFn(payload)
+	for user := range ch.Users {
+		for _, conn := range m.cm.FindConnectionsByOwnerName(user) {
+			conn.Write([]byte(encodedMsg))
 		}
-		encodedMsg := []byte(m.encodeFn(payload))
-		conn.Write([]byte(encodedMsg))
-		return true
-	})
+	}
 }
 
 // Cleanup for typingIndicator map
 // -------------------------------
 func (m *Manager) cleanUpTypingIndicators() {
 	ticker := time.NewTicker(10 * time.Second)
-	for range ticker.C {
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-m.done:
+			return
+		}
 		m.lock.Lock()
 		for _, ch := range m.chMap {
 			for user, lastTyped := range ch.typingIndicators {
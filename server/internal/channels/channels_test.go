@@ -0,0 +1,830 @@
+package channels
+
+import (
+	"bytes"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ogzhanolguncu/go-chat/protocol"
+	"github.com/ogzhanolguncu/go-chat/server/internal/connection"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestManager(t *testing.T, dbPath string) *Manager {
+	t.Helper()
+	m, err := NewChannelManager(connection.NewConnectionManager(), protocol.InitEncodeProtocol(false), dbPath)
+	require.NoError(t, err)
+	return m
+}
+
+func channelPayload(action protocol.ChannelActionType, requester, chName string, args *protocol.OptionalChannelArgs) protocol.Payload {
+	return protocol.Payload{
+		MessageType: protocol.MessageTypeCH,
+		ChannelPayload: &protocol.ChannelPayload{
+			ChannelAction:       action,
+			Requester:           requester,
+			ChannelName:         chName,
+			ChannelPassword:     "secret",
+			ChannelSize:         5,
+			OptionalChannelArgs: args,
+		},
+	}
+}
+
+func TestChannelPersistence(t *testing.T) {
+	t.Run("should reload channels and bans after restart", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "channels_test.db")
+		m := newTestManager(t, dbPath)
+
+		res, _ := m.Handle(channelPayload(protocol.CreateChannel, "oz", "golang", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic}))
+		require.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		m.Handle(channelPayload(protocol.JoinChannel, "john", "golang", &protocol.OptionalChannelArgs{}))
+		res, _ = m.Handle(channelPayload(protocol.BanUser, "oz", "golang", &protocol.OptionalChannelArgs{TargetUser: "john"}))
+		require.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		require.NoError(t, m.Close())
+
+		m = newTestManager(t, dbPath)
+		defer m.Close()
+		channel, exists := m.chMap["golang"]
+		require.True(t, exists)
+		assert.Equal(t, "oz", channel.Owner)
+		assert.NoError(t, bcrypt.CompareHashAndPassword([]byte(channel.ChPass), []byte("secret")))
+		assert.Equal(t, 5, channel.ChCapacity)
+		assert.Equal(t, string(protocol.VisibilityPublic), channel.Visibility)
+		assert.Empty(t, channel.Users)
+
+		res, _ = m.Handle(channelPayload(protocol.JoinChannel, "john", "golang", &protocol.OptionalChannelArgs{}))
+		assert.Equal(t, protocol.StatusFail, res.OptionalChannelArgs.Status)
+		assert.Equal(t, "You have been banned from 'golang'.", res.OptionalChannelArgs.Reason)
+	})
+
+	t.Run("should forget channels closed when empty", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "channels_test.db")
+		m := newTestManager(t, dbPath)
+		conn := &recordingConn{}
+		m.cm.AddConnection(conn, &connection.ConnectionInfo{Connection: conn, OwnerName: "john"})
+
+		m.Handle(channelPayload(protocol.CreateChannel, "oz", "golang", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic}))
+		m.Handle(channelPayload(protocol.LeaveChannel, "oz", "golang", &protocol.OptionalChannelArgs{}))
+		// Nobody was in it, so there's nobody to tell
+		assert.Empty(t, conn.written.String())
+		require.NoError(t, m.Close())
+
+		m = newTestManager(t, dbPath)
+		defer m.Close()
+		assert.NotContains(t, m.chMap, "golang")
+	})
+
+	t.Run("should keep permanent channels when empty or inactive", func(t *testing.T) {
+		m := newTestManager(t, filepath.Join(t.TempDir(), "channels_test.db"))
+		defer m.Close()
+
+		m.Handle(channelPayload(protocol.CreateChannel, "admin", "lobby", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic, Permanent: true}))
+		m.Handle(channelPayload(protocol.CreateChannel, "oz", "golang", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic}))
+		m.Handle(channelPayload(protocol.LeaveChannel, "admin", "lobby", &protocol.OptionalChannelArgs{}))
+		assert.Contains(t, m.chMap, "lobby")
+
+		for _, channel := range m.chMap {
+			channel.LastActivity = time.Now().Add(-time.Hour).Unix()
+		}
+		m.checkInactiveChannel()
+		assert.Contains(t, m.chMap, "lobby")
+		assert.NotContains(t, m.chMap, "golang")
+	})
+}
+
+func TestChannelDeletedUser(t *testing.T) {
+	t.Run("should forget a deleted user's rights and hand over their channels", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "channels_test.db")
+		m := newTestManager(t, dbPath)
+
+		m.Handle(channelPayload(protocol.CreateChannel, "oz", "lobby", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic, Permanent: true}))
+		m.Handle(channelPayload(protocol.LeaveChannel, "oz", "lobby", &protocol.OptionalChannelArgs{}))
+		m.Handle(channelPayload(protocol.CreateChannel, "john", "golang", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic}))
+		m.Handle(channelPayload(protocol.JoinChannel, "oz", "golang", &protocol.OptionalChannelArgs{}))
+		m.Handle(channelPayload(protocol.JoinChannel, "jane", "golang", &protocol.OptionalChannelArgs{}))
+		m.Handle(channelPayload(protocol.OpUser, "john", "golang", &protocol.OptionalChannelArgs{TargetUser: "oz"}))
+		m.Handle(channelPayload(protocol.MuteUser, "oz", "golang", &protocol.OptionalChannelArgs{TargetUser: "jane"}))
+		m.Handle(channelPayload(protocol.BanUser, "john", "golang", &protocol.OptionalChannelArgs{TargetUser: "oz"}))
+
+		require.NoError(t, m.DeleteUser("oz", "[deleted]"))
+		require.NoError(t, m.Close())
+
+		m = newTestManager(t, dbPath)
+		defer m.Close()
+		assert.Equal(t, "[deleted]", m.chMap["lobby"].Owner)
+		golang := m.chMap["golang"]
+		assert.NotContains(t, golang.Moderators, "oz")
+		assert.NotContains(t, golang.BannedUsers, "oz")
+		assert.Equal(t, "[deleted]", golang.MutedUsers["jane"].MutedBy)
+	})
+}
+
+func TestChannelPasswords(t *testing.T) {
+	t.Run("should hash password and never send it back", func(t *testing.T) {
+		m := newTestManager(t, filepath.Join(t.TempDir(), "channels_test.db"))
+		defer m.Close()
+
+		res, _ := m.Handle(channelPayload(protocol.CreateChannel, "oz", "golang", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic}))
+		assert.Empty(t, res.ChannelPassword)
+		assert.NotEqual(t, "secret", m.chMap["golang"].ChPass)
+
+		wrong := channelPayload(protocol.JoinChannel, "john", "golang", &protocol.OptionalChannelArgs{})
+		wrong.ChannelPayload.ChannelPassword = "guess"
+		res, _ = m.Handle(wrong)
+		assert.Equal(t, "Incorrect channel password.", res.OptionalChannelArgs.Reason)
+
+		res, notice := m.Handle(channelPayload(protocol.JoinChannel, "john", "golang", &protocol.OptionalChannelArgs{}))
+		assert.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		assert.Empty(t, res.ChannelPassword)
+		assert.Empty(t, notice.ChannelPassword)
+
+		restored, ok := m.RestoreMember("golang", "john")
+		assert.True(t, ok)
+		assert.Empty(t, restored.ChannelPassword)
+	})
+
+	t.Run("should authorize members without password", func(t *testing.T) {
+		m := newTestManager(t, filepath.Join(t.TempDir(), "channels_test.db"))
+		defer m.Close()
+
+		m.Handle(channelPayload(protocol.CreateChannel, "oz", "golang", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic}))
+		for _, action := range []protocol.ChannelActionType{protocol.GetUsers, protocol.MessageChannel, protocol.TypingChannel} {
+			member := channelPayload(action, "oz", "golang", &protocol.OptionalChannelArgs{Message: "hi"})
+			member.ChannelPayload.ChannelPassword = ""
+			res, _ := m.Handle(member)
+			assert.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status, action.String())
+
+			// Knowing the password isn't enough without joining
+			outsider := channelPayload(action, "john", "golang", &protocol.OptionalChannelArgs{Message: "hi"})
+			res, _ = m.Handle(outsider)
+			assert.Equal(t, protocol.StatusFail, res.OptionalChannelArgs.Status, action.String())
+		}
+	})
+
+}
+
+func TestChannelModerators(t *testing.T) {
+	t.Run("should let moderators kick and ban but not each other", func(t *testing.T) {
+		m := newTestManager(t, filepath.Join(t.TempDir(), "channels_test.db"))
+		defer m.Close()
+
+		m.Handle(channelPayload(protocol.CreateChannel, "oz", "golang", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic}))
+		for _, user := range []string{"john", "jane", "joe"} {
+			m.Handle(channelPayload(protocol.JoinChannel, user, "golang", &protocol.OptionalChannelArgs{}))
+		}
+
+		res, _ := m.Handle(channelPayload(protocol.OpUser, "john", "golang", &protocol.OptionalChannelArgs{TargetUser: "jane"}))
+		assert.Equal(t, "Not a channel owner.", res.OptionalChannelArgs.Reason)
+		res, _ = m.Handle(channelPayload(protocol.KickUser, "john", "golang", &protocol.OptionalChannelArgs{TargetUser: "joe"}))
+		assert.Equal(t, "Not a channel owner or moderator.", res.OptionalChannelArgs.Reason)
+
+		res, notice := m.Handle(channelPayload(protocol.OpUser, "oz", "golang", &protocol.OptionalChannelArgs{TargetUser: "john"}))
+		require.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		assert.Equal(t, "'john' is now a moderator", notice.OptionalChannelArgs.Notice)
+		m.Handle(channelPayload(protocol.OpUser, "oz", "golang", &protocol.OptionalChannelArgs{TargetUser: "jane"}))
+
+		res, _ = m.Handle(channelPayload(protocol.KickUser, "john", "golang", &protocol.OptionalChannelArgs{TargetUser: "jane"}))
+		assert.Equal(t, "Only the owner can kick, ban or mute moderators.", res.OptionalChannelArgs.Reason)
+		res, _ = m.Handle(channelPayload(protocol.BanUser, "john", "golang", &protocol.OptionalChannelArgs{TargetUser: "oz"}))
+		assert.Equal(t, protocol.StatusFail, res.OptionalChannelArgs.Status)
+		res, notice = m.Handle(channelPayload(protocol.KickUser, "john", "golang", &protocol.OptionalChannelArgs{TargetUser: "joe"}))
+		assert.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		assert.Equal(t, "'joe' has been kicked from the channel", notice.OptionalChannelArgs.Notice)
+
+		res, _ = m.Handle(channelPayload(protocol.DeopUser, "oz", "golang", &protocol.OptionalChannelArgs{TargetUser: "jane"}))
+		require.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		res, _ = m.Handle(channelPayload(protocol.BanUser, "john", "golang", &protocol.OptionalChannelArgs{TargetUser: "jane"}))
+		assert.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+	})
+
+	t.Run("should transfer ownership and persist moderators", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "channels_test.db")
+		m := newTestManager(t, dbPath)
+
+		m.Handle(channelPayload(protocol.CreateChannel, "oz", "golang", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic}))
+		m.Handle(channelPayload(protocol.JoinChannel, "john", "golang", &protocol.OptionalChannelArgs{}))
+		m.Handle(channelPayload(protocol.JoinChannel, "jane", "golang", &protocol.OptionalChannelArgs{}))
+		m.Handle(channelPayload(protocol.OpUser, "oz", "golang", &protocol.OptionalChannelArgs{TargetUser: "jane"}))
+
+		res, _ := m.Handle(channelPayload(protocol.TransferChannel, "oz", "golang", &protocol.OptionalChannelArgs{TargetUser: "bob"}))
+		assert.Equal(t, "User is not in the channel.", res.OptionalChannelArgs.Reason)
+		res, notice := m.Handle(channelPayload(protocol.TransferChannel, "oz", "golang", &protocol.OptionalChannelArgs{TargetUser: "john"}))
+		require.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		assert.Equal(t, "'john' is now the owner", notice.OptionalChannelArgs.Notice)
+		res, _ = m.Handle(channelPayload(protocol.OpUser, "oz", "golang", &protocol.OptionalChannelArgs{TargetUser: "jane"}))
+		assert.Equal(t, "Not a channel owner.", res.OptionalChannelArgs.Reason)
+		require.NoError(t, m.Close())
+
+		m = newTestManager(t, dbPath)
+		defer m.Close()
+		assert.Equal(t, "john", m.chMap["golang"].Owner)
+		assert.True(t, m.chMap["golang"].Moderators["jane"])
+	})
+
+	t.Run("should promote the longest present member when the owner leaves", func(t *testing.T) {
+		m := newTestManager(t, filepath.Join(t.TempDir(), "channels_test.db"))
+		defer m.Close()
+
+		m.Handle(channelPayload(protocol.CreateChannel, "oz", "golang", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic}))
+		m.Handle(channelPayload(protocol.JoinChannel, "john", "golang", &protocol.OptionalChannelArgs{}))
+		m.Handle(channelPayload(protocol.JoinChannel, "jane", "golang", &protocol.OptionalChannelArgs{}))
+		m.chMap["golang"].joinedAt["jane"] = time.Now().Add(-time.Minute)
+
+		_, notice := m.Handle(channelPayload(protocol.LeaveChannel, "oz", "golang", &protocol.OptionalChannelArgs{}))
+		assert.Equal(t, "'oz' has left the channel, 'jane' is now the owner", notice.OptionalChannelArgs.Notice)
+		assert.Equal(t, "jane", m.chMap["golang"].Owner)
+	})
+
+	t.Run("should hand the channel to a connected member when the owner's connection drops", func(t *testing.T) {
+		m := newTestManager(t, filepath.Join(t.TempDir(), "channels_test.db"))
+		defer m.Close()
+
+		m.Handle(channelPayload(protocol.CreateChannel, "oz", "golang", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic}))
+		m.Handle(channelPayload(protocol.JoinChannel, "john", "golang", &protocol.OptionalChannelArgs{}))
+		m.Handle(channelPayload(protocol.JoinChannel, "jane", "golang", &protocol.OptionalChannelArgs{}))
+		m.chMap["golang"].joinedAt["john"] = time.Now().Add(-time.Minute)
+
+		// Nobody else is connected, so oz keeps the channel
+		assert.Empty(t, m.MemberDisconnected("oz"))
+		assert.Equal(t, "oz", m.chMap["golang"].Owner)
+
+		// john joined earlier but isn't connected either
+		conn := &recordingConn{}
+		m.cm.AddConnection(conn, &connection.ConnectionInfo{Connection: conn, OwnerName: "jane"})
+		notices := m.MemberDisconnected("oz")
+		require.Len(t, notices, 1)
+		assert.Equal(t, "'oz' lost connection, 'jane' is now the owner", notices[0].OptionalChannelArgs.Notice)
+		assert.Equal(t, "jane", m.chMap["golang"].Owner)
+		// Still a member, so the session can be restored
+		assert.True(t, m.chMap["golang"].Users["oz"])
+	})
+}
+
+func TestChannelBans(t *testing.T) {
+	t.Run("should list and lift bans", func(t *testing.T) {
+		m := newTestManager(t, filepath.Join(t.TempDir(), "channels_test.db"))
+		defer m.Close()
+
+		m.Handle(channelPayload(protocol.CreateChannel, "oz", "golang", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic}))
+		m.Handle(channelPayload(protocol.JoinChannel, "john", "golang", &protocol.OptionalChannelArgs{}))
+		m.Handle(channelPayload(protocol.BanUser, "oz", "golang", &protocol.OptionalChannelArgs{TargetUser: "jane"}))
+		m.Handle(channelPayload(protocol.BanUser, "oz", "golang", &protocol.OptionalChannelArgs{TargetUser: "joe", Duration: time.Hour}))
+
+		res, _ := m.Handle(channelPayload(protocol.GetBans, "john", "golang", nil))
+		assert.Equal(t, "Not a channel owner or moderator.", res.OptionalChannelArgs.Reason)
+
+		res, _ = m.Handle(channelPayload(protocol.GetBans, "oz", "golang", nil))
+		require.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		require.Len(t, res.OptionalChannelArgs.Bans, 2)
+		for _, ban := range res.OptionalChannelArgs.Bans {
+			assert.Equal(t, "oz", ban.BannedBy)
+			if ban.Username == "joe" {
+				assert.InDelta(t, time.Now().Add(time.Hour).Unix(), ban.ExpiresAt, 5)
+			} else {
+				assert.Zero(t, ban.ExpiresAt)
+			}
+		}
+
+		res, _ = m.Handle(channelPayload(protocol.UnbanUser, "oz", "golang", &protocol.OptionalChannelArgs{TargetUser: "john"}))
+		assert.Equal(t, "User is not banned.", res.OptionalChannelArgs.Reason)
+		res, notice := m.Handle(channelPayload(protocol.UnbanUser, "oz", "golang", &protocol.OptionalChannelArgs{TargetUser: "jane"}))
+		require.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		assert.Equal(t, "'jane' has been unbanned", notice.OptionalChannelArgs.Notice)
+
+		res, _ = m.Handle(channelPayload(protocol.JoinChannel, "jane", "golang", &protocol.OptionalChannelArgs{}))
+		assert.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		res, _ = m.Handle(channelPayload(protocol.JoinChannel, "joe", "golang", &protocol.OptionalChannelArgs{}))
+		assert.Contains(t, res.OptionalChannelArgs.Reason, "You have been banned from 'golang' until")
+	})
+
+	t.Run("should let timed bans expire and keep them across restarts", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "channels_test.db")
+		m := newTestManager(t, dbPath)
+
+		m.Handle(channelPayload(protocol.CreateChannel, "admin", "lobby", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic, Permanent: true}))
+		m.Handle(channelPayload(protocol.BanUser, "admin", "lobby", &protocol.OptionalChannelArgs{TargetUser: "john", Duration: time.Hour}))
+		m.Handle(channelPayload(protocol.BanUser, "admin", "lobby", &protocol.OptionalChannelArgs{TargetUser: "jane", Duration: time.Hour}))
+		require.NoError(t, m.Close())
+
+		m = newTestManager(t, dbPath)
+		defer m.Close()
+		ban := m.chMap["lobby"].BannedUsers["john"]
+		assert.Equal(t, "admin", ban.BannedBy)
+		assert.False(t, ban.ExpiresAt.IsZero())
+
+		ban.ExpiresAt = time.Now().Add(-time.Second)
+		m.chMap["lobby"].BannedUsers["john"] = ban
+		res, _ := m.Handle(channelPayload(protocol.JoinChannel, "john", "lobby", &protocol.OptionalChannelArgs{}))
+		assert.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+
+		m.removeExpiredBans()
+		assert.NotContains(t, m.chMap["lobby"].BannedUsers, "john")
+		assert.Contains(t, m.chMap["lobby"].BannedUsers, "jane")
+		var stored int
+		require.NoError(t, m.db.Get(&stored, "SELECT COUNT(*) FROM channel_bans WHERE channel = 'lobby'"))
+		assert.Equal(t, 1, stored)
+	})
+}
+
+func TestChannelMutes(t *testing.T) {
+	t.Run("should keep muted members from posting until unmuted", func(t *testing.T) {
+		m := newTestManager(t, filepath.Join(t.TempDir(), "channels_test.db"))
+		defer m.Close()
+
+		m.Handle(channelPayload(protocol.CreateChannel, "oz", "golang", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic}))
+		m.Handle(channelPayload(protocol.JoinChannel, "john", "golang", &protocol.OptionalChannelArgs{}))
+		m.Handle(channelPayload(protocol.JoinChannel, "jane", "golang", &protocol.OptionalChannelArgs{}))
+
+		res, _ := m.Handle(channelPayload(protocol.MuteUser, "john", "golang", &protocol.OptionalChannelArgs{TargetUser: "jane"}))
+		assert.Equal(t, "Not a channel owner or moderator.", res.OptionalChannelArgs.Reason)
+		res, _ = m.Handle(channelPayload(protocol.MuteUser, "john", "golang", &protocol.OptionalChannelArgs{TargetUser: "oz"}))
+		assert.Equal(t, "Not a channel owner or moderator.", res.OptionalChannelArgs.Reason)
+
+		res, notice := m.Handle(channelPayload(protocol.MuteUser, "oz", "golang", &protocol.OptionalChannelArgs{TargetUser: "john"}))
+		require.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		assert.Equal(t, "'john' has been muted", notice.OptionalChannelArgs.Notice)
+		res, notice = m.Handle(channelPayload(protocol.MuteUser, "oz", "golang", &protocol.OptionalChannelArgs{TargetUser: "jane", Duration: time.Hour}))
+		require.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		assert.Equal(t, "'jane' has been muted for 1h0m0s", notice.OptionalChannelArgs.Notice)
+
+		res, _ = m.Handle(channelPayload(protocol.MessageChannel, "john", "golang", &protocol.OptionalChannelArgs{Message: "hello"}))
+		assert.Equal(t, "You are muted in this channel.", res.OptionalChannelArgs.Reason)
+		res, _ = m.Handle(channelPayload(protocol.MessageChannel, "jane", "golang", &protocol.OptionalChannelArgs{Message: "hello"}))
+		assert.Contains(t, res.OptionalChannelArgs.Reason, "You are muted in this channel until")
+		// Muted members still read the channel
+		res, _ = m.Handle(channelPayload(protocol.MessageChannel, "oz", "golang", &protocol.OptionalChannelArgs{Message: "hello"}))
+		require.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		assert.ElementsMatch(t, []string{"oz", "john", "jane"}, res.OptionalChannelArgs.Users)
+		res, _ = m.Handle(channelPayload(protocol.GetUsers, "john", "golang", &protocol.OptionalChannelArgs{}))
+		assert.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+
+		res, _ = m.Handle(channelPayload(protocol.UnmuteUser, "oz", "golang", &protocol.OptionalChannelArgs{TargetUser: "oz"}))
+		assert.Equal(t, "User is not muted.", res.OptionalChannelArgs.Reason)
+		res, notice = m.Handle(channelPayload(protocol.UnmuteUser, "oz", "golang", &protocol.OptionalChannelArgs{TargetUser: "john"}))
+		require.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		assert.Equal(t, "'john' has been unmuted", notice.OptionalChannelArgs.Notice)
+		res, _ = m.Handle(channelPayload(protocol.MessageChannel, "john", "golang", &protocol.OptionalChannelArgs{Message: "hello"}))
+		assert.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+	})
+
+	t.Run("should let timed mutes expire and keep them across restarts", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "channels_test.db")
+		m := newTestManager(t, dbPath)
+
+		m.Handle(channelPayload(protocol.CreateChannel, "admin", "lobby", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic, Permanent: true}))
+		m.Handle(channelPayload(protocol.MuteUser, "admin", "lobby", &protocol.OptionalChannelArgs{TargetUser: "john", Duration: time.Hour}))
+		m.Handle(channelPayload(protocol.MuteUser, "admin", "lobby", &protocol.OptionalChannelArgs{TargetUser: "jane"}))
+		require.NoError(t, m.Close())
+
+		m = newTestManager(t, dbPath)
+		defer m.Close()
+		mute := m.chMap["lobby"].MutedUsers["john"]
+		assert.Equal(t, "admin", mute.MutedBy)
+		assert.False(t, mute.ExpiresAt.IsZero())
+
+		mute.ExpiresAt = time.Now().Add(-time.Second)
+		m.chMap["lobby"].MutedUsers["john"] = mute
+		m.Handle(channelPayload(protocol.JoinChannel, "john", "lobby", &protocol.OptionalChannelArgs{}))
+		res, _ := m.Handle(channelPayload(protocol.MessageChannel, "john", "lobby", &protocol.OptionalChannelArgs{Message: "hello"}))
+		assert.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+
+		m.removeExpiredMutes()
+		assert.NotContains(t, m.chMap["lobby"].MutedUsers, "john")
+		assert.Contains(t, m.chMap["lobby"].MutedUsers, "jane")
+		var stored int
+		require.NoError(t, m.db.Get(&stored, "SELECT COUNT(*) FROM channel_mutes WHERE channel = 'lobby'"))
+		assert.Equal(t, 1, stored)
+	})
+
+	t.Run("should limit members to one message per slow mode interval", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "channels_test.db")
+		m := newTestManager(t, dbPath)
+
+		m.Handle(channelPayload(protocol.CreateChannel, "oz", "golang", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic, Permanent: true}))
+		m.Handle(channelPayload(protocol.JoinChannel, "john", "golang", &protocol.OptionalChannelArgs{}))
+
+		res, _ := m.Handle(channelPayload(protocol.SetSlowMode, "john", "golang", &protocol.OptionalChannelArgs{Duration: 10 * time.Second}))
+		assert.Equal(t, "Not a channel owner or moderator.", res.OptionalChannelArgs.Reason)
+		res, _ = m.Handle(channelPayload(protocol.SetSlowMode, "oz", "golang", &protocol.OptionalChannelArgs{Duration: 2 * time.Hour}))
+		assert.Equal(t, "Slow mode must be between 1s and 1h, or 0 to turn it off.", res.OptionalChannelArgs.Reason)
+		res, notice := m.Handle(channelPayload(protocol.SetSlowMode, "oz", "golang", &protocol.OptionalChannelArgs{Duration: 10 * time.Second}))
+		require.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		assert.Equal(t, "Slow mode is on, members can send one message every 10s", notice.OptionalChannelArgs.Notice)
+
+		res, _ = m.Handle(channelPayload(protocol.MessageChannel, "john", "golang", &protocol.OptionalChannelArgs{Message: "first"}))
+		require.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		res, _ = m.Handle(channelPayload(protocol.MessageChannel, "john", "golang", &protocol.OptionalChannelArgs{Message: "second"}))
+		assert.Contains(t, res.OptionalChannelArgs.Reason, "Slow mode is on, one message every 10s.")
+		// Owner and moderators aren't slowed down
+		for i := 0; i < 2; i++ {
+			res, _ = m.Handle(channelPayload(protocol.MessageChannel, "oz", "golang", &protocol.OptionalChannelArgs{Message: "hello"}))
+			assert.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		}
+
+		m.chMap["golang"].lastMessageAt["john"] = time.Now().Add(-11 * time.Second)
+		res, _ = m.Handle(channelPayload(protocol.MessageChannel, "john", "golang", &protocol.OptionalChannelArgs{Message: "third"}))
+		assert.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+
+		require.NoError(t, m.Close())
+		m = newTestManager(t, dbPath)
+		defer m.Close()
+		assert.Equal(t, 10*time.Second, m.chMap["golang"].SlowMode)
+
+		res, notice = m.Handle(channelPayload(protocol.SetSlowMode, "oz", "golang", &protocol.OptionalChannelArgs{}))
+		require.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		assert.Equal(t, "Slow mode has been turned off", notice.OptionalChannelArgs.Notice)
+		assert.Zero(t, m.chMap["golang"].SlowMode)
+	})
+}
+
+func TestChannelInvites(t *testing.T) {
+	t.Run("should let invited users in once without the password", func(t *testing.T) {
+		m := newTestManager(t, filepath.Join(t.TempDir(), "channels_test.db"))
+		defer m.Close()
+
+		m.Handle(channelPayload(protocol.CreateChannel, "oz", "secret-club", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPrivate}))
+		res, _ := m.Handle(channelPayload(protocol.InviteUser, "john", "secret-club", &protocol.OptionalChannelArgs{TargetUser: "john"}))
+		assert.Equal(t, "Not a channel owner or moderator.", res.OptionalChannelArgs.Reason)
+
+		res, _ = m.Handle(channelPayload(protocol.InviteUser, "oz", "secret-club", &protocol.OptionalChannelArgs{TargetUser: "john"}))
+		require.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		assert.Equal(t, "john", res.OptionalChannelArgs.TargetUser)
+		assert.Equal(t, 24*time.Hour, res.OptionalChannelArgs.Duration)
+		code := res.OptionalChannelArgs.InviteCode
+		require.NotEmpty(t, code)
+
+		accept := func(user, code string) protocol.ChannelPayload {
+			payload := channelPayload(protocol.AcceptInvite, user, "secret-club", &protocol.OptionalChannelArgs{InviteCode: code})
+			payload.ChannelPayload.ChannelPassword = ""
+			res, _ := m.Handle(payload)
+			return res
+		}
+		// Bound to john, so nobody else can use it
+		assert.Equal(t, "Invite code is invalid or has expired.", accept("jane", code).OptionalChannelArgs.Reason)
+		res = accept("john", code)
+		assert.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		assert.Equal(t, protocol.JoinChannel, res.ChannelAction)
+		assert.True(t, m.chMap["secret-club"].Users["john"])
+
+		m.Handle(channelPayload(protocol.LeaveChannel, "john", "secret-club", &protocol.OptionalChannelArgs{}))
+		assert.Equal(t, protocol.StatusFail, accept("john", code).OptionalChannelArgs.Status)
+	})
+
+	t.Run("should expire open invite codes and keep them across restarts", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "channels_test.db")
+		m := newTestManager(t, dbPath)
+
+		m.Handle(channelPayload(protocol.CreateChannel, "admin", "lobby", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPrivate, Permanent: true}))
+		res, _ := m.Handle(channelPayload(protocol.InviteUser, "admin", "lobby", &protocol.OptionalChannelArgs{TargetUser: protocol.EmptyChannelField, Duration: 8 * 24 * time.Hour}))
+		assert.Equal(t, "Invite expiry must be at most 7 days.", res.OptionalChannelArgs.Reason)
+		res, _ = m.Handle(channelPayload(protocol.InviteUser, "admin", "lobby", &protocol.OptionalChannelArgs{TargetUser: protocol.EmptyChannelField, Duration: time.Hour}))
+		require.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		code := res.OptionalChannelArgs.InviteCode
+		require.NoError(t, m.Close())
+
+		m = newTestManager(t, dbPath)
+		defer m.Close()
+		invite, exists := m.chMap["lobby"].invites[code]
+		require.True(t, exists)
+		assert.Empty(t, invite.Username)
+
+		invite.ExpiresAt = time.Now().Add(-time.Second)
+		m.chMap["lobby"].invites[code] = invite
+		res, _ = m.Handle(channelPayload(protocol.AcceptInvite, "jane", "lobby", &protocol.OptionalChannelArgs{InviteCode: code}))
+		assert.Equal(t, "Invite code is invalid or has expired.", res.OptionalChannelArgs.Reason)
+
+		m.removeExpiredInvites()
+		assert.Empty(t, m.chMap["lobby"].invites)
+	})
+}
+
+func TestChannelMetadata(t *testing.T) {
+	t.Run("should let moderators set topic and description and tell members", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "channels_test.db")
+		m := newTestManager(t, dbPath)
+
+		m.Handle(channelPayload(protocol.CreateChannel, "oz", "golang", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic}))
+		m.Handle(channelPayload(protocol.JoinChannel, "john", "golang", &protocol.OptionalChannelArgs{}))
+
+		res, _ := m.Handle(channelPayload(protocol.SetTopic, "john", "golang", &protocol.OptionalChannelArgs{Topic: "Generics"}))
+		assert.Equal(t, "Not a channel owner or moderator.", res.OptionalChannelArgs.Reason)
+		res, _ = m.Handle(channelPayload(protocol.SetTopic, "oz", "golang", &protocol.OptionalChannelArgs{Topic: "  "}))
+		assert.Equal(t, "Topic cannot be empty.", res.OptionalChannelArgs.Reason)
+
+		res, notice := m.Handle(channelPayload(protocol.SetTopic, "oz", "golang", &protocol.OptionalChannelArgs{Topic: "Generics"}))
+		require.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		assert.Equal(t, protocol.NoticeChannel, notice.ChannelAction)
+		assert.Equal(t, "Generics", notice.OptionalChannelArgs.Topic)
+		assert.Equal(t, "'oz' changed the topic to 'Generics'", notice.OptionalChannelArgs.Notice)
+
+		_, notice = m.Handle(channelPayload(protocol.SetTopic, "oz", "golang", &protocol.OptionalChannelArgs{Topic: "Generics; iterators|range"}))
+		assert.Equal(t, "Generics; iterators|range", notice.OptionalChannelArgs.Topic)
+		assert.Equal(t, "'oz' changed the topic to 'Generics, iterators/range'", notice.OptionalChannelArgs.Notice)
+
+		m.Handle(channelPayload(protocol.OpUser, "oz", "golang", &protocol.OptionalChannelArgs{TargetUser: "john"}))
+		res, _ = m.Handle(channelPayload(protocol.SetDescription, "john", "golang", &protocol.OptionalChannelArgs{Description: "All about Go"}))
+		require.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		require.NoError(t, m.Close())
+
+		m = newTestManager(t, dbPath)
+		defer m.Close()
+		res, _ = m.Handle(channelPayload(protocol.JoinChannel, "jane", "golang", &protocol.OptionalChannelArgs{}))
+		assert.Equal(t, "Generics; iterators|range", res.OptionalChannelArgs.Topic)
+		assert.Equal(t, "All about Go", res.OptionalChannelArgs.Description)
+		assert.Equal(t, m.chMap["golang"].CreatedAt, res.OptionalChannelArgs.CreatedAt)
+	})
+
+	t.Run("should list public channels with their summaries", func(t *testing.T) {
+		m := newTestManager(t, filepath.Join(t.TempDir(), "channels_test.db"))
+		defer m.Close()
+
+		m.Handle(channelPayload(protocol.CreateChannel, "oz", "rust", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic}))
+		m.Handle(channelPayload(protocol.CreateChannel, "oz", "golang", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic}))
+		m.Handle(channelPayload(protocol.CreateChannel, "oz", "hideout", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPrivate}))
+		m.Handle(channelPayload(protocol.SetTopic, "oz", "golang", &protocol.OptionalChannelArgs{Topic: "Generics"}))
+
+		res, _ := m.Handle(channelPayload(protocol.GetChannels, "john", "", nil))
+		require.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		assert.Equal(t, []string{"golang", "rust"}, res.OptionalChannelArgs.Channels)
+		require.Len(t, res.OptionalChannelArgs.Summaries, 2)
+		golang := res.OptionalChannelArgs.Summaries[0]
+		assert.Equal(t, "golang", golang.Name)
+		assert.Equal(t, "Generics", golang.Topic)
+		assert.Equal(t, 1, golang.Members)
+		assert.Equal(t, 5, golang.Capacity)
+		assert.NotZero(t, golang.CreatedAt)
+	})
+}
+
+func TestChannelDirectory(t *testing.T) {
+	m := newTestManager(t, filepath.Join(t.TempDir(), "channels_test.db"))
+	defer m.Close()
+
+	list := func(args *protocol.OptionalChannelArgs) protocol.ChannelPayload {
+		res, _ := m.Handle(channelPayload(protocol.GetChannels, "john", "", args))
+		return res
+	}
+	res := list(nil)
+	assert.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+	assert.Empty(t, res.OptionalChannelArgs.Summaries)
+
+	m.Handle(channelPayload(protocol.CreateChannel, "oz", "golang", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic}))
+	m.Handle(channelPayload(protocol.CreateChannel, "jane", "gopher-talk", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic}))
+	m.Handle(channelPayload(protocol.JoinChannel, "joe", "gopher-talk", &protocol.OptionalChannelArgs{}))
+	open := channelPayload(protocol.CreateChannel, "bob", "rust", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic})
+	open.ChannelPayload.ChannelPassword = ""
+	m.Handle(open)
+	m.chMap["rust"].LastActivity = time.Now().Add(time.Minute).Unix()
+
+	res = list(&protocol.OptionalChannelArgs{Filter: "GO"})
+	require.Len(t, res.OptionalChannelArgs.Summaries, 2)
+	assert.Equal(t, []string{"golang", "gopher-talk"}, res.OptionalChannelArgs.Channels)
+	golang := res.OptionalChannelArgs.Summaries[0]
+	assert.Equal(t, "oz", golang.Owner)
+	assert.True(t, golang.Protected)
+	assert.NotZero(t, golang.LastActivity)
+
+	res = list(&protocol.OptionalChannelArgs{Sort: protocol.SortByMembers})
+	assert.Equal(t, []string{"gopher-talk", "golang", "rust"}, res.OptionalChannelArgs.Channels)
+	res = list(&protocol.OptionalChannelArgs{Sort: protocol.SortByActivity})
+	assert.Equal(t, "rust", res.OptionalChannelArgs.Channels[0])
+	assert.False(t, res.OptionalChannelArgs.Summaries[0].Protected)
+
+	res = list(&protocol.OptionalChannelArgs{Sort: "size"})
+	assert.Equal(t, "Sort must be one of name, members, activity or created.", res.OptionalChannelArgs.Reason)
+}
+
+// recordingConn keeps what the manager writes to a member outside of a response
+type recordingConn struct {
+	net.Conn
+	written bytes.Buffer
+}
+
+func (c *recordingConn) Write(b []byte) (int, error) {
+	return c.written.Write(b)
+}
+
+func TestChannelLifecycle(t *testing.T) {
+	t.Run("should create a public channel with the default idle timeout without optional args", func(t *testing.T) {
+		m := newTestManager(t, filepath.Join(t.TempDir(), "channels_test.db"))
+		defer m.Close()
+
+		res, _ := m.Handle(channelPayload(protocol.CreateChannel, "oz", "golang", nil))
+		require.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		assert.Equal(t, defaultIdleTimeout, res.OptionalChannelArgs.IdleTimeout)
+		assert.Equal(t, string(protocol.VisibilityPublic), m.chMap["golang"].Visibility)
+	})
+
+	t.Run("should warn members once before closing an idle channel", func(t *testing.T) {
+		m := newTestManager(t, filepath.Join(t.TempDir(), "channels_test.db"))
+		defer m.Close()
+		conn := &recordingConn{}
+		m.cm.AddConnection(conn, &connection.ConnectionInfo{Connection: conn, OwnerName: "oz"})
+
+		res, _ := m.Handle(channelPayload(protocol.CreateChannel, "oz", "golang", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic, IdleTimeout: time.Minute}))
+		assert.Equal(t, "Idle timeout must be between 5m and 168h.", res.OptionalChannelArgs.Reason)
+		res, _ = m.Handle(channelPayload(protocol.CreateChannel, "oz", "golang", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic, IdleTimeout: 5 * time.Minute}))
+		require.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		assert.Equal(t, 5*time.Minute, res.OptionalChannelArgs.IdleTimeout)
+
+		m.chMap["golang"].LastActivity = time.Now().Add(-4 * time.Minute).Unix()
+		m.checkInactiveChannel()
+		assert.Contains(t, conn.written.String(), "Channel will be closed in 1m0s due to inactivity")
+		conn.written.Reset()
+		m.checkInactiveChannel()
+		assert.Empty(t, conn.written.String())
+
+		// Typing counts as activity
+		m.Handle(channelPayload(protocol.TypingChannel, "oz", "golang", &protocol.OptionalChannelArgs{}))
+		assert.WithinDuration(t, time.Now(), time.Unix(m.chMap["golang"].LastActivity, 0), time.Second)
+
+		m.chMap["golang"].LastActivity = time.Now().Add(-6 * time.Minute).Unix()
+		m.checkInactiveChannel()
+		assert.NotContains(t, m.chMap, "golang")
+		assert.Contains(t, conn.written.String(), "Channel 'golang' closed due to inactivity.")
+	})
+
+	t.Run("should archive idle channels and reopen them on join", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "channels_test.db")
+		m := newTestManager(t, dbPath)
+
+		res, _ := m.Handle(channelPayload(protocol.CreateChannel, "oz", "golang", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic, Archive: true, IdleTimeout: time.Hour}))
+		require.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		assert.True(t, res.OptionalChannelArgs.Archive)
+		m.Handle(channelPayload(protocol.JoinChannel, "john", "golang", &protocol.OptionalChannelArgs{}))
+		m.chMap["golang"].LastActivity = time.Now().Add(-2 * time.Hour).Unix()
+		m.checkInactiveChannel()
+		require.Contains(t, m.chMap, "golang")
+		assert.True(t, m.chMap["golang"].Archived)
+		assert.Empty(t, m.chMap["golang"].Users)
+		require.NoError(t, m.Close())
+
+		m = newTestManager(t, dbPath)
+		defer m.Close()
+		channel := m.chMap["golang"]
+		require.NotNil(t, channel)
+		assert.True(t, channel.Archived)
+		assert.Equal(t, time.Hour, channel.IdleTimeout)
+		_, restored := m.RestoreMember("golang", "john")
+		assert.False(t, restored)
+
+		res, _ = m.Handle(channelPayload(protocol.JoinChannel, "john", "golang", &protocol.OptionalChannelArgs{}))
+		require.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		assert.False(t, channel.Archived)
+		assert.Equal(t, "oz", channel.Owner)
+
+		// Archived again once the last member leaves, instead of being deleted
+		m.Handle(channelPayload(protocol.LeaveChannel, "john", "golang", &protocol.OptionalChannelArgs{}))
+		require.Contains(t, m.chMap, "golang")
+		assert.True(t, channel.Archived)
+	})
+}
+
+func TestChannelWaitlist(t *testing.T) {
+	resize := func(requester string, size int) protocol.Payload {
+		payload := channelPayload(protocol.ResizeChannel, requester, "golang", &protocol.OptionalChannelArgs{})
+		payload.ChannelPayload.ChannelSize = size
+		return payload
+	}
+
+	t.Run("should queue joins of a full channel and admit them in order", func(t *testing.T) {
+		m := newTestManager(t, filepath.Join(t.TempDir(), "channels_test.db"))
+		defer m.Close()
+		for _, user := range []string{"john", "jane"} {
+			conn := &recordingConn{}
+			m.cm.AddConnection(conn, &connection.ConnectionInfo{Connection: conn, OwnerName: user})
+		}
+
+		res, _ := m.Handle(channelPayload(protocol.CreateChannel, "oz", "golang", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic, Waitlist: true}))
+		require.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		assert.True(t, res.OptionalChannelArgs.Waitlist)
+		for _, user := range []string{"a", "b", "c", "d"} {
+			m.Handle(channelPayload(protocol.JoinChannel, user, "golang", &protocol.OptionalChannelArgs{}))
+		}
+
+		res, _ = m.Handle(channelPayload(protocol.JoinChannel, "joe", "golang", &protocol.OptionalChannelArgs{}))
+		assert.Equal(t, protocol.WaitlistChannel, res.ChannelAction)
+		assert.Contains(t, res.OptionalChannelArgs.Notice, "you're number 1 on the waitlist")
+		res, _ = m.Handle(channelPayload(protocol.JoinChannel, "john", "golang", &protocol.OptionalChannelArgs{}))
+		assert.Contains(t, res.OptionalChannelArgs.Notice, "you're number 2 on the waitlist")
+		res, _ = m.Handle(channelPayload(protocol.JoinChannel, "jane", "golang", &protocol.OptionalChannelArgs{}))
+		assert.Contains(t, res.OptionalChannelArgs.Notice, "you're number 3 on the waitlist")
+		assert.Len(t, m.chMap["golang"].Users, 5)
+
+		// Leaving gives up the place in the queue
+		m.Handle(channelPayload(protocol.LeaveChannel, "jane", "golang", &protocol.OptionalChannelArgs{}))
+		assert.Equal(t, []string{"joe", "john"}, m.chMap["golang"].waitlist)
+
+		// Only john and jane have a connection in this test, so joe loses the place and the seat goes to john
+		m.Handle(channelPayload(protocol.LeaveChannel, "a", "golang", &protocol.OptionalChannelArgs{}))
+		admissions := m.AdmitWaitlisted("golang")
+		require.Len(t, admissions, 1)
+		assert.Equal(t, "john", admissions[0].Join.Requester)
+		assert.Equal(t, protocol.StatusSuccess, admissions[0].Join.OptionalChannelArgs.Status)
+		assert.Contains(t, admissions[0].Notice.OptionalChannelArgs.Notice, "'john' has joined the channel")
+		assert.True(t, m.chMap["golang"].Users["john"])
+		assert.Empty(t, m.chMap["golang"].waitlist)
+
+		// Members joining again keep their seat instead of being queued
+		res, _ = m.Handle(channelPayload(protocol.JoinChannel, "john", "golang", &protocol.OptionalChannelArgs{}))
+		assert.Equal(t, protocol.JoinChannel, res.ChannelAction)
+		assert.Equal(t, "You are already in the channel.", res.OptionalChannelArgs.Reason)
+		assert.Empty(t, m.chMap["golang"].waitlist)
+	})
+
+	t.Run("should keep banned users queued until their ban runs out", func(t *testing.T) {
+		m := newTestManager(t, filepath.Join(t.TempDir(), "channels_test.db"))
+		defer m.Close()
+		conn := &recordingConn{}
+		m.cm.AddConnection(conn, &connection.ConnectionInfo{Connection: conn, OwnerName: "john"})
+		var freed []string
+		m.OnSeatsFreed(func(chName string) { freed = append(freed, chName) })
+
+		m.Handle(channelPayload(protocol.CreateChannel, "oz", "golang", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic, Waitlist: true}))
+		for _, user := range []string{"a", "b", "c", "d"} {
+			m.Handle(channelPayload(protocol.JoinChannel, user, "golang", &protocol.OptionalChannelArgs{}))
+		}
+		m.Handle(channelPayload(protocol.JoinChannel, "john", "golang", &protocol.OptionalChannelArgs{}))
+		m.Handle(channelPayload(protocol.BanUser, "oz", "golang", &protocol.OptionalChannelArgs{TargetUser: "john", Duration: time.Hour}))
+		m.Handle(channelPayload(protocol.LeaveChannel, "a", "golang", &protocol.OptionalChannelArgs{}))
+
+		assert.Empty(t, m.AdmitWaitlisted("golang"))
+		assert.Equal(t, []string{"john"}, m.chMap["golang"].waitlist)
+
+		// Once the ban ran out the background checker hands the channel over to be admitted
+		m.chMap["golang"].BannedUsers["john"] = ChannelBan{ExpiresAt: time.Now().Add(-time.Minute)}
+		m.removeExpiredBans()
+		m.notifySeatsFreed()
+		assert.Equal(t, []string{"golang"}, freed)
+		admissions := m.AdmitWaitlisted("golang")
+		require.Len(t, admissions, 1)
+		assert.Equal(t, "john", admissions[0].Join.Requester)
+	})
+
+	t.Run("should give up the seat of a disconnected member to the waitlist", func(t *testing.T) {
+		m := newTestManager(t, filepath.Join(t.TempDir(), "channels_test.db"))
+		defer m.Close()
+		conn := &recordingConn{}
+		m.cm.AddConnection(conn, &connection.ConnectionInfo{Connection: conn, OwnerName: "john"})
+
+		m.Handle(channelPayload(protocol.CreateChannel, "oz", "golang", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic, Waitlist: true}))
+		m.Handle(channelPayload(protocol.CreateChannel, "oz", "rust", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic}))
+		for _, user := range []string{"a", "b", "c", "d"} {
+			m.Handle(channelPayload(protocol.JoinChannel, user, "golang", &protocol.OptionalChannelArgs{}))
+		}
+		m.Handle(channelPayload(protocol.JoinChannel, "john", "golang", &protocol.OptionalChannelArgs{}))
+
+		// Nobody waits for rust, so the seat there is kept for a session restore
+		notices := m.MemberDisconnected("a")
+		require.Len(t, notices, 1)
+		assert.Equal(t, "'a' lost connection and gave up their seat", notices[0].OptionalChannelArgs.Notice)
+		assert.False(t, m.chMap["golang"].Users["a"])
+		admissions := m.AdmitWaitlisted("golang")
+		require.Len(t, admissions, 1)
+		assert.Equal(t, "john", admissions[0].Join.Requester)
+
+		// The seat is taken, so a restored session can't get it back
+		_, restored := m.RestoreMember("golang", "a")
+		assert.False(t, restored)
+	})
+
+	t.Run("should reject joins of a full channel without a waitlist", func(t *testing.T) {
+		m := newTestManager(t, filepath.Join(t.TempDir(), "channels_test.db"))
+		defer m.Close()
+
+		m.Handle(channelPayload(protocol.CreateChannel, "oz", "golang", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic}))
+		for _, user := range []string{"a", "b", "c", "d"} {
+			m.Handle(channelPayload(protocol.JoinChannel, user, "golang", &protocol.OptionalChannelArgs{}))
+		}
+		res, _ := m.Handle(channelPayload(protocol.JoinChannel, "joe", "golang", &protocol.OptionalChannelArgs{}))
+		assert.Equal(t, protocol.JoinChannel, res.ChannelAction)
+		assert.Equal(t, protocol.StatusFail, res.OptionalChannelArgs.Status)
+		assert.Empty(t, m.chMap["golang"].waitlist)
+	})
+
+	t.Run("should let only the owner resize and keep room for current members", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "channels_test.db")
+		m := newTestManager(t, dbPath)
+
+		m.Handle(channelPayload(protocol.CreateChannel, "oz", "golang", &protocol.OptionalChannelArgs{Visibility: protocol.VisibilityPublic}))
+		m.Handle(channelPayload(protocol.JoinChannel, "john", "golang", &protocol.OptionalChannelArgs{}))
+
+		res, _ := m.Handle(resize("john", 10))
+		assert.Equal(t, "Not a channel owner.", res.OptionalChannelArgs.Reason)
+		res, _ = m.Handle(resize("oz", 1))
+		assert.Equal(t, "Channel size must be at least 2, the number of members.", res.OptionalChannelArgs.Reason)
+		res, notice := m.Handle(resize("oz", 10))
+		require.Equal(t, protocol.StatusSuccess, res.OptionalChannelArgs.Status)
+		assert.Equal(t, "Channel size changed to 10", notice.OptionalChannelArgs.Notice)
+		require.NoError(t, m.Close())
+
+		m = newTestManager(t, dbPath)
+		defer m.Close()
+		assert.Equal(t, 10, m.chMap["golang"].ChCapacity)
+	})
+}
@@ -0,0 +1,161 @@
+package channels
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/ogzhanolguncu/go-chat/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultInviteTTL    = 24 * time.Hour
+	maxInviteTTL        = 7 * 24 * time.Hour
+	invalidInvite       = "Invite code is invalid or has expired."
+	invalidInviteExpiry = "Invite expiry must be at most 7 days."
+	inviteeBanned       = "User is banned from the channel."
+	inviteeAlreadyIn    = "User is already in the channel."
+	inviteNotCreated    = "Invite could not be created."
+)
+
+// channelInvite lets someone in without the password, once, until it expires
+type channelInvite struct {
+	Username  string // Empty when anyone holding the code can use it
+	CreatedBy string
+	ExpiresAt time.Time
+}
+
+func (i channelInvite) usableBy(username string, now time.Time) bool {
+	return now.Before(i.ExpiresAt) && (i.Username == "" || i.Username == username)
+}
+
+func newInviteCode() (string, error) {
+	codeBytes := make([]byte, 8)
+	if _, err := rand.Read(codeBytes); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(codeBytes), nil
+}
+
+// inviteUser creates a single-use code, bound to the target user if one is given
+func (m *Manager) inviteUser(chPayload protocol.ChannelPayload) protocol.ChannelPayload {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	channel := m.chMap[chPayload.ChannelName]
+	if channel == nil {
+		logger.WithField("channel", chPayload.ChannelName).Warn("Channel does not exist")
+		return failChannelAction(chPayload, chDoesNotExist)
+	}
+	if !channel.canModerate(chPayload.Requester) {
+		return failChannelAction(chPayload, notChannelModerator)
+	}
+
+	var target string
+	ttl := defaultInviteTTL
+	if chPayload.OptionalChannelArgs != nil {
+		if chPayload.OptionalChannelArgs.TargetUser != protocol.EmptyChannelField {
+			target = chPayload.OptionalChannelArgs.TargetUser
+		}
+		if chPayload.OptionalChannelArgs.Duration != 0 {
+			ttl = chPayload.OptionalChannelArgs.Duration
+		}
+	}
+	if ttl < 0 || ttl > maxInviteTTL {
+		return failChannelAction(chPayload, invalidInviteExpiry)
+	}
+	if target != "" && channel.isBanned(target) {
+		return failChannelAction(chPayload, inviteeBanned)
+	}
+	if target != "" && channel.Users[target] {
+		return failChannelAction(chPayload, inviteeAlreadyIn)
+	}
+
+	code, err := newInviteCode()
+	if err != nil {
+		logger.WithError(err).WithField("channel", channel.ChName).Error("Failed to generate invite code")
+		return failChannelAction(chPayload, inviteNotCreated)
+	}
+	invite := channelInvite{Username: target, CreatedBy: chPayload.Requester, ExpiresAt: time.Now().Add(ttl)}
+	if err := m.storeInvite(channel.ChName, code, invite); err != nil {
+		logger.WithError(err).WithField("channel", channel.ChName).Error("Failed to store channel invite")
+		return failChannelAction(chPayload, inviteNotCreated)
+	}
+	channel.invites[code] = invite
+
+	logger.WithFields(logrus.Fields{
+		"channel": channel.ChName,
+		"user":    target,
+	}).Info("Channel invite created")
+
+	chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
+		Status:     protocol.StatusSuccess,
+		TargetUser: target,
+		InviteCode: code,
+		Duration:   ttl,
+	}
+	return chPayload
+}
+
+// acceptInvite joins the channel with an invite code instead of the password.
+// The response is a JoinChannel one, so clients treat it like any other join.
+func (m *Manager) acceptInvite(chPayload protocol.ChannelPayload) (protocol.ChannelPayload, protocol.ChannelPayload) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	chPayload.ChannelAction = protocol.JoinChannel
+	channel := m.chMap[chPayload.ChannelName]
+	if channel == nil {
+		logger.WithField("channel", chPayload.ChannelName).Warn("Channel does not exist")
+		return failChannelAction(chPayload, chDoesNotExist), protocol.ChannelPayload{}
+	}
+	if reason, banned := channel.banReason(chPayload.Requester); banned {
+		return failChannelAction(chPayload, reason), protocol.ChannelPayload{}
+	}
+
+	var code string
+	if chPayload.OptionalChannelArgs != nil {
+		code = chPayload.OptionalChannelArgs.InviteCode
+	}
+	invite, exists := channel.invites[code]
+	if !exists || !invite.usableBy(chPayload.Requester, time.Now()) {
+		logger.WithFields(logrus.Fields{
+			"channel": chPayload.ChannelName,
+			"user":    chPayload.Requester,
+		}).Warn("Invalid channel invite")
+		return failChannelAction(chPayload, invalidInvite), protocol.ChannelPayload{}
+	}
+
+	res, notice := m.admitMember(channel, chPayload)
+	if res.OptionalChannelArgs.Status == protocol.StatusSuccess {
+		m.removeInvite(channel, code)
+	}
+	return res, notice
+}
+
+func (m *Manager) removeInvite(channel *ChannelDetails, code string) {
+	delete(channel.invites, code)
+	if err := m.deleteInvite(code); err != nil {
+		logger.WithError(err).WithField("channel", channel.ChName).Error("Failed to delete channel invite")
+	}
+}
+
+func (m *Manager) removeExpiredInvites() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	for _, channel := range m.chMap {
+		for code, invite := range channel.invites {
+			if now.Before(invite.ExpiresAt) {
+				continue
+			}
+			m.removeInvite(channel, code)
+		}
+	}
+	// Invites that had expired by startup were never loaded, so they are only in the database
+	if err := m.deleteExpiredInvites(now); err != nil {
+		logger.WithError(err).Error("Failed to delete expired channel invites")
+	}
+}
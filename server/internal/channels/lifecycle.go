@@ -0,0 +1,71 @@
+package channels
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ogzhanolguncu/go-chat/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultIdleTimeout = 10 * time.Minute
+	minIdleTimeout     = 5 * time.Minute
+	maxIdleTimeout     = 7 * 24 * time.Hour
+	// Inactive channels are checked every minute, so members get at least one warning within this window
+	idleWarningLead = 2 * time.Minute
+)
+
+func (ch *ChannelDetails) idleTimeout() time.Duration {
+	if ch.IdleTimeout == 0 {
+		return defaultIdleTimeout
+	}
+	return ch.IdleTimeout
+}
+
+func (m *Manager) sendIdleWarningToChannelUsers(ch *ChannelDetails, remaining time.Duration) {
+	outcome := "closed"
+	if ch.ArchiveOnIdle {
+		outcome = "archived"
+	}
+	m.writeToChannelUsers(ch, protocol.Payload{
+		MessageType: protocol.MessageTypeCH,
+		ChannelPayload: &protocol.ChannelPayload{
+			ChannelAction: protocol.NoticeChannel,
+			ChannelName:   ch.ChName,
+			OptionalChannelArgs: &protocol.OptionalChannelArgs{
+				Status: protocol.StatusSuccess,
+				Notice: fmt.Sprintf("Channel will be %s in %s due to inactivity, send a message to keep it open",
+					outcome, max(remaining.Round(time.Minute), time.Minute)),
+				Users: getUsersInChannnel(ch),
+			},
+		},
+	})
+	logger.WithField("channel", ch.ChName).Info("Warned channel members about inactivity")
+}
+
+// archiveChannel empties the channel but keeps it with its settings, bans and history.
+// Joining it again reopens it.
+func (m *Manager) archiveChannel(ch *ChannelDetails) {
+	if err := m.storeArchived(ch.ChName, true); err != nil {
+		logger.WithError(err).WithField("channel", ch.ChName).Error("Failed to store archived channel")
+	}
+	ch.Archived = true
+	ch.Users = map[string]bool{}
+	ch.joinedAt = make(map[string]time.Time)
+	ch.typingIndicators = make(map[string]time.Time)
+	ch.lastMessageAt = make(map[string]time.Time)
+	ch.waitlist = nil
+	logger.WithFields(logrus.Fields{
+		"channel": ch.ChName,
+		"owner":   ch.Owner,
+	}).Info("Channel archived")
+}
+
+func (m *Manager) reopenChannel(ch *ChannelDetails) {
+	if err := m.storeArchived(ch.ChName, false); err != nil {
+		logger.WithError(err).WithField("channel", ch.ChName).Error("Failed to store reopened channel")
+	}
+	ch.Archived = false
+	logger.WithField("channel", ch.ChName).Info("Archived channel reopened")
+}
@@ -0,0 +1,109 @@
+package channels
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ogzhanolguncu/go-chat/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	maxTopicLength       = 120
+	maxDescriptionLength = 500
+	emptyTopic           = "Topic cannot be empty."
+	emptyDescription     = "Description cannot be empty."
+	topicTooLong         = "Topic can be at most 120 characters."
+	descriptionTooLong   = "Description can be at most 500 characters."
+)
+
+// summarySorters tell whether a comes before b in the channel directory, ties are broken by name
+var summarySorters = map[protocol.ChannelSort]func(a, b protocol.ChannelSummary) bool{
+	protocol.SortByName:     func(a, b protocol.ChannelSummary) bool { return a.Name < b.Name },
+	protocol.SortByMembers:  func(a, b protocol.ChannelSummary) bool { return a.Members > b.Members },
+	protocol.SortByActivity: func(a, b protocol.ChannelSummary) bool { return a.LastActivity > b.LastActivity },
+	protocol.SortByCreated:  func(a, b protocol.ChannelSummary) bool { return a.CreatedAt > b.CreatedAt },
+}
+
+func (ch *ChannelDetails) summary() protocol.ChannelSummary {
+	return protocol.ChannelSummary{
+		Name:         ch.ChName,
+		Topic:        ch.Topic,
+		Description:  ch.Description,
+		Members:      len(ch.Users),
+		Capacity:     ch.ChCapacity,
+		CreatedAt:    ch.CreatedAt,
+		Owner:        ch.Owner,
+		Protected:    ch.ChPass != "",
+		LastActivity: ch.LastActivity,
+	}
+}
+
+// setMetadata changes the topic or the description, members are told through a notice
+// that carries the new topic so their header can follow it
+func (m *Manager) setMetadata(chPayload protocol.ChannelPayload) (protocol.ChannelPayload, protocol.ChannelPayload) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	channel := m.chMap[chPayload.ChannelName]
+	if channel == nil {
+		logger.WithField("channel", chPayload.ChannelName).Warn("Channel does not exist")
+		return failChannelAction(chPayload, chDoesNotExist), protocol.ChannelPayload{}
+	}
+	if !channel.canModerate(chPayload.Requester) {
+		return failChannelAction(chPayload, notChannelModerator), protocol.ChannelPayload{}
+	}
+
+	args := chPayload.OptionalChannelArgs
+	if args == nil {
+		args = &protocol.OptionalChannelArgs{}
+	}
+	topic, description := channel.Topic, channel.Description
+	var notice string
+	if chPayload.ChannelAction == protocol.SetTopic {
+		topic = strings.TrimSpace(args.Topic)
+		switch {
+		case topic == "":
+			return failChannelAction(chPayload, emptyTopic), protocol.ChannelPayload{}
+		case len([]rune(topic)) > maxTopicLength:
+			return failChannelAction(chPayload, topicTooLong), protocol.ChannelPayload{}
+		}
+		notice = fmt.Sprintf("'%s' changed the topic to '%s'", chPayload.Requester, noticeText(topic))
+	} else {
+		description = strings.TrimSpace(args.Description)
+		switch {
+		case description == "":
+			return failChannelAction(chPayload, emptyDescription), protocol.ChannelPayload{}
+		case len([]rune(description)) > maxDescriptionLength:
+			return failChannelAction(chPayload, descriptionTooLong), protocol.ChannelPayload{}
+		}
+		notice = fmt.Sprintf("'%s' changed the description to '%s'", chPayload.Requester, noticeText(description))
+	}
+
+	if err := m.storeMetadata(channel.ChName, topic, description); err != nil {
+		logger.WithError(err).WithField("channel", channel.ChName).Error("Failed to store channel metadata")
+		return failChannelAction(chPayload, chCouldNotBeUpdated), protocol.ChannelPayload{}
+	}
+	channel.Topic, channel.Description = topic, description
+	channel.LastActivity = time.Now().Unix()
+
+	logger.WithFields(logrus.Fields{
+		"channel": channel.ChName,
+		"user":    chPayload.Requester,
+	}).Info("Channel metadata changed")
+
+	chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
+		Status:      protocol.StatusSuccess,
+		Topic:       channel.Topic,
+		Description: channel.Description,
+	}
+	chNoticePayload := m.prepareNoticePayload(chPayload, channel, notice)
+	chNoticePayload.OptionalChannelArgs.Topic = channel.Topic
+	return chPayload, chNoticePayload
+}
+
+// noticeText keeps free text quoted in a notice from breaking the optional args it's sent in
+func noticeText(text string) string {
+	return strings.NewReplacer(";", ",", "|", "/").Replace(text)
+}
@@ -0,0 +1,224 @@
+package channels
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ogzhanolguncu/go-chat/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	targetNotInTheCh       = "User is not in the channel."
+	targetNotModerator     = "User is not a moderator."
+	targetAlreadyModerator = "User is already the owner or a moderator."
+)
+
+func (ch *ChannelDetails) canModerate(username string) bool {
+	return ch.Owner == username || ch.Moderators[username]
+}
+
+// removeMember drops a user from the channel, moderator status is kept so it's still there when they come back
+func (m *Manager) removeMember(channel *ChannelDetails, username string) {
+	delete(channel.Users, username)
+	delete(channel.joinedAt, username)
+	delete(channel.typingIndicators, username)
+}
+
+func (m *Manager) setModerator(channel *ChannelDetails, username string, moderator bool) {
+	if channel.Moderators[username] == moderator {
+		return
+	}
+	var err error
+	if moderator {
+		channel.Moderators[username] = true
+		err = m.storeModerator(channel.ChName, username)
+	} else {
+		delete(channel.Moderators, username)
+		err = m.deleteModerator(channel.ChName, username)
+	}
+	if err != nil {
+		logger.WithError(err).WithField("channel", channel.ChName).Error("Failed to store channel moderator")
+	}
+}
+
+func (m *Manager) setOwner(channel *ChannelDetails, username string) {
+	channel.Owner = username
+	// The owner already has every right of a moderator
+	m.setModerator(channel, username, false)
+	if err := m.storeOwner(channel.ChName, username); err != nil {
+		logger.WithError(err).WithField("channel", channel.ChName).Error("Failed to store channel owner")
+	}
+	logger.WithFields(logrus.Fields{
+		"channel": channel.ChName,
+		"owner":   username,
+	}).Info("Channel ownership changed")
+}
+
+// promoteLongestPresent hands the channel to the member that has been in it the longest.
+// Connected members go first, members whose connection dropped only when nobody else is left.
+func (m *Manager) promoteLongestPresent(channel *ChannelDetails) (string, bool) {
+	newOwner := m.longestPresent(channel, true)
+	if newOwner == "" {
+		newOwner = m.longestPresent(channel, false)
+	}
+	if newOwner == "" {
+		return "", false
+	}
+	m.setOwner(channel, newOwner)
+	return newOwner, true
+}
+
+// longestPresent finds the member besides the owner who joined first, ties go to the lower name
+func (m *Manager) longestPresent(channel *ChannelDetails, connectedOnly bool) string {
+	var found string
+	var earliest time.Time
+	for username := range channel.Users {
+		if username == channel.Owner || (connectedOnly && m.cm.CountConnectionsByOwnerName(username) == 0) {
+			continue
+		}
+		joinedAt := channel.joinedAt[username]
+		if found == "" || joinedAt.Before(earliest) || (joinedAt.Equal(earliest) && username < found) {
+			found, earliest = username, joinedAt
+		}
+	}
+	return found
+}
+
+// MemberDisconnected runs once the user's last connection dropped. They stay a member so the session can be restored,
+// unless people are waiting for a seat, then they give theirs up. Channels they own go to the longest present
+// connected member, nobody could moderate them otherwise.
+// Returns the notices for the members of each channel the user left or that changed hands.
+func (m *Manager) MemberDisconnected(username string) []protocol.ChannelPayload {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var notices []protocol.ChannelPayload
+	for _, channel := range m.chMap {
+		if !channel.Users[username] {
+			continue
+		}
+		notice := fmt.Sprintf("'%s' lost connection", username)
+		changed := false
+		if len(channel.waitlist) > 0 {
+			m.removeMember(channel, username)
+			notice += " and gave up their seat"
+			changed = true
+		}
+		if channel.Owner == username {
+			if newOwner := m.longestPresent(channel, true); newOwner != "" {
+				m.setOwner(channel, newOwner)
+				notice += fmt.Sprintf(", '%s' is now the owner", newOwner)
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		notices = append(notices, m.prepareNoticePayload(protocol.ChannelPayload{
+			ChannelName: channel.ChName,
+			Requester:   username,
+		}, channel, notice))
+	}
+	return notices
+}
+
+func (m *Manager) opUser(chPayload protocol.ChannelPayload) (protocol.ChannelPayload, protocol.ChannelPayload) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	channel := m.chMap[chPayload.ChannelName]
+	if checkPayload := m.ownerActionChecks(channel, chPayload); checkPayload != nil {
+		return *checkPayload, protocol.ChannelPayload{}
+	}
+	target := chPayload.OptionalChannelArgs.TargetUser
+	if !channel.Users[target] {
+		return failChannelAction(chPayload, targetNotInTheCh), protocol.ChannelPayload{}
+	}
+	if channel.canModerate(target) {
+		return failChannelAction(chPayload, targetAlreadyModerator), protocol.ChannelPayload{}
+	}
+
+	m.setModerator(channel, target, true)
+	channel.LastActivity = time.Now().Unix()
+	chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
+		Status:     protocol.StatusSuccess,
+		TargetUser: target,
+	}
+	chNoticePayload := m.prepareNoticePayload(chPayload, channel, fmt.Sprintf("'%s' is now a moderator", target))
+	return chPayload, chNoticePayload
+}
+
+func (m *Manager) deopUser(chPayload protocol.ChannelPayload) (protocol.ChannelPayload, protocol.ChannelPayload) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	channel := m.chMap[chPayload.ChannelName]
+	if checkPayload := m.ownerActionChecks(channel, chPayload); checkPayload != nil {
+		return *checkPayload, protocol.ChannelPayload{}
+	}
+	target := chPayload.OptionalChannelArgs.TargetUser
+	if !channel.Moderators[target] {
+		return failChannelAction(chPayload, targetNotModerator), protocol.ChannelPayload{}
+	}
+
+	m.setModerator(channel, target, false)
+	channel.LastActivity = time.Now().Unix()
+	chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
+		Status:     protocol.StatusSuccess,
+		TargetUser: target,
+	}
+	chNoticePayload := m.prepareNoticePayload(chPayload, channel, fmt.Sprintf("'%s' is no longer a moderator", target))
+	return chPayload, chNoticePayload
+}
+
+func (m *Manager) transferChannel(chPayload protocol.ChannelPayload) (protocol.ChannelPayload, protocol.ChannelPayload) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	channel := m.chMap[chPayload.ChannelName]
+	if checkPayload := m.ownerActionChecks(channel, chPayload); checkPayload != nil {
+		return *checkPayload, protocol.ChannelPayload{}
+	}
+	target := chPayload.OptionalChannelArgs.TargetUser
+	if !channel.Users[target] || target == channel.Owner {
+		return failChannelAction(chPayload, targetNotInTheCh), protocol.ChannelPayload{}
+	}
+
+	m.setOwner(channel, target)
+	channel.LastActivity = time.Now().Unix()
+	chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
+		Status:     protocol.StatusSuccess,
+		TargetUser: target,
+	}
+	chNoticePayload := m.prepareNoticePayload(chPayload, channel, fmt.Sprintf("'%s' is now the owner", target))
+	return chPayload, chNoticePayload
+}
+
+// ownerActionChecks validates op, deop and transfer which only the owner can do
+func (m *Manager) ownerActionChecks(channel *ChannelDetails, chPayload protocol.ChannelPayload) *protocol.ChannelPayload {
+	if channel == nil {
+		logger.WithField("channel", chPayload.ChannelName).Warn("Channel does not exist")
+		failed := failChannelAction(chPayload, chDoesNotExist)
+		return &failed
+	}
+	if channel.Owner != chPayload.Requester {
+		failed := failChannelAction(chPayload, notChannelOwner)
+		return &failed
+	}
+	if chPayload.OptionalChannelArgs == nil ||
+		chPayload.OptionalChannelArgs.TargetUser == "" ||
+		chPayload.OptionalChannelArgs.TargetUser == protocol.EmptyChannelField {
+		failed := failChannelAction(chPayload, emptyTargetUser)
+		return &failed
+	}
+	return nil
+}
+
+func failChannelAction(chPayload protocol.ChannelPayload, reason string) protocol.ChannelPayload {
+	chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
+		Status: protocol.StatusFail,
+		Reason: reason,
+	}
+	return chPayload
+}
@@ -0,0 +1,173 @@
+package channels
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/ogzhanolguncu/go-chat/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+const maxSlowMode = time.Hour
+
+// ChannelMute keeps a member from posting to a channel, they can still read it
+type ChannelMute struct {
+	MutedBy   string
+	MutedAt   time.Time
+	ExpiresAt time.Time // Zero time means the mute never expires
+}
+
+func (mu ChannelMute) active(now time.Time) bool {
+	return mu.ExpiresAt.IsZero() || now.Before(mu.ExpiresAt)
+}
+
+// postingRestriction tells a member why they can't post right now, owner and moderators aren't slowed down
+func (ch *ChannelDetails) postingRestriction(username string, now time.Time) (string, bool) {
+	if mute, muted := ch.MutedUsers[username]; muted && mute.active(now) {
+		if mute.ExpiresAt.IsZero() {
+			return mutedInChannel, true
+		}
+		return fmt.Sprintf(timedMuteInChannel, mute.ExpiresAt.Format("01-02 15:04")), true
+	}
+	if ch.SlowMode == 0 || ch.canModerate(username) {
+		return "", false
+	}
+	if wait := ch.SlowMode - now.Sub(ch.lastMessageAt[username]); wait > 0 {
+		return fmt.Sprintf(slowModeActive, ch.SlowMode, max(wait.Round(time.Second), time.Second)), true
+	}
+	return "", false
+}
+
+func (m *Manager) muteUser(chPayload protocol.ChannelPayload) (protocol.ChannelPayload, protocol.ChannelPayload) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	channel := m.chMap[chPayload.ChannelName]
+	if checkPayload := m.moderationChecks(channel, chPayload); checkPayload != nil {
+		return *checkPayload, protocol.ChannelPayload{}
+	}
+	target := chPayload.OptionalChannelArgs.TargetUser
+	duration := chPayload.OptionalChannelArgs.Duration
+	if duration < 0 {
+		return failChannelAction(chPayload, invalidMuteDuration), protocol.ChannelPayload{}
+	}
+
+	mute := ChannelMute{MutedBy: chPayload.Requester, MutedAt: time.Now()}
+	if duration > 0 {
+		mute.ExpiresAt = mute.MutedAt.Add(duration)
+	}
+	channel.MutedUsers[target] = mute
+	if err := m.storeMute(channel.ChName, target, mute); err != nil {
+		logger.WithError(err).WithField("channel", channel.ChName).Error("Failed to store channel mute")
+	}
+	channel.LastActivity = time.Now().Unix()
+	chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
+		Status:     protocol.StatusSuccess,
+		TargetUser: target,
+		Duration:   duration,
+	}
+
+	notice := fmt.Sprintf("'%s' has been muted", target)
+	if duration > 0 {
+		notice = fmt.Sprintf("'%s' has been muted for %s", target, duration)
+	}
+	chNoticePayload := m.prepareNoticePayload(chPayload, channel, notice)
+	return chPayload, chNoticePayload
+}
+
+func (m *Manager) unmuteUser(chPayload protocol.ChannelPayload) (protocol.ChannelPayload, protocol.ChannelPayload) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	channel := m.chMap[chPayload.ChannelName]
+	if checkPayload := m.banListChecks(channel, chPayload); checkPayload != nil {
+		return *checkPayload, protocol.ChannelPayload{}
+	}
+	if chPayload.OptionalChannelArgs == nil ||
+		chPayload.OptionalChannelArgs.TargetUser == "" ||
+		chPayload.OptionalChannelArgs.TargetUser == protocol.EmptyChannelField {
+		return failChannelAction(chPayload, emptyTargetUser), protocol.ChannelPayload{}
+	}
+	target := chPayload.OptionalChannelArgs.TargetUser
+	if mute, muted := channel.MutedUsers[target]; !muted || !mute.active(time.Now()) {
+		return failChannelAction(chPayload, userNotMuted), protocol.ChannelPayload{}
+	}
+
+	m.liftMute(channel, target)
+	channel.LastActivity = time.Now().Unix()
+	chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
+		Status:     protocol.StatusSuccess,
+		TargetUser: target,
+	}
+	chNoticePayload := m.prepareNoticePayload(chPayload, channel, fmt.Sprintf("'%s' has been unmuted", target))
+	return chPayload, chNoticePayload
+}
+
+// setSlowMode limits each member to one message per the given duration, zero turns it off
+func (m *Manager) setSlowMode(chPayload protocol.ChannelPayload) (protocol.ChannelPayload, protocol.ChannelPayload) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	channel := m.chMap[chPayload.ChannelName]
+	if checkPayload := m.banListChecks(channel, chPayload); checkPayload != nil {
+		return *checkPayload, protocol.ChannelPayload{}
+	}
+	var slowMode time.Duration
+	if chPayload.OptionalChannelArgs != nil {
+		slowMode = chPayload.OptionalChannelArgs.Duration
+	}
+	if slowMode != 0 && (slowMode < time.Second || slowMode > maxSlowMode) {
+		return failChannelAction(chPayload, invalidSlowMode), protocol.ChannelPayload{}
+	}
+	// Stored in whole seconds
+	slowMode = slowMode.Truncate(time.Second)
+
+	if err := m.storeSlowMode(channel.ChName, slowMode); err != nil {
+		logger.WithError(err).WithField("channel", channel.ChName).Error("Failed to store slow mode")
+		return failChannelAction(chPayload, chCouldNotBeUpdated), protocol.ChannelPayload{}
+	}
+	channel.SlowMode = slowMode
+	channel.LastActivity = time.Now().Unix()
+	logger.WithFields(logrus.Fields{
+		"channel":   channel.ChName,
+		"slow_mode": slowMode,
+	}).Info("Channel slow mode changed")
+
+	chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
+		Status:   protocol.StatusSuccess,
+		Duration: slowMode,
+	}
+	notice := "Slow mode has been turned off"
+	if slowMode > 0 {
+		notice = fmt.Sprintf("Slow mode is on, members can send one message every %s", slowMode)
+	}
+	chNoticePayload := m.prepareNoticePayload(chPayload, channel, notice)
+	return chPayload, chNoticePayload
+}
+
+func (m *Manager) liftMute(channel *ChannelDetails, username string) {
+	delete(channel.MutedUsers, username)
+	if err := m.deleteMute(channel.ChName, username); err != nil {
+		logger.WithError(err).WithField("channel", channel.ChName).Error("Failed to delete channel mute")
+	}
+}
+
+// removeExpiredMutes drops timed mutes that ran out, posting already ignores them
+func (m *Manager) removeExpiredMutes() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	now := time.Now()
+	for _, channel := range m.chMap {
+		for username, mute := range channel.MutedUsers {
+			if mute.active(now) {
+				continue
+			}
+			logger.WithFields(logrus.Fields{
+				"channel": channel.ChName,
+				"user":    username,
+			}).Info("Channel mute expired")
+			m.liftMute(channel, username)
+		}
+	}
+}
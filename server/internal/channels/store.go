@@ -0,0 +1,353 @@
+package channels
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Channels, their ban lists, mutes, moderators and invites are stored so they survive restarts.
+// Members, waitlists, typing indicators and slow mode timestamps are not, they only make sense for live connections.
+// Archived channels are kept without members instead of being deleted.
+
+type channelRow struct {
+	Name          string `db:"name"`
+	Password      string `db:"password"`
+	Capacity      int    `db:"capacity"`
+	Owner         string `db:"owner"`
+	Visibility    string `db:"visibility"`
+	Permanent     bool   `db:"permanent"`
+	CreatedAt     int64  `db:"created_at"`
+	Topic         string `db:"topic"`
+	Description   string `db:"description"`
+	SlowMode      int64  `db:"slow_mode"`    // Seconds
+	IdleTimeout   int64  `db:"idle_timeout"` // Seconds, zero uses the default
+	ArchiveOnIdle bool   `db:"archive_on_idle"`
+	Archived      bool   `db:"archived"`
+	Waitlist      bool   `db:"waitlist"`
+}
+
+func createSchema(db *sqlx.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS channels (
+			name TEXT PRIMARY KEY,
+			password TEXT NOT NULL DEFAULT '',
+			capacity INTEGER NOT NULL,
+			owner TEXT NOT NULL,
+			visibility TEXT NOT NULL,
+			permanent INTEGER NOT NULL DEFAULT 0,
+			created_at INTEGER NOT NULL,
+			topic TEXT NOT NULL DEFAULT '',
+			description TEXT NOT NULL DEFAULT '',
+			slow_mode INTEGER NOT NULL DEFAULT 0,
+			idle_timeout INTEGER NOT NULL DEFAULT 0,
+			archive_on_idle INTEGER NOT NULL DEFAULT 0,
+			archived INTEGER NOT NULL DEFAULT 0,
+			waitlist INTEGER NOT NULL DEFAULT 0
+		)`,
+		`CREATE TABLE IF NOT EXISTS channel_bans (
+			channel TEXT NOT NULL,
+			username TEXT NOT NULL,
+			banned_by TEXT NOT NULL DEFAULT '',
+			banned_at INTEGER NOT NULL DEFAULT 0,
+			expires_at INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (channel, username)
+		)`,
+		`CREATE TABLE IF NOT EXISTS channel_mutes (
+			channel TEXT NOT NULL,
+			username TEXT NOT NULL,
+			muted_by TEXT NOT NULL,
+			muted_at INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (channel, username)
+		)`,
+		`CREATE TABLE IF NOT EXISTS channel_invites (
+			code TEXT PRIMARY KEY,
+			channel TEXT NOT NULL,
+			username TEXT NOT NULL DEFAULT '',
+			created_by TEXT NOT NULL,
+			expires_at INTEGER NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS channel_moderators (
+			channel TEXT NOT NULL,
+			username TEXT NOT NULL,
+			PRIMARY KEY (channel, username)
+		)`,
+	}
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadChannels reads every stored channel along with its ban list
+func loadChannels(db *sqlx.DB) (map[string]*ChannelDetails, error) {
+	var rows []channelRow
+	if err := db.Select(&rows, "SELECT name, password, capacity, owner, visibility, permanent, created_at, topic, description, slow_mode, idle_timeout, archive_on_idle, archived, waitlist FROM channels"); err != nil {
+		return nil, fmt.Errorf("failed to query channels: %w", err)
+	}
+
+	chMap := make(map[string]*ChannelDetails, len(rows))
+	for _, row := range rows {
+		chMap[row.Name] = &ChannelDetails{
+			ChName:        row.Name,
+			ChPass:        row.Password,
+			ChCapacity:    row.Capacity,
+			Owner:         row.Owner,
+			Visibility:    row.Visibility,
+			Permanent:     row.Permanent,
+			CreatedAt:     row.CreatedAt,
+			Topic:         row.Topic,
+			Description:   row.Description,
+			SlowMode:      time.Duration(row.SlowMode) * time.Second,
+			IdleTimeout:   time.Duration(row.IdleTimeout) * time.Second,
+			ArchiveOnIdle: row.ArchiveOnIdle,
+			Archived:      row.Archived,
+			Waitlist:      row.Waitlist,
+			Users:         map[string]bool{},
+			// Nobody could rejoin while the server was down, so the inactivity window starts over
+			LastActivity:     time.Now().Unix(),
+			BannedUsers:      map[string]ChannelBan{},
+			MutedUsers:       map[string]ChannelMute{},
+			Moderators:       map[string]bool{},
+			typingIndicators: make(map[string]time.Time),
+			invites:          make(map[string]channelInvite),
+			joinedAt:         make(map[string]time.Time),
+			lastMessageAt:    make(map[string]time.Time),
+		}
+	}
+
+	var bans []struct {
+		Channel   string `db:"channel"`
+		Username  string `db:"username"`
+		BannedBy  string `db:"banned_by"`
+		BannedAt  int64  `db:"banned_at"`
+		ExpiresAt int64  `db:"expires_at"`
+	}
+	if err := db.Select(&bans, "SELECT channel, username, banned_by, banned_at, expires_at FROM channel_bans"); err != nil {
+		return nil, fmt.Errorf("failed to query channel bans: %w", err)
+	}
+	for _, row := range bans {
+		if channel, exists := chMap[row.Channel]; exists {
+			ban := ChannelBan{BannedBy: row.BannedBy, BannedAt: time.Unix(row.BannedAt, 0)}
+			if row.ExpiresAt != 0 {
+				ban.ExpiresAt = time.Unix(row.ExpiresAt, 0)
+			}
+			channel.BannedUsers[row.Username] = ban
+		}
+	}
+
+	var mutes []struct {
+		Channel   string `db:"channel"`
+		Username  string `db:"username"`
+		MutedBy   string `db:"muted_by"`
+		MutedAt   int64  `db:"muted_at"`
+		ExpiresAt int64  `db:"expires_at"`
+	}
+	if err := db.Select(&mutes, "SELECT channel, username, muted_by, muted_at, expires_at FROM channel_mutes"); err != nil {
+		return nil, fmt.Errorf("failed to query channel mutes: %w", err)
+	}
+	for _, row := range mutes {
+		if channel, exists := chMap[row.Channel]; exists {
+			mute := ChannelMute{MutedBy: row.MutedBy, MutedAt: time.Unix(row.MutedAt, 0)}
+			if row.ExpiresAt != 0 {
+				mute.ExpiresAt = time.Unix(row.ExpiresAt, 0)
+			}
+			channel.MutedUsers[row.Username] = mute
+		}
+	}
+
+	var moderators []struct {
+		Channel  string `db:"channel"`
+		Username string `db:"username"`
+	}
+	if err := db.Select(&moderators, "SELECT channel, username FROM channel_moderators"); err != nil {
+		return nil, fmt.Errorf("failed to query channel moderators: %w", err)
+	}
+	for _, moderator := range moderators {
+		if channel, exists := chMap[moderator.Channel]; exists {
+			channel.Moderators[moderator.Username] = true
+		}
+	}
+
+	var invites []struct {
+		Code      string `db:"code"`
+		Channel   string `db:"channel"`
+		Username  string `db:"username"`
+		CreatedBy string `db:"created_by"`
+		ExpiresAt int64  `db:"expires_at"`
+	}
+	// Expired invites are left for the next sweep to delete
+	if err := db.Select(&invites, "SELECT code, channel, username, created_by, expires_at FROM channel_invites WHERE expires_at > ?", time.Now().Unix()); err != nil {
+		return nil, fmt.Errorf("failed to query channel invites: %w", err)
+	}
+	for _, row := range invites {
+		if channel, exists := chMap[row.Channel]; exists {
+			channel.invites[row.Code] = channelInvite{Username: row.Username, CreatedBy: row.CreatedBy, ExpiresAt: time.Unix(row.ExpiresAt, 0)}
+		}
+	}
+	return chMap, nil
+}
+
+func (m *Manager) storeChannel(channel *ChannelDetails) error {
+	_, err := m.db.Exec(
+		"INSERT INTO channels (name, password, capacity, owner, visibility, permanent, created_at, topic, description, idle_timeout, archive_on_idle, waitlist) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		channel.ChName, channel.ChPass, channel.ChCapacity, channel.Owner, channel.Visibility, channel.Permanent, channel.CreatedAt, channel.Topic, channel.Description,
+		int64(channel.IdleTimeout/time.Second), channel.ArchiveOnIdle, channel.Waitlist,
+	)
+	return err
+}
+
+// deleteChannel removes the channel along with its ban list, mutes, moderators and invites
+func (m *Manager) deleteChannel(chName string) error {
+	tx, err := m.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM channel_bans WHERE channel = ?", chName); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM channel_mutes WHERE channel = ?", chName); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM channel_moderators WHERE channel = ?", chName); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM channel_invites WHERE channel = ?", chName); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("DELETE FROM channels WHERE name = ?", chName); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deleteUser removes the rows of a deleted account and hands its channels to newOwners, keyed by channel name.
+// Bans, mutes and invites it gave out are kept under replacement, except invites, they die with their creator.
+func (m *Manager) deleteUser(username, replacement string, newOwners map[string]string) error {
+	tx, err := m.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	type statement struct {
+		query string
+		args  []any
+	}
+	statements := []statement{
+		{"DELETE FROM channel_bans WHERE username = ?", []any{username}},
+		{"UPDATE channel_bans SET banned_by = ? WHERE banned_by = ?", []any{replacement, username}},
+		{"DELETE FROM channel_mutes WHERE username = ?", []any{username}},
+		{"UPDATE channel_mutes SET muted_by = ? WHERE muted_by = ?", []any{replacement, username}},
+		{"DELETE FROM channel_moderators WHERE username = ?", []any{username}},
+		{"DELETE FROM channel_invites WHERE username = ? OR created_by = ?", []any{username, username}},
+	}
+	for chName, owner := range newOwners {
+		statements = append(statements,
+			statement{"UPDATE channels SET owner = ? WHERE name = ?", []any{owner, chName}},
+			statement{"DELETE FROM channel_moderators WHERE channel = ? AND username = ?", []any{chName, owner}},
+		)
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt.query, stmt.args...); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// storeBan replaces an earlier ban of the user, so banning again changes the expiry
+func (m *Manager) storeBan(chName, username string, ban ChannelBan) error {
+	var expiresAt int64
+	if !ban.ExpiresAt.IsZero() {
+		expiresAt = ban.ExpiresAt.Unix()
+	}
+	_, err := m.db.Exec(
+		"INSERT OR REPLACE INTO channel_bans (channel, username, banned_by, banned_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+		chName, username, ban.BannedBy, ban.BannedAt.Unix(), expiresAt,
+	)
+	return err
+}
+
+func (m *Manager) deleteBan(chName, username string) error {
+	_, err := m.db.Exec("DELETE FROM channel_bans WHERE channel = ? AND username = ?", chName, username)
+	return err
+}
+
+// storeMute replaces an earlier mute of the user, so muting again changes the expiry
+func (m *Manager) storeMute(chName, username string, mute ChannelMute) error {
+	var expiresAt int64
+	if !mute.ExpiresAt.IsZero() {
+		expiresAt = mute.ExpiresAt.Unix()
+	}
+	_, err := m.db.Exec(
+		"INSERT OR REPLACE INTO channel_mutes (channel, username, muted_by, muted_at, expires_at) VALUES (?, ?, ?, ?, ?)",
+		chName, username, mute.MutedBy, mute.MutedAt.Unix(), expiresAt,
+	)
+	return err
+}
+
+func (m *Manager) deleteMute(chName, username string) error {
+	_, err := m.db.Exec("DELETE FROM channel_mutes WHERE channel = ? AND username = ?", chName, username)
+	return err
+}
+
+func (m *Manager) storeModerator(chName, username string) error {
+	_, err := m.db.Exec("INSERT OR IGNORE INTO channel_moderators (channel, username) VALUES (?, ?)", chName, username)
+	return err
+}
+
+func (m *Manager) deleteModerator(chName, username string) error {
+	_, err := m.db.Exec("DELETE FROM channel_moderators WHERE channel = ? AND username = ?", chName, username)
+	return err
+}
+
+func (m *Manager) storeOwner(chName, owner string) error {
+	_, err := m.db.Exec("UPDATE channels SET owner = ? WHERE name = ?", owner, chName)
+	return err
+}
+
+func (m *Manager) storeInvite(chName, code string, invite channelInvite) error {
+	_, err := m.db.Exec(
+		"INSERT INTO channel_invites (code, channel, username, created_by, expires_at) VALUES (?, ?, ?, ?, ?)",
+		code, chName, invite.Username, invite.CreatedBy, invite.ExpiresAt.Unix(),
+	)
+	return err
+}
+
+func (m *Manager) deleteInvite(code string) error {
+	_, err := m.db.Exec("DELETE FROM channel_invites WHERE code = ?", code)
+	return err
+}
+
+func (m *Manager) deleteExpiredInvites(now time.Time) error {
+	_, err := m.db.Exec("DELETE FROM channel_invites WHERE expires_at <= ?", now.Unix())
+	return err
+}
+
+func (m *Manager) storeMetadata(chName, topic, description string) error {
+	_, err := m.db.Exec("UPDATE channels SET topic = ?, description = ? WHERE name = ?", topic, description, chName)
+	return err
+}
+
+func (m *Manager) storeSlowMode(chName string, slowMode time.Duration) error {
+	_, err := m.db.Exec("UPDATE channels SET slow_mode = ? WHERE name = ?", int64(slowMode/time.Second), chName)
+	return err
+}
+
+func (m *Manager) storeArchived(chName string, archived bool) error {
+	_, err := m.db.Exec("UPDATE channels SET archived = ? WHERE name = ?", archived, chName)
+	return err
+}
+
+func (m *Manager) storeCapacity(chName string, capacity int) error {
+	_, err := m.db.Exec("UPDATE channels SET capacity = ? WHERE name = ?", capacity, chName)
+	return err
+}
@@ -0,0 +1,153 @@
+package channels
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/ogzhanolguncu/go-chat/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+// Admission is a queued user let into the channel, the join response goes to them and the notice to the members
+type Admission struct {
+	Join   protocol.ChannelPayload
+	Notice protocol.ChannelPayload
+}
+
+// enqueue puts the user at the end of the waitlist, returns their position counting from 1
+func (ch *ChannelDetails) enqueue(username string) int {
+	if position := slices.Index(ch.waitlist, username); position >= 0 {
+		return position + 1
+	}
+	ch.waitlist = append(ch.waitlist, username)
+	return len(ch.waitlist)
+}
+
+// dequeue removes the user from the waitlist, reports whether they were on it
+func (ch *ChannelDetails) dequeue(username string) bool {
+	position := slices.Index(ch.waitlist, username)
+	if position < 0 {
+		return false
+	}
+	ch.waitlist = slices.Delete(ch.waitlist, position, position+1)
+	return true
+}
+
+// waitlistMember answers a join of a full channel with the requester's place in the queue
+func (m *Manager) waitlistMember(channel *ChannelDetails, chPayload protocol.ChannelPayload) protocol.ChannelPayload {
+	position := channel.enqueue(chPayload.Requester)
+	logger.WithFields(logrus.Fields{
+		"channel":  channel.ChName,
+		"user":     chPayload.Requester,
+		"position": position,
+	}).Info("User put on channel waitlist")
+
+	chPayload.ChannelAction = protocol.WaitlistChannel
+	chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
+		Status: protocol.StatusSuccess,
+		Notice: fmt.Sprintf("Channel '%s' is full, you're number %d on the waitlist and will join once a seat frees up", channel.ChName, position),
+	}
+	return chPayload
+}
+
+// resizeChannel changes how many members the channel holds, it can't shrink below the current members
+func (m *Manager) resizeChannel(chPayload protocol.ChannelPayload) (protocol.ChannelPayload, protocol.ChannelPayload) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	channel := m.chMap[chPayload.ChannelName]
+	if channel == nil {
+		logger.WithField("channel", chPayload.ChannelName).Warn("Channel does not exist")
+		return failChannelAction(chPayload, chDoesNotExist), protocol.ChannelPayload{}
+	}
+	if channel.Owner != chPayload.Requester {
+		return failChannelAction(chPayload, notChannelOwner), protocol.ChannelPayload{}
+	}
+	size := chPayload.ChannelSize
+	if minSize := max(len(channel.Users), 1); size < minSize {
+		return failChannelAction(chPayload, fmt.Sprintf(invalidChannelSize, minSize)), protocol.ChannelPayload{}
+	}
+
+	if err := m.storeCapacity(channel.ChName, size); err != nil {
+		logger.WithError(err).WithField("channel", channel.ChName).Error("Failed to store channel capacity")
+		return failChannelAction(chPayload, chCouldNotBeUpdated), protocol.ChannelPayload{}
+	}
+	channel.ChCapacity = size
+	channel.LastActivity = time.Now().Unix()
+	logger.WithFields(logrus.Fields{
+		"channel":  channel.ChName,
+		"capacity": size,
+	}).Info("Channel resized")
+
+	chPayload.OptionalChannelArgs = &protocol.OptionalChannelArgs{
+		Status: protocol.StatusSuccess,
+	}
+	chNoticePayload := m.prepareNoticePayload(chPayload, channel, fmt.Sprintf("Channel size changed to %d", size))
+	return chPayload, chNoticePayload
+}
+
+// AdmitWaitlisted lets queued users in while the channel has free seats, first come first served.
+// Users who aren't connected anymore lose their place, banned ones keep it until their ban runs out or is lifted.
+func (m *Manager) AdmitWaitlisted(chName string) []Admission {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	channel, exists := m.chMap[chName]
+	if !exists || channel.Archived {
+		return nil
+	}
+
+	var admissions []Admission
+	queue := channel.waitlist
+	channel.waitlist = nil
+	for _, username := range queue {
+		switch {
+		case len(channel.Users) >= channel.ChCapacity || channel.isBanned(username):
+			channel.waitlist = append(channel.waitlist, username)
+		case m.cm.CountConnectionsByOwnerName(username) == 0:
+			logger.WithFields(logrus.Fields{
+				"channel": chName,
+				"user":    username,
+			}).Info("Dropping disconnected user from waitlist")
+		default:
+			join, notice := m.admitMember(channel, protocol.ChannelPayload{
+				ChannelAction: protocol.JoinChannel,
+				Requester:     username,
+				ChannelName:   channel.ChName,
+				ChannelSize:   channel.ChCapacity,
+			})
+			admissions = append(admissions, Admission{Join: join, Notice: notice})
+		}
+	}
+	return admissions
+}
+
+// OnSeatsFreed sets the function the background checker calls with channels whose waitlist can move,
+// like after a queued user's ban ran out
+func (m *Manager) OnSeatsFreed(fn func(chName string)) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.seatsFreedFn = fn
+}
+
+// notifySeatsFreed hands every channel with free seats and people waiting to the OnSeatsFreed function
+func (m *Manager) notifySeatsFreed() {
+	m.lock.RLock()
+	fn := m.seatsFreedFn
+	var chNames []string
+	for chName, channel := range m.chMap {
+		if !channel.Archived && len(channel.waitlist) > 0 && len(channel.Users) < channel.ChCapacity {
+			chNames = append(chNames, chName)
+		}
+	}
+	m.lock.RUnlock()
+
+	if fn == nil {
+		return
+	}
+	// Called without the lock, admitting takes it again
+	for _, chName := range chNames {
+		fn(chName)
+	}
+}
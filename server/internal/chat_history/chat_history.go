@@ -59,7 +59,23 @@ func createSchema(db *sqlx.DB) error {
 			return err
 		}
 	}
-	return nil
+	return migrateChannelColumn(db)
+}
+
+// migrateChannelColumn adds the channel column to databases created before channel messages were stored
+func migrateChannelColumn(db *sqlx.DB) error {
+	var exists bool
+	err := db.Get(&exists, "SELECT EXISTS(SELECT 1 FROM pragma_table_info('messages') WHERE name = 'channel')")
+	if err != nil {
+		return fmt.Errorf("failed to inspect messages table: %w", err)
+	}
+	if !exists {
+		if _, err := db.Exec("ALTER TABLE messages ADD COLUMN channel TEXT NOT NULL DEFAULT ''"); err != nil {
+			return fmt.Errorf("failed to add channel column: %w", err)
+		}
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_messages_channel ON messages(channel, timestamp)")
+	return err
 }
 
 func (ch *ChatHistory) AddMessage(message string, messageTypes ...protocol.MessageType) error {
@@ -111,6 +127,11 @@ SELECT :sender, :recipient, :message_type, :content, :timestamp,
 }
 
 func (ch *ChatHistory) GetHistory(user string, messageTypes ...string) ([]string, error) {
+	return ch.GetHistorySince(user, 0, messageTypes...)
+}
+
+// GetHistorySince works like GetHistory but only returns messages stored after the given message ID
+func (ch *ChatHistory) GetHistorySince(user string, afterID int64, messageTypes ...string) ([]string, error) {
 	const messageLimit = 200
 	// Default message types if none provided
 	if len(messageTypes) == 0 {
@@ -121,6 +142,7 @@ func (ch *ChatHistory) GetHistory(user string, messageTypes ...string) ([]string
     SELECT sender, recipient, message_type, content, timestamp
     FROM messages
     WHERE message_type IN (:message_type)
+    AND id > :after_id
     AND (sender = :user OR recipient = '' OR recipient = :user)
     AND (
 		(blocked_users = '' OR blocked_users = ',')
@@ -138,6 +160,7 @@ func (ch *ChatHistory) GetHistory(user string, messageTypes ...string) ([]string
 	params := map[string]interface{}{
 		"user":         user,
 		"message_type": messageTypes,
+		"after_id":     afterID,
 		"limit":        messageLimit,
 	}
 
@@ -186,6 +209,109 @@ func (ch *ChatHistory) GetHistory(user string, messageTypes ...string) ([]string
 	return encodedMessages, nil
 }
 
+// AddChannelMessage stores a message sent to a channel, they are kept apart from MSG and WSP history by their type
+func (ch *ChatHistory) AddChannelMessage(channel, sender, content string, timestamp int64) error {
+	_, err := ch.db.Exec(
+		"INSERT INTO messages (sender, recipient, message_type, content, timestamp, blocked_users, channel) VALUES (?, '', ?, ?, ?, '', ?)",
+		sender, protocol.MessageTypeCH, content, timestamp, channel,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to insert channel message: %w", err)
+	}
+	return nil
+}
+
+// GetChannelHistory returns the last messages of the channel sent at or after since, oldest first, encoded as CH frames.
+// Messages sent by excludedSenders are left out, callers pass the users blocked by or blocking the requester
+func (ch *ChatHistory) GetChannelHistory(channel string, since int64, limit int, excludedSenders []string) ([]string, error) {
+	senderFilter := ""
+	args := []interface{}{protocol.MessageTypeCH, channel, since}
+	if len(excludedSenders) > 0 {
+		senderFilter = "AND sender NOT IN (?)"
+		args = append(args, excludedSenders)
+	}
+	args = append(args, limit)
+
+	query, args, err := sqlx.In(fmt.Sprintf(`
+	SELECT sender, content, timestamp FROM (
+		SELECT id, sender, content, timestamp
+		FROM messages
+		WHERE message_type = ? AND channel = ? AND timestamp >= ? %s
+		ORDER BY id DESC
+		LIMIT ?
+	) ORDER BY id ASC`, senderFilter), args...)
+	if err != nil {
+		return nil, fmt.Errorf("error expanding IN clause: %w", err)
+	}
+
+	var entries []MessageEntry
+	if err := ch.db.Select(&entries, ch.db.Rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("failed to query channel messages: %w", err)
+	}
+
+	encodedMessages := make([]string, len(entries))
+	for i, entry := range entries {
+		msg := protocol.Payload{
+			MessageType: protocol.MessageTypeCH,
+			Timestamp:   entry.Timestamp,
+			ChannelPayload: &protocol.ChannelPayload{
+				ChannelAction: protocol.MessageChannel,
+				Requester:     entry.Sender,
+				ChannelName:   channel,
+				OptionalChannelArgs: &protocol.OptionalChannelArgs{
+					Status:  protocol.StatusSuccess,
+					Message: entry.Content,
+				},
+			},
+		}
+		encodedMessages[i] = strings.TrimSpace(protocol.InitEncodeProtocol(ch.encoding)(msg))
+	}
+	return encodedMessages, nil
+}
+
+// LastMessageID returns the ID of the latest stored message, 0 if there is none
+func (ch *ChatHistory) LastMessageID() (int64, error) {
+	var id int64
+	if err := ch.db.Get(&id, "SELECT COALESCE(MAX(id), 0) FROM messages"); err != nil {
+		return 0, fmt.Errorf("failed to get last message id: %w", err)
+	}
+	return id, nil
+}
+
+// AnonymizeUser replaces the user's name in every message they sent or received, used when an account is deleted
+func (ch *ChatHistory) AnonymizeUser(username, replacement string) error {
+	tx, err := ch.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE messages SET sender = ? WHERE sender = ?", replacement, username); err != nil {
+		return fmt.Errorf("failed to anonymize sent messages: %w", err)
+	}
+	if _, err := tx.Exec("UPDATE messages SET recipient = ? WHERE recipient = ?", replacement, username); err != nil {
+		return fmt.Errorf("failed to anonymize received messages: %w", err)
+	}
+	// Blocks die with the account, so the name leaves the blocked users lists instead of being replaced
+	var blockedRows []struct {
+		ID           int64  `db:"id"`
+		BlockedUsers string `db:"blocked_users"`
+	}
+	if err := tx.Select(&blockedRows, "SELECT id, blocked_users FROM messages WHERE blocked_users LIKE '%' || ? || '%'", username); err != nil {
+		return fmt.Errorf("failed to query blocks of user: %w", err)
+	}
+	for _, row := range blockedRows {
+		names := slices.DeleteFunc(strings.Split(row.BlockedUsers, ","), func(name string) bool { return name == username })
+		if _, err := tx.Exec("UPDATE messages SET blocked_users = ? WHERE id = ?", strings.Join(names, ","), row.ID); err != nil {
+			return fmt.Errorf("failed to remove blocks of user: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit anonymization: %w", err)
+	}
+	return nil
+}
+
 func (ch *ChatHistory) Close() error {
 	if err := ch.db.Close(); err != nil {
 		return fmt.Errorf("failed to close database: %w", err)
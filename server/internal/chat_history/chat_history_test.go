@@ -82,3 +82,110 @@ func TestGetHistoryWithBlockedUser(t *testing.T) {
 	}
 	assert.NoError(t, os.Remove(dbPath))
 }
+
+func TestGetHistorySince(t *testing.T) {
+	ch, err := NewChatHistory(false, dbPath)
+	require.NoError(t, err)
+	defer os.Remove(dbPath)
+	defer ch.Close()
+
+	// Creates blocked_users table that AddMessage relies on
+	bm, err := block_user.NewBlockUserManager(dbPath)
+	require.NoError(t, err)
+	defer bm.Close()
+
+	lastID, err := ch.LastMessageID()
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), lastID)
+
+	require.NoError(t, ch.AddMessage("MSG|1724188406|Oz|Before disconnect\r\n"))
+	lastID, err = ch.LastMessageID()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), lastID)
+
+	require.NoError(t, ch.AddMessage("MSG|1724188410|John|While away\r\n"))
+	require.NoError(t, ch.AddMessage("WSP|1724188411|John|Oz|Missed whisper\r\n"))
+	require.NoError(t, ch.AddMessage("WSP|1724188412|John|Frey|Not for Oz\r\n"))
+
+	messages, err := ch.GetHistorySince("Oz", lastID, "MSG", "WSP")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"MSG|1724188410|John|While away",
+		"WSP|1724188411|John|Oz|Missed whisper",
+	}, messages)
+}
+
+func TestAnonymizeUser(t *testing.T) {
+	ch, err := NewChatHistory(false, dbPath)
+	require.NoError(t, err)
+	defer os.Remove(dbPath)
+	defer ch.Close()
+
+	bm, err := block_user.NewBlockUserManager(dbPath)
+	require.NoError(t, err)
+	defer bm.Close()
+
+	require.NoError(t, ch.AddMessage("MSG|1724188406|Oz|Goodbye\r\n"))
+	require.NoError(t, ch.AddMessage("WSP|1724188410|John|Oz|Are you leaving?\r\n"))
+	require.NoError(t, ch.AddMessage("MSG|1724188411|John|Bye Oz\r\n"))
+	require.NoError(t, bm.BlockUser("Jane", "Oz"))
+	require.NoError(t, ch.AddMessage("MSG|1724188412|Jane|Not for Oz\r\n"))
+
+	require.NoError(t, ch.AnonymizeUser("Oz", "[deleted]"))
+
+	messages, err := ch.GetHistory("John", "MSG", "WSP")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"MSG|1724188406|[deleted]|Goodbye",
+		"WSP|1724188410|John|[deleted]|Are you leaving?",
+		"MSG|1724188411|John|Bye Oz",
+		"MSG|1724188412|Jane|Not for Oz",
+	}, messages)
+
+	// Whoever registers the name next isn't hidden by the old account's blocks
+	messages, err = ch.GetHistory("Oz", "MSG", "WSP")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"MSG|1724188406|[deleted]|Goodbye",
+		"MSG|1724188411|John|Bye Oz",
+		"MSG|1724188412|Jane|Not for Oz",
+	}, messages)
+}
+
+func TestChannelHistory(t *testing.T) {
+	ch, err := NewChatHistory(false, dbPath)
+	require.NoError(t, err)
+	defer os.Remove(dbPath)
+	defer ch.Close()
+
+	bm, err := block_user.NewBlockUserManager(dbPath)
+	require.NoError(t, err)
+	defer bm.Close()
+
+	require.NoError(t, ch.AddChannelMessage("golang", "Oz", "From an older channel", 1724188400))
+	require.NoError(t, ch.AddMessage("MSG|1724188405|Oz|Public message\r\n"))
+	require.NoError(t, ch.AddChannelMessage("golang", "Oz", "First", 1724188406))
+	require.NoError(t, ch.AddChannelMessage("nodejs", "John", "Other channel", 1724188407))
+	require.NoError(t, ch.AddChannelMessage("golang", "John", "Second", 1724188408))
+	require.NoError(t, ch.AddChannelMessage("golang", "Oz", "Third", 1724188409))
+
+	messages, err := ch.GetChannelHistory("golang", 1724188405, 2, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"CH|1724188408|MessageChannel|John|golang|-|-|status=success;message=Second",
+		"CH|1724188409|MessageChannel|Oz|golang|-|-|status=success;message=Third",
+	}, messages)
+
+	// Senders the requester blocked, or was blocked by, don't take up the limit either
+	messages, err = ch.GetChannelHistory("golang", 1724188405, 2, []string{"John"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"CH|1724188406|MessageChannel|Oz|golang|-|-|status=success;message=First",
+		"CH|1724188409|MessageChannel|Oz|golang|-|-|status=success;message=Third",
+	}, messages)
+
+	// Channel messages stay out of the group chat history
+	history, err := ch.GetHistory("Oz", "MSG", "WSP")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"MSG|1724188405|Oz|Public message"}, history)
+}
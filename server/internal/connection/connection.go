@@ -8,75 +8,134 @@ import (
 type ConnectionInfo struct {
 	Connection net.Conn
 	OwnerName  string
+	SessionID  string
 }
 
+// Manager keeps track of authenticated connections.
+// Connections are indexed both by net.Conn and by username, so looking up a user never scans every connection.
 type Manager struct {
-	connectionMap sync.Map
+	connections map[net.Conn]*ConnectionInfo
+	// owners indexes connections by username, a user can be connected from multiple devices
+	owners map[string]map[net.Conn]struct{}
+	lock   sync.RWMutex
 }
 
 func NewConnectionManager() *Manager {
-	return &Manager{}
+	return &Manager{
+		connections: make(map[net.Conn]*ConnectionInfo),
+		owners:      make(map[string]map[net.Conn]struct{}),
+	}
 }
 
 func (cm *Manager) AddConnection(c net.Conn, info *ConnectionInfo) {
-	cm.connectionMap.Store(c, info)
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	// Connection might be re-added under a different owner, drop the stale index entry first
+	if existing, exists := cm.connections[c]; exists {
+		cm.removeFromOwner(existing.OwnerName, c)
+	}
+	cm.connections[c] = info
+	if _, exists := cm.owners[info.OwnerName]; !exists {
+		cm.owners[info.OwnerName] = make(map[net.Conn]struct{})
+	}
+	cm.owners[info.OwnerName][c] = struct{}{}
 }
 
 func (cm *Manager) DeleteConnection(c net.Conn) {
-	cm.connectionMap.Delete(c)
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	info, exists := cm.connections[c]
+	if !exists {
+		return
+	}
+	delete(cm.connections, c)
+	cm.removeFromOwner(info.OwnerName, c)
 }
 
 func (cm *Manager) GetConnectedUsersCount() int {
-	count := 0
-	cm.connectionMap.Range(func(key, value interface{}) bool {
-		count++
-		return true
-	})
-	return count
+	cm.lock.RLock()
+	defer cm.lock.RUnlock()
+	return len(cm.owners)
 }
 
+// GetActiveUsers returns every connected username once, no matter how many connections they have
 func (cm *Manager) GetActiveUsers() []string {
-	var users []string
-	cm.connectionMap.Range(func(key, value interface{}) bool {
-		info := value.(*ConnectionInfo)
-		users = append(users, info.OwnerName)
-		return true
-	})
+	cm.lock.RLock()
+	defer cm.lock.RUnlock()
+
+	users := make([]string, 0, len(cm.owners))
+	for owner := range cm.owners {
+		users = append(users, owner)
+	}
 	return users
 }
 
 func (cm *Manager) GetConnectionInfo(c net.Conn) (*ConnectionInfo, bool) {
-	value, ok := cm.connectionMap.Load(c)
-	if !ok {
-		return nil, false
-	}
-	info, ok := value.(*ConnectionInfo)
+	cm.lock.RLock()
+	defer cm.lock.RUnlock()
+
+	info, ok := cm.connections[c]
 	return info, ok
 }
 
-func (cm *Manager) FindConnectionByOwnerName(ownerName string) (net.Conn, bool) {
-	var foundConn net.Conn
-	var found bool
+// FindConnectionsByOwnerName returns all connections of the user
+func (cm *Manager) FindConnectionsByOwnerName(ownerName string) []net.Conn {
+	cm.lock.RLock()
+	defer cm.lock.RUnlock()
 
-	cm.connectionMap.Range(func(key, value interface{}) bool {
-		conn := key.(net.Conn)
-		info := value.(*ConnectionInfo)
+	conns := make([]net.Conn, 0, len(cm.owners[ownerName]))
+	for conn := range cm.owners[ownerName] {
+		conns = append(conns, conn)
+	}
+	return conns
+}
 
-		if info.OwnerName == ownerName {
-			foundConn = conn
-			found = true
-			return false
+// FindConnectionsByOwnerNames returns connections of all given users
+func (cm *Manager) FindConnectionsByOwnerNames(ownerNames []string) []net.Conn {
+	cm.lock.RLock()
+	defer cm.lock.RUnlock()
+
+	conns := make([]net.Conn, 0, len(ownerNames))
+	for _, ownerName := range ownerNames {
+		for conn := range cm.owners[ownerName] {
+			conns = append(conns, conn)
 		}
-		return true
-	})
-	return foundConn, found
+	}
+	return conns
+}
+
+// CountConnectionsByOwnerName returns how many connections the user currently has
+func (cm *Manager) CountConnectionsByOwnerName(ownerName string) int {
+	cm.lock.RLock()
+	defer cm.lock.RUnlock()
+	return len(cm.owners[ownerName])
 }
 
-// RangeConnections iterates over all connections and applies the given function
-func (m *Manager) RangeConnections(f func(conn net.Conn, info *ConnectionInfo) bool) {
-	m.connectionMap.Range(func(key, value interface{}) bool {
-		conn := key.(net.Conn)
-		info := value.(*ConnectionInfo)
-		return f(conn, info)
-	})
+// RangeConnections iterates over all connections and applies the given function.
+// It works on a snapshot, so the function is free to write to connections or call back into the manager without holding the lock.
+func (cm *Manager) RangeConnections(f func(conn net.Conn, info *ConnectionInfo) bool) {
+	cm.lock.RLock()
+	conns := make([]net.Conn, 0, len(cm.connections))
+	infos := make([]*ConnectionInfo, 0, len(cm.connections))
+	for conn, info := range cm.connections {
+		conns = append(conns, conn)
+		infos = append(infos, info)
+	}
+	cm.lock.RUnlock()
+
+	for i, conn := range conns {
+		if !f(conn, infos[i]) {
+			return
+		}
+	}
+}
+
+// removeFromOwner drops the connection from the username index, caller must hold the lock
+func (cm *Manager) removeFromOwner(ownerName string, c net.Conn) {
+	delete(cm.owners[ownerName], c)
+	if len(cm.owners[ownerName]) == 0 {
+		delete(cm.owners, ownerName)
+	}
 }
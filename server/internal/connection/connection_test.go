@@ -0,0 +1,143 @@
+package connection
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeConn is only used as a map key, it's never read from or written to
+type fakeConn struct {
+	net.Conn
+	id int
+}
+
+func TestConnectionManager(t *testing.T) {
+	t.Run("should index connections by owner", func(t *testing.T) {
+		cm := NewConnectionManager()
+		laptop, phone, other := &fakeConn{id: 1}, &fakeConn{id: 2}, &fakeConn{id: 3}
+
+		cm.AddConnection(laptop, &ConnectionInfo{Connection: laptop, OwnerName: "oz"})
+		cm.AddConnection(phone, &ConnectionInfo{Connection: phone, OwnerName: "oz"})
+		cm.AddConnection(other, &ConnectionInfo{Connection: other, OwnerName: "john"})
+
+		assert.Equal(t, 2, cm.GetConnectedUsersCount())
+		assert.ElementsMatch(t, []string{"oz", "john"}, cm.GetActiveUsers())
+		assert.ElementsMatch(t, []net.Conn{laptop, phone}, cm.FindConnectionsByOwnerName("oz"))
+		assert.ElementsMatch(t, []net.Conn{laptop, phone, other}, cm.FindConnectionsByOwnerNames([]string{"oz", "john", "unknown"}))
+		assert.Empty(t, cm.FindConnectionsByOwnerName("unknown"))
+	})
+
+	t.Run("should drop owner once last connection is deleted", func(t *testing.T) {
+		cm := NewConnectionManager()
+		laptop, phone := &fakeConn{id: 1}, &fakeConn{id: 2}
+
+		cm.AddConnection(laptop, &ConnectionInfo{Connection: laptop, OwnerName: "oz"})
+		cm.AddConnection(phone, &ConnectionInfo{Connection: phone, OwnerName: "oz"})
+
+		cm.DeleteConnection(laptop)
+		assert.Equal(t, 1, cm.CountConnectionsByOwnerName("oz"))
+		assert.ElementsMatch(t, []string{"oz"}, cm.GetActiveUsers())
+
+		cm.DeleteConnection(phone)
+		cm.DeleteConnection(phone)
+		assert.Equal(t, 0, cm.CountConnectionsByOwnerName("oz"))
+		assert.Empty(t, cm.GetActiveUsers())
+	})
+
+	t.Run("should move connection when re-added under another owner", func(t *testing.T) {
+		cm := NewConnectionManager()
+		conn := &fakeConn{id: 1}
+
+		cm.AddConnection(conn, &ConnectionInfo{Connection: conn, OwnerName: "oz"})
+		cm.AddConnection(conn, &ConnectionInfo{Connection: conn, OwnerName: "john"})
+
+		assert.Empty(t, cm.FindConnectionsByOwnerName("oz"))
+		assert.ElementsMatch(t, []net.Conn{conn}, cm.FindConnectionsByOwnerName("john"))
+		assert.ElementsMatch(t, []string{"john"}, cm.GetActiveUsers())
+	})
+
+	t.Run("should allow calling back into manager while ranging", func(t *testing.T) {
+		cm := NewConnectionManager()
+		for i := 0; i < 10; i++ {
+			conn := &fakeConn{id: i}
+			cm.AddConnection(conn, &ConnectionInfo{Connection: conn, OwnerName: fmt.Sprintf("user%d", i)})
+		}
+
+		visited := 0
+		cm.RangeConnections(func(conn net.Conn, _ *ConnectionInfo) bool {
+			cm.DeleteConnection(conn)
+			visited++
+			return true
+		})
+		assert.Equal(t, 10, visited)
+		assert.Equal(t, 0, cm.GetConnectedUsersCount())
+	})
+}
+
+// Benchmarks
+// -----------------------------
+
+func newBenchmarkManager(b *testing.B, users int) *Manager {
+	b.Helper()
+	cm := NewConnectionManager()
+	for i := 0; i < users; i++ {
+		conn := &fakeConn{id: i}
+		cm.AddConnection(conn, &ConnectionInfo{Connection: conn, OwnerName: fmt.Sprintf("user%d", i)})
+	}
+	return cm
+}
+
+func BenchmarkFindConnectionsByOwnerName(b *testing.B) {
+	for _, users := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("users=%d", users), func(b *testing.B) {
+			cm := newBenchmarkManager(b, users)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				cm.FindConnectionsByOwnerName(fmt.Sprintf("user%d", i%users))
+			}
+		})
+	}
+}
+
+// Simulates a channel broadcast where a fraction of the connected users are channel members
+func BenchmarkFindConnectionsByOwnerNames(b *testing.B) {
+	for _, users := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("users=%d", users), func(b *testing.B) {
+			cm := newBenchmarkManager(b, users)
+			members := make([]string, 0, users/10)
+			for i := 0; i < users; i += 10 {
+				members = append(members, fmt.Sprintf("user%d", i))
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				cm.FindConnectionsByOwnerNames(members)
+			}
+		})
+	}
+}
+
+// Simulates a group broadcast with a handful of blocked users excluded
+func BenchmarkRangeConnectionsWithExclusion(b *testing.B) {
+	for _, users := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("users=%d", users), func(b *testing.B) {
+			cm := newBenchmarkManager(b, users)
+			excluded := make(map[net.Conn]struct{})
+			for _, conn := range cm.FindConnectionsByOwnerNames([]string{"user1", "user2", "user3"}) {
+				excluded[conn] = struct{}{}
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				recipients := 0
+				cm.RangeConnections(func(conn net.Conn, _ *ConnectionInfo) bool {
+					if _, found := excluded[conn]; !found {
+						recipients++
+					}
+					return true
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,161 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SlowConsumerPolicy decides what happens when a client can't keep up with its outbound queue
+type SlowConsumerPolicy string
+
+const (
+	// SlowConsumerDrop drops messages that don't fit into the queue, the client misses them
+	SlowConsumerDrop SlowConsumerPolicy = "drop"
+	// SlowConsumerDisconnect closes the connection once its queue is full
+	SlowConsumerDisconnect SlowConsumerPolicy = "disconnect"
+)
+
+var ErrQueueFull = errors.New("outbound queue is full")
+
+// closeFlushTimeout bounds flushing on close when writes have no deadline, a stalled client would keep it open forever
+const closeFlushTimeout = 5 * time.Second
+
+func ParseSlowConsumerPolicy(policy string) (SlowConsumerPolicy, error) {
+	switch SlowConsumerPolicy(policy) {
+	case SlowConsumerDrop, SlowConsumerDisconnect:
+		return SlowConsumerPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("unknown slow consumer policy '%s', expected '%s' or '%s'", policy, SlowConsumerDrop, SlowConsumerDisconnect)
+	}
+}
+
+type QueueOptions struct {
+	Size         int                // Max number of messages waiting to be written
+	WriteTimeout time.Duration      // Deadline for a single write, zero means no deadline
+	Policy       SlowConsumerPolicy // What to do when the queue is full
+}
+
+func DefaultQueueOptions() QueueOptions {
+	return QueueOptions{
+		Size:         256,
+		WriteTimeout: 5 * time.Second,
+		Policy:       SlowConsumerDisconnect,
+	}
+}
+
+// QueuedConn wraps a connection so writes never block the caller.
+// Messages are put into a bounded queue and written in order by a single goroutine,
+// so one slow client can't stall broadcasts and concurrent writers can't interleave frames.
+type QueuedConn struct {
+	net.Conn
+	opts    QueueOptions
+	queue   chan []byte
+	closing chan struct{} // Closed when Close is called, writer flushes what's left and stops
+	done    chan struct{} // Closed when the writer goroutine has stopped and the connection is closed
+
+	closeOnce sync.Once
+	dropped   atomic.Int64
+}
+
+func NewQueuedConn(conn net.Conn, opts QueueOptions) *QueuedConn {
+	if opts.Size <= 0 {
+		opts.Size = DefaultQueueOptions().Size
+	}
+	qc := &QueuedConn{
+		Conn:    conn,
+		opts:    opts,
+		queue:   make(chan []byte, opts.Size),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go qc.writeLoop()
+	return qc
+}
+
+// Write queues a copy of b and returns right away.
+// When the queue is full the message is dropped or the connection is closed, depending on the policy.
+func (qc *QueuedConn) Write(b []byte) (int, error) {
+	select {
+	case <-qc.closing:
+		return 0, net.ErrClosed
+	case <-qc.done:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	msg := make([]byte, len(b))
+	copy(msg, b)
+
+	select {
+	case qc.queue <- msg:
+		return len(b), nil
+	default:
+	}
+
+	if qc.opts.Policy == SlowConsumerDisconnect {
+		// Closing the underlying connection right away unblocks a writer stuck on the slow client
+		qc.Conn.Close()
+		qc.closeOnce.Do(func() { close(qc.closing) })
+	} else {
+		qc.dropped.Add(1)
+	}
+	return 0, ErrQueueFull
+}
+
+// Close returns right away, so a stalled client never blocks whoever is closing it.
+// The writer flushes queued messages, giving them one write timeout in total, then closes the connection.
+func (qc *QueuedConn) Close() error {
+	qc.closeOnce.Do(func() { close(qc.closing) })
+	return nil
+}
+
+// Dropped returns how many messages were dropped because the queue was full
+func (qc *QueuedConn) Dropped() int64 {
+	return qc.dropped.Load()
+}
+
+// Writer
+// -----------------------------
+
+func (qc *QueuedConn) writeLoop() {
+	defer close(qc.done)
+	defer qc.Conn.Close()
+
+	for {
+		select {
+		case msg := <-qc.queue:
+			if qc.opts.WriteTimeout > 0 {
+				qc.Conn.SetWriteDeadline(time.Now().Add(qc.opts.WriteTimeout))
+			}
+			if _, err := qc.Conn.Write(msg); err != nil {
+				return
+			}
+		case <-qc.closing:
+			qc.flush()
+			return
+		}
+	}
+}
+
+// flush writes whatever is left in the queue without waiting for new messages
+func (qc *QueuedConn) flush() {
+	timeout := qc.opts.WriteTimeout
+	if timeout <= 0 {
+		timeout = closeFlushTimeout
+	}
+	qc.Conn.SetWriteDeadline(time.Now().Add(timeout))
+	for {
+		select {
+		case msg := <-qc.queue:
+			if _, err := qc.Conn.Write(msg); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
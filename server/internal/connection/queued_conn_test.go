@@ -0,0 +1,139 @@
+package connection
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fillQueue writes until the queue rejects a message, the other end of the pipe is never read
+func fillQueue(t *testing.T, qc *QueuedConn) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if _, err := qc.Write([]byte("msg\n")); err != nil {
+			assert.ErrorIs(t, err, ErrQueueFull)
+			return
+		}
+	}
+	t.Fatal("queue never filled up")
+}
+
+func TestQueuedConn(t *testing.T) {
+	t.Run("should write queued messages in order", func(t *testing.T) {
+		server, client := net.Pipe()
+		qc := NewQueuedConn(server, QueueOptions{Size: 10, WriteTimeout: time.Second, Policy: SlowConsumerDrop})
+
+		go func() {
+			qc.Write([]byte("first\n"))
+			qc.Write([]byte("second\n"))
+			qc.Close()
+		}()
+
+		received, err := io.ReadAll(client)
+		require.NoError(t, err)
+		assert.Equal(t, "first\nsecond\n", string(received))
+	})
+
+	t.Run("should flush queue on close", func(t *testing.T) {
+		server, client := net.Pipe()
+		qc := NewQueuedConn(server, QueueOptions{Size: 10, WriteTimeout: time.Second, Policy: SlowConsumerDrop})
+
+		for i := 0; i < 5; i++ {
+			_, err := qc.Write([]byte("msg\n"))
+			require.NoError(t, err)
+		}
+		closed := make(chan struct{})
+		go func() {
+			qc.Close()
+			close(closed)
+		}()
+
+		received, err := io.ReadAll(client)
+		require.NoError(t, err)
+		assert.Equal(t, 5*len("msg\n"), len(received))
+		<-closed
+
+		_, err = qc.Write([]byte("late\n"))
+		assert.ErrorIs(t, err, net.ErrClosed)
+	})
+
+	t.Run("should drop messages when queue is full with drop policy", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer client.Close()
+		qc := NewQueuedConn(server, QueueOptions{Size: 2, WriteTimeout: time.Minute, Policy: SlowConsumerDrop})
+
+		fillQueue(t, qc)
+		assert.Equal(t, int64(1), qc.Dropped())
+
+		// Connection stays usable, once the client catches up messages go through again
+		buf := make([]byte, 64)
+		_, err := client.Read(buf)
+		require.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			_, err := qc.Write([]byte("msg\n"))
+			return err == nil
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("should disconnect when queue is full with disconnect policy", func(t *testing.T) {
+		server, client := net.Pipe()
+		qc := NewQueuedConn(server, QueueOptions{Size: 2, WriteTimeout: time.Minute, Policy: SlowConsumerDisconnect})
+
+		fillQueue(t, qc)
+
+		_, err := qc.Write([]byte("msg\n"))
+		assert.ErrorIs(t, err, net.ErrClosed)
+		assert.NoError(t, qc.Close())
+		_, err = io.ReadAll(client)
+		assert.NoError(t, err)
+	})
+
+	t.Run("should close connection when a write exceeds the deadline", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer client.Close()
+		qc := NewQueuedConn(server, QueueOptions{Size: 2, WriteTimeout: 20 * time.Millisecond, Policy: SlowConsumerDrop})
+
+		_, err := qc.Write([]byte("never read\n"))
+		require.NoError(t, err)
+
+		assert.Eventually(t, func() bool {
+			_, err := qc.Write([]byte("msg\n"))
+			return errors.Is(err, net.ErrClosed)
+		}, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("should not block on close when the client stalls", func(t *testing.T) {
+		server, client := net.Pipe()
+		defer client.Close()
+		qc := NewQueuedConn(server, QueueOptions{Size: 10, Policy: SlowConsumerDrop})
+
+		_, err := qc.Write([]byte("never read\n"))
+		require.NoError(t, err)
+		closed := make(chan struct{})
+		go func() {
+			qc.Close()
+			close(closed)
+		}()
+		select {
+		case <-closed:
+		case <-time.After(time.Second):
+			t.Fatal("close waited for the stalled client")
+		}
+		_, err = qc.Write([]byte("late\n"))
+		assert.ErrorIs(t, err, net.ErrClosed)
+	})
+
+	t.Run("should parse slow consumer policy", func(t *testing.T) {
+		policy, err := ParseSlowConsumerPolicy("drop")
+		assert.NoError(t, err)
+		assert.Equal(t, SlowConsumerDrop, policy)
+
+		_, err = ParseSlowConsumerPolicy("ignore")
+		assert.Error(t, err)
+	})
+}
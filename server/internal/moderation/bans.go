@@ -0,0 +1,167 @@
+package moderation
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Ban keeps a username, and optionally an IP, from logging in
+type Ban struct {
+	Username  string
+	IP        string // Empty when only the username is banned
+	Reason    string
+	BannedBy  string
+	ExpiresAt time.Time // Zero time means the ban never expires
+}
+
+// Permanent reports whether the ban lasts until it's lifted
+func (b Ban) Permanent() bool {
+	return b.ExpiresAt.IsZero()
+}
+
+// Message tells the banned user why and until when
+func (b Ban) Message() string {
+	var sb strings.Builder
+	if b.Permanent() {
+		sb.WriteString("You are banned permanently")
+	} else {
+		sb.WriteString(fmt.Sprintf("You are banned until %s", b.ExpiresAt.Format("2006-01-02 15:04:05")))
+	}
+	if b.Reason != "" {
+		sb.WriteString(fmt.Sprintf(". Reason: %s", b.Reason))
+	}
+	return sb.String()
+}
+
+type banRow struct {
+	Username  string `db:"username"`
+	IP        string `db:"ip"`
+	Reason    string `db:"reason"`
+	BannedBy  string `db:"banned_by"`
+	ExpiresAt int64  `db:"expires_at"`
+}
+
+func (r banRow) toBan() Ban {
+	ban := Ban{Username: r.Username, IP: r.IP, Reason: r.Reason, BannedBy: r.BannedBy}
+	if r.ExpiresAt != 0 {
+		ban.ExpiresAt = time.Unix(r.ExpiresAt, 0)
+	}
+	return ban
+}
+
+func createSchema(db *sqlx.DB) error {
+	statements := []string{
+		`CREATE TABLE IF NOT EXISTS server_bans (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL,
+			ip TEXT NOT NULL DEFAULT '',
+			reason TEXT NOT NULL DEFAULT '',
+			banned_by TEXT NOT NULL DEFAULT '',
+			created_at INTEGER NOT NULL,
+			expires_at INTEGER NOT NULL DEFAULT 0
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_server_bans_username ON server_bans(username)`,
+		`CREATE INDEX IF NOT EXISTS idx_server_bans_ip ON server_bans(ip)`,
+	}
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Ban stores a ban for the username and, if given, each of the IPs.
+// A zero duration bans permanently. Earlier bans of the username are replaced.
+func (m *Manager) Ban(username, reason, bannedBy string, duration time.Duration, ips ...string) (Ban, error) {
+	ban := Ban{Username: username, Reason: sanitizeReason(reason), BannedBy: bannedBy}
+	var expiresAt int64
+	if duration > 0 {
+		ban.ExpiresAt = now().Add(duration)
+		expiresAt = ban.ExpiresAt.Unix()
+	}
+
+	tx, err := m.db.Beginx()
+	if err != nil {
+		return Ban{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM server_bans WHERE username = ?", username); err != nil {
+		return Ban{}, fmt.Errorf("failed to replace ban: %w", err)
+	}
+	// Username only bans are stored with an empty IP, otherwise there is a row per IP
+	if len(ips) == 0 {
+		ips = []string{""}
+	}
+	for _, ip := range ips {
+		_, err = tx.Exec(
+			"INSERT INTO server_bans (username, ip, reason, banned_by, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)",
+			username, ip, ban.Reason, bannedBy, now().Unix(), expiresAt,
+		)
+		if err != nil {
+			return Ban{}, fmt.Errorf("failed to store ban: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return Ban{}, fmt.Errorf("failed to commit ban: %w", err)
+	}
+	return ban, nil
+}
+
+// Unban lifts bans of the username, including IP bans made along with it. Returns false if there were none.
+func (m *Manager) Unban(username string) (bool, error) {
+	result, err := m.db.Exec("DELETE FROM server_bans WHERE username = ?", username)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove ban: %w", err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to remove ban: %w", err)
+	}
+	return affected > 0, nil
+}
+
+// ActiveBan returns the ban matching the username or IP, if there is one that hasn't expired
+func (m *Manager) ActiveBan(username, ip string) (Ban, bool, error) {
+	var rows []banRow
+	query := `
+	SELECT username, ip, reason, banned_by, expires_at
+	FROM server_bans
+	WHERE (username = ? OR (ip != '' AND ip = ?))
+	AND (expires_at = 0 OR expires_at > ?)
+	ORDER BY expires_at = 0 DESC, expires_at DESC
+	LIMIT 1`
+	if err := m.db.Select(&rows, query, username, ip, now().Unix()); err != nil {
+		return Ban{}, false, fmt.Errorf("failed to query bans: %w", err)
+	}
+	if len(rows) == 0 {
+		return Ban{}, false, nil
+	}
+	return rows[0].toBan(), true, nil
+}
+
+// Bans lists every ban that hasn't expired, expired ones are cleaned up on the way
+func (m *Manager) Bans() ([]Ban, error) {
+	if _, err := m.db.Exec("DELETE FROM server_bans WHERE expires_at != 0 AND expires_at <= ?", now().Unix()); err != nil {
+		return nil, fmt.Errorf("failed to remove expired bans: %w", err)
+	}
+
+	var rows []banRow
+	if err := m.db.Select(&rows, "SELECT username, ip, reason, banned_by, expires_at FROM server_bans ORDER BY created_at"); err != nil {
+		return nil, fmt.Errorf("failed to query bans: %w", err)
+	}
+	bans := make([]Ban, len(rows))
+	for i, row := range rows {
+		bans[i] = row.toBan()
+	}
+	return bans, nil
+}
+
+// sanitizeReason keeps the free text reason from breaking the SYS and USR frames it's sent in
+func sanitizeReason(reason string) string {
+	return strings.NewReplacer("|", "/", "\r", " ", "\n", " ").Replace(strings.TrimSpace(reason))
+}
@@ -0,0 +1,82 @@
+package moderation
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// Manager keeps server-wide mutes and bans.
+// Bans are stored in the database so they survive restarts, mutes are only kept in memory.
+type Manager struct {
+	db    *sqlx.DB
+	mutes map[string]time.Time // Zero time means muted until unmuted
+	lock  sync.Mutex
+}
+
+// now is swapped in tests
+var now = time.Now
+
+func NewManager(dbPath string) (*Manager, error) {
+	db, err := sqlx.Connect("sqlite3", dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %w", err)
+	}
+
+	if err := createSchema(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+	return &Manager{
+		db:    db,
+		mutes: make(map[string]time.Time),
+	}, nil
+}
+
+func (m *Manager) Close() error {
+	return m.db.Close()
+}
+
+// Mutes
+// -----------------------------
+
+// Mute stops the user from sending messages for the given duration, zero mutes until Unmute is called
+func (m *Manager) Mute(username string, duration time.Duration) time.Time {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var until time.Time
+	if duration > 0 {
+		until = now().Add(duration)
+	}
+	m.mutes[username] = until
+	return until
+}
+
+func (m *Manager) Unmute(username string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	_, muted := m.mutes[username]
+	delete(m.mutes, username)
+	return muted
+}
+
+// MutedUntil reports whether the user is muted and until when, zero time means indefinitely
+func (m *Manager) MutedUntil(username string) (time.Time, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	until, exists := m.mutes[username]
+	if !exists {
+		return time.Time{}, false
+	}
+	if !until.IsZero() && !now().Before(until) {
+		delete(m.mutes, username)
+		return time.Time{}, false
+	}
+	return until, true
+}
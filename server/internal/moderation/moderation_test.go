@@ -0,0 +1,143 @@
+package moderation
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestManager(t *testing.T) *Manager {
+	m, err := NewManager(filepath.Join(t.TempDir(), "moderation_test.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+func TestMutes(t *testing.T) {
+	current := time.Unix(1000, 0)
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+
+	t.Run("should lift mutes once they expire", func(t *testing.T) {
+		m := newTestManager(t)
+		until := m.Mute("Oz", time.Minute)
+
+		mutedUntil, muted := m.MutedUntil("Oz")
+		assert.True(t, muted)
+		assert.Equal(t, until, mutedUntil)
+
+		current = current.Add(2 * time.Minute)
+		_, muted = m.MutedUntil("Oz")
+		assert.False(t, muted)
+	})
+
+	t.Run("should keep indefinite mutes until unmuted", func(t *testing.T) {
+		m := newTestManager(t)
+		m.Mute("Oz", 0)
+
+		current = current.Add(24 * time.Hour)
+		_, muted := m.MutedUntil("Oz")
+		assert.True(t, muted)
+
+		assert.True(t, m.Unmute("Oz"))
+		_, muted = m.MutedUntil("Oz")
+		assert.False(t, muted)
+		assert.False(t, m.Unmute("Oz"))
+	})
+}
+
+func TestBans(t *testing.T) {
+	current := time.Unix(1000, 0)
+	now = func() time.Time { return current }
+	defer func() { now = time.Now }()
+
+	t.Run("should find bans by username or ip until they expire", func(t *testing.T) {
+		m := newTestManager(t)
+		_, err := m.Ban("Oz", "spam", "admin", time.Hour, "10.0.0.1", "10.0.0.2")
+		require.NoError(t, err)
+
+		ban, banned, err := m.ActiveBan("Oz", "")
+		require.NoError(t, err)
+		assert.True(t, banned)
+		assert.Equal(t, "spam", ban.Reason)
+		assert.Equal(t, time.Unix(1000, 0).Add(time.Hour), ban.ExpiresAt)
+
+		for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+			_, banned, err = m.ActiveBan("sockpuppet", ip)
+			require.NoError(t, err)
+			assert.True(t, banned, "same ip should be banned")
+		}
+
+		_, banned, err = m.ActiveBan("John", "")
+		require.NoError(t, err)
+		assert.False(t, banned, "empty ip should not match username only bans")
+
+		current = current.Add(time.Hour)
+		_, banned, err = m.ActiveBan("Oz", "10.0.0.1")
+		require.NoError(t, err)
+		assert.False(t, banned)
+	})
+
+	t.Run("should survive a restart", func(t *testing.T) {
+		dbPath := filepath.Join(t.TempDir(), "moderation_test.db")
+		m, err := NewManager(dbPath)
+		require.NoError(t, err)
+		_, err = m.Ban("Oz", "", "admin", 0)
+		require.NoError(t, err)
+		require.NoError(t, m.Close())
+
+		m, err = NewManager(dbPath)
+		require.NoError(t, err)
+		defer m.Close()
+
+		ban, banned, err := m.ActiveBan("Oz", "")
+		require.NoError(t, err)
+		assert.True(t, banned)
+		assert.True(t, ban.Permanent())
+	})
+
+	t.Run("should replace, list and lift bans", func(t *testing.T) {
+		m := newTestManager(t)
+		_, err := m.Ban("Oz", "first", "admin", time.Minute)
+		require.NoError(t, err)
+		_, err = m.Ban("Oz", "second", "admin", time.Hour)
+		require.NoError(t, err)
+		_, err = m.Ban("John", "", "admin", time.Minute)
+		require.NoError(t, err)
+
+		bans, err := m.Bans()
+		require.NoError(t, err)
+		require.Len(t, bans, 2)
+		assert.Equal(t, "second", bans[0].Reason)
+
+		lifted, err := m.Unban("Oz")
+		require.NoError(t, err)
+		assert.True(t, lifted)
+		lifted, err = m.Unban("Oz")
+		require.NoError(t, err)
+		assert.False(t, lifted)
+
+		current = current.Add(2 * time.Minute)
+		bans, err = m.Bans()
+		require.NoError(t, err)
+		assert.Empty(t, bans)
+	})
+
+	t.Run("should tell the user why and until when", func(t *testing.T) {
+		assert.Equal(t, "You are banned permanently. Reason: spam", Ban{Reason: "spam"}.Message())
+		assert.Equal(t, "You are banned until 2024-01-02 03:04:05", Ban{ExpiresAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)}.Message())
+	})
+
+	t.Run("should keep frame separators out of the reason", func(t *testing.T) {
+		m := newTestManager(t)
+		_, err := m.Ban("oz", "spam | links", "admin", 0)
+		require.NoError(t, err)
+
+		ban, _, err := m.ActiveBan("oz", "")
+		require.NoError(t, err)
+		assert.Equal(t, "You are banned permanently. Reason: spam / links", ban.Message())
+	})
+}
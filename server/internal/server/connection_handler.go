@@ -2,12 +2,16 @@ package server
 
 import (
 	"bufio"
+	"errors"
+	"fmt"
 	"log"
 	"net"
+	"time"
 
 	"github.com/ogzhanolguncu/go-chat/protocol"
 	"github.com/ogzhanolguncu/go-chat/server/internal/auth"
 	"github.com/ogzhanolguncu/go-chat/server/internal/connection"
+	"github.com/ogzhanolguncu/go-chat/server/internal/session"
 )
 
 type ConnectionHandler struct {
@@ -17,6 +21,7 @@ type ConnectionHandler struct {
 	encodeFn       func(payload protocol.Payload) string
 	decodeFn       func(message string) (protocol.Payload, error)
 	connectionInfo *connection.ConnectionInfo
+	resumedSession *session.Session // Set when the client authenticated by resuming a session
 }
 
 func NewConnectionHandler(conn net.Conn, server *TCPServer) *ConnectionHandler {
@@ -36,6 +41,10 @@ func NewConnectionHandler(conn net.Conn, server *TCPServer) *ConnectionHandler {
 func (ch *ConnectionHandler) Handle() {
 	defer ch.conn.Close()
 
+	// Added before login, clients already ping from the login screen
+	ch.server.backgroundLimiter.Add(ch.conn)
+	defer ch.server.backgroundLimiter.Remove(ch.conn)
+
 	if !ch.authenticate() {
 		return
 	}
@@ -43,14 +52,24 @@ func (ch *ConnectionHandler) Handle() {
 	ch.server.OnClientJoin(ch.connectionInfo)
 	defer ch.server.OnClientLeave(ch.connectionInfo)
 
+	if ch.resumedSession != nil {
+		ch.server.restoreSession(ch.connectionInfo, *ch.resumedSession)
+	}
+
 	ch.handleMessages()
 }
 
 // handleMessages continuously reads and processes incoming messages
 func (ch *ConnectionHandler) handleMessages() {
 	for {
+		ch.refreshReadDeadline()
 		message, err := ch.reader.ReadString('\n')
 		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				log.Printf("Client timed out '%s': no frame within %s\n", ch.connectionInfo.OwnerName, ch.server.heartbeatTimeout)
+				break
+			}
 			log.Printf("Client left the chat '%s': %v\n", ch.connectionInfo.OwnerName, err)
 			break
 		}
@@ -64,6 +83,7 @@ func (ch *ConnectionHandler) handleMessages() {
 
 func (ch *ConnectionHandler) authenticate() bool {
 	for {
+		ch.refreshReadDeadline()
 		data, err := ch.reader.ReadString('\n')
 		if err != nil {
 			log.Printf("User closed connection during auth: %v", err)
@@ -77,16 +97,59 @@ func (ch *ConnectionHandler) authenticate() bool {
 			continue
 		}
 
-		authenticated, err := ch.server.authManager.AuthenticateUser(payload.Username, payload.Password)
+		// Clients keep pinging while the login screen is open
+		if payload.MessageType == protocol.MessageTypePING {
+			if ch.server.allowBackground(ch.conn, payload) {
+				ch.sendPong()
+			}
+			continue
+		}
+
+		if payload.MessageType == protocol.MessageTypeSESS {
+			if ch.resumeSession(payload) {
+				return true
+			}
+			continue
+		}
+
+		var authenticated bool
+		switch payload.Status {
+		case protocol.AuthActionRegister:
+			err = ch.server.authManager.RegisterUser(payload.Username, payload.Password)
+			authenticated = err == nil
+		default:
+			authenticated, err = ch.server.authManager.Login(payload.Username, payload.Password, remoteIP(ch.conn))
+		}
 		if err != nil {
 			ch.handleAuthError(err)
 			continue
 		}
 
 		if authenticated {
+			ban, banned, err := ch.server.moderationManager.ActiveBan(payload.Username, remoteIP(ch.conn))
+			if err != nil {
+				// Fail closed, a ban we can't check might still be there
+				log.Printf("Failed to check bans of '%s': %v", payload.Username, err)
+				ch.sendAuthResponse("Authentication failed", "fail")
+				continue
+			}
+			if banned {
+				ch.sendSysMessage(ban.Message(), "fail")
+				ch.sendAuthResponse(ban.Message(), "fail")
+				continue
+			}
+			token, sess, err := ch.server.sessionManager.Issue(payload.Username)
+			if err != nil {
+				log.Printf("Failed to issue session for '%s': %v", payload.Username, err)
+			}
 			ch.connectionInfo = &connection.ConnectionInfo{
 				Connection: ch.conn,
 				OwnerName:  payload.Username,
+				SessionID:  sess.ID,
+			}
+			// Token goes out first, so the client has it by the time it leaves the login screen
+			if token != "" {
+				ch.sendSessionResponse(payload.Username, token, protocol.SessionStatusIssued)
 			}
 			ch.sendAuthResponse(payload.Username, "success")
 			return true
@@ -96,6 +159,71 @@ func (ch *ConnectionHandler) authenticate() bool {
 	}
 }
 
+// refreshReadDeadline gives the client another heartbeat timeout to send its next frame, so half-open connections get dropped
+func (ch *ConnectionHandler) refreshReadDeadline() {
+	if ch.server.heartbeatTimeout > 0 {
+		ch.conn.SetReadDeadline(time.Now().Add(ch.server.heartbeatTimeout))
+	}
+}
+
+// resumeSession authenticates the client with a session token from an earlier connection
+func (ch *ConnectionHandler) resumeSession(payload protocol.Payload) bool {
+	if payload.Status != protocol.SessionStatusResume {
+		ch.sendSessionResponse("", "", protocol.SessionStatusFail)
+		return false
+	}
+
+	token, sess, err := ch.server.sessionManager.Resume(payload.SessionToken)
+	if err != nil {
+		log.Printf("Failed to resume session of '%s': %v", payload.Username, err)
+		ch.sendSessionResponse("", "", protocol.SessionStatusFail)
+		return false
+	}
+	ban, banned, err := ch.server.moderationManager.ActiveBan(sess.Username, remoteIP(ch.conn))
+	if err != nil {
+		log.Printf("Failed to check bans of '%s': %v", sess.Username, err)
+		ch.sendSessionResponse("", "", protocol.SessionStatusFail)
+		return false
+	}
+	if banned {
+		ch.sendSysMessage(ban.Message(), "fail")
+		ch.sendSessionResponse("", "", protocol.SessionStatusFail)
+		return false
+	}
+
+	ch.connectionInfo = &connection.ConnectionInfo{
+		Connection: ch.conn,
+		OwnerName:  sess.Username,
+		SessionID:  sess.ID,
+	}
+	ch.resumedSession = &sess
+	ch.sendSessionResponse(sess.Username, token, protocol.SessionStatusIssued)
+	return true
+}
+
+func (ch *ConnectionHandler) sendSessionResponse(username, token, status string) {
+	msg := ch.encodeFn(protocol.Payload{
+		MessageType:  protocol.MessageTypeSESS,
+		Username:     username,
+		SessionToken: token,
+		Status:       status,
+	})
+	ch.conn.Write([]byte(msg))
+}
+
+func (ch *ConnectionHandler) sendPong() {
+	ch.conn.Write([]byte(ch.encodeFn(protocol.Payload{MessageType: protocol.MessageTypePONG})))
+}
+
+// sendSysMessage sends a system notice before the client is authenticated, e.g. why a login was refused
+func (ch *ConnectionHandler) sendSysMessage(message, status string) {
+	ch.conn.Write([]byte(ch.encodeFn(protocol.Payload{
+		MessageType: protocol.MessageTypeSYS,
+		Content:     message,
+		Status:      status,
+	})))
+}
+
 func (ch *ConnectionHandler) sendAuthResponse(message, status string) {
 	msg := ch.encodeFn(protocol.Payload{
 		MessageType: protocol.MessageTypeUSR,
@@ -108,13 +236,37 @@ func (ch *ConnectionHandler) sendAuthResponse(message, status string) {
 // handleAuthError processes authentication errors and sends appropriate mapped responses
 func (ch *ConnectionHandler) handleAuthError(err error) {
 	var message string
-	switch err {
-	case auth.ErrWeakPassword:
+	var throttledErr *auth.LoginThrottledError
+	switch {
+	case errors.Is(err, auth.ErrWeakPassword):
 		message = "Password does not meet strength requirements"
-	case auth.ErrInvalidUsername:
+	case errors.Is(err, auth.ErrInvalidUsername):
 		message = "Username must be at least 2 characters long"
+	case errors.Is(err, auth.ErrReservedUsername):
+		message = "Username is reserved"
+	case errors.Is(err, auth.ErrUserExists):
+		message = "Username is already taken"
+	case errors.Is(err, auth.ErrRegistrationClosed):
+		message = "Registration is invite only"
+	case errors.As(err, &throttledErr) && throttledErr.Locked:
+		message = fmt.Sprintf("Too many failed attempts. Account locked until %s", throttledErr.Until.Format("15:04:05"))
+	case errors.As(err, &throttledErr):
+		message = fmt.Sprintf("Too many failed attempts. Try again in %s", throttledErr.RetryAfter())
 	default:
 		message = "Authentication failed"
 	}
 	ch.sendAuthResponse(message, "fail")
 }
+
+// remoteIP returns the IP part of the connection's remote address, or empty string if unknown
+func remoteIP(conn net.Conn) string {
+	addr := conn.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -3,21 +3,50 @@ package server
 import (
 	"bytes"
 	"net"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/ogzhanolguncu/go-chat/protocol"
+	chat_ratelimit "github.com/ogzhanolguncu/go-chat/ratelimit"
 	"github.com/ogzhanolguncu/go-chat/server/internal/auth"
 	"github.com/ogzhanolguncu/go-chat/server/internal/block_user"
 	"github.com/ogzhanolguncu/go-chat/server/internal/chat_history"
 	"github.com/ogzhanolguncu/go-chat/server/internal/connection"
+	"github.com/ogzhanolguncu/go-chat/server/internal/moderation"
+	"github.com/ogzhanolguncu/go-chat/server/internal/session"
 	"github.com/stretchr/testify/assert"
 )
 
 // TestConn implements net.Conn for testing
 type TestConn struct {
 	ReadBuffer  *bytes.Buffer
-	WriteBuffer *bytes.Buffer
+	WriteBuffer *syncBuffer
+}
+
+// syncBuffer lets tests read what was written while notices are still broadcast in the background
+type syncBuffer struct {
+	buf bytes.Buffer
+	mu  sync.Mutex
+}
+
+func (sb *syncBuffer) Write(b []byte) (int, error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.buf.Write(b)
+}
+
+func (sb *syncBuffer) String() string {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.buf.String()
+}
+
+func (sb *syncBuffer) Reset() {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	sb.buf.Reset()
 }
 
 func (tc *TestConn) Read(b []byte) (n int, err error)   { return tc.ReadBuffer.Read(b) }
@@ -49,24 +78,66 @@ func TestConnectionHandler_Authenticate(t *testing.T) {
 			expectedResult: true,
 			expectedWrite:  "testuser||success\r\n",
 		},
+		{
+			name:  "Successful Authentication - Session Issued",
+			input: "USR|1234567890|sessionuser|Test1234.|login\r\n",
+			setupAuth: func(am *auth.AuthManager) error {
+				return am.AddUser("sessionuser", "Test1234.")
+			},
+			expectedResult: true,
+			expectedWrite:  "|sessionuser|",
+		},
 		{
 			name:           "Failed Authentication",
 			input:          "USR|1234567890|testuser|wrongpassword|\r\n",
 			expectedResult: false,
 			expectedWrite:  "Authentication failed||fail\r\n",
 		},
+		{
+			name:           "Failed Authentication - Unknown User",
+			input:          "USR|1234567890|unknownuser|Test1234.|login\r\n",
+			expectedResult: false,
+			expectedWrite:  "Authentication failed||fail\r\n",
+		},
+		{
+			name:           "Successful Registration",
+			input:          "USR|1234567890|newuser|Test1234.|register\r\n",
+			expectedResult: true,
+			expectedWrite:  "newuser||success\r\n",
+		},
+		{
+			name:           "Registration Error - Username Taken",
+			input:          "USR|1234567890|testuser|Test1234.|register\r\n",
+			expectedResult: false,
+			expectedWrite:  "Username is already taken||fail\r\n",
+		},
 		{
 			name:           "Authentication Error - Weak Password",
-			input:          "USR|1234567890|weakuser|weak|\r\n",
+			input:          "USR|1234567890|weakuser|weak|register\r\n",
 			expectedResult: false,
 			expectedWrite:  "Password does not meet strength requirements||fail\r\n",
 		},
 		{
 			name:           "Authentication Error - Short Username",
-			input:          "USR|1234567890|o|Test1234.|\r\n",
+			input:          "USR|1234567890|o|Test1234.|register\r\n",
 			expectedResult: false,
 			expectedWrite:  "Username must be at least 2 characters long||fail\r\n",
 		},
+		{
+			name:  "Heartbeat During Login",
+			input: "PING|1234567890\r\nUSR|1234567890|pinguser|Test1234.|login\r\n",
+			setupAuth: func(am *auth.AuthManager) error {
+				return am.AddUser("pinguser", "Test1234.")
+			},
+			expectedResult: true,
+			expectedWrite:  "PONG|",
+		},
+		{
+			name:           "Failed Session Resume - Invalid Token",
+			input:          "SESS|1234567890|testuser|forged.token|resume\r\n",
+			expectedResult: false,
+			expectedWrite:  "|||fail\r\n",
+		},
 		{
 			name:           "Invalid Data Format",
 			input:          "INVALID|DATA|FORMAT|\r\n",
@@ -78,12 +149,22 @@ func TestConnectionHandler_Authenticate(t *testing.T) {
 	assert.NoError(t, err)
 	defer authManager.Close()
 
+	sessionManager, err := session.NewManager(nil, time.Hour)
+	assert.NoError(t, err)
+
+	moderationManager, err := moderation.NewManager(filepath.Join(t.TempDir(), "moderation_test.db"))
+	assert.NoError(t, err)
+	defer moderationManager.Close()
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			// Setup
+			if tt.setupAuth != nil {
+				assert.NoError(t, tt.setupAuth(authManager))
+			}
 			testConn := &TestConn{
 				ReadBuffer:  bytes.NewBufferString(tt.input),
-				WriteBuffer: &bytes.Buffer{},
+				WriteBuffer: &syncBuffer{},
 			}
 
 			// Create actual components
@@ -101,9 +182,16 @@ func TestConnectionHandler_Authenticate(t *testing.T) {
 				historyManager:    historyManager,
 				authManager:       authManager,
 				blockUserManager:  blockUserManager,
-				encodeFn:          protocol.InitEncodeProtocol(false),
-				decodeFn:          protocol.InitDecodeProtocol(false),
+				sessionManager:    sessionManager,
+				moderationManager: moderationManager,
+				backgroundLimiter: chat_ratelimit.NewConnLimiter(func() chat_ratelimit.Limiter {
+					return chat_ratelimit.NewTokenBucketLimiter(20, 2, time.Second, chat_ratelimit.RealClock)
+				}),
+				costTable: chat_ratelimit.DefaultCostTable(),
+				encodeFn:  protocol.InitEncodeProtocol(false),
+				decodeFn:  protocol.InitDecodeProtocol(false),
 			}
+			server.backgroundLimiter.Add(testConn)
 
 			handler := NewConnectionHandler(testConn, server)
 
@@ -116,3 +204,95 @@ func TestConnectionHandler_Authenticate(t *testing.T) {
 		})
 	}
 }
+
+func TestConnectionHandler_ResumeSession(t *testing.T) {
+	dbPath := ":memory:"
+
+	authManager, err := auth.NewAuthManager(dbPath)
+	assert.NoError(t, err)
+	defer authManager.Close()
+
+	sessionManager, err := session.NewManager(nil, time.Hour)
+	assert.NoError(t, err)
+	token, issued, err := sessionManager.Issue("testuser")
+	assert.NoError(t, err)
+
+	encodeFn := protocol.InitEncodeProtocol(false)
+	historyDBPath := filepath.Join(t.TempDir(), "history_test.db")
+	// Storing messages looks up the sender's blocks
+	blockUserManager, err := block_user.NewBlockUserManager(historyDBPath)
+	assert.NoError(t, err)
+	defer blockUserManager.Close()
+	historyManager, err := chat_history.NewChatHistory(false, historyDBPath)
+	assert.NoError(t, err)
+	defer historyManager.Close()
+	assert.NoError(t, historyManager.AddMessage(encodeFn(protocol.Payload{MessageType: protocol.MessageTypeMSG, Sender: "jane", Content: "seen before the drop", Timestamp: 1})))
+	lastMessageID, err := historyManager.LastMessageID()
+	assert.NoError(t, err)
+	sessionManager.Suspend(issued.ID, lastMessageID, nil)
+	assert.NoError(t, historyManager.AddMessage(encodeFn(protocol.Payload{MessageType: protocol.MessageTypeMSG, Sender: "jane", Content: "missed while away", Timestamp: 2})))
+
+	moderationManager, err := moderation.NewManager(filepath.Join(t.TempDir(), "moderation_test.db"))
+	assert.NoError(t, err)
+	defer moderationManager.Close()
+
+	testConn := &TestConn{
+		ReadBuffer:  bytes.NewBufferString("SESS|1234567890|testuser|" + token + "|resume\r\n"),
+		WriteBuffer: &syncBuffer{},
+	}
+	server := &TCPServer{
+		authManager:       authManager,
+		historyManager:    historyManager,
+		sessionManager:    sessionManager,
+		moderationManager: moderationManager,
+		encodeFn:          encodeFn,
+		decodeFn:          protocol.InitDecodeProtocol(false),
+	}
+
+	handler := NewConnectionHandler(testConn, server)
+
+	assert.True(t, handler.authenticate())
+	assert.Equal(t, "testuser", handler.connectionInfo.OwnerName)
+	assert.Equal(t, issued.ID, handler.connectionInfo.SessionID)
+	assert.NotNil(t, handler.resumedSession)
+
+	response, err := protocol.InitDecodeProtocol(false)(testConn.WriteBuffer.String())
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.MessageTypeSESS, response.MessageType)
+	assert.Equal(t, protocol.SessionStatusIssued, response.Status)
+	assert.Equal(t, "testuser", response.Username)
+	assert.NotEmpty(t, response.SessionToken)
+
+	testConn.WriteBuffer.Reset()
+	server.restoreSession(handler.connectionInfo, *handler.resumedSession)
+	replay, err := protocol.InitDecodeProtocol(false)(testConn.WriteBuffer.String())
+	assert.NoError(t, err)
+	assert.Equal(t, protocol.MessageTypeHSTRY, replay.MessageType)
+	assert.Equal(t, "missed", replay.Status)
+	if assert.Len(t, replay.DecodedChatHistory, 1) {
+		assert.Equal(t, "missed while away", replay.DecodedChatHistory[0].Content)
+	}
+}
+
+func TestConnectionHandler_HeartbeatTimeout(t *testing.T) {
+	serverConn, clientConn := net.Pipe()
+	defer clientConn.Close()
+
+	server := &TCPServer{
+		heartbeatTimeout: 50 * time.Millisecond,
+		encodeFn:         protocol.InitEncodeProtocol(false),
+		decodeFn:         protocol.InitDecodeProtocol(false),
+	}
+	handler := NewConnectionHandler(serverConn, server)
+
+	// Client never sends anything, like a peer that vanished without closing the connection
+	done := make(chan bool)
+	go func() { done <- handler.authenticate() }()
+
+	select {
+	case authenticated := <-done:
+		assert.False(t, authenticated)
+	case <-time.After(time.Second):
+		t.Fatal("silent connection was not dropped")
+	}
+}
@@ -1,16 +1,21 @@
 package server
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net"
-	"slices"
+	"strings"
 	"time"
 
 	"github.com/ogzhanolguncu/go-chat/protocol"
+	"github.com/ogzhanolguncu/go-chat/server/internal/auth"
 	"github.com/ogzhanolguncu/go-chat/server/internal/connection"
 )
 
+// channelHistoryLimit is how many of the latest channel messages are sent on join or /history
+const channelHistoryLimit = 50
+
 type MessageRouter struct {
 	server *TCPServer
 }
@@ -44,6 +49,12 @@ func (mr *MessageRouter) RouteMessage(info *connection.ConnectionInfo, message s
 		mr.handleChatHistory(payload, info)
 	case protocol.MessageTypeACT_USRS:
 		mr.handleActiveUsers(info)
+	case protocol.MessageTypePASSWD:
+		mr.handlePasswordChange(payload, info)
+	case protocol.MessageTypeDEL_ACCT:
+		mr.handleDeleteAccount(payload, info)
+	case protocol.MessageTypeMOD:
+		mr.handleModeration(payload, info)
 	default:
 		log.Printf("Unknown message type received from %s\n", info.Connection.RemoteAddr().String())
 	}
@@ -52,13 +63,29 @@ func (mr *MessageRouter) RouteMessage(info *connection.ConnectionInfo, message s
 // Message Handlers
 // -----------------------------
 func (mr *MessageRouter) handleChannelMessage(payload protocol.Payload, info *connection.ConnectionInfo) {
+	// Every membership and rights check trusts the requester, so it's whoever is logged in, never what the frame claims
+	payload.ChannelPayload.Requester = info.OwnerName
+	if mr.rejectPermanentChannel(payload, info) {
+		return
+	}
 	roomPayload, noticePayload := mr.server.channelManager.Handle(payload)
 	payload.Timestamp = time.Now().Unix()
 	payload.ChannelPayload = &roomPayload
 
+	// Seats freed up by this action go to the waitlist, once the requester has been answered
+	if freesSeats(roomPayload) {
+		defer mr.admitWaitlisted(roomPayload.ChannelName)
+	}
+
 	if payload.ChannelPayload.ChannelAction == protocol.TypingChannel && payload.ChannelPayload.OptionalChannelArgs.Status == protocol.StatusSuccess {
+		// Blocked and blocking members don't see each other typing, not worth an error if that can't be looked up
+		excludedConns, err := mr.getExcludedConnections(info.Connection)
+		if err != nil {
+			log.Printf("Failed to get blocked users of '%s': %v", info.OwnerName, err)
+			return
+		}
 		roomMsg := []byte(mr.server.encodeFn(payload))
-		mr.broadcastToUsers(roomMsg, payload.ChannelPayload.OptionalChannelArgs.Users, info.Connection)
+		mr.broadcastToUsers(roomMsg, payload.ChannelPayload.OptionalChannelArgs.Users, excludedConns)
 		return
 	}
 	if payload.ChannelPayload.ChannelAction == protocol.TypingChannel && payload.ChannelPayload.OptionalChannelArgs.Status == protocol.StatusFail {
@@ -71,8 +98,17 @@ func (mr *MessageRouter) handleChannelMessage(payload protocol.Payload, info *co
 		noticePayloadCopy.ChannelPayload = &noticePayload
 
 		if noticePayloadCopy.ChannelPayload.ChannelAction == protocol.NoticeChannel {
+			excludedConns := connSet{info.Connection: {}}
+			// Join and leave notices are about the requester, so blocks apply. Moderation notices reach every member.
+			if action := roomPayload.ChannelAction; action == protocol.JoinChannel || action == protocol.LeaveChannel {
+				var err error
+				if excludedConns, err = mr.getExcludedConnections(info.Connection); err != nil {
+					log.Printf("Failed to get blocked users of '%s': %v", info.OwnerName, err)
+					return
+				}
+			}
 			roomMsg := []byte(mr.server.encodeFn(noticePayloadCopy))
-			mr.broadcastToUsers(roomMsg, noticePayloadCopy.ChannelPayload.OptionalChannelArgs.Users, info.Connection)
+			mr.broadcastToUsers(roomMsg, noticePayloadCopy.ChannelPayload.OptionalChannelArgs.Users, excludedConns)
 		}
 	}()
 
@@ -85,9 +121,50 @@ func (mr *MessageRouter) handleChannelMessage(payload protocol.Payload, info *co
 		}
 	}()
 
+	isSuccess := payload.ChannelPayload.OptionalChannelArgs.Status == protocol.StatusSuccess
+	if payload.ChannelPayload.ChannelAction == protocol.HistoryChannel && isSuccess {
+		mr.sendChannelHistory(info, payload.ChannelPayload.ChannelName)
+		return
+	}
+
 	if payload.ChannelPayload.ChannelAction == protocol.MessageChannel {
+		if !isSuccess {
+			// Muted, slowed down or not a member, only the requester has to know
+			writeToAConn(mr, payload, info.Connection)
+			return
+		}
+		excludedConns, err := mr.getExcludedConnections(info.Connection)
+		if err != nil {
+			mr.sendSysResponse(info.Connection, fmt.Sprintf("Error preparing channel message: %v", err), "fail")
+			return
+		}
+		err = mr.server.historyManager.AddChannelMessage(payload.ChannelPayload.ChannelName, info.OwnerName, payload.ChannelPayload.OptionalChannelArgs.Message, payload.Timestamp)
+		if err != nil {
+			log.Printf("Failed to store channel message: %v", err)
+		}
 		roomMsg := []byte(mr.server.encodeFn(payload))
-		mr.broadcastToUsers(roomMsg, payload.ChannelPayload.OptionalChannelArgs.Users, info.Connection)
+		mr.broadcastToUsers(roomMsg, payload.ChannelPayload.OptionalChannelArgs.Users, excludedConns)
+		return
+	}
+
+	if payload.ChannelPayload.ChannelAction == protocol.InviteUser && isSuccess {
+		// Requester gets the code either way, the invited user on every device they're connected from
+		// unless one of them blocked the other
+		writeToAConn(mr, payload, info.Connection)
+		target := roomPayload.OptionalChannelArgs.TargetUser
+		if target == "" {
+			return
+		}
+		excludedConns, err := mr.getExcludedConnections(info.Connection)
+		if err != nil {
+			log.Printf("Failed to get blocked users of '%s': %v", info.OwnerName, err)
+			return
+		}
+		for _, userConn := range mr.server.connectionManager.FindConnectionsByOwnerName(target) {
+			if !excludedConns.contains(userConn) {
+				writeToAConn(mr, payload, userConn)
+			}
+		}
 		return
 	}
 
@@ -97,16 +174,124 @@ func (mr *MessageRouter) handleChannelMessage(payload protocol.Payload, info *co
 		if payload.ChannelPayload.OptionalChannelArgs.Status == protocol.StatusFail {
 			user = payload.ChannelPayload.Requester
 		}
-		userConn, found := mr.server.connectionManager.FindConnectionByOwnerName(user)
-		//Skip if connection not found
-		if !found {
-			return
+		// Every device of the user has to know, skipped if user is not connected
+		for _, userConn := range mr.server.connectionManager.FindConnectionsByOwnerName(user) {
+			writeToAConn(mr, payload, userConn)
 		}
-		writeToAConn(mr, payload, userConn)
 		return
 	}
 
 	writeToAConn(mr, payload, info.Connection)
+	// History goes out after the join response, so the client has already opened the channel tab
+	if payload.ChannelPayload.ChannelAction == protocol.JoinChannel && isSuccess {
+		mr.sendChannelHistory(info, payload.ChannelPayload.ChannelName)
+	}
+}
+
+// handleChannelDisconnect tells channel members about changes caused by a user's last connection dropping,
+// seats they gave up go to the waitlist
+func (mr *MessageRouter) handleChannelDisconnect(username string) {
+	for _, notice := range mr.server.channelManager.MemberDisconnected(username) {
+		noticePayload := notice
+		noticeMsg := []byte(mr.server.encodeFn(protocol.Payload{MessageType: protocol.MessageTypeCH, Timestamp: time.Now().Unix(), ChannelPayload: &noticePayload}))
+		mr.broadcastToUsers(noticeMsg, noticePayload.OptionalChannelArgs.Users, connSet{})
+		mr.admitWaitlisted(noticePayload.ChannelName)
+	}
+}
+
+func freesSeats(chPayload protocol.ChannelPayload) bool {
+	if chPayload.OptionalChannelArgs == nil || chPayload.OptionalChannelArgs.Status != protocol.StatusSuccess {
+		return false
+	}
+	switch chPayload.ChannelAction {
+	case protocol.LeaveChannel, protocol.KickUser, protocol.BanUser, protocol.ResizeChannel:
+		return true
+	}
+	return false
+}
+
+// admitWaitlisted lets queued users into the channel, they're answered like any other join and the members are told
+func (mr *MessageRouter) admitWaitlisted(chName string) {
+	for _, admission := range mr.server.channelManager.AdmitWaitlisted(chName) {
+		joinPayload := admission.Join
+		conns := mr.server.connectionManager.FindConnectionsByOwnerName(joinPayload.Requester)
+		for _, conn := range conns {
+			writeToAConn(mr, protocol.Payload{MessageType: protocol.MessageTypeCH, Timestamp: time.Now().Unix(), ChannelPayload: &joinPayload}, conn)
+			if info, ok := mr.server.connectionManager.GetConnectionInfo(conn); ok {
+				mr.sendChannelHistory(info, chName)
+			}
+		}
+		if len(conns) == 0 {
+			continue
+		}
+
+		excludedConns, err := mr.getExcludedConnections(conns[0])
+		if err != nil {
+			log.Printf("Failed to get blocked users of '%s': %v", joinPayload.Requester, err)
+			continue
+		}
+		for _, conn := range conns {
+			excludedConns[conn] = struct{}{}
+		}
+		noticePayload := admission.Notice
+		noticeMsg := []byte(mr.server.encodeFn(protocol.Payload{MessageType: protocol.MessageTypeCH, Timestamp: time.Now().Unix(), ChannelPayload: &noticePayload}))
+		mr.broadcastToUsers(noticeMsg, noticePayload.OptionalChannelArgs.Users, excludedConns)
+	}
+}
+
+// sendChannelHistory sends the latest messages of the channel as a HSTRY frame with "channel" status
+func (mr *MessageRouter) sendChannelHistory(info *connection.ConnectionInfo, chName string) {
+	createdAt, exists := mr.server.channelManager.CreatedAt(chName)
+	if !exists {
+		return
+	}
+	excludedSenders, err := mr.getBlockRelatedUsers(info.OwnerName)
+	if err != nil {
+		log.Printf("Failed to get blocked users of '%s': %v", info.OwnerName, err)
+		mr.sendSysResponse(info.Connection, "Channel history not available", "fail")
+		return
+	}
+	history, err := mr.server.historyManager.GetChannelHistory(chName, createdAt, channelHistoryLimit, excludedSenders)
+	if err != nil {
+		log.Printf("Failed to get history of channel '%s': %v", chName, err)
+		mr.sendSysResponse(info.Connection, "Channel history not available", "fail")
+		return
+	}
+
+	historyMsg := mr.server.encodeFn(protocol.Payload{
+		MessageType:        protocol.MessageTypeHSTRY,
+		Sender:             info.OwnerName,
+		EncodedChatHistory: history,
+		Status:             "channel",
+	})
+	if _, err := info.Connection.Write([]byte(historyMsg)); err != nil {
+		log.Printf("Failed to send channel history: %v", err)
+	}
+}
+
+// rejectPermanentChannel answers with a failed create if a non-admin asks for a permanent channel, returns false otherwise
+func (mr *MessageRouter) rejectPermanentChannel(payload protocol.Payload, info *connection.ConnectionInfo) bool {
+	chPayload := payload.ChannelPayload
+	if chPayload.ChannelAction != protocol.CreateChannel || chPayload.OptionalChannelArgs == nil || !chPayload.OptionalChannelArgs.Permanent {
+		return false
+	}
+	role, err := mr.server.authManager.GetRole(info.OwnerName)
+	if err != nil {
+		log.Printf("Failed to get role of '%s': %v", info.OwnerName, err)
+	}
+	if err == nil && role.AtLeast(auth.RoleAdmin) {
+		return false
+	}
+
+	rejected := *chPayload
+	rejected.OptionalChannelArgs = &protocol.OptionalChannelArgs{
+		Status: protocol.StatusFail,
+		Reason: "Only admins can create permanent channels.",
+	}
+	payload.Timestamp = time.Now().Unix()
+	payload.ChannelPayload = &rejected
+	writeToAConn(mr, payload, info.Connection)
+	return true
 }
 
 func (mr *MessageRouter) handleGroupMessage(payload protocol.Payload, info *connection.ConnectionInfo) {
@@ -117,12 +302,12 @@ func (mr *MessageRouter) handleGroupMessage(payload protocol.Payload, info *conn
 	}
 
 	msg := []byte(mr.server.encodeFn(payload))
-	mr.broadcastToAll(msg, "Error broadcasting message", excludedConns...)
+	mr.broadcastToAll(msg, "Error broadcasting message", excludedConns)
 }
 
 func (mr *MessageRouter) handleWhisper(payload protocol.Payload, info *connection.ConnectionInfo) {
-	recipientConn, found := mr.server.connectionManager.FindConnectionByOwnerName(payload.Recipient)
-	if !found || recipientConn == nil {
+	recipientConns := mr.server.connectionManager.FindConnectionsByOwnerName(payload.Recipient)
+	if len(recipientConns) == 0 {
 		mr.sendSysResponse(info.Connection, "Recipient not found or connection lost", "fail")
 		return
 	}
@@ -133,9 +318,13 @@ func (mr *MessageRouter) handleWhisper(payload protocol.Payload, info *connectio
 		return
 	}
 
-	if !containsConnection(excludedConns, recipientConn) {
-		_, err := recipientConn.Write([]byte(mr.server.encodeFn(payload)))
-		if err != nil {
+	// Whisper reaches every device the recipient is connected from
+	msg := []byte(mr.server.encodeFn(payload))
+	for _, recipientConn := range recipientConns {
+		if excludedConns.contains(recipientConn) {
+			continue
+		}
+		if _, err := recipientConn.Write(msg); err != nil {
 			log.Println("Error sending whisper:", err)
 		}
 	}
@@ -185,47 +374,321 @@ func (mr *MessageRouter) handleActiveUsers(info *connection.ConnectionInfo) {
 	mr.server.sendActiveUsers(info.Connection)
 }
 
+// Account Handlers
+// -----------------------------
+
+func (mr *MessageRouter) handlePasswordChange(payload protocol.Payload, info *connection.ConnectionInfo) {
+	err := mr.server.authManager.ChangePassword(info.OwnerName, payload.Password, payload.NewPassword)
+	if err != nil {
+		log.Printf("Failed to change password of '%s': %v", info.OwnerName, err)
+		mr.sendSysResponse(info.Connection, accountErrorMessage(err, "Could not change password"), "fail")
+		return
+	}
+
+	// Tokens issued before the change shouldn't keep working, except for the device that made it
+	mr.server.sessionManager.RevokeOthers(info.OwnerName, info.SessionID)
+	mr.sendSysResponse(info.Connection, "Password changed successfully", "success")
+}
+
+func (mr *MessageRouter) handleDeleteAccount(payload protocol.Payload, info *connection.ConnectionInfo) {
+	if err := mr.server.authManager.DeleteUser(info.OwnerName, payload.Password); err != nil {
+		log.Printf("Failed to delete account of '%s': %v", info.OwnerName, err)
+		mr.sendSysResponse(info.Connection, accountErrorMessage(err, "Could not delete account"), "fail")
+		return
+	}
+
+	// Account is gone at this point, cleanup failures are only logged
+	if err := mr.server.blockUserManager.DeleteUser(info.OwnerName); err != nil {
+		log.Printf("Failed to remove blocks of deleted user '%s': %v", info.OwnerName, err)
+	}
+	if err := mr.server.historyManager.AnonymizeUser(info.OwnerName, auth.DeletedUsername); err != nil {
+		log.Printf("Failed to anonymize history of deleted user '%s': %v", info.OwnerName, err)
+	}
+	for _, chName := range mr.server.channelManager.UserChannels(info.OwnerName) {
+		mr.handleChannelMessage(protocol.Payload{
+			MessageType: protocol.MessageTypeCH,
+			ChannelPayload: &protocol.ChannelPayload{
+				ChannelAction: protocol.LeaveChannel,
+				Requester:     info.OwnerName,
+				ChannelName:   chName,
+			},
+		}, info)
+	}
+	// Whoever registers the name next mustn't inherit moderator rights, ownership, mutes or invites
+	if err := mr.server.channelManager.DeleteUser(info.OwnerName, auth.DeletedUsername); err != nil {
+		log.Printf("Failed to remove channel data of deleted user '%s': %v", info.OwnerName, err)
+	}
+	mr.disconnectUser(info.OwnerName, "Your account has been deleted", "success")
+}
+
+// accountErrorMessage maps account management errors to messages safe to show the user
+func accountErrorMessage(err error, fallback string) string {
+	var throttledErr *auth.LoginThrottledError
+	switch {
+	case errors.Is(err, auth.ErrAuthenticationFailed):
+		return "Current password is incorrect"
+	case errors.As(err, &throttledErr) && throttledErr.Locked:
+		return fmt.Sprintf("Too many failed attempts. Account locked until %s", throttledErr.Until.Format("15:04:05"))
+	case errors.As(err, &throttledErr):
+		return fmt.Sprintf("Too many failed attempts. Try again in %s", throttledErr.RetryAfter())
+	case errors.Is(err, auth.ErrWeakPassword):
+		return "New password does not meet strength requirements"
+	default:
+		return fallback
+	}
+}
+
+// Moderation Handlers
+// -----------------------------
+
+func (mr *MessageRouter) handleModeration(payload protocol.Payload, info *connection.ConnectionInfo) {
+	actorRole, err := mr.server.authManager.GetRole(info.OwnerName)
+	if err != nil {
+		log.Printf("Failed to get role of '%s': %v", info.OwnerName, err)
+		mr.sendSysResponse(info.Connection, "Could not verify your role", "fail")
+		return
+	}
+	requiredRole := auth.RoleModerator
+	if payload.Status == protocol.ModActionRole {
+		requiredRole = auth.RoleAdmin
+	}
+	if !actorRole.AtLeast(requiredRole) {
+		mr.sendSysResponse(info.Connection, "You are not allowed to do that", "fail")
+		return
+	}
+
+	// Listing bans has no target
+	if payload.Status == protocol.ModActionBans {
+		mr.sendBanList(info.Connection)
+		return
+	}
+
+	target := payload.Recipient
+	targetRole, err := mr.server.authManager.GetRole(target)
+	if errors.Is(err, auth.ErrNoSuchUser) {
+		mr.sendSysResponse(info.Connection, fmt.Sprintf("User '%s' does not exist", target), "fail")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to get role of '%s': %v", target, err)
+		mr.sendSysResponse(info.Connection, "Could not verify target role", "fail")
+		return
+	}
+	// Moderators can't act on each other, and nobody can act on someone above them
+	if !actorRole.Outranks(targetRole) {
+		mr.sendSysResponse(info.Connection, fmt.Sprintf("You can't moderate '%s'", target), "fail")
+		return
+	}
+
+	switch payload.Status {
+	case protocol.ModActionKick:
+		mr.disconnectUser(target, fmt.Sprintf("You have been kicked by '%s'", info.OwnerName), "fail")
+		mr.sendSysResponse(info.Connection, fmt.Sprintf("'%s' has been kicked", target), "success")
+	case protocol.ModActionMute:
+		duration, err := parseModerationDuration(payload.Content)
+		if err != nil {
+			mr.sendSysResponse(info.Connection, "Mute duration must look like 10m or 2h", "fail")
+			return
+		}
+		until := mr.server.moderationManager.Mute(target, duration)
+		mr.notifyUser(target, mutedMessage(until), "fail")
+		mr.sendSysResponse(info.Connection, fmt.Sprintf("'%s' has been muted", target), "success")
+	case protocol.ModActionUnmute:
+		if !mr.server.moderationManager.Unmute(target) {
+			mr.sendSysResponse(info.Connection, fmt.Sprintf("'%s' is not muted", target), "fail")
+			return
+		}
+		mr.notifyUser(target, "You have been unmuted", "success")
+		mr.sendSysResponse(info.Connection, fmt.Sprintf("'%s' has been unmuted", target), "success")
+	case protocol.ModActionBan, protocol.ModActionBanIP:
+		duration, reason, err := parseBanArgument(payload.Content)
+		if err != nil {
+			mr.sendSysResponse(info.Connection, "Ban duration must look like 10m, 2h or perm", "fail")
+			return
+		}
+		var ips []string
+		if payload.Status == protocol.ModActionBanIP {
+			for _, conn := range mr.server.connectionManager.FindConnectionsByOwnerName(target) {
+				if ip := remoteIP(conn); ip != "" {
+					ips = append(ips, ip)
+				}
+			}
+		}
+		ban, err := mr.server.moderationManager.Ban(target, reason, info.OwnerName, duration, ips...)
+		if err != nil {
+			log.Printf("Failed to ban '%s': %v", target, err)
+			mr.sendSysResponse(info.Connection, "Could not ban user", "fail")
+			return
+		}
+		mr.disconnectUser(target, ban.Message(), "fail")
+		mr.sendSysResponse(info.Connection, fmt.Sprintf("'%s' has been banned", target), "success")
+	case protocol.ModActionUnban:
+		unbanned, err := mr.server.moderationManager.Unban(target)
+		if err != nil {
+			log.Printf("Failed to unban '%s': %v", target, err)
+			mr.sendSysResponse(info.Connection, "Could not unban user", "fail")
+			return
+		}
+		if !unbanned {
+			mr.sendSysResponse(info.Connection, fmt.Sprintf("'%s' is not banned", target), "fail")
+			return
+		}
+		mr.sendSysResponse(info.Connection, fmt.Sprintf("'%s' has been unbanned", target), "success")
+	case protocol.ModActionRole:
+		role, err := auth.ParseRole(payload.Content)
+		if err != nil {
+			mr.sendSysResponse(info.Connection, fmt.Sprintf("Role must be one of '%s', '%s' or '%s'", auth.RoleUser, auth.RoleModerator, auth.RoleAdmin), "fail")
+			return
+		}
+		if err := mr.server.authManager.SetRole(target, role); err != nil {
+			log.Printf("Failed to set role of '%s': %v", target, err)
+			mr.sendSysResponse(info.Connection, "Could not change role", "fail")
+			return
+		}
+		mr.notifyUser(target, fmt.Sprintf("Your role is now '%s'", role), "success")
+		mr.sendSysResponse(info.Connection, fmt.Sprintf("'%s' is now '%s'", target, role), "success")
+	default:
+		mr.sendSysResponse(info.Connection, "Unknown moderation action", "fail")
+	}
+}
+
+// rejectMuted tells a muted user their message was not delivered, returns false if the user isn't muted
+func (mr *MessageRouter) rejectMuted(info *connection.ConnectionInfo) bool {
+	until, muted := mr.server.moderationManager.MutedUntil(info.OwnerName)
+	if !muted {
+		return false
+	}
+	mr.sendSysResponse(info.Connection, mutedMessage(until), "fail")
+	return true
+}
+
+// disconnectUser sends a last notice to every device of the user and closes them.
+// Sessions are revoked too, so the user has to log in again.
+func (mr *MessageRouter) disconnectUser(username, message, status string) {
+	mr.server.sessionManager.Revoke(username)
+	// Closing makes every device of the user go through OnClientLeave
+	for _, conn := range mr.server.connectionManager.FindConnectionsByOwnerName(username) {
+		mr.sendSysResponse(conn, message, status)
+		conn.Close()
+	}
+}
+
+// notifyUser sends a system message to every device of the user
+func (mr *MessageRouter) notifyUser(username, message, status string) {
+	for _, conn := range mr.server.connectionManager.FindConnectionsByOwnerName(username) {
+		mr.sendSysResponse(conn, message, status)
+	}
+}
+
+// parseModerationDuration parses durations like 10m or 2h, empty means no expiry
+func parseModerationDuration(duration string) (time.Duration, error) {
+	if duration == "" {
+		return 0, nil
+	}
+	parsed, err := time.ParseDuration(duration)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("invalid duration '%s'", duration)
+	}
+	return parsed, nil
+}
+
+func mutedMessage(until time.Time) string {
+	if until.IsZero() {
+		return "You are muted"
+	}
+	return fmt.Sprintf("You are muted until %s", until.Format("2006-01-02 15:04:05"))
+}
+
+// parseBanArgument splits "duration [reason]", "perm" or "permanent" bans until lifted
+func parseBanArgument(argument string) (time.Duration, string, error) {
+	durationArg, reason, _ := strings.Cut(strings.TrimSpace(argument), " ")
+	if durationArg == "perm" || durationArg == "permanent" {
+		return 0, strings.TrimSpace(reason), nil
+	}
+	duration, err := parseModerationDuration(durationArg)
+	if err != nil || duration == 0 {
+		return 0, "", fmt.Errorf("invalid ban duration '%s'", durationArg)
+	}
+	return duration, strings.TrimSpace(reason), nil
+}
+
+// sendBanList sends each active ban as a separate system message
+func (mr *MessageRouter) sendBanList(conn net.Conn) {
+	bans, err := mr.server.moderationManager.Bans()
+	if err != nil {
+		log.Printf("Failed to list bans: %v", err)
+		mr.sendSysResponse(conn, "Could not list bans", "fail")
+		return
+	}
+	if len(bans) == 0 {
+		mr.sendSysResponse(conn, "There are no active bans", "success")
+		return
+	}
+	for _, ban := range bans {
+		var sb strings.Builder
+		sb.WriteString(ban.Username)
+		if ban.IP != "" {
+			sb.WriteString(fmt.Sprintf(" (%s)", ban.IP))
+		}
+		if ban.Permanent() {
+			sb.WriteString(" permanently")
+		} else {
+			sb.WriteString(fmt.Sprintf(" until %s", ban.ExpiresAt.Format("2006-01-02 15:04:05")))
+		}
+		sb.WriteString(fmt.Sprintf(" by %s", ban.BannedBy))
+		if ban.Reason != "" {
+			sb.WriteString(fmt.Sprintf(": %s", ban.Reason))
+		}
+		mr.sendSysResponse(conn, sb.String(), "success")
+	}
+}
+
 // User Filtering
 // -----------------------------
 
 // Finds connections to exclude when routing messages. This is used for filtering recipients based on block status and sender.
-func (mr *MessageRouter) getExcludedConnections(sender net.Conn) ([]net.Conn, error) {
+func (mr *MessageRouter) getExcludedConnections(sender net.Conn) (connSet, error) {
 	senderInfo, ok := mr.server.connectionManager.GetConnectionInfo(sender)
 	if !ok {
 		return nil, fmt.Errorf("failed to get sender info")
 	}
 
-	blockedUsers, err := mr.server.blockUserManager.GetBlockedUsers(senderInfo.OwnerName)
+	namesToExclude, err := mr.getBlockRelatedUsers(senderInfo.OwnerName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the sending connection is excluded, so the sender's other devices still see the message
+	excludedConns := connSet{sender: {}}
+	for _, conn := range mr.server.connectionManager.FindConnectionsByOwnerNames(namesToExclude) {
+		excludedConns[conn] = struct{}{}
+	}
+
+	return excludedConns, nil
+}
+
+// getBlockRelatedUsers returns the users the given user blocked and the ones who blocked them
+func (mr *MessageRouter) getBlockRelatedUsers(username string) ([]string, error) {
+	blockedUsers, err := mr.server.blockUserManager.GetBlockedUsers(username)
 	if err != nil {
 		return nil, fmt.Errorf("could not fetch blocked users: %w", err)
 	}
 
-	blockerUsers, err := mr.server.blockUserManager.GetBlockerUsers(senderInfo.OwnerName)
+	blockerUsers, err := mr.server.blockUserManager.GetBlockerUsers(username)
 	if err != nil {
 		return nil, fmt.Errorf("could not fetch blocker users: %w", err)
 	}
 
-	namesToExclude := append(blockedUsers, blockerUsers...)
-	namesToExclude = append(namesToExclude, senderInfo.OwnerName) // Exclude sender
-
-	var excludedConns []net.Conn
-	mr.server.connectionManager.RangeConnections(func(conn net.Conn, info *connection.ConnectionInfo) bool {
-		if contains(namesToExclude, info.OwnerName) {
-			excludedConns = append(excludedConns, conn)
-		}
-		return true
-	})
-
-	return excludedConns, nil
+	return append(blockedUsers, blockerUsers...), nil
 }
 
 // Broadcasting Methods
 // -----------------------------
 
-// broadcastToAll sends a message to all connections except those in the exclude list
-func (mr *MessageRouter) broadcastToAll(b []byte, errLog string, excludeConn ...net.Conn) {
+// broadcastToAll sends a message to all connections except the excluded ones
+func (mr *MessageRouter) broadcastToAll(b []byte, errLog string, excludedConns connSet) {
 	mr.server.connectionManager.RangeConnections(func(conn net.Conn, _ *connection.ConnectionInfo) bool {
-		if !containsConnection(excludeConn, conn) {
+		if !excludedConns.contains(conn) {
 			_, err := conn.Write(b)
 			if err != nil {
 				log.Printf("%s %s\n", errLog, err)
@@ -235,17 +698,16 @@ func (mr *MessageRouter) broadcastToAll(b []byte, errLog string, excludeConn ...
 	})
 }
 
-func (mr *MessageRouter) broadcastToUsers(b []byte, users []string, excludeConn ...net.Conn) {
-	mr.server.connectionManager.RangeConnections(func(conn net.Conn, details *connection.ConnectionInfo) bool {
-		// Exclude initator from broadcast and make sure user is in connection list
-		if !containsConnection(excludeConn, conn) && slices.Contains(users, details.OwnerName) {
-			_, err := conn.Write(b)
-			if err != nil {
-				log.Printf("%s %s\n", "Couldn't send broadcast message", err)
-			}
+// broadcastToUsers sends a message to every connection of the given users except the excluded ones, looked up through the username index
+func (mr *MessageRouter) broadcastToUsers(b []byte, users []string, excludedConns connSet) {
+	for _, conn := range mr.server.connectionManager.FindConnectionsByOwnerNames(users) {
+		if excludedConns.contains(conn) {
+			continue
 		}
-		return true
-	})
+		if _, err := conn.Write(b); err != nil {
+			log.Printf("%s %s\n", "Couldn't send broadcast message", err)
+		}
+	}
 }
 
 // sendSysResponse sends a system response message to a specific connection
@@ -257,17 +719,20 @@ func (mr *MessageRouter) sendSysResponse(conn net.Conn, message, status string)
 	})))
 }
 
+// sendPong answers a heartbeat, proving the server is still there
+func (mr *MessageRouter) sendPong(conn net.Conn) {
+	conn.Write([]byte(mr.server.encodeFn(protocol.Payload{MessageType: protocol.MessageTypePONG})))
+}
+
 // Helper Functions
 // -----------------------------
 
-// containsConnection checks if a given connection is present in a slice of connections
-func containsConnection(slice []net.Conn, conn net.Conn) bool {
-	for _, v := range slice {
-		if v == conn {
-			return true
-		}
-	}
-	return false
+// connSet is a set of connections, used to exclude recipients without scanning slices
+type connSet map[net.Conn]struct{}
+
+func (cs connSet) contains(conn net.Conn) bool {
+	_, found := cs[conn]
+	return found
 }
 
 // Write to a connection mostly used for channel messages
@@ -3,6 +3,7 @@ package server
 import (
 	"fmt"
 	"net"
+	"os"
 	"time"
 
 	"github.com/ogzhanolguncu/go-chat/protocol"
@@ -12,12 +13,45 @@ import (
 	"github.com/ogzhanolguncu/go-chat/server/internal/channels"
 	"github.com/ogzhanolguncu/go-chat/server/internal/chat_history"
 	"github.com/ogzhanolguncu/go-chat/server/internal/connection"
+	"github.com/ogzhanolguncu/go-chat/server/internal/moderation"
+	"github.com/ogzhanolguncu/go-chat/server/internal/session"
 	"github.com/ogzhanolguncu/go-chat/threadpool"
 	"github.com/sirupsen/logrus"
 )
 
 var logger *logrus.Logger
 
+func init() {
+	logger = logrus.New()
+	logger.SetFormatter(&logrus.TextFormatter{
+		ForceColors:   true,
+		FullTimestamp: true,
+	})
+}
+
+// SessionPolicy decides what happens when a user logs in while already connected
+type SessionPolicy string
+
+const (
+	// SessionPolicyMulti keeps every connection, messages are fanned out to all devices of the user
+	SessionPolicyMulti SessionPolicy = "multi"
+	// SessionPolicyKickOlder disconnects older connections of the user when a new one logs in
+	SessionPolicyKickOlder SessionPolicy = "kick-older"
+)
+
+func ParseSessionPolicy(policy string) (SessionPolicy, error) {
+	switch SessionPolicy(policy) {
+	case SessionPolicyMulti, SessionPolicyKickOlder:
+		return SessionPolicy(policy), nil
+	default:
+		return "", fmt.Errorf("unknown session policy '%s', expected '%s' or '%s'", policy, SessionPolicyMulti, SessionPolicyKickOlder)
+	}
+}
+
+// DefaultHeartbeatTimeout is how long a connection may stay silent before it's considered dead.
+// Clients ping well within this window, so only half-open connections hit it.
+const DefaultHeartbeatTimeout = 90 * time.Second
+
 type TCPServer struct {
 	listener net.Listener
 
@@ -26,25 +60,26 @@ type TCPServer struct {
 	authManager       *auth.AuthManager
 	blockUserManager  *block_user.BlockUserManager
 	channelManager    *channels.Manager
+	sessionManager    *session.Manager
+	moderationManager *moderation.Manager
+	sessionPolicy     SessionPolicy
+	queueOptions      connection.QueueOptions
+	heartbeatTimeout  time.Duration // Connections that send nothing for this long are dropped, zero disables it
 
 	messageRouter *MessageRouter
 	encodeFn      func(payload protocol.Payload) string
 	decodeFn      func(message string) (protocol.Payload, error)
 
-	ratelimiter *chat_ratelimit.Ratelimit
-	threadpool  *threadpool.Threadpool
+	ratelimiter       *chat_ratelimit.ConnLimiter
+	backgroundLimiter *chat_ratelimit.ConnLimiter // Budget of frames clients send on their own, e.g. heartbeats and typing indicators
+	costTable         chat_ratelimit.CostTable
+	threadpool        *threadpool.Threadpool
 }
 
 // Server Initialization
 // -----------------------------
 
 func NewServer(port int, dbPath string, encoding bool) (*TCPServer, error) {
-	logger = logrus.New()
-	logger.SetFormatter(&logrus.TextFormatter{
-		ForceColors:   true,
-		FullTimestamp: true,
-	})
-
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return nil, fmt.Errorf("failed to start server: %w", err)
@@ -63,7 +98,19 @@ func NewServer(port int, dbPath string, encoding bool) (*TCPServer, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize block user manager: %w", err)
 	}
-	chanm := channels.NewChannelManager(cm, protocol.InitEncodeProtocol(encoding))
+	chanm, err := channels.NewChannelManager(cm, protocol.InitEncodeProtocol(encoding), dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize channel manager: %w", err)
+	}
+	// Sessions are kept in memory, so they never outlive the server whether or not a secret is configured
+	sm, err := session.NewManager([]byte(os.Getenv("CHAT_SESSION_SECRET")), session.DefaultTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize session manager: %w", err)
+	}
+	mm, err := moderation.NewManager(dbPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize moderation manager: %w", err)
+	}
 
 	server := &TCPServer{
 		listener: listener,
@@ -73,21 +120,28 @@ func NewServer(port int, dbPath string, encoding bool) (*TCPServer, error) {
 		authManager:       am,
 		blockUserManager:  bum,
 		channelManager:    chanm,
+		sessionManager:    sm,
+		moderationManager: mm,
+		sessionPolicy:     SessionPolicyMulti,
+		queueOptions:      connection.DefaultQueueOptions(),
+		heartbeatTimeout:  DefaultHeartbeatTimeout,
 
 		encodeFn: protocol.InitEncodeProtocol(encoding),
 		decodeFn: protocol.InitDecodeProtocol(encoding),
 
-		ratelimiter: chat_ratelimit.NewRatelimit(
-			chat_ratelimit.TokenBucket{
-				RefillInterval: time.Second * 3,
-				RefillRate:     1,
-				BucketLimit:    10,
-			}),
+		ratelimiter: chat_ratelimit.NewConnLimiter(func() chat_ratelimit.Limiter {
+			return chat_ratelimit.NewTokenBucketLimiter(10, 1, time.Second*3, chat_ratelimit.RealClock)
+		}),
+		backgroundLimiter: chat_ratelimit.NewConnLimiter(func() chat_ratelimit.Limiter {
+			return chat_ratelimit.NewTokenBucketLimiter(20, 2, time.Second, chat_ratelimit.RealClock)
+		}),
+		costTable: chat_ratelimit.DefaultCostTable(),
 
 		threadpool: threadpool.NewThreadpool(5),
 	}
 
 	server.messageRouter = NewMessageRouter(server)
+	chanm.OnSeatsFreed(server.messageRouter.admitWaitlisted)
 
 	return server, nil
 }
@@ -96,7 +150,6 @@ func (s *TCPServer) Start() {
 	logger.Info("Server started. Listening for connections...")
 	for {
 		conn, err := s.listener.Accept()
-		s.ratelimiter.Add(conn)
 		if err != nil {
 			logger.WithError(err).Error("Error accepting connection")
 			continue
@@ -107,6 +160,26 @@ func (s *TCPServer) Start() {
 	}
 }
 
+// SetInviteOnly toggles between open registration and invite only registration
+func (s *TCPServer) SetInviteOnly(inviteOnly bool) {
+	s.authManager.SetInviteOnly(inviteOnly)
+}
+
+// SetSessionPolicy sets how logging in from multiple devices is handled
+func (s *TCPServer) SetSessionPolicy(policy SessionPolicy) {
+	s.sessionPolicy = policy
+}
+
+// SetHeartbeatTimeout sets how long a connection may stay silent before it's dropped
+func (s *TCPServer) SetHeartbeatTimeout(timeout time.Duration) {
+	s.heartbeatTimeout = timeout
+}
+
+// SetQueueOptions sets the outbound queue size, write timeout and slow consumer policy of new connections
+func (s *TCPServer) SetQueueOptions(opts connection.QueueOptions) {
+	s.queueOptions = opts
+}
+
 func (s *TCPServer) Close() error {
 	if err := s.listener.Close(); err != nil {
 		return fmt.Errorf("failed to close listener: %w", err)
@@ -120,6 +193,12 @@ func (s *TCPServer) Close() error {
 	if err := s.blockUserManager.Close(); err != nil {
 		return fmt.Errorf("failed to close block user manager: %w", err)
 	}
+	if err := s.channelManager.Close(); err != nil {
+		return fmt.Errorf("failed to close channel manager: %w", err)
+	}
+	if err := s.moderationManager.Close(); err != nil {
+		return fmt.Errorf("failed to close moderation manager: %w", err)
+	}
 	s.threadpool.Stop()
 	logger.Info("Server closed successfully")
 
@@ -130,8 +209,9 @@ func (s *TCPServer) Close() error {
 // -----------------------------
 
 func (s *TCPServer) handleNewConnection(conn net.Conn) {
-	// This function mainly handles auth then forwards users to message router
-	handler := NewConnectionHandler(conn, s)
+	// This function mainly handles auth then forwards users to message router.
+	// Writes go through a queue, so a slow client never blocks whoever is writing to it
+	handler := NewConnectionHandler(connection.NewQueuedConn(conn, s.queueOptions), s)
 	handler.Handle()
 }
 
@@ -140,26 +220,71 @@ func (s *TCPServer) handleNewConnection(conn net.Conn) {
 
 func (s *TCPServer) OnClientJoin(info *connection.ConnectionInfo) {
 	s.connectionManager.AddConnection(info.Connection, info)
+	s.ratelimiter.Add(info.Connection)
+	s.backgroundLimiter.Add(info.Connection)
 	logger.WithField("user", info.OwnerName).Info("Client joined the chat")
-	s.broadcastSystemNotice(fmt.Sprintf("%s has joined the chat.", info.OwnerName), info.Connection)
+	// Other devices of an already connected user join silently
+	firstConnection := s.connectionManager.CountConnectionsByOwnerName(info.OwnerName) == 1
+	if s.sessionPolicy == SessionPolicyKickOlder {
+		s.kickOlderConnections(info)
+	}
+	if firstConnection {
+		s.broadcastSystemNotice(fmt.Sprintf("%s has joined the chat.", info.OwnerName), info.Connection)
+	}
 	s.broadcastActiveUsers()
 }
 
 func (s *TCPServer) OnClientLeave(info *connection.ConnectionInfo) {
 	logger.WithField("user", info.OwnerName).Info("Client left the chat")
-	s.broadcastSystemNotice(fmt.Sprintf("%s has left the chat.", info.OwnerName), info.Connection)
+	s.suspendSession(info)
+	lastConnection := s.connectionManager.CountConnectionsByOwnerName(info.OwnerName) == 1
+	if lastConnection {
+		s.broadcastSystemNotice(fmt.Sprintf("%s has left the chat.", info.OwnerName), info.Connection)
+	}
 	s.connectionManager.DeleteConnection(info.Connection)
 	s.ratelimiter.Remove(info.Connection)
+	s.backgroundLimiter.Remove(info.Connection)
+	if lastConnection {
+		s.messageRouter.handleChannelDisconnect(info.OwnerName)
+	}
 	s.broadcastActiveUsers()
 }
 
 func (s *TCPServer) OnMessageReceived(info *connection.ConnectionInfo, message string) {
+	payload, err := s.decodeFn(message)
+	background := err == nil && s.costTable.IsBackground(payload)
+	if background && !s.allowBackground(info.Connection, payload) {
+		// Clients send these on their own, telling the user to slow down wouldn't help
+		return
+	}
+	if err == nil && payload.MessageType == protocol.MessageTypePING {
+		// Heartbeats only keep the connection alive, they are not logged or stored
+		s.messageRouter.sendPong(info.Connection)
+		return
+	}
+
+	loggedMessage := message
+	if err == nil && (payload.MessageType == protocol.MessageTypePASSWD || payload.MessageType == protocol.MessageTypeDEL_ACCT) {
+		// Never write passwords to the log
+		loggedMessage = fmt.Sprintf("%s <redacted>", payload.MessageType)
+	}
 	logger.WithFields(logrus.Fields{
 		"user":    info.OwnerName,
-		"message": message,
+		"message": loggedMessage,
 	}).Info("Message received")
 
-	allowed := s.ratelimiter.Check(info.Connection)
+	// Checked before storing, so muted messages don't end up in history either
+	if err == nil && isChatMessage(payload) && s.messageRouter.rejectMuted(info) {
+		return
+	}
+
+	cost := s.costTable.Default
+	if err == nil {
+		cost = s.costTable.Cost(payload)
+	}
+
+	// Background frames were already charged to their own budget
+	allowed := background || s.ratelimiter.CheckCost(info.Connection, cost)
 	if !allowed {
 		s.messageRouter.sendSysResponse(info.Connection, "Please wait a moment before sending your next message", "fail")
 		return
@@ -169,6 +294,75 @@ func (s *TCPServer) OnMessageReceived(info *connection.ConnectionInfo, message s
 	s.messageRouter.RouteMessage(info, message)
 }
 
+// allowBackground charges a frame the client sent on its own to the background budget
+func (s *TCPServer) allowBackground(conn net.Conn, payload protocol.Payload) bool {
+	return s.backgroundLimiter.CheckCost(conn, s.costTable.Cost(payload))
+}
+
+// Session Handling
+// -----------------------------
+
+// suspendSession remembers the last message and channels of a leaving user, so they can be restored if the session is resumed
+func (s *TCPServer) suspendSession(info *connection.ConnectionInfo) {
+	if info.SessionID == "" {
+		return
+	}
+	lastMessageID, err := s.historyManager.LastMessageID()
+	if err != nil {
+		logger.WithError(err).Error("Failed to get last message id for session")
+		return
+	}
+	s.sessionManager.Suspend(info.SessionID, lastMessageID, s.channelManager.UserChannels(info.OwnerName))
+}
+
+// kickOlderConnections disconnects every other connection of the user
+func (s *TCPServer) kickOlderConnections(info *connection.ConnectionInfo) {
+	for _, conn := range s.connectionManager.FindConnectionsByOwnerName(info.OwnerName) {
+		if conn == info.Connection {
+			continue
+		}
+		// Revoke so the older device can't resume its session and kick this one in return
+		if olderInfo, ok := s.connectionManager.GetConnectionInfo(conn); ok && olderInfo.SessionID != info.SessionID {
+			s.sessionManager.RevokeSession(olderInfo.SessionID)
+		}
+		logger.WithField("user", info.OwnerName).Info("Kicking older connection")
+		s.messageRouter.sendSysResponse(conn, "You have been logged in from another device", "fail")
+		conn.Close()
+	}
+}
+
+// restoreSession replays messages the user missed while disconnected and puts them back into their channels
+func (s *TCPServer) restoreSession(info *connection.ConnectionInfo, sess session.Session) {
+	missed, err := s.historyManager.GetHistorySince(info.OwnerName, sess.LastMessageID, "MSG", "WSP")
+	if err != nil {
+		logger.WithError(err).Error("Failed to get missed messages")
+		s.messageRouter.sendSysResponse(info.Connection, "Missed messages not available", "fail")
+	} else {
+		logger.WithFields(logrus.Fields{
+			"user":   info.OwnerName,
+			"missed": len(missed),
+		}).Info("Replaying missed messages")
+		historyMsg := s.encodeFn(protocol.Payload{
+			MessageType:        protocol.MessageTypeHSTRY,
+			Sender:             info.OwnerName,
+			EncodedChatHistory: missed,
+			Status:             "missed",
+		})
+		if _, err := info.Connection.Write([]byte(historyMsg)); err != nil {
+			logger.WithError(err).Error("Failed to replay missed messages")
+		}
+	}
+
+	for _, chName := range sess.Channels {
+		chPayload, restored := s.channelManager.RestoreMember(chName, info.OwnerName)
+		if !restored {
+			s.messageRouter.sendSysResponse(info.Connection, fmt.Sprintf("Could not rejoin channel '%s'", chName), "fail")
+			continue
+		}
+		writeToAConn(s.messageRouter, protocol.Payload{MessageType: protocol.MessageTypeCH, ChannelPayload: &chPayload}, info.Connection)
+	}
+}
+
 // Broadcasting Methods
 // -----------------------------
 
@@ -186,21 +380,27 @@ func (s *TCPServer) broadcastSystemNotice(message string, excludeConn net.Conn)
 	}
 
 	encodedMsg := []byte(s.encodeFn(payload))
-	s.messageRouter.broadcastToAll(encodedMsg, "Error sending system notice", excludedConns...)
+	s.messageRouter.broadcastToAll(encodedMsg, "Error sending system notice", excludedConns)
 }
 
 func (s *TCPServer) broadcastActiveUsers() {
 	logger.Info("Broadcasting active users")
-	s.connectionManager.RangeConnections(func(conn net.Conn, _ *connection.ConnectionInfo) bool {
-		s.sendActiveUsers(conn)
+	// Fetched once and shared, instead of once per connection
+	activeUsers := s.connectionManager.GetActiveUsers()
+	s.connectionManager.RangeConnections(func(conn net.Conn, info *connection.ConnectionInfo) bool {
+		s.writeActiveUsers(conn, info, activeUsers)
 		return true
 	})
 }
 
 func (s *TCPServer) sendActiveUsers(conn net.Conn) {
-	activeUsers := s.connectionManager.GetActiveUsers()
-	connectionInfo, ok := s.connectionManager.GetConnectionInfo(conn)
-	if ok {
+	connectionInfo, _ := s.connectionManager.GetConnectionInfo(conn)
+	s.writeActiveUsers(conn, connectionInfo, s.connectionManager.GetActiveUsers())
+}
+
+// writeActiveUsers sends the active users to the connection, hiding users its owner blocked or got blocked by
+func (s *TCPServer) writeActiveUsers(conn net.Conn, connectionInfo *connection.ConnectionInfo, activeUsers []string) {
+	if connectionInfo != nil {
 		blockedUsers, _ := s.blockUserManager.GetBlockedUsers(connectionInfo.OwnerName)
 		blockerUsers, _ := s.blockUserManager.GetBlockerUsers(connectionInfo.OwnerName)
 
@@ -221,21 +421,29 @@ func (s *TCPServer) sendActiveUsers(conn net.Conn) {
 // Helper Functions
 // -----------------------------
 
+// isChatMessage reports whether the payload is something other users read, which muted users can't send
+func isChatMessage(payload protocol.Payload) bool {
+	switch payload.MessageType {
+	case protocol.MessageTypeMSG, protocol.MessageTypeWSP:
+		return true
+	case protocol.MessageTypeCH:
+		return payload.ChannelPayload != nil && payload.ChannelPayload.ChannelAction == protocol.MessageChannel
+	default:
+		return false
+	}
+}
+
 func filterActiveUsers(activeUsers, excludeUsers []string) []string {
-	filtered := make([]string, 0)
+	excluded := make(map[string]struct{}, len(excludeUsers))
+	for _, user := range excludeUsers {
+		excluded[user] = struct{}{}
+	}
+
+	filtered := make([]string, 0, len(activeUsers))
 	for _, user := range activeUsers {
-		if !contains(excludeUsers, user) {
+		if _, found := excluded[user]; !found {
 			filtered = append(filtered, user)
 		}
 	}
 	return filtered
 }
-
-func contains(slice []string, str string) bool {
-	for _, v := range slice {
-		if v == str {
-			return true
-		}
-	}
-	return false
-}
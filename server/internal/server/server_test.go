@@ -2,18 +2,26 @@ package server
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/ogzhanolguncu/go-chat/protocol"
+	chat_ratelimit "github.com/ogzhanolguncu/go-chat/ratelimit"
 	"github.com/ogzhanolguncu/go-chat/server/internal/auth"
 	"github.com/ogzhanolguncu/go-chat/server/internal/block_user"
+	"github.com/ogzhanolguncu/go-chat/server/internal/channels"
 	"github.com/ogzhanolguncu/go-chat/server/internal/chat_history"
+	"github.com/ogzhanolguncu/go-chat/server/internal/connection"
+	"github.com/ogzhanolguncu/go-chat/server/internal/moderation"
+	"github.com/ogzhanolguncu/go-chat/server/internal/session"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -23,6 +31,8 @@ import (
 type TestClient struct {
 	conn     net.Conn
 	username string
+	token    string
+	reader   *bufio.Reader
 	encodeFn func(payload protocol.Payload) string
 	decodeFn func(message string) (protocol.Payload, error)
 }
@@ -35,6 +45,7 @@ func NewTestClient(address string) (*TestClient, error) {
 	}
 	return &TestClient{
 		conn:     conn,
+		reader:   bufio.NewReader(conn),
 		encodeFn: protocol.InitEncodeProtocol(false),
 		decodeFn: protocol.InitDecodeProtocol(false),
 	}, nil
@@ -54,8 +65,7 @@ func (c *TestClient) SendMessage(payload protocol.Payload) error {
 
 // ReadMessage reads a message from the server
 func (c *TestClient) ReadMessage() (protocol.Payload, error) {
-	reader := bufio.NewReader(c.conn)
-	msg, err := reader.ReadString('\n')
+	msg, err := c.reader.ReadString('\n')
 	if err != nil {
 		return protocol.Payload{}, err
 	}
@@ -77,6 +87,13 @@ func (c *TestClient) Authenticate(username, password string) error {
 	if err != nil {
 		return err
 	}
+	// Session token is sent right before the auth response
+	if resp.MessageType == protocol.MessageTypeSESS {
+		c.token = resp.SessionToken
+		if resp, err = c.ReadMessage(); err != nil {
+			return err
+		}
+	}
 	if resp.MessageType != protocol.MessageTypeUSR || resp.Status != "success" {
 		return fmt.Errorf("authentication failed: %+v", resp)
 	}
@@ -367,3 +384,611 @@ func cleanupTest(t *testing.T, clients map[string]*TestClient, s *TCPServer) {
 	assert.NoError(t, s.Close())
 	assert.NoError(t, os.Remove(dbPath))
 }
+
+// ==================== Test Server Section ====================
+
+type testServerOption func(*TCPServer)
+
+func withSessionPolicy(policy SessionPolicy) testServerOption {
+	return func(s *TCPServer) { s.sessionPolicy = policy }
+}
+
+// newTestServer wires every manager to a fresh database, users join it with joinTestConn
+func newTestServer(t *testing.T, opts ...testServerOption) *TCPServer {
+	testDBPath := filepath.Join(t.TempDir(), "server_test.db")
+	blockUserManager, err := block_user.NewBlockUserManager(testDBPath)
+	assert.NoError(t, err)
+	t.Cleanup(func() { blockUserManager.Close() })
+
+	historyManager, err := chat_history.NewChatHistory(false, testDBPath)
+	assert.NoError(t, err)
+	t.Cleanup(func() { historyManager.Close() })
+
+	authManager, err := auth.NewAuthManager(testDBPath)
+	assert.NoError(t, err)
+	t.Cleanup(func() { authManager.Close() })
+
+	sessionManager, err := session.NewManager(nil, time.Hour)
+	assert.NoError(t, err)
+
+	moderationManager, err := moderation.NewManager(testDBPath)
+	assert.NoError(t, err)
+	t.Cleanup(func() { moderationManager.Close() })
+
+	cm := connection.NewConnectionManager()
+	channelManager, err := channels.NewChannelManager(cm, protocol.InitEncodeProtocol(false), testDBPath)
+	assert.NoError(t, err)
+	t.Cleanup(func() { channelManager.Close() })
+
+	s := &TCPServer{
+		connectionManager: cm,
+		authManager:       authManager,
+		channelManager:    channelManager,
+		blockUserManager:  blockUserManager,
+		historyManager:    historyManager,
+		sessionManager:    sessionManager,
+		moderationManager: moderationManager,
+		sessionPolicy:     SessionPolicyMulti,
+		ratelimiter: chat_ratelimit.NewConnLimiter(func() chat_ratelimit.Limiter {
+			return chat_ratelimit.NewTokenBucketLimiter(10, 1, time.Second, chat_ratelimit.RealClock)
+		}),
+		backgroundLimiter: chat_ratelimit.NewConnLimiter(func() chat_ratelimit.Limiter {
+			return chat_ratelimit.NewTokenBucketLimiter(20, 2, time.Second, chat_ratelimit.RealClock)
+		}),
+		costTable: chat_ratelimit.DefaultCostTable(),
+		encodeFn:  protocol.InitEncodeProtocol(false),
+		decodeFn:  protocol.InitDecodeProtocol(false),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.messageRouter = NewMessageRouter(s)
+	s.channelManager.OnSeatsFreed(s.messageRouter.admitWaitlisted)
+	return s
+}
+
+func joinTestConn(t *testing.T, s *TCPServer, username string) (*TestConn, string) {
+	token, sess, err := s.sessionManager.Issue(username)
+	assert.NoError(t, err)
+
+	conn := &TestConn{ReadBuffer: &bytes.Buffer{}, WriteBuffer: &syncBuffer{}}
+	s.OnClientJoin(&connection.ConnectionInfo{Connection: conn, OwnerName: username, SessionID: sess.ID})
+	return conn, token
+}
+
+// routeFrom routes a frame as if it was sent on the given connection
+func routeFrom(s *TCPServer, conn *TestConn, message string) {
+	info, _ := s.connectionManager.GetConnectionInfo(conn)
+	s.messageRouter.RouteMessage(info, message)
+}
+
+// ==================== Session Policy Section ====================
+
+func TestSessionPolicy(t *testing.T) {
+	t.Run("multi policy should fan out whispers to every device", func(t *testing.T) {
+		s := newTestServer(t)
+
+		sender, _ := joinTestConn(t, s, "sender")
+		laptop, _ := joinTestConn(t, s, "oz")
+		phone, _ := joinTestConn(t, s, "oz")
+
+		assert.Equal(t, 2, s.connectionManager.CountConnectionsByOwnerName("oz"))
+		assert.ElementsMatch(t, []string{"sender", "oz"}, s.connectionManager.GetActiveUsers())
+		// Second device joins silently
+		assert.Equal(t, 1, strings.Count(sender.WriteBuffer.String(), "oz has joined the chat."))
+
+		senderInfo, _ := s.connectionManager.GetConnectionInfo(sender)
+		s.messageRouter.RouteMessage(senderInfo, "WSP|1234567890|sender|oz|hello\r\n")
+
+		assert.Contains(t, laptop.WriteBuffer.String(), "WSP|1234567890|sender|oz|hello\r\n")
+		assert.Contains(t, phone.WriteBuffer.String(), "WSP|1234567890|sender|oz|hello\r\n")
+	})
+
+	t.Run("multi policy should announce leave only for the last device", func(t *testing.T) {
+		s := newTestServer(t)
+
+		observer, _ := joinTestConn(t, s, "observer")
+		laptop, _ := joinTestConn(t, s, "oz")
+		phone, _ := joinTestConn(t, s, "oz")
+
+		laptopInfo, _ := s.connectionManager.GetConnectionInfo(laptop)
+		s.OnClientLeave(laptopInfo)
+		assert.NotContains(t, observer.WriteBuffer.String(), "oz has left the chat.")
+
+		phoneInfo, _ := s.connectionManager.GetConnectionInfo(phone)
+		s.OnClientLeave(phoneInfo)
+		assert.Contains(t, observer.WriteBuffer.String(), "oz has left the chat.")
+	})
+
+	t.Run("kick older policy should disconnect older devices and revoke their session", func(t *testing.T) {
+		s := newTestServer(t, withSessionPolicy(SessionPolicyKickOlder))
+
+		laptop, laptopToken := joinTestConn(t, s, "oz")
+		phone, phoneToken := joinTestConn(t, s, "oz")
+
+		assert.Contains(t, laptop.WriteBuffer.String(), "You have been logged in from another device|fail")
+		assert.NotContains(t, phone.WriteBuffer.String(), "You have been logged in from another device")
+
+		_, _, err := s.sessionManager.Resume(laptopToken)
+		assert.ErrorIs(t, err, session.ErrUnknownSession)
+		_, _, err = s.sessionManager.Resume(phoneToken)
+		assert.NoError(t, err)
+	})
+}
+
+func TestHeartbeat(t *testing.T) {
+	s := newTestServer(t)
+	conn, _ := joinTestConn(t, s, "oz")
+	info, _ := s.connectionManager.GetConnectionInfo(conn)
+	conn.WriteBuffer.Reset()
+
+	s.OnMessageReceived(info, "PING|1234567890\r\n")
+
+	assert.Contains(t, conn.WriteBuffer.String(), "PONG|")
+	lastMessageID, err := s.historyManager.LastMessageID()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), lastMessageID, "heartbeats should not be stored")
+}
+
+func TestBackgroundRateLimit(t *testing.T) {
+	s := newTestServer(t)
+	conn, _ := joinTestConn(t, s, "oz")
+	info, _ := s.connectionManager.GetConnectionInfo(conn)
+	conn.WriteBuffer.Reset()
+
+	for range 50 {
+		s.OnMessageReceived(info, "PING|1234567890\r\n")
+	}
+	assert.Equal(t, 20, strings.Count(conn.WriteBuffer.String(), "PONG|"), "heartbeat floods should be cut off")
+	assert.NotContains(t, conn.WriteBuffer.String(), "Please wait a moment", "clients send heartbeats on their own")
+
+	s.OnMessageReceived(info, "MSG|1234567890|oz|hello\r\n")
+	assert.NotContains(t, conn.WriteBuffer.String(), "Please wait a moment", "heartbeats shouldn't eat into the budget of messages")
+}
+
+func TestAccountManagement(t *testing.T) {
+	t.Run("should change password and revoke sessions of other devices", func(t *testing.T) {
+		s := newTestServer(t)
+		assert.NoError(t, s.authManager.AddUser("oz", "P@ssw0rd"))
+
+		laptop, laptopToken := joinTestConn(t, s, "oz")
+		_, phoneToken := joinTestConn(t, s, "oz")
+		laptopInfo, _ := s.connectionManager.GetConnectionInfo(laptop)
+
+		s.messageRouter.RouteMessage(laptopInfo, "PASSWD|1234567890|wrongpassword|N3wP@ssword\r\n")
+		assert.Contains(t, laptop.WriteBuffer.String(), "Current password is incorrect|fail")
+
+		s.messageRouter.RouteMessage(laptopInfo, "PASSWD|1234567890|P@ssw0rd|N3wP@ssword\r\n")
+		assert.Contains(t, laptop.WriteBuffer.String(), "Password changed successfully|success")
+
+		_, _, err := s.sessionManager.Resume(laptopToken)
+		assert.NoError(t, err)
+		_, _, err = s.sessionManager.Resume(phoneToken)
+		assert.ErrorIs(t, err, session.ErrUnknownSession)
+	})
+
+	t.Run("should delete account and clean up after the user", func(t *testing.T) {
+		s := newTestServer(t)
+		assert.NoError(t, s.authManager.AddUser("oz", "P@ssw0rd"))
+		assert.NoError(t, s.blockUserManager.BlockUser("oz", "john"))
+		assert.NoError(t, s.blockUserManager.BlockUser("john", "oz"))
+		assert.NoError(t, s.historyManager.AddMessage("WSP|1234567890|john|oz|secret\r\n"))
+
+		laptop, token := joinTestConn(t, s, "oz")
+		phone, _ := joinTestConn(t, s, "oz")
+		laptopInfo, _ := s.connectionManager.GetConnectionInfo(laptop)
+
+		s.messageRouter.RouteMessage(laptopInfo, "DEL_ACCT|1234567890|wrongpassword\r\n")
+		assert.Contains(t, laptop.WriteBuffer.String(), "Current password is incorrect|fail")
+
+		s.messageRouter.RouteMessage(laptopInfo, "DEL_ACCT|1234567890|P@ssw0rd\r\n")
+		assert.Contains(t, laptop.WriteBuffer.String(), "Your account has been deleted|success")
+		assert.Contains(t, phone.WriteBuffer.String(), "Your account has been deleted|success")
+
+		_, err := s.authManager.AuthenticateUser("oz", "P@ssw0rd")
+		assert.ErrorIs(t, err, auth.ErrAuthenticationFailed)
+		blockers, err := s.blockUserManager.GetBlockerUsers("oz")
+		assert.NoError(t, err)
+		assert.Empty(t, blockers)
+		blocked, err := s.blockUserManager.GetBlockedUsers("oz")
+		assert.NoError(t, err)
+		assert.Empty(t, blocked)
+		history, err := s.historyManager.GetHistory("john", "WSP")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"WSP|1234567890|john|[deleted]|secret"}, history)
+		_, _, err = s.sessionManager.Resume(token)
+		assert.ErrorIs(t, err, session.ErrUnknownSession)
+	})
+
+	t.Run("should leave nothing behind for whoever registers the name next", func(t *testing.T) {
+		s := newTestServer(t)
+		assert.NoError(t, s.authManager.AddUser("oz", "P@ssw0rd"))
+		assert.NoError(t, s.authManager.SetRole("oz", auth.RoleAdmin))
+		ozConn, _ := joinTestConn(t, s, "oz")
+		johnConn, _ := joinTestConn(t, s, "john")
+
+		routeFrom(s, ozConn, "CH|1234567890|CreateChannel|oz|lobby|-|5|visibility=public;permanent=true\r\n")
+		routeFrom(s, johnConn, "CH|1234567890|CreateChannel|john|golang|-|5|visibility=public\r\n")
+		routeFrom(s, johnConn, "CH|1234567890|CreateChannel|john|hideout|-|5|visibility=private\r\n")
+		routeFrom(s, ozConn, "CH|1234567890|JoinChannel|oz|golang|-|-\r\n")
+		routeFrom(s, johnConn, "CH|1234567890|OpUser|john|golang|-|-|target_user=oz\r\n")
+		routeFrom(s, johnConn, "CH|1234567890|MuteUser|john|golang|-|-|target_user=oz\r\n")
+		routeFrom(s, johnConn, "CH|1234567890|InviteUser|john|hideout|-|-|target_user=oz\r\n")
+		_, code, found := strings.Cut(ozConn.WriteBuffer.String(), "invite_code=")
+		assert.True(t, found)
+		code, _, _ = strings.Cut(code, "\r\n")
+		assert.NoError(t, s.blockUserManager.BlockUser("john", "oz"))
+		assert.NoError(t, s.historyManager.AddMessage("MSG|1234567890|john|hidden from oz\r\n"))
+
+		ozInfo, _ := s.connectionManager.GetConnectionInfo(ozConn)
+		s.messageRouter.RouteMessage(ozInfo, "DEL_ACCT|1234567890|P@ssw0rd\r\n")
+		assert.Contains(t, ozConn.WriteBuffer.String(), "Your account has been deleted|success")
+		assert.NoError(t, s.blockUserManager.UnblockUser("john", "oz"))
+
+		assert.NoError(t, s.authManager.RegisterUser("oz", "N3wP@ssword"))
+		newOzConn, _ := joinTestConn(t, s, "oz")
+		history, err := s.historyManager.GetHistory("oz", "MSG")
+		assert.NoError(t, err)
+		assert.Contains(t, history, "MSG|1234567890|john|hidden from oz")
+
+		routeFrom(s, newOzConn, "CH|1234567890|AcceptInvite|oz|hideout|-|-|invite_code="+code+"\r\n")
+		assert.Contains(t, newOzConn.WriteBuffer.String(), "|JoinChannel|oz|hideout|-|-|status=fail;reason=Invite code is invalid or has expired.")
+		routeFrom(s, newOzConn, "CH|1234567890|ResizeChannel|oz|lobby|-|10|-\r\n")
+		assert.Contains(t, newOzConn.WriteBuffer.String(), "|ResizeChannel|oz|lobby|-|10|status=fail;reason=Not a channel owner.")
+		routeFrom(s, newOzConn, "CH|1234567890|JoinChannel|oz|golang|-|-\r\n")
+		routeFrom(s, newOzConn, "CH|1234567890|SetTopic|oz|golang|-|-|topic=Generics\r\n")
+		assert.Contains(t, newOzConn.WriteBuffer.String(), "|SetTopic|oz|golang|-|-|status=fail;reason=Not a channel owner or moderator.")
+		routeFrom(s, newOzConn, "CH|1234567890|MessageChannel|oz|golang|-|-|message=hello\r\n")
+		assert.NotContains(t, newOzConn.WriteBuffer.String(), "|MessageChannel|oz|golang|-|-|status=fail")
+	})
+}
+
+func TestModeration(t *testing.T) {
+	setup := func(t *testing.T) (*TCPServer, map[string]*TestConn) {
+		s := newTestServer(t)
+		conns := make(map[string]*TestConn)
+		for username, role := range map[string]auth.Role{"admin": auth.RoleAdmin, "mod": auth.RoleModerator, "othermod": auth.RoleModerator, "oz": auth.RoleUser, "john": auth.RoleUser} {
+			assert.NoError(t, s.authManager.AddUser(username, "P@ssw0rd"))
+			assert.NoError(t, s.authManager.SetRole(username, role))
+			conns[username], _ = joinTestConn(t, s, username)
+		}
+		return s, conns
+	}
+	t.Run("should only let moderators moderate users below them", func(t *testing.T) {
+		s, conns := setup(t)
+
+		routeFrom(s, conns["oz"], "MOD|1234567890|john||kick\r\n")
+		assert.Contains(t, conns["oz"].WriteBuffer.String(), "You are not allowed to do that|fail")
+
+		routeFrom(s, conns["mod"], "MOD|1234567890|othermod||kick\r\n")
+		assert.Contains(t, conns["mod"].WriteBuffer.String(), "You can't moderate 'othermod'|fail")
+
+		routeFrom(s, conns["mod"], "MOD|1234567890|oz|admin|role\r\n")
+		assert.Contains(t, conns["mod"].WriteBuffer.String(), "You are not allowed to do that|fail")
+
+		routeFrom(s, conns["admin"], "MOD|1234567890|oz|moderator|role\r\n")
+		role, err := s.authManager.GetRole("oz")
+		assert.NoError(t, err)
+		assert.Equal(t, auth.RoleModerator, role)
+	})
+
+	t.Run("should kick user from every device and revoke sessions", func(t *testing.T) {
+		s, conns := setup(t)
+		_, token := joinTestConn(t, s, "oz")
+
+		routeFrom(s, conns["mod"], "MOD|1234567890|oz||kick\r\n")
+
+		assert.Contains(t, conns["oz"].WriteBuffer.String(), "You have been kicked by 'mod'|fail")
+		assert.Contains(t, conns["mod"].WriteBuffer.String(), "'oz' has been kicked|success")
+		_, _, err := s.sessionManager.Resume(token)
+		assert.ErrorIs(t, err, session.ErrUnknownSession)
+	})
+
+	t.Run("should drop messages of muted users without storing them", func(t *testing.T) {
+		s, conns := setup(t)
+
+		routeFrom(s, conns["mod"], "MOD|1234567890|oz|10m|mute\r\n")
+		assert.Contains(t, conns["oz"].WriteBuffer.String(), "You are muted until")
+
+		ozInfo, _ := s.connectionManager.GetConnectionInfo(conns["oz"])
+		s.OnMessageReceived(ozInfo, "MSG|1234567890|oz|spam\r\n")
+		assert.NotContains(t, conns["john"].WriteBuffer.String(), "spam")
+		history, err := s.historyManager.GetHistory("john", "MSG")
+		assert.NoError(t, err)
+		assert.Empty(t, history)
+
+		routeFrom(s, conns["mod"], "MOD|1234567890|oz||unmute\r\n")
+		s.OnMessageReceived(ozInfo, "MSG|1234567890|oz|sorry\r\n")
+		assert.Contains(t, conns["john"].WriteBuffer.String(), "sorry")
+	})
+
+	t.Run("should ban user with a reason until unbanned", func(t *testing.T) {
+		s, conns := setup(t)
+
+		routeFrom(s, conns["mod"], "MOD|1234567890|oz||ban\r\n")
+		assert.Contains(t, conns["mod"].WriteBuffer.String(), "Ban duration must look like 10m, 2h or perm|fail")
+
+		routeFrom(s, conns["mod"], "MOD|1234567890|oz|1h spamming | links|ban\r\n")
+		assert.Contains(t, conns["oz"].WriteBuffer.String(), "You are banned until")
+		assert.Contains(t, conns["oz"].WriteBuffer.String(), "Reason: spamming / links")
+
+		loginConn := &TestConn{
+			ReadBuffer:  bytes.NewBufferString("USR|1234567890|oz|P@ssw0rd|login\r\n"),
+			WriteBuffer: &syncBuffer{},
+		}
+		assert.False(t, NewConnectionHandler(loginConn, s).authenticate())
+		assert.Contains(t, loginConn.WriteBuffer.String(), "SYS|")
+		assert.Contains(t, loginConn.WriteBuffer.String(), "You are banned until")
+
+		routeFrom(s, conns["mod"], "MOD|1234567890|||bans\r\n")
+		assert.Contains(t, conns["mod"].WriteBuffer.String(), "by mod: spamming / links|success")
+
+		routeFrom(s, conns["mod"], "MOD|1234567890|oz||unban\r\n")
+		loginConn.ReadBuffer = bytes.NewBufferString("USR|1234567890|oz|P@ssw0rd|login\r\n")
+		assert.True(t, NewConnectionHandler(loginConn, s).authenticate())
+
+		routeFrom(s, conns["mod"], "MOD|1234567890|oz||unban\r\n")
+		assert.Contains(t, conns["mod"].WriteBuffer.String(), "'oz' is not banned|fail")
+	})
+
+	t.Run("should ban permanently", func(t *testing.T) {
+		s, conns := setup(t)
+
+		routeFrom(s, conns["mod"], "MOD|1234567890|oz|perm|ban\r\n")
+		assert.Contains(t, conns["oz"].WriteBuffer.String(), "You are banned permanently|fail")
+
+		ban, banned, err := s.moderationManager.ActiveBan("oz", "")
+		assert.NoError(t, err)
+		assert.True(t, banned)
+		assert.True(t, ban.Permanent())
+		assert.Equal(t, "mod", ban.BannedBy)
+	})
+}
+
+func TestPermanentChannel(t *testing.T) {
+	s := newTestServer(t)
+	for username, role := range map[string]auth.Role{"admin": auth.RoleAdmin, "oz": auth.RoleUser} {
+		assert.NoError(t, s.authManager.AddUser(username, "P@ssw0rd"))
+		assert.NoError(t, s.authManager.SetRole(username, role))
+	}
+	adminConn, _ := joinTestConn(t, s, "admin")
+	ozConn, _ := joinTestConn(t, s, "oz")
+
+	routeFrom(s, ozConn, "CH|1234567890|CreateChannel|oz|golang|-|5|visibility=public;permanent=true\r\n")
+	assert.Contains(t, ozConn.WriteBuffer.String(), "status=fail;reason=Only admins can create permanent channels.")
+	assert.Empty(t, s.channelManager.UserChannels("oz"))
+
+	routeFrom(s, adminConn, "CH|1234567890|CreateChannel|admin|lobby|-|5|visibility=public;permanent=true\r\n")
+	assert.Contains(t, adminConn.WriteBuffer.String(), "status=success;visibility=public;permanent=true")
+	assert.Equal(t, []string{"lobby"}, s.channelManager.UserChannels("admin"))
+}
+
+func TestChannelHistory(t *testing.T) {
+	s := newTestServer(t)
+	ozConn, _ := joinTestConn(t, s, "oz")
+	johnConn, _ := joinTestConn(t, s, "john")
+
+	routeFrom(s, ozConn, "CH|1234567890|CreateChannel|oz|golang|-|5|visibility=public\r\n")
+	routeFrom(s, ozConn, "CH|1234567890|MessageChannel|oz|golang|-|-|message=hello channel\r\n")
+	// Not a member, so nothing is stored
+	routeFrom(s, johnConn, "CH|1234567890|MessageChannel|john|golang|-|-|message=sneaky\r\n")
+	routeFrom(s, johnConn, "CH|1234567890|HistoryChannel|john|golang|-|-\r\n")
+	assert.Contains(t, johnConn.WriteBuffer.String(), "status=fail;reason=User not in the channel.")
+
+	routeFrom(s, johnConn, "CH|1234567890|JoinChannel|john|golang|-|-\r\n")
+	joined := johnConn.WriteBuffer.String()
+	assert.Contains(t, joined, "|MessageChannel|oz|golang|-|-|status=success;message=hello channel|channel")
+	assert.NotContains(t, joined, "message=sneaky")
+	assert.Less(t, strings.Index(joined, "JoinChannel"), strings.Index(joined, "HSTRY"))
+
+	// The channel creation announcement goes out in the background, it must not land after the reset
+	assert.Eventually(t, func() bool {
+		return strings.Contains(johnConn.WriteBuffer.String(), "Channel 'golang' has been created by 'oz'")
+	}, time.Second, 10*time.Millisecond)
+	johnConn.WriteBuffer.Reset()
+	routeFrom(s, johnConn, "CH|1234567890|HistoryChannel|john|golang|-|-\r\n")
+	assert.True(t, strings.HasPrefix(johnConn.WriteBuffer.String(), "HSTRY|"))
+	assert.Contains(t, johnConn.WriteBuffer.String(), "message=hello channel")
+
+	// Messages of a user john blocked aren't in his history
+	janeConn, _ := joinTestConn(t, s, "jane")
+	routeFrom(s, janeConn, "CH|1234567890|JoinChannel|jane|golang|-|-\r\n")
+	routeFrom(s, janeConn, "CH|1234567890|MessageChannel|jane|golang|-|-|message=from jane\r\n")
+	assert.NoError(t, s.blockUserManager.BlockUser("john", "jane"))
+	johnConn.WriteBuffer.Reset()
+	routeFrom(s, johnConn, "CH|1234567890|HistoryChannel|john|golang|-|-\r\n")
+	assert.Contains(t, johnConn.WriteBuffer.String(), "message=hello channel")
+	assert.NotContains(t, johnConn.WriteBuffer.String(), "message=from jane")
+}
+
+func TestChannelSpoofedRequester(t *testing.T) {
+	s := newTestServer(t)
+	ozConn, _ := joinTestConn(t, s, "oz")
+	johnConn, _ := joinTestConn(t, s, "john")
+
+	routeFrom(s, ozConn, "CH|1234567890|CreateChannel|oz|golang|-|5|visibility=public\r\n")
+	routeFrom(s, ozConn, "CH|1234567890|MessageChannel|oz|golang|-|-|message=hello channel\r\n")
+
+	// john isn't a member, claiming to be oz doesn't change that
+	routeFrom(s, johnConn, "CH|1234567890|MessageChannel|oz|golang|-|-|message=posing as oz\r\n")
+	assert.Contains(t, johnConn.WriteBuffer.String(), "|MessageChannel|john|golang|-|-|status=fail;reason=User not in the channel.")
+	assert.NotContains(t, ozConn.WriteBuffer.String(), "message=posing as oz")
+
+	routeFrom(s, johnConn, "CH|1234567890|HistoryChannel|oz|golang|-|-\r\n")
+	assert.Contains(t, johnConn.WriteBuffer.String(), "|HistoryChannel|john|golang|-|-|status=fail;reason=User not in the channel.")
+	assert.NotContains(t, johnConn.WriteBuffer.String(), "message=hello channel")
+
+	routeFrom(s, johnConn, "CH|1234567890|GetUsers|oz|golang|-|-\r\n")
+	assert.Contains(t, johnConn.WriteBuffer.String(), "|GetUsers|john|golang|-|-|status=fail")
+	assert.NotContains(t, johnConn.WriteBuffer.String(), "users=")
+}
+
+func TestChannelSpoofedOwnerActions(t *testing.T) {
+	s := newTestServer(t)
+	ozConn, _ := joinTestConn(t, s, "oz")
+	johnConn, _ := joinTestConn(t, s, "john")
+	janeConn, _ := joinTestConn(t, s, "jane")
+
+	routeFrom(s, ozConn, "CH|1234567890|CreateChannel|oz|golang|-|5|visibility=public\r\n")
+	routeFrom(s, johnConn, "CH|1234567890|JoinChannel|john|golang|-|-\r\n")
+	routeFrom(s, janeConn, "CH|1234567890|JoinChannel|jane|golang|-|-\r\n")
+	routeFrom(s, ozConn, "CH|1234567890|BanUser|oz|golang|-|-|target_user=joe\r\n")
+
+	// jane is a plain member, putting the owner's name in the frame doesn't grant the owner's rights
+	for _, frame := range []string{
+		"CH|1234567890|TransferChannel|oz|golang|-|-|target_user=jane\r\n",
+		"CH|1234567890|OpUser|oz|golang|-|-|target_user=jane\r\n",
+		"CH|1234567890|KickUser|oz|golang|-|-|target_user=john\r\n",
+		"CH|1234567890|BanUser|oz|golang|-|-|target_user=john\r\n",
+		"CH|1234567890|UnbanUser|oz|golang|-|-|target_user=joe\r\n",
+		"CH|1234567890|GetBans|oz|golang|-|-\r\n",
+		"CH|1234567890|MuteUser|oz|golang|-|-|target_user=john\r\n",
+		"CH|1234567890|SetSlowMode|oz|golang|-|-|duration=1m0s\r\n",
+		"CH|1234567890|ResizeChannel|oz|golang|-|3|-\r\n",
+	} {
+		action := strings.Split(frame, "|")[2]
+		janeConn.WriteBuffer.Reset()
+		routeFrom(s, janeConn, frame)
+		assert.Regexp(t, `\|`+action+`\|jane\|golang\|-\|(-|3)\|status=fail`, janeConn.WriteBuffer.String(), action)
+	}
+	assert.ElementsMatch(t, []string{"golang"}, s.channelManager.UserChannels("john"))
+	// Neither muted nor slowed down
+	routeFrom(s, johnConn, "CH|1234567890|MessageChannel|john|golang|-|-|message=first\r\n")
+	routeFrom(s, johnConn, "CH|1234567890|MessageChannel|john|golang|-|-|message=second\r\n")
+	assert.NotContains(t, johnConn.WriteBuffer.String(), "|MessageChannel|john|golang|-|-|status=fail")
+	routeFrom(s, ozConn, "CH|1234567890|GetBans|oz|golang|-|-\r\n")
+	assert.Contains(t, ozConn.WriteBuffer.String(), "|GetBans|oz|golang|-|-|status=success;bans=joe:oz:")
+	// Three members and still five seats
+	routeFrom(s, ozConn, "CH|1234567890|GetChannels|oz|-|-|-\r\n")
+	assert.Contains(t, ozConn.WriteBuffer.String(), "summaries=golang:::3:5:")
+}
+
+func TestChannelInvites(t *testing.T) {
+	s := newTestServer(t)
+	ozConn, _ := joinTestConn(t, s, "oz")
+	johnConn, _ := joinTestConn(t, s, "john")
+
+	routeFrom(s, ozConn, "CH|1234567890|CreateChannel|oz|hideout|secret|5|visibility=private\r\n")
+	routeFrom(s, ozConn, "CH|1234567890|InviteUser|oz|hideout|-|-|target_user=john\r\n")
+	invite := johnConn.WriteBuffer.String()
+	assert.Contains(t, invite, "|InviteUser|oz|hideout|-|-|status=success;target_user=john;")
+	assert.Contains(t, ozConn.WriteBuffer.String(), "|InviteUser|oz|hideout|")
+
+	_, code, found := strings.Cut(invite, "invite_code=")
+	assert.True(t, found)
+	code = strings.TrimSpace(code)
+	routeFrom(s, johnConn, "CH|1234567890|AcceptInvite|john|hideout|-|-|invite_code="+code+"\r\n")
+	assert.Contains(t, johnConn.WriteBuffer.String(), "|JoinChannel|john|hideout|-|-|status=success")
+	assert.Equal(t, []string{"hideout"}, s.channelManager.UserChannels("john"))
+
+	// A user who blocked the requester doesn't get the invite, the requester still gets the code
+	janeConn, _ := joinTestConn(t, s, "jane")
+	assert.NoError(t, s.blockUserManager.BlockUser("jane", "oz"))
+	ozConn.WriteBuffer.Reset()
+	routeFrom(s, ozConn, "CH|1234567890|InviteUser|oz|hideout|-|-|target_user=jane\r\n")
+	assert.Contains(t, ozConn.WriteBuffer.String(), "|InviteUser|oz|hideout|-|-|status=success;target_user=jane;")
+	assert.NotContains(t, janeConn.WriteBuffer.String(), "InviteUser")
+}
+
+func TestChannelMutes(t *testing.T) {
+	s := newTestServer(t)
+	ozConn, _ := joinTestConn(t, s, "oz")
+	johnConn, _ := joinTestConn(t, s, "john")
+
+	routeFrom(s, ozConn, "CH|1234567890|CreateChannel|oz|golang|-|5|visibility=public\r\n")
+	routeFrom(s, johnConn, "CH|1234567890|JoinChannel|john|golang|-|-\r\n")
+	routeFrom(s, ozConn, "CH|1234567890|MuteUser|oz|golang|-|-|target_user=john\r\n")
+	assert.Contains(t, ozConn.WriteBuffer.String(), "|MuteUser|oz|golang|-|-|status=success;target_user=john")
+
+	ozConn.WriteBuffer.Reset()
+	johnConn.WriteBuffer.Reset()
+	routeFrom(s, johnConn, "CH|1234567890|MessageChannel|john|golang|-|-|message=still here\r\n")
+	// Only the muted member hears about it, nothing is stored or broadcast
+	assert.Contains(t, johnConn.WriteBuffer.String(), "status=fail;reason=You are muted in this channel.")
+	assert.NotContains(t, ozConn.WriteBuffer.String(), "still here")
+	routeFrom(s, johnConn, "CH|1234567890|HistoryChannel|john|golang|-|-\r\n")
+	assert.NotContains(t, johnConn.WriteBuffer.String(), "message=still here")
+}
+
+func TestChannelWaitlist(t *testing.T) {
+	s := newTestServer(t)
+	ozConn, _ := joinTestConn(t, s, "oz")
+	janeConn, _ := joinTestConn(t, s, "jane")
+	johnConn, _ := joinTestConn(t, s, "john")
+
+	routeFrom(s, ozConn, "CH|1234567890|CreateChannel|oz|golang|-|2|visibility=public;waitlist=true\r\n")
+	routeFrom(s, janeConn, "CH|1234567890|JoinChannel|jane|golang|-|-\r\n")
+	routeFrom(s, johnConn, "CH|1234567890|JoinChannel|john|golang|-|-\r\n")
+	assert.Contains(t, johnConn.WriteBuffer.String(), "|WaitlistChannel|john|golang|")
+	assert.Contains(t, johnConn.WriteBuffer.String(), "you're number 1 on the waitlist")
+	assert.NotContains(t, johnConn.WriteBuffer.String(), "|JoinChannel|john|golang|")
+
+	// The seat jane leaves goes to john, who is answered like any other join
+	johnConn.WriteBuffer.Reset()
+	routeFrom(s, janeConn, "CH|1234567890|LeaveChannel|jane|golang|-|-\r\n")
+	assert.Contains(t, johnConn.WriteBuffer.String(), "|JoinChannel|john|golang|-|2|status=success")
+	assert.Contains(t, johnConn.WriteBuffer.String(), "HSTRY|")
+	assert.Eventually(t, func() bool {
+		return strings.Contains(ozConn.WriteBuffer.String(), "notice='john' has joined the channel")
+	}, time.Second, 10*time.Millisecond)
+
+	routeFrom(s, johnConn, "CH|1234567890|ResizeChannel|john|golang|-|3|-\r\n")
+	assert.Contains(t, johnConn.WriteBuffer.String(), "reason=Not a channel owner.")
+	routeFrom(s, ozConn, "CH|1234567890|ResizeChannel|oz|golang|-|3|-\r\n")
+	assert.Contains(t, ozConn.WriteBuffer.String(), "|ResizeChannel|oz|golang|-|3|status=success")
+
+	// Members joining again aren't queued, even once the channel is full
+	routeFrom(s, janeConn, "CH|1234567890|JoinChannel|jane|golang|-|-\r\n")
+	routeFrom(s, johnConn, "CH|1234567890|JoinChannel|john|golang|-|-\r\n")
+	assert.Contains(t, johnConn.WriteBuffer.String(), "|JoinChannel|john|golang|-|-|status=fail;reason=You are already in the channel.")
+
+	// jane dropping off frees a seat for joe, who is waiting for it
+	joeConn, _ := joinTestConn(t, s, "joe")
+	routeFrom(s, joeConn, "CH|1234567890|JoinChannel|joe|golang|-|-\r\n")
+	assert.Contains(t, joeConn.WriteBuffer.String(), "|WaitlistChannel|joe|golang|")
+	janeInfo, _ := s.connectionManager.GetConnectionInfo(janeConn)
+	s.OnClientLeave(janeInfo)
+	assert.Contains(t, joeConn.WriteBuffer.String(), "|JoinChannel|joe|golang|-|3|status=success")
+	assert.Eventually(t, func() bool {
+		return strings.Contains(ozConn.WriteBuffer.String(), "notice='jane' lost connection and gave up their seat")
+	}, time.Second, 10*time.Millisecond)
+	assert.ElementsMatch(t, []string{"golang"}, s.channelManager.UserChannels("joe"))
+	assert.Empty(t, s.channelManager.UserChannels("jane"))
+}
+
+func TestChannelBlocks(t *testing.T) {
+	s := newTestServer(t)
+	ozConn, _ := joinTestConn(t, s, "oz")
+	johnConn, _ := joinTestConn(t, s, "john")
+	janeConn, _ := joinTestConn(t, s, "jane")
+	// Notices go out in the background
+	eventually := func(conn *TestConn, text string) {
+		assert.Eventually(t, func() bool { return strings.Contains(conn.WriteBuffer.String(), text) }, time.Second, 10*time.Millisecond)
+	}
+
+	assert.NoError(t, s.blockUserManager.BlockUser("john", "oz"))
+	routeFrom(s, ozConn, "CH|1234567890|CreateChannel|oz|golang|-|5|visibility=public\r\n")
+	routeFrom(s, janeConn, "CH|1234567890|JoinChannel|jane|golang|-|-\r\n")
+	routeFrom(s, johnConn, "CH|1234567890|JoinChannel|john|golang|-|-\r\n")
+	eventually(janeConn, "notice='john' has joined the channel")
+	assert.NotContains(t, ozConn.WriteBuffer.String(), "'john' has joined the channel")
+
+	routeFrom(s, ozConn, "CH|1234567890|MessageChannel|oz|golang|-|-|message=from oz\r\n")
+	routeFrom(s, johnConn, "CH|1234567890|MessageChannel|john|golang|-|-|message=from john\r\n")
+	assert.Contains(t, janeConn.WriteBuffer.String(), "message=from oz")
+	assert.Contains(t, janeConn.WriteBuffer.String(), "message=from john")
+	assert.NotContains(t, johnConn.WriteBuffer.String(), "message=from oz")
+	assert.NotContains(t, ozConn.WriteBuffer.String(), "message=from john")
+
+	routeFrom(s, ozConn, "CH|1234567890|TypingChannel|oz|golang|-|-\r\n")
+	assert.Contains(t, janeConn.WriteBuffer.String(), "|TypingChannel|oz|golang|")
+	assert.NotContains(t, johnConn.WriteBuffer.String(), "|TypingChannel|oz|golang|")
+
+	// Moderation notices still reach everyone in the channel
+	routeFrom(s, ozConn, "CH|1234567890|SetTopic|oz|golang|-|-|topic=Generics\r\n")
+	eventually(johnConn, "topic=Generics")
+
+	routeFrom(s, johnConn, "CH|1234567890|LeaveChannel|john|golang|-|-\r\n")
+	eventually(janeConn, "notice='john' has left the channel")
+	assert.NotContains(t, ozConn.WriteBuffer.String(), "'john' has left the channel")
+}
@@ -0,0 +1,196 @@
+package session
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultTTL = 24 * time.Hour
+
+var (
+	ErrInvalidToken   = errors.New("invalid session token")
+	ErrExpiredToken   = errors.New("session token has expired")
+	ErrUnknownSession = errors.New("session does not exist")
+)
+
+// Session is the server side state kept for a logged in user so a dropped connection can be resumed
+type Session struct {
+	ID            string
+	Username      string
+	ExpiresAt     time.Time
+	LastMessageID int64    // Last chat history message the user had seen when the connection dropped
+	Channels      []string // Channels the user was in when the connection dropped
+}
+
+// Manager issues signed session tokens and keeps the sessions they point to.
+// Tokens look like base64url(id|username|expiry).base64url(hmac) so they can be verified without a lookup,
+// but a token is only accepted while its session is still in the store.
+type Manager struct {
+	secret   []byte
+	ttl      time.Duration
+	sessions map[string]*Session
+	lock     sync.Mutex
+}
+
+// now is swapped in tests
+var now = time.Now
+
+// NewManager creates a session manager signing tokens with the given secret.
+// If secret is empty a random one is generated. Sessions only live in memory, so either way none survive a server restart.
+func NewManager(secret []byte, ttl time.Duration) (*Manager, error) {
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("failed to generate session secret: %w", err)
+		}
+	}
+	return &Manager{
+		secret:   secret,
+		ttl:      ttl,
+		sessions: make(map[string]*Session),
+	}, nil
+}
+
+// Issue creates a new session for the user and returns its token
+func (m *Manager) Issue(username string) (string, Session, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", Session{}, fmt.Errorf("failed to generate session id: %w", err)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.removeExpired()
+	sess := &Session{
+		ID:        hex.EncodeToString(idBytes),
+		Username:  username,
+		ExpiresAt: now().Add(m.ttl),
+	}
+	m.sessions[sess.ID] = sess
+	return m.sign(sess), *sess, nil
+}
+
+// Resume validates the token and returns a copy of its session along with a fresh token extending the expiry
+func (m *Manager) Resume(token string) (string, Session, error) {
+	id, username, expiresAt, err := m.verify(token)
+	if err != nil {
+		return "", Session{}, err
+	}
+	if now().After(expiresAt) {
+		return "", Session{}, ErrExpiredToken
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	sess, exists := m.sessions[id]
+	if !exists || sess.Username != username {
+		return "", Session{}, ErrUnknownSession
+	}
+	sess.ExpiresAt = now().Add(m.ttl)
+	return m.sign(sess), *sess, nil
+}
+
+// Suspend records where the user left off so it can be restored when the session is resumed
+func (m *Manager) Suspend(id string, lastMessageID int64, channels []string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	sess, exists := m.sessions[id]
+	if !exists {
+		return
+	}
+	sess.LastMessageID = lastMessageID
+	sess.Channels = channels
+}
+
+// Revoke removes all sessions of the user, their tokens can no longer be used
+func (m *Manager) Revoke(username string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for id, sess := range m.sessions {
+		if sess.Username == username {
+			delete(m.sessions, id)
+		}
+	}
+}
+
+// RevokeOthers removes all sessions of the user except the given one
+func (m *Manager) RevokeOthers(username, keepID string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for id, sess := range m.sessions {
+		if sess.Username == username && id != keepID {
+			delete(m.sessions, id)
+		}
+	}
+}
+
+// RevokeSession removes a single session, its token can no longer be used
+func (m *Manager) RevokeSession(id string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	delete(m.sessions, id)
+}
+
+// Helper Methods
+// -----------------------------
+
+func (m *Manager) sign(sess *Session) string {
+	claims := fmt.Sprintf("%s|%s|%d", sess.ID, sess.Username, sess.ExpiresAt.Unix())
+	encodedClaims := base64.RawURLEncoding.EncodeToString([]byte(claims))
+	return encodedClaims + "." + base64.RawURLEncoding.EncodeToString(m.mac(encodedClaims))
+}
+
+func (m *Manager) verify(token string) (id, username string, expiresAt time.Time, err error) {
+	encodedClaims, encodedSig, found := strings.Cut(token, ".")
+	if !found {
+		return "", "", time.Time{}, ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil || !hmac.Equal(sig, m.mac(encodedClaims)) {
+		return "", "", time.Time{}, ErrInvalidToken
+	}
+
+	claims, err := base64.RawURLEncoding.DecodeString(encodedClaims)
+	if err != nil {
+		return "", "", time.Time{}, ErrInvalidToken
+	}
+	parts := strings.Split(string(claims), "|")
+	if len(parts) != 3 {
+		return "", "", time.Time{}, ErrInvalidToken
+	}
+	expiry, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return "", "", time.Time{}, ErrInvalidToken
+	}
+	return parts[0], parts[1], time.Unix(expiry, 0), nil
+}
+
+func (m *Manager) mac(data string) []byte {
+	h := hmac.New(sha256.New, m.secret)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// removeExpired drops sessions nobody can resume anymore, caller must hold the lock
+func (m *Manager) removeExpired() {
+	current := now()
+	for id, sess := range m.sessions {
+		if current.After(sess.ExpiresAt) {
+			delete(m.sessions, id)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package session
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionManager(t *testing.T) {
+	t.Run("should resume an issued session and rotate the token", func(t *testing.T) {
+		m, err := NewManager([]byte("secret"), time.Hour)
+		require.NoError(t, err)
+
+		token, issued, err := m.Issue("Oz")
+		require.NoError(t, err)
+
+		newToken, resumed, err := m.Resume(token)
+		require.NoError(t, err)
+		assert.Equal(t, issued.ID, resumed.ID)
+		assert.Equal(t, "Oz", resumed.Username)
+
+		_, _, err = m.Resume(newToken)
+		assert.NoError(t, err)
+	})
+
+	t.Run("should restore state recorded on suspend", func(t *testing.T) {
+		m, err := NewManager(nil, time.Hour)
+		require.NoError(t, err)
+
+		token, issued, err := m.Issue("Oz")
+		require.NoError(t, err)
+		m.Suspend(issued.ID, 42, []string{"general"})
+
+		_, resumed, err := m.Resume(token)
+		require.NoError(t, err)
+		assert.Equal(t, int64(42), resumed.LastMessageID)
+		assert.Equal(t, []string{"general"}, resumed.Channels)
+	})
+
+	t.Run("should reject tampered tokens", func(t *testing.T) {
+		m, err := NewManager([]byte("secret"), time.Hour)
+		require.NoError(t, err)
+		other, err := NewManager([]byte("other-secret"), time.Hour)
+		require.NoError(t, err)
+
+		token, _, err := m.Issue("Oz")
+		require.NoError(t, err)
+		forged, _, err := other.Issue("Oz")
+		require.NoError(t, err)
+
+		claims, sig, _ := strings.Cut(token, ".")
+		tests := []string{"", "garbage", claims, claims + ".", "x" + token, claims + "." + sig + "x", forged}
+		for _, tt := range tests {
+			_, _, err := m.Resume(tt)
+			assert.ErrorIs(t, err, ErrInvalidToken, tt)
+		}
+	})
+
+	t.Run("should reject expired tokens", func(t *testing.T) {
+		current := time.Unix(1000, 0)
+		now = func() time.Time { return current }
+		defer func() { now = time.Now }()
+
+		m, err := NewManager([]byte("secret"), time.Minute)
+		require.NoError(t, err)
+		token, _, err := m.Issue("Oz")
+		require.NoError(t, err)
+
+		current = current.Add(2 * time.Minute)
+		_, _, err = m.Resume(token)
+		assert.ErrorIs(t, err, ErrExpiredToken)
+	})
+
+	t.Run("should reject revoked sessions", func(t *testing.T) {
+		m, err := NewManager([]byte("secret"), time.Hour)
+		require.NoError(t, err)
+		token, _, err := m.Issue("Oz")
+		require.NoError(t, err)
+		otherToken, _, err := m.Issue("John")
+		require.NoError(t, err)
+
+		m.Revoke("Oz")
+
+		_, _, err = m.Resume(token)
+		assert.ErrorIs(t, err, ErrUnknownSession)
+		_, _, err = m.Resume(otherToken)
+		assert.NoError(t, err)
+	})
+	t.Run("should keep only the given session when revoking others", func(t *testing.T) {
+		m, err := NewManager([]byte("secret"), time.Hour)
+		require.NoError(t, err)
+		current, currentSess, err := m.Issue("Oz")
+		require.NoError(t, err)
+		other, _, err := m.Issue("Oz")
+		require.NoError(t, err)
+
+		m.RevokeOthers("Oz", currentSess.ID)
+
+		_, _, err = m.Resume(current)
+		assert.NoError(t, err)
+		_, _, err = m.Resume(other)
+		assert.ErrorIs(t, err, ErrUnknownSession)
+	})
+}
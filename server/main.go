@@ -2,9 +2,13 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"path/filepath"
 
+	"github.com/ogzhanolguncu/go-chat/server/internal/auth"
+	"github.com/ogzhanolguncu/go-chat/server/internal/connection"
+	"github.com/ogzhanolguncu/go-chat/server/internal/moderation"
 	"github.com/ogzhanolguncu/go-chat/server/internal/server"
 	"github.com/ogzhanolguncu/go-chat/server/utils"
 )
@@ -16,10 +20,74 @@ const (
 
 func main() {
 	encoding := flag.Bool("encoding", false, "enable encoding")
+	unlock := flag.String("unlock", "", "unlock a user locked out by failed logins and exit")
+	invite := flag.String("invite", "", "allow a user to register while registration is invite only and exit")
+	promote := flag.String("promote", "", "give a user the role set by -role and exit")
+	role := flag.String("role", string(auth.RoleAdmin), "role given by -promote: 'admin', 'moderator' or 'user'")
+	ban := flag.String("ban", "", "ban a user for -ban-duration with -ban-reason and exit")
+	banDuration := flag.Duration("ban-duration", 0, "how long -ban lasts, 0 bans permanently")
+	banReason := flag.String("ban-reason", "", "reason shown to the user banned by -ban")
+	unban := flag.String("unban", "", "lift the bans of a user and exit")
+	inviteOnly := flag.Bool("invite-only", false, "only invited users can register")
+	sessionPolicyFlag := flag.String("session-policy", string(server.SessionPolicyMulti), "what to do when a user logs in while already connected: 'multi' or 'kick-older'")
+	slowConsumerFlag := flag.String("slow-consumer", string(connection.SlowConsumerDisconnect), "what to do when a client can't keep up with its messages: 'drop' or 'disconnect'")
+	writeQueue := flag.Int("write-queue", connection.DefaultQueueOptions().Size, "max number of outgoing messages queued per connection")
+	writeTimeout := flag.Duration("write-timeout", connection.DefaultQueueOptions().WriteTimeout, "deadline for writing a single message to a client")
+	heartbeatTimeout := flag.Duration("heartbeat-timeout", server.DefaultHeartbeatTimeout, "drop connections that send nothing for this long, 0 disables it")
 	flag.Parse()
 
 	dbPath := filepath.Join(utils.RootDir(), dbName)
 
+	if *unlock != "" {
+		runAuthCommand(dbPath, func(am *auth.AuthManager) error { return am.UnlockUser(*unlock) })
+		log.Printf("User '%s' has been unlocked", *unlock)
+		return
+	}
+	if *invite != "" {
+		runAuthCommand(dbPath, func(am *auth.AuthManager) error { return am.InviteUser(*invite) })
+		log.Printf("User '%s' has been invited", *invite)
+		return
+	}
+
+	if *promote != "" {
+		parsedRole, err := auth.ParseRole(*role)
+		if err != nil {
+			log.Fatalf("Invalid flag: %v", err)
+		}
+		runAuthCommand(dbPath, func(am *auth.AuthManager) error { return am.SetRole(*promote, parsedRole) })
+		log.Printf("User '%s' is now '%s'", *promote, parsedRole)
+		return
+	}
+
+	if *ban != "" {
+		runModerationCommand(dbPath, func(mm *moderation.Manager) error {
+			_, err := mm.Ban(*ban, *banReason, "server", *banDuration)
+			return err
+		})
+		log.Printf("User '%s' has been banned", *ban)
+		return
+	}
+	if *unban != "" {
+		runModerationCommand(dbPath, func(mm *moderation.Manager) error {
+			unbanned, err := mm.Unban(*unban)
+			if err == nil && !unbanned {
+				err = fmt.Errorf("user '%s' is not banned", *unban)
+			}
+			return err
+		})
+		log.Printf("User '%s' has been unbanned", *unban)
+		return
+	}
+
+	sessionPolicy, err := server.ParseSessionPolicy(*sessionPolicyFlag)
+	if err != nil {
+		log.Fatalf("Invalid flag: %v", err)
+	}
+	slowConsumerPolicy, err := connection.ParseSlowConsumerPolicy(*slowConsumerFlag)
+	if err != nil {
+		log.Fatalf("Invalid flag: %v", err)
+	}
+
 	s, err := server.NewServer(port, dbPath, *encoding)
 	if err != nil {
 		log.Fatalf("Failed to create server: %v", err)
@@ -31,8 +99,47 @@ func main() {
 		}
 	}()
 
+	s.SetInviteOnly(*inviteOnly)
+	s.SetSessionPolicy(sessionPolicy)
+	s.SetHeartbeatTimeout(*heartbeatTimeout)
+	s.SetQueueOptions(connection.QueueOptions{
+		Size:         *writeQueue,
+		WriteTimeout: *writeTimeout,
+		Policy:       slowConsumerPolicy,
+	})
+
 	log.Printf("Chat server starting on port %d\n", port)
 	log.Printf("Encoding: %v\n", *encoding)
+	log.Printf("Invite only: %v\n", *inviteOnly)
+	log.Printf("Session policy: %s\n", sessionPolicy)
+	log.Printf("Slow consumer policy: %s\n", slowConsumerPolicy)
+	log.Printf("Heartbeat timeout: %s\n", *heartbeatTimeout)
 
 	s.Start()
 }
+
+// runAuthCommand runs a one-off admin command against the auth database
+func runAuthCommand(dbPath string, command func(am *auth.AuthManager) error) {
+	am, err := auth.NewAuthManager(dbPath)
+	if err != nil {
+		log.Fatalf("Failed to open auth manager: %v", err)
+	}
+	defer am.Close()
+
+	if err := command(am); err != nil {
+		log.Fatalf("Command failed: %v", err)
+	}
+}
+
+// runModerationCommand runs a one-off admin command against the ban list
+func runModerationCommand(dbPath string, command func(mm *moderation.Manager) error) {
+	mm, err := moderation.NewManager(dbPath)
+	if err != nil {
+		log.Fatalf("Failed to open moderation manager: %v", err)
+	}
+	defer mm.Close()
+
+	if err := command(mm); err != nil {
+		log.Fatalf("Command failed: %v", err)
+	}
+}